@@ -13,9 +13,11 @@ import (
 	"github.com/kristohberg/CreatixBackend/config"
 	"github.com/kristohberg/CreatixBackend/handler"
 	"github.com/kristohberg/CreatixBackend/internal/mail"
+	"github.com/kristohberg/CreatixBackend/internal/oidc"
 	"github.com/kristohberg/CreatixBackend/logging"
 	jwtmiddleware "github.com/kristohberg/CreatixBackend/middleware"
 	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
 	"github.com/labstack/echo"
 	"github.com/labstack/echo/middleware"
 	_ "github.com/lib/pq"
@@ -26,11 +28,24 @@ import (
 const ioTimeout = time.Second * 3
 const cacheWriteTimeout = time.Second * 30
 
+// A request holds a connection for its tenant transaction until it ends,
+// while the session client checks sessions and permissions directly on the
+// database. The tenant transactions get a pool of their own, so requests
+// waiting for that second connection cannot take up every connection of the
+// pool and wait on each other
+const (
+	dbMaxOpenConns     = 25
+	tenantMaxOpenConns = 25
+)
+
 type App struct {
-	cfg    config.Config
-	echo   *echo.Echo
-	DB     *sql.DB
-	logger *logging.StandardLogger
+	cfg      config.Config
+	echo     *echo.Echo
+	DB       *sql.DB
+	tenantDB *sql.DB
+	logger   *logging.StandardLogger
+	keyring  *utils.Keyring
+	proxies  utils.TrustedProxies
 }
 
 type (
@@ -38,15 +53,15 @@ type (
 	HandlerFunc            func(w http.ResponseWriter, r *http.Request)
 )
 
-func ConnectDB(cfg *config.Config) (*sql.DB, error) {
+func ConnectDB(cfg *config.Config, maxOpenConns int) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.DatabaseUrl)
 	if err != nil {
 		return nil, err
 	}
 
 	db.SetConnMaxLifetime(0)
-	db.SetMaxIdleConns(50)
-	db.SetMaxOpenConns(50)
+	db.SetMaxIdleConns(maxOpenConns)
+	db.SetMaxOpenConns(maxOpenConns)
 	return db, nil
 }
 
@@ -67,7 +82,11 @@ func New(cfg config.Config) (App, error) {
 	var a App
 	a.logger = logging.NewLogger()
 
-	db, err := ConnectDB(&cfg)
+	db, err := ConnectDB(&cfg, dbMaxOpenConns)
+	if err != nil {
+		return a, errors.Wrap(err, "could not establish contact with the database")
+	}
+	a.tenantDB, err = ConnectDB(&cfg, tenantMaxOpenConns)
 	if err != nil {
 		return a, errors.Wrap(err, "could not establish contact with the database")
 	}
@@ -80,12 +99,73 @@ func New(cfg config.Config) (App, error) {
 	if err != nil {
 		return a, errors.Wrap(err, "not able to migrate up database")
 	}
+	a.keyring, err = newKeyring(cfg)
+	if err != nil {
+		return a, errors.Wrap(err, "not able to set up jwt keyring")
+	}
+	a.proxies, err = utils.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return a, errors.Wrap(err, "not able to set up trusted proxies")
+	}
 	a.DB = db
 	a.cfg = cfg
 
 	return a, nil
 }
 
+// newKeyring sets up the jwt signing keys. Without JWT_KEYS the token secret
+// is used as a single HS256 key
+func newKeyring(cfg config.Config) (*utils.Keyring, error) {
+	gracePeriod := time.Hour * time.Duration(cfg.JwtKeyGracePeriodHours)
+	if len(cfg.JwtKeys) == 0 {
+		return utils.NewKeyring(utils.NewHMACKey("default", []byte(cfg.TokenSecret)), gracePeriod)
+	}
+
+	var (
+		current *utils.SigningKey
+		others  []utils.SigningKey
+	)
+	for _, id := range cfg.JwtKeys {
+		keyCfg := cfg.JwtKey[id]
+		material := keyCfg.Secret
+		if keyCfg.Algorithm != utils.AlgHS256 {
+			material = keyCfg.PrivateKey
+		}
+
+		key, err := utils.ParseSigningKey(id, keyCfg.Algorithm, []byte(material))
+		if err != nil {
+			return nil, err
+		}
+		key.RetiredAt = keyCfg.RetiredAt
+
+		if id == cfg.JwtSigningKey {
+			current = &key
+			continue
+		}
+		others = append(others, key)
+	}
+
+	if current == nil {
+		return nil, errors.Errorf("signing key %q is not in JWT_KEYS", cfg.JwtSigningKey)
+	}
+	return utils.NewKeyring(*current, gracePeriod, others...)
+}
+
+func newOidcProviders(cfg config.Config) map[string]*oidc.Provider {
+	providers := make(map[string]*oidc.Provider)
+	for name, providerCfg := range cfg.Oidc {
+		providers[name] = oidc.NewProvider(oidc.Config{
+			Name:         name,
+			Issuer:       providerCfg.Issuer,
+			ClientID:     providerCfg.ClientID,
+			ClientSecret: providerCfg.ClientSecret,
+			RedirectURL:  providerCfg.RedirectURL,
+			Scopes:       providerCfg.Scopes,
+		}, &http.Client{Timeout: ioTimeout})
+	}
+	return providers
+}
+
 // Run starts up the application
 func (a App) Run() {
 	e := echo.New()
@@ -96,6 +176,7 @@ func (a App) Run() {
 		AllowCredentials: true,
 		AllowMethods:     []string{echo.OPTIONS, echo.GET, echo.HEAD, echo.PUT, echo.PATCH, echo.POST, echo.DELETE},
 	}))
+	e.Use(jwtmiddleware.ClientIP(a.proxies))
 	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
 		Format: "method=${method}, uri=${uri}, status=${status}\n",
 	}))
@@ -104,17 +185,19 @@ func (a App) Run() {
 	if port == "" {
 		port = ":8000"
 	}
-	sessionClient := models.NewSessionClient(a.DB, []byte(a.cfg.TokenSecret), a.cfg.TokenExpirationTimeMinutes, a.logger)
+	mailClient := mail.NewMailClient(a.cfg.SendgridKey)
+	sessionClient := models.NewSessionClient(a.DB, a.keyring, a.cfg.TokenExpirationTimeMinutes, a.cfg.RefreshTokenExpirationTimeHours, a.logger)
 	openSubrouter := e.Group("/v0")
 	restAPI := handler.RestAPI{
 		DB:             a.DB,
 		Logging:        a.logger,
 		Cfg:            a.cfg,
 		Feedback:       models.Feedback{},
-		Middleware:     &jwtmiddleware.Middleware{Cfg: a.cfg},
+		Middleware:     &jwtmiddleware.Middleware{Cfg: a.cfg, SessionClient: sessionClient, TenantDB: a.tenantDB},
 		CompanyClient:  models.NewCompanyClient(a.DB),
 		SessionClient:  sessionClient,
 		FeedbackClient: models.NewFeedbackClient(a.DB),
+		MailClient:     mailClient,
 	}
 	restAPI.Handler(openSubrouter)
 
@@ -124,14 +207,17 @@ func (a App) Run() {
 		Logging:       a.logger,
 		Cfg:           a.cfg,
 		SessionClient: sessionClient,
+		MailClient:    mailClient,
+		OidcProviders: newOidcProviders(a.cfg),
 	}
 	sessionAPI.Handler(authSubrouter)
+	e.GET(handler.GETJwksPath, sessionAPI.Jwks)
 
 	publicSubrouter := e.Group("/v0/public")
 	publicAPI := handler.PublicAPI{
 		Cfg:        a.cfg,
 		Logging:    logrus.StandardLogger(),
-		MailClient: mail.NewMailClient(a.cfg.SendgridKey),
+		MailClient: mailClient,
 	}
 	publicAPI.Handler(publicSubrouter)
 
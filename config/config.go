@@ -1,30 +1,60 @@
 package config
 
 import (
+	"time"
+
 	"github.com/kelseyhightower/envconfig"
 	"github.com/sirupsen/logrus"
 )
 
 type Config struct {
-	Env                        string `default:""`
-	DbUser                     string `default:""`
-	DbPass                     string `default:""`
-	DbName                     string `default:""`
-	DbHost                     string `default:""`
-	DbPort                     string `default:""`
-	DbURI                      string `default:""`
-	DatabaseUrl                string `split_words:"true"`
-	Port                       string `split_words:"true" default:":8080"`
-	OriginAllowed              string `default:""`
-	FromEmail                  string `default:""`
-	SMTPServer                 string `default:""`
-	SMTPPWD                    string `default:""`
-	SMTPUserName               string `default:""`
-	SendgridKey                string `split_words:"true" default:""`
-	ContactEmail               string `split_words:"true" default:""`
-	AllowCookieDomain          string `split_words:"true" default:""`
-	TokenSecret                string `split_words:"true" default:"secretkey"`
-	TokenExpirationTimeMinutes int
+	Env                             string                  `default:""`
+	DbUser                          string                  `default:""`
+	DbPass                          string                  `default:""`
+	DbName                          string                  `default:""`
+	DbHost                          string                  `default:""`
+	DbPort                          string                  `default:""`
+	DbURI                           string                  `default:""`
+	DatabaseUrl                     string                  `split_words:"true"`
+	Port                            string                  `split_words:"true" default:":8080"`
+	OriginAllowed                   string                  `default:""`
+	FromEmail                       string                  `default:""`
+	SMTPServer                      string                  `default:""`
+	SMTPPWD                         string                  `default:""`
+	SMTPUserName                    string                  `default:""`
+	SendgridKey                     string                  `split_words:"true" default:""`
+	ContactEmail                    string                  `split_words:"true" default:""`
+	FrontendUrl                     string                  `split_words:"true" default:"https://thecreatix.io"`
+	AllowCookieDomain               string                  `split_words:"true" default:""`
+	TrustedProxies                  []string                `split_words:"true" default:""`
+	TokenSecret                     string                  `split_words:"true" default:"secretkey"`
+	JwtKeys                         []string                `split_words:"true" default:""`
+	JwtSigningKey                   string                  `split_words:"true" default:""`
+	JwtKeyGracePeriodHours          int                     `split_words:"true" default:"24"`
+	JwtKey                          map[string]JwtKeyConfig `ignored:"true"`
+	TokenExpirationTimeMinutes      int
+	RefreshTokenExpirationTimeHours int
+	OidcProviders                   []string                      `split_words:"true" default:""`
+	Oidc                            map[string]OidcProviderConfig `ignored:"true"`
+}
+
+// OidcProviderConfig is read from OIDC_<PROVIDER>_* for every provider
+// listed in OIDC_PROVIDERS
+type OidcProviderConfig struct {
+	Issuer       string   `default:""`
+	ClientID     string   `split_words:"true" default:""`
+	ClientSecret string   `split_words:"true" default:""`
+	RedirectURL  string   `split_words:"true" default:""`
+	Scopes       []string `default:"openid,email,profile"`
+}
+
+// JwtKeyConfig is read from JWT_KEY_<ID>_* for every key listed in
+// JWT_KEYS. HS256 keys take a secret, RS256 and EdDSA keys a PEM private key
+type JwtKeyConfig struct {
+	Algorithm  string    `default:"HS256"`
+	Secret     string    `default:""`
+	PrivateKey string    `split_words:"true" default:""`
+	RetiredAt  time.Time `split_words:"true"`
 }
 
 // SetUpConfig sets up the correct configuration for the app
@@ -35,6 +65,25 @@ func SetUpConfig() (cfg Config, err error) {
 	if err != nil {
 		return
 	}
-	cfg.TokenExpirationTimeMinutes = 90
+	cfg.TokenExpirationTimeMinutes = 15
+	cfg.RefreshTokenExpirationTimeHours = 24 * 14
+
+	cfg.Oidc = make(map[string]OidcProviderConfig)
+	for _, provider := range cfg.OidcProviders {
+		var providerCfg OidcProviderConfig
+		if err = envconfig.Process("oidc_"+provider, &providerCfg); err != nil {
+			return
+		}
+		cfg.Oidc[provider] = providerCfg
+	}
+
+	cfg.JwtKey = make(map[string]JwtKeyConfig)
+	for _, id := range cfg.JwtKeys {
+		var keyCfg JwtKeyConfig
+		if err = envconfig.Process("jwt_key_"+id, &keyCfg); err != nil {
+			return
+		}
+		cfg.JwtKey[id] = keyCfg
+	}
 	return cfg, err
 }
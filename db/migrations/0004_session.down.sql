@@ -0,0 +1,2 @@
+DROP TABLE REFRESH_TOKENS;
+DROP TABLE SESSIONS;
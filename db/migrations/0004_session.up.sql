@@ -0,0 +1,30 @@
+CREATE TABLE SESSIONS
+(
+    Id UUID PRIMARY KEY,
+    UserId INT NOT NULL,
+    CreatedAt TIMESTAMP
+    WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    RevokedAt TIMESTAMP
+    WITH TIME ZONE,
+
+    CONSTRAINT fk_session_user FOREIGN KEY
+    (UserId) REFERENCES USERS
+    (ID)
+);
+
+CREATE TABLE REFRESH_TOKENS
+(
+    Id SERIAL PRIMARY KEY,
+    SessionId UUID NOT NULL,
+    TokenHash VARCHAR(64) NOT NULL UNIQUE,
+    CreatedAt TIMESTAMP
+    WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    ExpiresAt TIMESTAMP
+    WITH TIME ZONE NOT NULL,
+    UsedAt TIMESTAMP
+    WITH TIME ZONE,
+
+    CONSTRAINT fk_refresh_token_session FOREIGN KEY
+    (SessionId) REFERENCES SESSIONS
+    (Id)
+);
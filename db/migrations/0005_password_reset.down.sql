@@ -0,0 +1 @@
+DROP TABLE PASSWORD_RESETS;
@@ -0,0 +1,16 @@
+CREATE TABLE PASSWORD_RESETS
+(
+    Id SERIAL PRIMARY KEY,
+    UserId INT NOT NULL,
+    TokenHash VARCHAR(64) NOT NULL UNIQUE,
+    CreatedAt TIMESTAMP
+    WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    ExpiresAt TIMESTAMP
+    WITH TIME ZONE NOT NULL,
+    UsedAt TIMESTAMP
+    WITH TIME ZONE,
+
+    CONSTRAINT fk_password_reset_user FOREIGN KEY
+    (UserId) REFERENCES USERS
+    (ID)
+);
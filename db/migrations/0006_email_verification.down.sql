@@ -0,0 +1,2 @@
+DROP TABLE EMAIL_VERIFICATIONS;
+ALTER TABLE USERS DROP COLUMN EmailVerifiedAt;
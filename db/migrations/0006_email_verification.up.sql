@@ -0,0 +1,23 @@
+ALTER TABLE USERS
+ADD COLUMN EmailVerifiedAt TIMESTAMP
+WITH TIME ZONE;
+
+-- Users that signed up before verification existed are trusted
+UPDATE USERS SET EmailVerifiedAt=CURRENT_TIMESTAMP;
+
+CREATE TABLE EMAIL_VERIFICATIONS
+(
+    Id SERIAL PRIMARY KEY,
+    UserId INT NOT NULL,
+    TokenHash VARCHAR(64) NOT NULL UNIQUE,
+    CreatedAt TIMESTAMP
+    WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    ExpiresAt TIMESTAMP
+    WITH TIME ZONE NOT NULL,
+    UsedAt TIMESTAMP
+    WITH TIME ZONE,
+
+    CONSTRAINT fk_email_verification_user FOREIGN KEY
+    (UserId) REFERENCES USERS
+    (ID)
+);
@@ -0,0 +1,7 @@
+ALTER TABLE COMPANY DROP COLUMN RequireMfa;
+DROP TABLE MFA_CHALLENGES;
+DROP TABLE RECOVERY_CODES;
+ALTER TABLE USERS
+DROP COLUMN TotpSecret,
+DROP COLUMN TotpEnabledAt,
+DROP COLUMN TotpLastStep;
@@ -0,0 +1,40 @@
+ALTER TABLE USERS
+ADD COLUMN TotpSecret VARCHAR(64),
+ADD COLUMN TotpEnabledAt TIMESTAMP WITH TIME ZONE,
+ADD COLUMN TotpLastStep BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE RECOVERY_CODES
+(
+    Id SERIAL PRIMARY KEY,
+    UserId INT NOT NULL,
+    CodeHash VARCHAR(64) NOT NULL,
+    CreatedAt TIMESTAMP
+    WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UsedAt TIMESTAMP
+    WITH TIME ZONE,
+
+    CONSTRAINT fk_recovery_code_user FOREIGN KEY
+    (UserId) REFERENCES USERS
+    (ID)
+);
+
+CREATE TABLE MFA_CHALLENGES
+(
+    Id SERIAL PRIMARY KEY,
+    UserId INT NOT NULL,
+    TokenHash VARCHAR(64) NOT NULL UNIQUE,
+    Attempts INT NOT NULL DEFAULT 0,
+    CreatedAt TIMESTAMP
+    WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    ExpiresAt TIMESTAMP
+    WITH TIME ZONE NOT NULL,
+    UsedAt TIMESTAMP
+    WITH TIME ZONE,
+
+    CONSTRAINT fk_mfa_challenge_user FOREIGN KEY
+    (UserId) REFERENCES USERS
+    (ID)
+);
+
+ALTER TABLE COMPANY
+ADD COLUMN RequireMfa BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,15 @@
+CREATE TABLE USER_IDENTITIES
+(
+    Id SERIAL PRIMARY KEY,
+    UserId INT NOT NULL,
+    Provider VARCHAR(64) NOT NULL,
+    Subject VARCHAR(255) NOT NULL,
+    Email VARCHAR(64),
+    CreatedAt TIMESTAMP
+    WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT uq_user_identity UNIQUE (Provider,Subject),
+    CONSTRAINT fk_user_identity_user FOREIGN KEY
+    (UserId) REFERENCES USERS
+    (ID)
+);
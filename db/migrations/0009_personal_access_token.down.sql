@@ -0,0 +1 @@
+DROP TABLE PERSONAL_ACCESS_TOKENS;
@@ -0,0 +1,20 @@
+CREATE TABLE PERSONAL_ACCESS_TOKENS
+(
+    Id SERIAL PRIMARY KEY,
+    UserId INT NOT NULL,
+    Name VARCHAR(64) NOT NULL,
+    TokenHash VARCHAR(64) NOT NULL UNIQUE,
+    Scopes VARCHAR(255) NOT NULL,
+    CreatedAt TIMESTAMP
+    WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    ExpiresAt TIMESTAMP
+    WITH TIME ZONE NOT NULL,
+    LastUsedAt TIMESTAMP
+    WITH TIME ZONE,
+    RevokedAt TIMESTAMP
+    WITH TIME ZONE,
+
+    CONSTRAINT fk_personal_access_token_user FOREIGN KEY
+    (UserId) REFERENCES USERS
+    (ID)
+);
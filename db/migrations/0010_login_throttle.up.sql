@@ -0,0 +1,12 @@
+CREATE TABLE LOGIN_THROTTLES
+(
+    Scope VARCHAR(16) NOT NULL,
+    Subject VARCHAR(255) NOT NULL,
+    Failures INT NOT NULL DEFAULT 0,
+    LastFailedAt TIMESTAMP
+    WITH TIME ZONE NOT NULL,
+    LockedUntil TIMESTAMP
+    WITH TIME ZONE,
+
+    PRIMARY KEY (Scope,Subject)
+);
@@ -0,0 +1,2 @@
+-- Fails while argon2id hashes are stored, they do not fit in 64 characters
+ALTER TABLE USERS ALTER COLUMN Password TYPE VARCHAR(64);
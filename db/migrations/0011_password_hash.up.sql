@@ -0,0 +1 @@
+ALTER TABLE USERS ALTER COLUMN Password TYPE VARCHAR(255);
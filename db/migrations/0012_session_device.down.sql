@@ -0,0 +1,4 @@
+ALTER TABLE SESSIONS
+    DROP COLUMN UserAgent,
+    DROP COLUMN IP,
+    DROP COLUMN LastSeenAt;
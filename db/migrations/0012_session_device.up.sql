@@ -0,0 +1,4 @@
+ALTER TABLE SESSIONS
+    ADD COLUMN UserAgent VARCHAR(512) NOT NULL DEFAULT '',
+    ADD COLUMN IP VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN LastSeenAt TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
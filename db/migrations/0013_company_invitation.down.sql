@@ -0,0 +1 @@
+DROP TABLE COMPANY_INVITATIONS;
@@ -0,0 +1,35 @@
+CREATE TABLE COMPANY_INVITATIONS
+(
+    Id SERIAL PRIMARY KEY,
+    CompanyId INT NOT NULL,
+    Email VARCHAR(64) NOT NULL,
+    AccessId INT NOT NULL,
+    InvitedBy INT NOT NULL,
+    TokenHash VARCHAR(64) NOT NULL UNIQUE,
+    CreatedAt TIMESTAMP
+    WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    ExpiresAt TIMESTAMP
+    WITH TIME ZONE NOT NULL,
+    AcceptedAt TIMESTAMP
+    WITH TIME ZONE,
+    AcceptedBy INT,
+    RevokedAt TIMESTAMP
+    WITH TIME ZONE,
+
+    CONSTRAINT fk_company_invitation_company FOREIGN KEY
+    (CompanyId) REFERENCES COMPANY
+    (ID),
+    CONSTRAINT fk_company_invitation_access FOREIGN KEY
+    (AccessId) REFERENCES COMPANY_ACCESS
+    (AccessID),
+    CONSTRAINT fk_company_invitation_invited_by FOREIGN KEY
+    (InvitedBy) REFERENCES USERS
+    (ID),
+    CONSTRAINT fk_company_invitation_accepted_by FOREIGN KEY
+    (AcceptedBy) REFERENCES USERS
+    (ID)
+);
+
+CREATE UNIQUE INDEX company_invitation_pending_idx
+    ON COMPANY_INVITATIONS (CompanyId, LOWER(Email))
+    WHERE AcceptedAt IS NULL AND RevokedAt IS NULL;
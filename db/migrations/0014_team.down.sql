@@ -0,0 +1,7 @@
+ALTER TABLE USER_TEAM
+    DROP CONSTRAINT pk_user_team,
+    DROP COLUMN IsLead;
+
+ALTER TABLE TEAM
+    DROP CONSTRAINT uq_team_company_name,
+    ALTER COLUMN Name DROP NOT NULL;
@@ -0,0 +1,7 @@
+ALTER TABLE TEAM
+    ALTER COLUMN Name SET NOT NULL,
+    ADD CONSTRAINT uq_team_company_name UNIQUE (CompanyID, Name);
+
+ALTER TABLE USER_TEAM
+    ADD COLUMN IsLead BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD CONSTRAINT pk_user_team PRIMARY KEY (TeamId, UserId);
@@ -0,0 +1,4 @@
+DROP TABLE FEEDBACK_TEAM;
+
+ALTER TABLE FEEDBACK
+    DROP COLUMN TeamScoped;
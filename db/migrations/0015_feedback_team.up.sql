@@ -0,0 +1,18 @@
+ALTER TABLE FEEDBACK
+    ADD COLUMN TeamScoped BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE FEEDBACK_TEAM
+(
+    FeedbackId INT NOT NULL,
+    TeamId INT NOT NULL,
+
+    CONSTRAINT pk_feedback_team PRIMARY KEY (FeedbackId, TeamId),
+    CONSTRAINT fk_feedback_team_feedback FOREIGN KEY
+    (FeedbackId) REFERENCES FEEDBACK
+    (Id),
+    CONSTRAINT fk_feedback_team_team FOREIGN KEY
+    (TeamId) REFERENCES TEAM
+    (Id)
+);
+
+CREATE INDEX feedback_team_team_idx ON FEEDBACK_TEAM (TeamId);
@@ -0,0 +1,33 @@
+CREATE TABLE COMPANY_ACCESS
+(
+    AccessID INT UNIQUE NOT NULL,
+    AccessLevel VARCHAR(32) NOT NULL
+);
+
+INSERT INTO COMPANY_ACCESS
+VALUES
+    (1, 'admin'),
+    (2, 'write'),
+    (3, 'read');
+
+-- Members with a custom role fall back to read access
+UPDATE USER_COMPANY SET RoleId=3 WHERE RoleId>3;
+UPDATE COMPANY_INVITATIONS SET RoleId=3 WHERE RoleId>3 OR RoleId IS NULL;
+
+ALTER TABLE COMPANY_INVITATIONS
+    DROP CONSTRAINT fk_company_invitation_role;
+ALTER TABLE COMPANY_INVITATIONS
+    RENAME COLUMN RoleId TO AccessId;
+ALTER TABLE COMPANY_INVITATIONS
+    ALTER COLUMN AccessId SET NOT NULL,
+    ADD CONSTRAINT fk_company_invitation_access FOREIGN KEY (AccessId) REFERENCES COMPANY_ACCESS (AccessID);
+
+ALTER TABLE USER_COMPANY
+    DROP CONSTRAINT fk_user_company_role;
+ALTER TABLE USER_COMPANY
+    RENAME COLUMN RoleId TO AccessID;
+ALTER TABLE USER_COMPANY
+    ADD CONSTRAINT fk_access FOREIGN KEY (AccessID) REFERENCES COMPANY_ACCESS (AccessID);
+
+DROP TABLE ROLE_PERMISSION;
+DROP TABLE ROLE;
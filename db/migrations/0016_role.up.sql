@@ -0,0 +1,64 @@
+CREATE TABLE ROLE
+(
+    Id SERIAL PRIMARY KEY,
+    CompanyId INT,
+    Name VARCHAR(64) NOT NULL,
+
+    CONSTRAINT fk_role_company FOREIGN KEY
+    (CompanyId) REFERENCES COMPANY
+    (ID)
+);
+
+CREATE UNIQUE INDEX role_default_name_idx ON ROLE (LOWER(Name)) WHERE CompanyId IS NULL;
+CREATE UNIQUE INDEX role_company_name_idx ON ROLE (CompanyId, LOWER(Name)) WHERE CompanyId IS NOT NULL;
+
+CREATE TABLE ROLE_PERMISSION
+(
+    RoleId INT NOT NULL,
+    Permission VARCHAR(64) NOT NULL,
+
+    CONSTRAINT pk_role_permission PRIMARY KEY (RoleId, Permission),
+    CONSTRAINT fk_role_permission_role FOREIGN KEY
+    (RoleId) REFERENCES ROLE
+    (Id)
+);
+
+-- The access levels become the default roles every company has, keeping
+-- their ids so existing members and invitations keep their access
+INSERT INTO ROLE(Id, CompanyId, Name)
+SELECT AccessID, NULL, AccessLevel
+FROM COMPANY_ACCESS;
+
+SELECT setval('role_id_seq', (SELECT MAX(Id) FROM ROLE));
+
+INSERT INTO ROLE_PERMISSION(RoleId, Permission)
+VALUES
+    (1, 'feedback.read'),
+    (1, 'feedback.create'),
+    (1, 'feedback.moderate'),
+    (1, 'members.invite'),
+    (1, 'members.manage'),
+    (1, 'teams.manage'),
+    (1, 'roles.manage'),
+    (1, 'company.settings'),
+    (2, 'feedback.read'),
+    (2, 'feedback.create'),
+    (3, 'feedback.read');
+
+ALTER TABLE USER_COMPANY
+    DROP CONSTRAINT fk_access;
+ALTER TABLE USER_COMPANY
+    RENAME COLUMN AccessID TO RoleId;
+ALTER TABLE USER_COMPANY
+    ADD CONSTRAINT fk_user_company_role FOREIGN KEY (RoleId) REFERENCES ROLE (Id);
+
+ALTER TABLE COMPANY_INVITATIONS
+    DROP CONSTRAINT fk_company_invitation_access;
+ALTER TABLE COMPANY_INVITATIONS
+    RENAME COLUMN AccessId TO RoleId;
+-- Deleting a role only affects invitations that are no longer pending
+ALTER TABLE COMPANY_INVITATIONS
+    ALTER COLUMN RoleId DROP NOT NULL,
+    ADD CONSTRAINT fk_company_invitation_role FOREIGN KEY (RoleId) REFERENCES ROLE (Id) ON DELETE SET NULL;
+
+DROP TABLE COMPANY_ACCESS;
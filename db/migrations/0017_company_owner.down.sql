@@ -0,0 +1,6 @@
+ALTER TABLE COMPANY
+    DROP CONSTRAINT fk_company_pending_owner,
+    DROP CONSTRAINT fk_company_owner,
+    DROP COLUMN OwnershipOfferedAt,
+    DROP COLUMN PendingOwnerId,
+    DROP COLUMN OwnerId;
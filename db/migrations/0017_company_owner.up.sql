@@ -0,0 +1,15 @@
+ALTER TABLE COMPANY
+    ADD COLUMN OwnerId INT,
+    ADD COLUMN PendingOwnerId INT,
+    ADD COLUMN OwnershipOfferedAt TIMESTAMP
+    WITH TIME ZONE,
+    ADD CONSTRAINT fk_company_owner FOREIGN KEY (OwnerId) REFERENCES USERS (ID),
+    ADD CONSTRAINT fk_company_pending_owner FOREIGN KEY (PendingOwnerId) REFERENCES USERS (ID);
+
+-- Existing companies are owned by their first admin
+UPDATE COMPANY as c
+SET OwnerId=(
+    SELECT MIN(uc.UserId)
+    FROM USER_COMPANY as uc
+    WHERE uc.CompanyId=c.Id AND uc.RoleId=1
+);
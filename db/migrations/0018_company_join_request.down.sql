@@ -0,0 +1,4 @@
+DROP TABLE COMPANY_JOIN_REQUESTS;
+
+ALTER TABLE COMPANY
+    DROP COLUMN Discoverable;
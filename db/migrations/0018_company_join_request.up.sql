@@ -0,0 +1,30 @@
+ALTER TABLE COMPANY
+    ADD COLUMN Discoverable BOOLEAN NOT NULL DEFAULT TRUE;
+
+CREATE TABLE COMPANY_JOIN_REQUESTS
+(
+    Id SERIAL PRIMARY KEY,
+    CompanyId INT NOT NULL,
+    UserId INT NOT NULL,
+    Message VARCHAR(500) NOT NULL DEFAULT '',
+    CreatedAt TIMESTAMP
+    WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    DecidedAt TIMESTAMP
+    WITH TIME ZONE,
+    DecidedBy INT,
+    Approved BOOLEAN,
+
+    CONSTRAINT fk_company_join_request_company FOREIGN KEY
+    (CompanyId) REFERENCES COMPANY
+    (ID),
+    CONSTRAINT fk_company_join_request_user FOREIGN KEY
+    (UserId) REFERENCES USERS
+    (ID),
+    CONSTRAINT fk_company_join_request_decided_by FOREIGN KEY
+    (DecidedBy) REFERENCES USERS
+    (ID)
+);
+
+CREATE UNIQUE INDEX company_join_request_pending_idx
+    ON COMPANY_JOIN_REQUESTS (CompanyId, UserId)
+    WHERE DecidedAt IS NULL;
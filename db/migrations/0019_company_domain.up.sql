@@ -0,0 +1,25 @@
+CREATE TABLE COMPANY_DOMAINS
+(
+    Id SERIAL PRIMARY KEY,
+    CompanyId INT NOT NULL,
+    Domain VARCHAR(253) NOT NULL,
+    RoleId INT NOT NULL,
+    VerificationToken VARCHAR(64) NOT NULL,
+    CreatedAt TIMESTAMP
+    WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    VerifiedAt TIMESTAMP
+    WITH TIME ZONE,
+
+    CONSTRAINT uq_company_domain UNIQUE (CompanyId, Domain),
+    CONSTRAINT fk_company_domain_company FOREIGN KEY
+    (CompanyId) REFERENCES COMPANY
+    (ID),
+    CONSTRAINT fk_company_domain_role FOREIGN KEY
+    (RoleId) REFERENCES ROLE
+    (Id)
+);
+
+-- A domain can be claimed by several companies but only verified by one
+CREATE UNIQUE INDEX company_domain_verified_idx
+    ON COMPANY_DOMAINS (Domain)
+    WHERE VerifiedAt IS NOT NULL;
@@ -0,0 +1,10 @@
+ALTER TABLE COMPANY
+    DROP CONSTRAINT fk_company_default_role,
+    DROP COLUMN DeletionExpiresAt,
+    DROP COLUMN DeletionTokenHash,
+    DROP COLUMN ArchivedAt,
+    DROP COLUMN Timezone,
+    DROP COLUMN AllowAnonymousFeedback,
+    DROP COLUMN DefaultRoleId,
+    DROP COLUMN LogoUrl,
+    DROP COLUMN Description;
@@ -0,0 +1,13 @@
+ALTER TABLE COMPANY
+    ADD COLUMN Description VARCHAR(1000) NOT NULL DEFAULT '',
+    ADD COLUMN LogoUrl VARCHAR(2048) NOT NULL DEFAULT '',
+    ADD COLUMN DefaultRoleId INT,
+    ADD COLUMN AllowAnonymousFeedback BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN Timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+    ADD COLUMN ArchivedAt TIMESTAMP
+    WITH TIME ZONE,
+    ADD COLUMN DeletionTokenHash VARCHAR(64),
+    ADD COLUMN DeletionExpiresAt TIMESTAMP
+    WITH TIME ZONE,
+    -- Deleting the default role makes new members get the read role again
+    ADD CONSTRAINT fk_company_default_role FOREIGN KEY (DefaultRoleId) REFERENCES ROLE (Id) ON DELETE SET NULL;
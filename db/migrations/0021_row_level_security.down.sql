@@ -0,0 +1,23 @@
+DROP POLICY team_tenant ON TEAM;
+ALTER TABLE TEAM DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY user_company_tenant ON USER_COMPANY;
+ALTER TABLE USER_COMPANY DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY claps_tenant ON CLAPS;
+ALTER TABLE CLAPS DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY comments_tenant ON COMMENTS;
+ALTER TABLE COMMENTS DISABLE ROW LEVEL SECURITY;
+
+DROP POLICY feedback_tenant ON FEEDBACK;
+ALTER TABLE FEEDBACK DISABLE ROW LEVEL SECURITY;
+
+DROP FUNCTION app_user_companies();
+DROP FUNCTION app_user_id();
+
+ALTER DEFAULT PRIVILEGES IN SCHEMA public REVOKE USAGE, SELECT ON SEQUENCES FROM creatix_user;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public REVOKE SELECT, INSERT, UPDATE, DELETE ON TABLES FROM creatix_user;
+REVOKE USAGE, SELECT ON ALL SEQUENCES IN SCHEMA public FROM creatix_user;
+REVOKE SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public FROM creatix_user;
+REVOKE USAGE ON SCHEMA public FROM creatix_user;
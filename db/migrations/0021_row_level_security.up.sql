@@ -0,0 +1,51 @@
+-- Requests run their queries as creatix_user with the id of the user in
+-- app.user_id. The role is not the owner of the tables, so the policies below
+-- apply to it and limit the rows to the companies of the user
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'creatix_user') THEN
+        CREATE ROLE creatix_user NOLOGIN;
+    END IF;
+END
+$$;
+
+GRANT creatix_user TO CURRENT_USER;
+GRANT USAGE ON SCHEMA public TO creatix_user;
+GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA public TO creatix_user;
+GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA public TO creatix_user;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO creatix_user;
+ALTER DEFAULT PRIVILEGES IN SCHEMA public GRANT USAGE, SELECT ON SEQUENCES TO creatix_user;
+
+CREATE FUNCTION app_user_id() RETURNS INT AS $$
+    SELECT NULLIF(current_setting('app.user_id', true), '')::INT
+$$ LANGUAGE SQL STABLE;
+
+-- Runs as the owner of USER_COMPANY so the policy on USER_COMPANY can use it
+-- without applying itself
+CREATE FUNCTION app_user_companies() RETURNS SETOF INT AS $$
+    SELECT CompanyId FROM USER_COMPANY WHERE UserId = app_user_id()
+$$ LANGUAGE SQL STABLE SECURITY DEFINER SET search_path = public;
+
+ALTER TABLE FEEDBACK ENABLE ROW LEVEL SECURITY;
+CREATE POLICY feedback_tenant ON FEEDBACK
+    USING (CompanyId IN (SELECT app_user_companies()));
+
+-- Comments and claps belong to the company of their feedback, which the
+-- policy on FEEDBACK already limits
+ALTER TABLE COMMENTS ENABLE ROW LEVEL SECURITY;
+CREATE POLICY comments_tenant ON COMMENTS
+    USING (EXISTS (SELECT 1 FROM FEEDBACK as f WHERE f.Id = COMMENTS.FeedbackId));
+
+ALTER TABLE CLAPS ENABLE ROW LEVEL SECURITY;
+CREATE POLICY claps_tenant ON CLAPS
+    USING (EXISTS (SELECT 1 FROM FEEDBACK as f WHERE f.Id = CLAPS.FeedbackId));
+
+-- Users can always see and change their own memberships, which lets them
+-- create, join and leave companies
+ALTER TABLE USER_COMPANY ENABLE ROW LEVEL SECURITY;
+CREATE POLICY user_company_tenant ON USER_COMPANY
+    USING (UserId = app_user_id() OR CompanyId IN (SELECT app_user_companies()));
+
+ALTER TABLE TEAM ENABLE ROW LEVEL SECURITY;
+CREATE POLICY team_tenant ON TEAM
+    USING (CompanyId IN (SELECT app_user_companies()));
@@ -0,0 +1,4 @@
+DELETE FROM ROLE_PERMISSION WHERE Permission = 'audit.read';
+
+DROP TABLE AUDIT_EVENTS;
+DROP FUNCTION audit_events_append_only();
@@ -0,0 +1,37 @@
+CREATE TABLE AUDIT_EVENTS
+(
+    Id BIGSERIAL PRIMARY KEY,
+    Action VARCHAR(64) NOT NULL,
+    -- Events outlive the users and companies they are about, so there are no
+    -- foreign keys
+    ActorId INT,
+    TargetType VARCHAR(32) NOT NULL DEFAULT '',
+    TargetId VARCHAR(64) NOT NULL DEFAULT '',
+    CompanyId INT,
+    Ip VARCHAR(64) NOT NULL DEFAULT '',
+    UserAgent VARCHAR(512) NOT NULL DEFAULT '',
+    Before JSONB,
+    After JSONB,
+    CreatedAt TIMESTAMP
+    WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX audit_event_company_idx ON AUDIT_EVENTS (CompanyId, Id);
+CREATE INDEX audit_event_target_idx ON AUDIT_EVENTS (TargetType, TargetId, Id);
+
+-- The log is append-only
+CREATE FUNCTION audit_events_append_only() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit events cannot be changed or deleted';
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only
+    BEFORE UPDATE OR DELETE ON AUDIT_EVENTS
+    FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only();
+
+REVOKE UPDATE, DELETE, TRUNCATE ON AUDIT_EVENTS FROM creatix_user;
+
+INSERT INTO ROLE_PERMISSION(RoleId, Permission)
+VALUES
+    (1, 'audit.read');
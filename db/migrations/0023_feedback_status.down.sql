@@ -0,0 +1,14 @@
+DELETE FROM ROLE_PERMISSION WHERE Permission='feedback.triage';
+
+DROP TABLE FEEDBACK_STATUS_HISTORY;
+
+ALTER TABLE FEEDBACK
+    DROP CONSTRAINT fk_feedback_status,
+    DROP COLUMN StatusId;
+
+DROP TRIGGER company_default_feedback_statuses ON COMPANY;
+DROP FUNCTION company_default_feedback_statuses();
+DROP FUNCTION copy_default_feedback_statuses(INT);
+
+DROP TABLE FEEDBACK_STATUS_TRANSITION;
+DROP TABLE FEEDBACK_STATUS;
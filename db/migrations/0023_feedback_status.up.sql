@@ -0,0 +1,150 @@
+-- Statuses without a company make up the default workflow, which every
+-- company gets a copy of to change as it likes
+CREATE TABLE FEEDBACK_STATUS
+(
+    Id SERIAL PRIMARY KEY,
+    CompanyId INT,
+    Name VARCHAR(64) NOT NULL,
+    Position INT NOT NULL DEFAULT 0,
+    -- New feedback gets the initial status of its company
+    Initial BOOLEAN NOT NULL DEFAULT FALSE,
+
+    CONSTRAINT fk_feedback_status_company FOREIGN KEY
+    (CompanyId) REFERENCES COMPANY
+    (ID)
+);
+
+CREATE UNIQUE INDEX feedback_status_default_name_idx ON FEEDBACK_STATUS (LOWER(Name)) WHERE CompanyId IS NULL;
+CREATE UNIQUE INDEX feedback_status_company_name_idx ON FEEDBACK_STATUS (CompanyId, LOWER(Name)) WHERE CompanyId IS NOT NULL;
+CREATE UNIQUE INDEX feedback_status_initial_idx ON FEEDBACK_STATUS (COALESCE(CompanyId, 0)) WHERE Initial;
+
+CREATE TABLE FEEDBACK_STATUS_TRANSITION
+(
+    FromStatusId INT NOT NULL,
+    ToStatusId INT NOT NULL,
+
+    CONSTRAINT pk_feedback_status_transition PRIMARY KEY (FromStatusId, ToStatusId),
+    CONSTRAINT fk_feedback_status_transition_from FOREIGN KEY
+    (FromStatusId) REFERENCES FEEDBACK_STATUS
+    (Id) ON DELETE CASCADE,
+    CONSTRAINT fk_feedback_status_transition_to FOREIGN KEY
+    (ToStatusId) REFERENCES FEEDBACK_STATUS
+    (Id) ON DELETE CASCADE
+);
+
+INSERT INTO FEEDBACK_STATUS(CompanyId, Name, Position, Initial)
+VALUES
+    (NULL, 'open', 1, TRUE),
+    (NULL, 'under review', 2, FALSE),
+    (NULL, 'planned', 3, FALSE),
+    (NULL, 'in progress', 4, FALSE),
+    (NULL, 'done', 5, FALSE),
+    (NULL, 'declined', 6, FALSE);
+
+INSERT INTO FEEDBACK_STATUS_TRANSITION(FromStatusId, ToStatusId)
+SELECT f.Id, t.Id
+FROM (
+    VALUES
+        ('open', 'under review'),
+        ('open', 'planned'),
+        ('open', 'declined'),
+        ('under review', 'open'),
+        ('under review', 'planned'),
+        ('under review', 'declined'),
+        ('planned', 'in progress'),
+        ('planned', 'declined'),
+        ('in progress', 'planned'),
+        ('in progress', 'done'),
+        ('done', 'open'),
+        ('declined', 'open')
+) as d (FromName, ToName)
+INNER JOIN FEEDBACK_STATUS as f
+ON f.Name=d.FromName AND f.CompanyId IS NULL
+INNER JOIN FEEDBACK_STATUS as t
+ON t.Name=d.ToName AND t.CompanyId IS NULL;
+
+-- Copies the default workflow to the company. It runs as the owner of the
+-- tables as the company has no members yet when it is created by a request
+CREATE FUNCTION copy_default_feedback_statuses(company INT) RETURNS VOID AS $$
+    INSERT INTO FEEDBACK_STATUS(CompanyId, Name, Position, Initial)
+    SELECT company, Name, Position, Initial
+    FROM FEEDBACK_STATUS
+    WHERE CompanyId IS NULL;
+
+    INSERT INTO FEEDBACK_STATUS_TRANSITION(FromStatusId, ToStatusId)
+    SELECT cf.Id, ct.Id
+    FROM FEEDBACK_STATUS_TRANSITION as dt
+    INNER JOIN FEEDBACK_STATUS as df
+    ON df.Id=dt.FromStatusId
+    INNER JOIN FEEDBACK_STATUS as dto
+    ON dto.Id=dt.ToStatusId
+    INNER JOIN FEEDBACK_STATUS as cf
+    ON cf.CompanyId=company AND cf.Name=df.Name
+    INNER JOIN FEEDBACK_STATUS as ct
+    ON ct.CompanyId=company AND ct.Name=dto.Name
+    WHERE df.CompanyId IS NULL;
+$$ LANGUAGE SQL VOLATILE SECURITY DEFINER SET search_path = public;
+
+CREATE FUNCTION company_default_feedback_statuses() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM copy_default_feedback_statuses(NEW.Id);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER company_default_feedback_statuses
+    AFTER INSERT ON COMPANY
+    FOR EACH ROW EXECUTE PROCEDURE company_default_feedback_statuses();
+
+SELECT copy_default_feedback_statuses(Id) FROM COMPANY;
+
+ALTER TABLE FEEDBACK
+    ADD COLUMN StatusId INT,
+    ADD CONSTRAINT fk_feedback_status FOREIGN KEY (StatusId) REFERENCES FEEDBACK_STATUS (Id);
+
+UPDATE FEEDBACK as f
+SET StatusId=s.Id
+FROM FEEDBACK_STATUS as s
+WHERE s.CompanyId=f.CompanyId AND s.Initial;
+
+-- Status names are kept as they were, so the history survives renamed and
+-- deleted statuses
+CREATE TABLE FEEDBACK_STATUS_HISTORY
+(
+    Id SERIAL PRIMARY KEY,
+    FeedbackId INT NOT NULL,
+    UserId INT NOT NULL,
+    FromStatus VARCHAR(64),
+    ToStatus VARCHAR(64) NOT NULL,
+    CreatedAt TIMESTAMP
+    WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT fk_feedback_status_history_feedback FOREIGN KEY
+    (FeedbackId) REFERENCES FEEDBACK
+    (Id),
+    CONSTRAINT fk_feedback_status_history_user FOREIGN KEY
+    (UserId) REFERENCES USERS
+    (ID)
+);
+
+CREATE INDEX feedback_status_history_feedback_idx ON FEEDBACK_STATUS_HISTORY (FeedbackId, Id);
+
+-- Everyone can read the default workflow but only companies change theirs
+ALTER TABLE FEEDBACK_STATUS ENABLE ROW LEVEL SECURITY;
+CREATE POLICY feedback_status_default ON FEEDBACK_STATUS
+    FOR SELECT USING (CompanyId IS NULL);
+CREATE POLICY feedback_status_tenant ON FEEDBACK_STATUS
+    USING (CompanyId IN (SELECT app_user_companies()));
+
+ALTER TABLE FEEDBACK_STATUS_TRANSITION ENABLE ROW LEVEL SECURITY;
+CREATE POLICY feedback_status_transition_tenant ON FEEDBACK_STATUS_TRANSITION
+    USING (EXISTS (SELECT 1 FROM FEEDBACK_STATUS as s WHERE s.Id = FEEDBACK_STATUS_TRANSITION.FromStatusId AND s.CompanyId IS NOT NULL));
+
+ALTER TABLE FEEDBACK_STATUS_HISTORY ENABLE ROW LEVEL SECURITY;
+CREATE POLICY feedback_status_history_tenant ON FEEDBACK_STATUS_HISTORY
+    USING (EXISTS (SELECT 1 FROM FEEDBACK as f WHERE f.Id = FEEDBACK_STATUS_HISTORY.FeedbackId));
+
+INSERT INTO ROLE_PERMISSION(RoleId, Permission)
+VALUES
+    (1, 'feedback.triage'),
+    (2, 'feedback.triage');
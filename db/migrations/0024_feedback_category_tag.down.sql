@@ -0,0 +1,8 @@
+DROP TABLE FEEDBACK_TAGS;
+DROP TABLE FEEDBACK_TAG;
+
+ALTER TABLE FEEDBACK
+    DROP CONSTRAINT fk_feedback_category,
+    DROP COLUMN CategoryId;
+
+DROP TABLE FEEDBACK_CATEGORY;
@@ -0,0 +1,61 @@
+CREATE TABLE FEEDBACK_CATEGORY
+(
+    Id SERIAL PRIMARY KEY,
+    CompanyId INT NOT NULL,
+    Name VARCHAR(64) NOT NULL,
+
+    CONSTRAINT fk_feedback_category_company FOREIGN KEY
+    (CompanyId) REFERENCES COMPANY
+    (ID)
+);
+
+CREATE UNIQUE INDEX feedback_category_name_idx ON FEEDBACK_CATEGORY (CompanyId, LOWER(Name));
+
+-- Deleting a category leaves its feedback without a category
+ALTER TABLE FEEDBACK
+    ADD COLUMN CategoryId INT,
+    ADD CONSTRAINT fk_feedback_category FOREIGN KEY (CategoryId) REFERENCES FEEDBACK_CATEGORY (Id) ON DELETE SET NULL;
+
+CREATE INDEX feedback_category_idx ON FEEDBACK (CategoryId);
+
+-- Tags are created when feedback is first tagged with them, their names are
+-- lowercase
+CREATE TABLE FEEDBACK_TAG
+(
+    Id SERIAL PRIMARY KEY,
+    CompanyId INT NOT NULL,
+    Name VARCHAR(32) NOT NULL,
+
+    CONSTRAINT uq_feedback_tag_name UNIQUE (CompanyId, Name),
+    CONSTRAINT fk_feedback_tag_company FOREIGN KEY
+    (CompanyId) REFERENCES COMPANY
+    (ID)
+);
+
+CREATE TABLE FEEDBACK_TAGS
+(
+    FeedbackId INT NOT NULL,
+    TagId INT NOT NULL,
+
+    CONSTRAINT pk_feedback_tags PRIMARY KEY (FeedbackId, TagId),
+    CONSTRAINT fk_feedback_tags_feedback FOREIGN KEY
+    (FeedbackId) REFERENCES FEEDBACK
+    (Id),
+    CONSTRAINT fk_feedback_tags_tag FOREIGN KEY
+    (TagId) REFERENCES FEEDBACK_TAG
+    (Id) ON DELETE CASCADE
+);
+
+CREATE INDEX feedback_tags_tag_idx ON FEEDBACK_TAGS (TagId);
+
+ALTER TABLE FEEDBACK_CATEGORY ENABLE ROW LEVEL SECURITY;
+CREATE POLICY feedback_category_tenant ON FEEDBACK_CATEGORY
+    USING (CompanyId IN (SELECT app_user_companies()));
+
+ALTER TABLE FEEDBACK_TAG ENABLE ROW LEVEL SECURITY;
+CREATE POLICY feedback_tag_tenant ON FEEDBACK_TAG
+    USING (CompanyId IN (SELECT app_user_companies()));
+
+ALTER TABLE FEEDBACK_TAGS ENABLE ROW LEVEL SECURITY;
+CREATE POLICY feedback_tags_tenant ON FEEDBACK_TAGS
+    USING (EXISTS (SELECT 1 FROM FEEDBACK as f WHERE f.Id = FEEDBACK_TAGS.FeedbackId));
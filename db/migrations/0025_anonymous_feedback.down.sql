@@ -0,0 +1,19 @@
+UPDATE FEEDBACK_STATUS_HISTORY as h
+SET UserId=a.UserId
+FROM FEEDBACK_AUTHOR as a
+WHERE a.FeedbackId=h.FeedbackId AND h.UserId IS NULL;
+
+ALTER TABLE FEEDBACK_STATUS_HISTORY
+    ALTER COLUMN UserId SET NOT NULL;
+
+UPDATE FEEDBACK as f
+SET UserID=a.UserId
+FROM FEEDBACK_AUTHOR as a
+WHERE a.FeedbackId=f.Id AND f.UserID IS NULL;
+
+ALTER TABLE FEEDBACK
+    DROP CONSTRAINT chk_feedback_anonymous,
+    DROP COLUMN Anonymous,
+    ALTER COLUMN UserID SET NOT NULL;
+
+DROP TABLE FEEDBACK_AUTHOR;
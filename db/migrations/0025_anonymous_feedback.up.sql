@@ -0,0 +1,34 @@
+-- Authors are kept apart from their feedback, so anonymous feedback has no
+-- UserID for anyone to read. Users only ever see the rows of their own
+-- feedback, which lets them edit and delete it and limits how much they post
+CREATE TABLE FEEDBACK_AUTHOR
+(
+    FeedbackId INT PRIMARY KEY,
+    UserId INT NOT NULL,
+
+    CONSTRAINT fk_feedback_author_feedback FOREIGN KEY
+    (FeedbackId) REFERENCES FEEDBACK
+    (Id) ON DELETE CASCADE,
+    CONSTRAINT fk_feedback_author_user FOREIGN KEY
+    (UserId) REFERENCES USERS
+    (ID)
+);
+
+CREATE INDEX feedback_author_user_idx ON FEEDBACK_AUTHOR (UserId);
+
+INSERT INTO FEEDBACK_AUTHOR(FeedbackId, UserId)
+SELECT Id, UserID
+FROM FEEDBACK;
+
+ALTER TABLE FEEDBACK
+    ALTER COLUMN UserID DROP NOT NULL,
+    ADD COLUMN Anonymous BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD CONSTRAINT chk_feedback_anonymous CHECK (NOT Anonymous OR UserID IS NULL);
+
+-- Anonymous feedback starts out without a user in its status history
+ALTER TABLE FEEDBACK_STATUS_HISTORY
+    ALTER COLUMN UserId DROP NOT NULL;
+
+ALTER TABLE FEEDBACK_AUTHOR ENABLE ROW LEVEL SECURITY;
+CREATE POLICY feedback_author_own ON FEEDBACK_AUTHOR
+    USING (UserId = app_user_id());
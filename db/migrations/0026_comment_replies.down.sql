@@ -0,0 +1,5 @@
+DROP INDEX comments_feedback_idx;
+
+ALTER TABLE COMMENTS
+    DROP CONSTRAINT fk_comment_parent,
+    DROP COLUMN ParentId;
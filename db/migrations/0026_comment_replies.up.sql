@@ -0,0 +1,6 @@
+-- Replies point to the comment they answer, on the same feedback
+ALTER TABLE COMMENTS
+    ADD COLUMN ParentId INT,
+    ADD CONSTRAINT fk_comment_parent FOREIGN KEY (ParentId) REFERENCES COMMENTS (Id);
+
+CREATE INDEX comments_feedback_idx ON COMMENTS (FeedbackId, Id);
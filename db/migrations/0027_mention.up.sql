@@ -0,0 +1,68 @@
+-- Members mentioned with @username in the description of feedback, or in one
+-- of its comments when CommentId is set. Start and Length are the character
+-- offsets of the mention in the text
+CREATE TABLE MENTION
+(
+    Id SERIAL PRIMARY KEY,
+    FeedbackId INT NOT NULL,
+    CommentId INT,
+    UserId INT NOT NULL,
+    Start INT NOT NULL,
+    Length INT NOT NULL,
+
+    CONSTRAINT fk_mention_feedback FOREIGN KEY
+    (FeedbackId) REFERENCES FEEDBACK
+    (Id) ON DELETE CASCADE,
+    CONSTRAINT fk_mention_comment FOREIGN KEY
+    (CommentId) REFERENCES COMMENTS
+    (Id) ON DELETE CASCADE,
+    CONSTRAINT fk_mention_user FOREIGN KEY
+    (UserId) REFERENCES USERS
+    (ID) ON DELETE CASCADE
+);
+
+CREATE INDEX mention_feedback_idx ON MENTION (FeedbackId, CommentId);
+
+CREATE TABLE NOTIFICATION
+(
+    Id SERIAL PRIMARY KEY,
+    UserId INT NOT NULL,
+    CompanyId INT NOT NULL,
+    Kind VARCHAR(32) NOT NULL,
+    FeedbackId INT NOT NULL,
+    CommentId INT,
+    -- No actor for mentions in anonymous feedback
+    ActorId INT,
+    CreatedAt TIMESTAMP
+    WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ReadAt TIMESTAMP
+    WITH TIME ZONE,
+
+    CONSTRAINT fk_notification_user FOREIGN KEY
+    (UserId) REFERENCES USERS
+    (ID) ON DELETE CASCADE,
+    CONSTRAINT fk_notification_company FOREIGN KEY
+    (CompanyId) REFERENCES COMPANY
+    (Id) ON DELETE CASCADE,
+    CONSTRAINT fk_notification_feedback FOREIGN KEY
+    (FeedbackId) REFERENCES FEEDBACK
+    (Id) ON DELETE CASCADE,
+    CONSTRAINT fk_notification_comment FOREIGN KEY
+    (CommentId) REFERENCES COMMENTS
+    (Id) ON DELETE CASCADE
+);
+
+CREATE INDEX notification_user_idx ON NOTIFICATION (UserId, Id);
+
+ALTER TABLE MENTION ENABLE ROW LEVEL SECURITY;
+CREATE POLICY mention_tenant ON MENTION
+    USING (EXISTS (SELECT 1 FROM FEEDBACK as f WHERE f.Id = MENTION.FeedbackId));
+
+-- Users only read their own notifications, but notify the other members of
+-- their companies
+ALTER TABLE NOTIFICATION ENABLE ROW LEVEL SECURITY;
+CREATE POLICY notification_own ON NOTIFICATION
+    USING (UserId = app_user_id());
+CREATE POLICY notification_notify ON NOTIFICATION
+    FOR INSERT
+    WITH CHECK (CompanyId IN (SELECT app_user_companies()));
@@ -0,0 +1,11 @@
+DROP POLICY user_company_delete ON USER_COMPANY;
+DROP POLICY user_company_update ON USER_COMPANY;
+DROP POLICY user_company_insert ON USER_COMPANY;
+DROP POLICY user_company_select ON USER_COMPANY;
+
+DROP FUNCTION app_user_can_join(INT, INT);
+DROP FUNCTION app_user_owns_company(INT);
+DROP FUNCTION app_user_has_permission(INT, VARCHAR);
+
+CREATE POLICY user_company_tenant ON USER_COMPANY
+    USING (UserId = app_user_id() OR CompanyId IN (SELECT app_user_companies()));
@@ -0,0 +1,79 @@
+-- The policy on USER_COMPANY applied to writes as well, so users could add
+-- themselves to any company or change their own role. Memberships are now
+-- read as before, but only changed by members who manage the members of the
+-- company, or by users joining in one of the ways they are allowed to
+DROP POLICY user_company_tenant ON USER_COMPANY;
+
+-- The functions run as the owner of the tables so the policies on
+-- USER_COMPANY can use them without applying themselves
+CREATE FUNCTION app_user_has_permission(company_id INT, wanted VARCHAR) RETURNS BOOLEAN AS $$
+    SELECT EXISTS (
+        SELECT 1
+        FROM USER_COMPANY as uc
+        INNER JOIN ROLE_PERMISSION as rp
+        ON rp.RoleId = uc.RoleId
+        WHERE uc.CompanyId = company_id AND uc.UserId = app_user_id() AND rp.Permission = wanted
+    )
+$$ LANGUAGE SQL STABLE SECURITY DEFINER SET search_path = public;
+
+CREATE FUNCTION app_user_owns_company(company_id INT) RETURNS BOOLEAN AS $$
+    SELECT EXISTS (
+        SELECT 1
+        FROM COMPANY as c
+        WHERE c.Id = company_id AND c.OwnerId = app_user_id()
+    )
+$$ LANGUAGE SQL STABLE SECURITY DEFINER SET search_path = public;
+
+-- Users join by themselves as admin of the company they created, with the
+-- role of a pending invitation to their email, or with the role of a domain
+-- the company has verified for their verified email
+CREATE FUNCTION app_user_can_join(company_id INT, role_id INT) RETURNS BOOLEAN AS $$
+    SELECT (role_id = 1 AND app_user_owns_company(company_id))
+    OR EXISTS (
+        SELECT 1
+        FROM COMPANY_INVITATIONS as i
+        INNER JOIN USERS as u
+        ON LOWER(u.Email) = LOWER(i.Email)
+        WHERE i.CompanyId = company_id AND i.RoleId = role_id AND u.ID = app_user_id()
+        AND i.AcceptedAt IS NULL AND i.RevokedAt IS NULL AND i.ExpiresAt > NOW()
+    )
+    OR EXISTS (
+        SELECT 1
+        FROM COMPANY_DOMAINS as d
+        INNER JOIN USERS as u
+        ON d.Domain = RTRIM(LOWER(SUBSTRING(u.Email FROM '@([^@]*)$')), '.')
+        WHERE d.CompanyId = company_id AND d.RoleId = role_id AND u.ID = app_user_id()
+        AND d.VerifiedAt IS NOT NULL AND u.EmailVerifiedAt IS NOT NULL
+    )
+$$ LANGUAGE SQL STABLE SECURITY DEFINER SET search_path = public;
+
+CREATE POLICY user_company_select ON USER_COMPANY
+    FOR SELECT
+    USING (UserId = app_user_id() OR CompanyId IN (SELECT app_user_companies()));
+
+CREATE POLICY user_company_insert ON USER_COMPANY
+    FOR INSERT
+    WITH CHECK (
+        app_user_has_permission(CompanyId, 'members.manage')
+        OR (UserId = app_user_id() AND app_user_can_join(CompanyId, RoleId))
+    );
+
+-- The owner makes themselves admin when accepting the ownership
+CREATE POLICY user_company_update ON USER_COMPANY
+    FOR UPDATE
+    USING (
+        app_user_has_permission(CompanyId, 'members.manage')
+        OR (UserId = app_user_id() AND app_user_owns_company(CompanyId))
+    )
+    WITH CHECK (
+        app_user_has_permission(CompanyId, 'members.manage')
+        OR (UserId = app_user_id() AND RoleId = 1 AND app_user_owns_company(CompanyId))
+    );
+
+-- Deleting the company removes all of its members
+CREATE POLICY user_company_delete ON USER_COMPANY
+    FOR DELETE
+    USING (
+        app_user_has_permission(CompanyId, 'members.manage')
+        OR app_user_has_permission(CompanyId, 'company.settings')
+    );
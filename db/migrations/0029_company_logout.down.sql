@@ -0,0 +1,2 @@
+ALTER TABLE USER_COMPANY
+    DROP COLUMN LoggedOutAt;
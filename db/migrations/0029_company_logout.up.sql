@@ -0,0 +1,6 @@
+-- Admins log a member out of one company by setting LoggedOutAt. Sessions
+-- and personal access tokens created before it are no longer authorized in
+-- the company, while the member stays logged in to their other companies
+ALTER TABLE USER_COMPANY
+    ADD COLUMN LoggedOutAt TIMESTAMP
+    WITH TIME ZONE;
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/kristohberg/CreatixBackend/web"
+	"github.com/labstack/echo"
+)
+
+var (
+	POSTAccessTokenPath   = "/user/tokens"
+	GETAccessTokensPath   = "/user/tokens"
+	DELETEAccessTokenPath = "/user/tokens/:id"
+)
+
+// AccessTokenHandler registers the personal access token routes. They are
+// not opened to personal access tokens, so a token cannot create new tokens
+func (api RestAPI) AccessTokenHandler(e *echo.Group) {
+	e.POST(POSTAccessTokenPath, api.CreateAccessToken)
+	e.GET(GETAccessTokensPath, api.GetAccessTokens)
+	e.DELETE(DELETEAccessTokenPath, api.RevokeAccessToken)
+}
+
+// CreateAccessToken creates a personal access token. The token is only
+// returned in this response
+func (api RestAPI) CreateAccessToken(c echo.Context) error {
+	userID, err := utils.GetUserIDFromContext(c)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.token.create: could not get user", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	tokenRequest := new(models.AccessTokenRequest)
+	if err = c.Bind(tokenRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.token.create: could not bind request", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "invalid body"})
+	}
+
+	if err = tokenRequest.Valid(); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	resp, err := api.SessionClient.CreateAccessToken(c.Request().Context(), userID, *tokenRequest)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.token.create: not able to create token", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// GetAccessTokens lists the active personal access tokens of the user
+func (api RestAPI) GetAccessTokens(c echo.Context) error {
+	userID, err := utils.GetUserIDFromContext(c)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.token.list: could not get user", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	accessTokens, err := api.SessionClient.GetAccessTokens(c.Request().Context(), userID)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.token.list: not able to get tokens", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, accessTokens)
+}
+
+// RevokeAccessToken revokes one of the user's personal access tokens
+func (api RestAPI) RevokeAccessToken(c echo.Context) error {
+	userID, err := utils.GetUserIDFromContext(c)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.token.revoke: could not get user", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	tokenID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError("access token not found"))
+	}
+
+	err = api.SessionClient.RevokeAccessToken(c.Request().Context(), userID, tokenID)
+	if err == models.AccessTokenNotFoundError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError("access token not found"))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.token.revoke: not able to revoke token", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "revoked"})
+}
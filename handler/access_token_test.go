@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createAccessToken(t *testing.T, e *echo.Echo, restAPI RestAPI, userID string, scopes ...models.TokenScope) models.NewAccessTokenResponse {
+	tokenByte, err := json.Marshal(models.AccessTokenRequest{Name: "ci", Scopes: scopes})
+	require.NoError(t, err)
+	c, rec := newContext(e, tokenByte, POSTAccessTokenPath)
+	c.Set(utils.UserIDContext.String(), userID)
+	require.NoError(t, restAPI.CreateAccessToken(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp models.NewAccessTokenResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.True(t, strings.HasPrefix(resp.Token, models.AccessTokenPrefix))
+	return resp
+}
+
+// serveWithBearer sends the request through the router so the middleware runs
+func serveWithBearer(e *echo.Echo, method, path, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader("{}"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAccessToken(t *testing.T) {
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	restAPI.Handler(e.Group("/v0"))
+
+	// Unknown scopes are rejected
+	tokenByte, err := json.Marshal(models.AccessTokenRequest{Name: "ci", Scopes: []models.TokenScope{"everything"}})
+	require.NoError(t, err)
+	c, rec := newContext(e, tokenByte, POSTAccessTokenPath)
+	c.Set(utils.UserIDContext.String(), "1")
+	require.NoError(t, restAPI.CreateAccessToken(c))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	readToken := createAccessToken(t, e, restAPI, "1", models.ScopeFeedbackRead)
+
+	// A read only token can list but not post feedback
+	rec = serveWithBearer(e, http.MethodGet, "/v0/user/1/feedback", readToken.Token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serveWithBearer(e, http.MethodPost, "/v0/user/1/feedback", readToken.Token)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// Tokens cannot manage tokens
+	rec = serveWithBearer(e, http.MethodGet, "/v0"+GETAccessTokensPath, readToken.Token)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// The token is listed with its last use
+	c, rec = newContext(e, nil, GETAccessTokensPath)
+	c.Set(utils.UserIDContext.String(), "1")
+	require.NoError(t, restAPI.GetAccessTokens(c))
+	var accessTokens []models.AccessToken
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &accessTokens))
+	require.Len(t, accessTokens, 1)
+	assert.Equal(t, readToken.ID, accessTokens[0].ID)
+	assert.NotNil(t, accessTokens[0].LastUsedAt)
+
+	// Other users cannot revoke the token
+	c, rec = newContext(e, nil, DELETEAccessTokenPath)
+	c.Set(utils.UserIDContext.String(), "2")
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.Itoa(readToken.ID))
+	require.NoError(t, restAPI.RevokeAccessToken(c))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	c, rec = newContext(e, nil, DELETEAccessTokenPath)
+	c.Set(utils.UserIDContext.String(), "1")
+	c.SetParamNames("id")
+	c.SetParamValues(strconv.Itoa(readToken.ID))
+	require.NoError(t, restAPI.RevokeAccessToken(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serveWithBearer(e, http.MethodGet, "/v0/user/1/feedback", readToken.Token)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Session tokens are accepted in the Authorization header
+	resp, err := restAPI.SessionClient.LoginExternalUser(c.Request().Context(), models.ExternalIdentity{Provider: "test", Subject: "1", Email: "kristoffer@berg.no", EmailVerified: true})
+	require.NoError(t, err)
+	rec = serveWithBearer(e, http.MethodGet, "/v0"+GETAccessTokensPath, resp.Token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/kristohberg/CreatixBackend/web"
+	"github.com/labstack/echo"
+)
+
+var (
+	GETActiveSessionsPath     = "/user/sessions"
+	DELETEActiveSessionPath   = "/user/sessions/:session"
+	DELETEOtherSessionsPath   = "/user/sessions"
+	POSTForceLogoutMemberPath = "/company/:company/user/:userid/logout"
+)
+
+func (api RestAPI) ActiveSessionHandler(e *echo.Group) {
+	e.GET(GETActiveSessionsPath, api.GetActiveSessions)
+	e.DELETE(DELETEActiveSessionPath, api.RevokeActiveSession)
+	e.DELETE(DELETEOtherSessionsPath, api.RevokeOtherSessions)
+	e.POST(POSTForceLogoutMemberPath, api.ForceLogoutMember)
+	e.GET(GETSecurityLogPath, api.GetSecurityLog)
+}
+
+// GetActiveSessions lists the devices the user is logged in on
+func (api RestAPI) GetActiveSessions(c echo.Context) error {
+	userID, err := utils.GetUserIDFromContext(c)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.sessions.list: could not get user", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+	sessionID, _ := utils.GetSessionIDFromContext(c)
+
+	sessions, err := api.SessionClient.GetActiveSessions(c.Request().Context(), userID, sessionID)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.sessions.list: not able to get sessions", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeActiveSession signs the user out of one of their sessions
+func (api RestAPI) RevokeActiveSession(c echo.Context) error {
+	userID, err := utils.GetUserIDFromContext(c)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.sessions.revoke: could not get user", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	if _, err = uuid.Parse(c.Param("session")); err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError("session not found"))
+	}
+
+	err = api.SessionClient.RevokeUserSession(c.Request().Context(), userID, c.Param("session"))
+	if err == models.SessionNotFoundError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError("session not found"))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.sessions.revoke: not able to revoke session", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "revoked"})
+}
+
+// RevokeOtherSessions signs the user out everywhere except the current session
+func (api RestAPI) RevokeOtherSessions(c echo.Context) error {
+	userID, err := utils.GetUserIDFromContext(c)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.sessions.revokeothers: could not get user", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	sessionID, err := utils.GetSessionIDFromContext(c)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.sessions.revokeothers: could not get session", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	if err = api.SessionClient.RevokeOtherSessions(c.Request().Context(), userID, sessionID); err != nil {
+		api.Logging.Unsuccessful("creatix.sessions.revokeothers: not able to revoke sessions", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "revoked"})
+}
+
+// ForceLogoutMember lets a company admin log a member out of the company, for
+// example when their account is compromised. Only members whose permissions
+// the admin has can be logged out
+func (api RestAPI) ForceLogoutMember(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.MembersManage)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.sessions.forcelogout: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	canManage, err := api.CompanyClient.CanManageMember(c.Request().Context(), c.Param("company"), userID, c.Param("userid"))
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.sessions.forcelogout: could not check member", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if !canManage {
+		return c.JSON(http.StatusForbidden, utils.NewWebError(models.MemberNotManageableError.Error()))
+	}
+
+	err = api.SessionClient.ForceLogoutMember(utils.WithClientInfo(c), c.Param("company"), c.Param("userid"))
+	if err == models.NotCompanyMemberError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError("user is not a member of the company"))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.sessions.forcelogout: not able to log out member", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "logged out"})
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loginFromDevice logs the user in with the given user agent and returns the
+// access token
+func loginFromDevice(t *testing.T, e *echo.Echo, sessionAPI SessionAPI, user models.User, userAgent string) string {
+	loginRequestByte, err := json.Marshal(newLoginRequest(user))
+	require.NoError(t, err)
+	c, rec := newContext(e, loginRequestByte, "/")
+	c.Request().Header.Set("User-Agent", userAgent)
+	require.NoError(t, sessionAPI.Login(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	return findCookie(rec.Result().Cookies(), accessTokenCookie).Value
+}
+
+func TestActiveSessions(t *testing.T) {
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	restAPI.Handler(e.Group("/v0"))
+	sessionAPI := NewSessionAPI(db, logger)
+	sessionAPI.SessionClient = restAPI.SessionClient
+
+	user := models.User{Firstname: "John", Lastname: "Doe", Username: "johndoe", Email: "john@doe.com", Password: "MyPassword@123"}
+	require.NoError(t, sessionAPI.SessionClient.CreateUser(context.Background(), models.Signup{User: user}))
+	laptopToken := loginFromDevice(t, e, sessionAPI, user, "laptop")
+	phoneToken := loginFromDevice(t, e, sessionAPI, user, "phone")
+
+	claims, err := utils.GetClaims(laptopToken, restAPI.SessionClient.Keyring)
+	require.NoError(t, err)
+
+	// Both devices are listed and the current one is marked
+	rec := serveWithBearer(e, http.MethodGet, "/v0"+GETActiveSessionsPath, laptopToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	var sessions []models.ActiveSession
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &sessions))
+	require.Len(t, sessions, 2)
+	for _, session := range sessions {
+		assert.Equal(t, session.ID == claims.SessionID, session.Current)
+		assert.Equal(t, map[bool]string{true: "laptop", false: "phone"}[session.Current], session.Device)
+		assert.NotEmpty(t, session.IP)
+	}
+
+	// Signing out the other sessions rejects the phone on its next request
+	rec = serveWithBearer(e, http.MethodDelete, "/v0"+DELETEOtherSessionsPath, laptopToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = serveWithBearer(e, http.MethodGet, "/v0"+GETActiveSessionsPath, phoneToken)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = serveWithBearer(e, http.MethodGet, "/v0"+GETActiveSessionsPath, laptopToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// A single session can be revoked from another device
+	tabletToken := loginFromDevice(t, e, sessionAPI, user, "tablet")
+	rec = serveWithBearer(e, http.MethodDelete, "/v0/user/sessions/"+claims.SessionID, tabletToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serveWithBearer(e, http.MethodGet, "/v0"+GETActiveSessionsPath, laptopToken)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestForceLogoutMember(t *testing.T) {
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	restAPI.Handler(e.Group("/v0"))
+	sessionAPI := NewSessionAPI(db, logger)
+	sessionAPI.SessionClient = restAPI.SessionClient
+
+	member := models.User{Firstname: "John", Lastname: "Doe", Username: "johndoe", Email: "john@doe.com", Password: "MyPassword@123"}
+	require.NoError(t, sessionAPI.SessionClient.CreateUser(context.Background(), models.Signup{User: member}))
+	memberUser, err := utils.FindUserByEmail(context.Background(), db, member.Email)
+	require.NoError(t, err)
+	memberToken := loginFromDevice(t, e, sessionAPI, member, "laptop")
+
+	forceLogout := func(adminID, memberID string) int {
+		c, rec := newContext(e, nil, POSTForceLogoutMemberPath)
+		c.Set(utils.UserIDContext.String(), adminID)
+		c.SetParamNames("company", "userid")
+		c.SetParamValues("1", memberID)
+		require.NoError(t, restAPI.ForceLogoutMember(c))
+		return rec.Code
+	}
+
+	// Only members of the company can be logged out
+	assert.Equal(t, http.StatusNotFound, forceLogout("1", memberUser.ID))
+
+	_, err = db.Exec("UPDATE USERS SET EmailVerifiedAt=NOW() WHERE ID=$1", memberUser.ID)
+	require.NoError(t, err)
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(context.Background(), "1", models.AddUser{Email: member.Email, Access: models.Read}))
+
+	// Members cannot log out other members
+	assert.Equal(t, http.StatusUnauthorized, forceLogout(memberUser.ID, "1"))
+
+	companyFeedback := "/v0/company/1/feedback"
+	rec := serveWithBearer(e, http.MethodGet, companyFeedback, memberToken)
+	require.Equal(t, http.StatusOK, rec.Code)
+	otherCompanyID, err := restAPI.CompanyClient.CreateCompany(context.Background(), "OtherCorp", memberUser.ID)
+	require.NoError(t, err)
+	otherFeedback := fmt.Sprintf("/v0/company/%d/feedback", *otherCompanyID)
+
+	// The member is logged out of the company, but not of their other
+	// companies, and the admin is recorded in the audit log
+	assert.Equal(t, http.StatusOK, forceLogout("1", memberUser.ID))
+	rec = serveWithBearer(e, http.MethodGet, companyFeedback, memberToken)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = serveWithBearer(e, http.MethodGet, otherFeedback, memberToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var actorID string
+	err = db.QueryRow("SELECT ActorId FROM AUDIT_EVENTS WHERE Action=$1 AND TargetId=$2 AND CompanyId=1", models.AuditMemberLoggedOut, memberUser.ID).Scan(&actorID)
+	require.NoError(t, err)
+	assert.Equal(t, "1", actorID)
+
+	// Logging in again gives access to the company again
+	memberToken = loginFromDevice(t, e, sessionAPI, member, "laptop")
+	rec = serveWithBearer(e, http.MethodGet, companyFeedback, memberToken)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Members who manage members cannot log out those with more permissions
+	_, err = restAPI.CompanyClient.CreateRole(context.Background(), "1", models.RoleRequest{Name: "Manager", Permissions: []models.Permission{models.FeedbackRead, models.MembersManage}})
+	require.NoError(t, err)
+	require.NoError(t, restAPI.CompanyClient.UpdateUserPermission(context.Background(), "1", models.UserPermissionRequest{UserID: memberUser.ID, Access: "Manager"}))
+	assert.Equal(t, http.StatusForbidden, forceLogout(memberUser.ID, "1"))
+}
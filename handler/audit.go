@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/labstack/echo"
+)
+
+var (
+	GETAuditLogPath    = "/company/:company/audit"
+	GETAuditExportPath = "/company/:company/audit/export"
+	GETSecurityLogPath = "/user/security-log"
+)
+
+func auditFilterRequest(c echo.Context) models.AuditFilterRequest {
+	return models.AuditFilterRequest{
+		Actor:  c.QueryParam("actor"),
+		Action: c.QueryParam("action"),
+		From:   c.QueryParam("from"),
+		To:     c.QueryParam("to"),
+		Before: c.QueryParam("before"),
+		Limit:  c.QueryParam("limit"),
+	}
+}
+
+// GetAuditLog gets a page of the audit log of the company, newest first. The
+// log can be filtered by actor, action and time with the query parameters
+func (api RestAPI) GetAuditLog(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.AuditRead)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.audit.log: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	filter, err := auditFilterRequest(c).Filter(models.CompanyAuditActions)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	log, err := api.CompanyClient.GetAuditLog(c.Request().Context(), c.Param("company"), filter)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.audit.log: not able to get audit log", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, log)
+}
+
+// GetSecurityLog gets a page of the logins, failed logins and logouts of the
+// user, newest first. It is filtered like the audit log of a company
+func (api RestAPI) GetSecurityLog(c echo.Context) error {
+	userID, err := utils.GetUserIDFromContext(c)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.audit.security: could not get user", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	filter, err := auditFilterRequest(c).Filter(models.SecurityLogActions)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	log, err := api.SessionClient.GetSecurityLog(c.Request().Context(), userID, filter)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.audit.security: not able to get security log", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, log)
+}
+
+var auditExportHeader = []string{"id", "createdAt", "action", "actorId", "targetType", "targetId", "companyId", "ip", "userAgent", "before", "after"}
+
+// ExportAuditLog downloads the audit log of the company matching the filter
+// as csv
+func (api RestAPI) ExportAuditLog(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.AuditRead)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.audit.export: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	filter, err := auditFilterRequest(c).Filter(models.CompanyAuditActions)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	companyID := c.Param("company")
+	events, err := api.CompanyClient.ExportAuditLog(c.Request().Context(), companyID, filter)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.audit.export: not able to get audit log", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="audit-`+companyID+`.csv"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(c.Response())
+	if err = w.Write(auditExportHeader); err != nil {
+		return err
+	}
+	for _, event := range events {
+		err = w.Write([]string{
+			strconv.FormatInt(event.ID, 10),
+			event.CreatedAt.UTC().Format(time.RFC3339),
+			string(event.Action),
+			event.ActorID,
+			event.TargetType,
+			event.TargetID,
+			event.CompanyID,
+			event.IP,
+			event.UserAgent,
+			string(event.Before),
+			string(event.After),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
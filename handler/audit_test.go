@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	sessionAPI := NewSessionAPI(db, logger)
+	ctx := context.Background()
+
+	auditLog := func(query string) models.AuditLog {
+		code, body := callHandlerWithQuery(t, restAPI, restAPI.GetAuditLog, "1", query, nil, "1")
+		require.Equal(t, http.StatusOK, code, string(body))
+		var log models.AuditLog
+		require.NoError(t, json.Unmarshal(body, &log))
+		return log
+	}
+
+	// The admin adds John, makes him an admin and removes him again
+	code, _ := callHandler(t, restAPI, restAPI.AddUserByEmailToCompany, "1", models.AddUser{Email: "john@doe.no", Access: models.Write}, "1")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.ChangeUserPermission, "1", models.UserPermissionRequest{UserID: "2", Access: models.Admin}, "1")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.DeleteCompanyUser, "1", nil, "1", "2")
+	require.Equal(t, http.StatusOK, code)
+
+	events := auditLog("").Events
+	require.Len(t, events, 3)
+	assert.Equal(t, models.AuditMemberRemoved, events[0].Action)
+	assert.JSONEq(t, `{"role":"admin"}`, string(events[0].Before))
+	assert.Empty(t, events[0].After)
+	assert.Equal(t, models.AuditMemberRole, events[1].Action)
+	assert.JSONEq(t, `{"role":"write"}`, string(events[1].Before))
+	assert.JSONEq(t, `{"role":"admin"}`, string(events[1].After))
+	assert.Equal(t, models.AuditMemberAdded, events[2].Action)
+	assert.JSONEq(t, `{"role":"write"}`, string(events[2].After))
+	for _, event := range events {
+		assert.Equal(t, "1", event.ActorID)
+		assert.Equal(t, "user", event.TargetType)
+		assert.Equal(t, "2", event.TargetID)
+		assert.Equal(t, "1", event.CompanyID)
+		assert.Equal(t, testUserAgent, event.UserAgent)
+		assert.NotEmpty(t, event.IP)
+	}
+
+	// Logins and logouts are not about a company, so they are left out of its
+	// log and only shown to the user in their security log
+	member := models.User{Firstname: "Jane", Lastname: "Doe", Username: "janedoe", Email: "jane@doe.com", Password: "MyPassword@123"}
+	require.NoError(t, sessionAPI.SessionClient.CreateUser(ctx, models.Signup{User: member}))
+	memberUser, err := utils.FindUserByEmail(ctx, db, member.Email)
+	require.NoError(t, err)
+	_, err = db.Exec("UPDATE USERS SET EmailVerifiedAt=NOW() WHERE ID=$1", memberUser.ID)
+	require.NoError(t, err)
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: member.Email, Access: models.Read}))
+
+	wrongPassword := member
+	wrongPassword.Password = "wrong"
+	loginRequestByte, err := json.Marshal(newLoginRequest(wrongPassword))
+	require.NoError(t, err)
+	c, rec := newContext(e, loginRequestByte, "/")
+	require.NoError(t, sessionAPI.Login(c))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	loginRequestByte, err = json.Marshal(newLoginRequest(member))
+	require.NoError(t, err)
+	c, rec = newContext(e, loginRequestByte, "/")
+	c.Request().Header.Set("User-Agent", "member-laptop")
+	require.NoError(t, sessionAPI.Login(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	c, rec = newContextWithCookies(e, nil, "/", rec.Result().Cookies())
+	require.NoError(t, sessionAPI.Logout(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var sessionEvents int
+	err = db.QueryRow("SELECT COUNT(*) FROM AUDIT_EVENTS WHERE CompanyId IS NULL AND TargetId=$1", memberUser.ID).Scan(&sessionEvents)
+	require.NoError(t, err)
+	assert.Equal(t, 3, sessionEvents)
+	assert.Empty(t, auditLog("actor="+memberUser.ID).Events)
+	code, _ = callHandlerWithQuery(t, restAPI, restAPI.GetAuditLog, "1", "action="+string(models.AuditLoginFailed), nil, "1")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	securityLog := func(userID, query string) models.AuditLog {
+		code, body := callHandlerWithQuery(t, restAPI, restAPI.GetSecurityLog, userID, query, nil)
+		require.Equal(t, http.StatusOK, code, string(body))
+		var log models.AuditLog
+		require.NoError(t, json.Unmarshal(body, &log))
+		return log
+	}
+	events = securityLog(memberUser.ID, "").Events
+	require.Len(t, events, 3)
+	assert.Equal(t, models.AuditLogout, events[0].Action)
+	assert.Equal(t, models.AuditLogin, events[1].Action)
+	assert.Equal(t, "member-laptop", events[1].UserAgent)
+	assert.Equal(t, models.AuditLoginFailed, events[2].Action)
+	for _, event := range events {
+		assert.Equal(t, memberUser.ID, event.TargetID)
+		assert.Empty(t, event.CompanyID)
+	}
+	assert.Len(t, securityLog(memberUser.ID, "action="+string(models.AuditLogin)).Events, 1)
+	assert.Empty(t, securityLog("1", "").Events)
+	code, _ = callHandlerWithQuery(t, restAPI, restAPI.GetSecurityLog, memberUser.ID, "action="+string(models.AuditMemberAdded), nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Filters on the date
+	today := time.Now().UTC()
+	assert.Len(t, auditLog("from="+today.Format("2006-01-02")+"&to="+today.Format("2006-01-02")).Events, 4)
+	assert.Empty(t, auditLog("from="+today.AddDate(0, 0, 1).Format("2006-01-02")).Events)
+	assert.Empty(t, auditLog("to="+today.AddDate(0, 0, -1).Format(time.RFC3339)).Events)
+
+	code, _ = callHandlerWithQuery(t, restAPI, restAPI.GetAuditLog, "1", "action=everything&from=yesterday&limit=1000", nil, "1")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Pages follow each other without gaps
+	page := auditLog("limit=3")
+	require.Len(t, page.Events, 3)
+	require.NotZero(t, page.NextCursor)
+	next := auditLog("limit=3&before=" + strconv.FormatInt(page.NextCursor, 10))
+	require.Len(t, next.Events, 1)
+	assert.Zero(t, next.NextCursor)
+	assert.Equal(t, page.Events[2].ID-1, next.Events[0].ID)
+
+	// The export has a row for each event
+	code, body := callHandlerWithQuery(t, restAPI, restAPI.ExportAuditLog, "1", "action="+string(models.AuditMemberAdded), nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	rows, err := csv.NewReader(strings.NewReader(string(body))).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, "id", rows[0][0])
+	assert.Equal(t, string(models.AuditMemberAdded), rows[1][2])
+	assert.Equal(t, memberUser.ID, rows[1][5])
+
+	// Only admins read the log
+	code, _ = callHandler(t, restAPI, restAPI.GetAuditLog, memberUser.ID, nil, "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = callHandler(t, restAPI, restAPI.ExportAuditLog, memberUser.ID, nil, "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// Other companies do not see the events
+	otherCompanyID, err := restAPI.CompanyClient.CreateCompany(ctx, "OtherCorp", "3")
+	require.NoError(t, err)
+	otherLog, err := restAPI.CompanyClient.GetAuditLog(ctx, strconv.FormatInt(*otherCompanyID, 10), models.AuditFilter{Limit: 50})
+	require.NoError(t, err)
+	assert.Empty(t, otherLog.Events)
+
+	// The log cannot be changed
+	_, err = db.Exec("UPDATE AUDIT_EVENTS SET ActorId=3")
+	assert.Error(t, err)
+	_, err = db.Exec("DELETE FROM AUDIT_EVENTS")
+	assert.Error(t, err)
+	assert.Len(t, auditLog("").Events, 4)
+}
@@ -13,17 +13,63 @@ import (
 var (
 	AddNewUserByEmailToCompanyPath = "/company/:company/adduser"
 	POSTCreateNewCompanyPath       = "/company/create"
+	GETSearchCompanyPath           = "/company/search/:query"
 )
 
 func (api RestAPI) CompanyHandler(e *echo.Group) {
-	e.GET("/company/search/{query}", api.SearchCompany)
-
-	e.POST(POSTCreateNewCompanyPath, api.CreateCompany)
-	e.POST(AddNewUserByEmailToCompanyPath, api.AddUserByEmailToCompany)
-	e.POST("/company/:company/permission", api.ChangeUserPermission)
-	e.GET("/company/:company/users", api.GetCompanyUsers)
-	e.GET("/user/companies", api.GetUserCompanies)
-	e.DELETE("/company/:company/user/:userid", api.DeleteCompanyUser)
+	api.Middleware.RequireScope(models.ScopeCompanyRead, e.GET(GETSearchCompanyPath, api.SearchCompany))
+
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTCreateNewCompanyPath, api.CreateCompany))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(AddNewUserByEmailToCompanyPath, api.AddUserByEmailToCompany))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST("/company/:company/permission", api.ChangeUserPermission))
+	api.Middleware.RequireScope(models.ScopeCompanyRead, e.GET("/company/:company/users", api.GetCompanyUsers))
+	api.Middleware.RequireScope(models.ScopeCompanyRead, e.GET("/user/companies", api.GetUserCompanies))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.DELETE("/company/:company/user/:userid", api.DeleteCompanyUser))
+
+	api.Middleware.RequireScope(models.ScopeCompanyRead, e.GET(GETCompanyTeamsPath, api.GetCompanyTeams))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTTeamPath, api.CreateTeam))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.PUT(PUTTeamPath, api.RenameTeam))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.DELETE(DELETETeamPath, api.DeleteTeam))
+	api.Middleware.RequireScope(models.ScopeCompanyRead, e.GET(GETTeamMembersPath, api.GetTeamMembers))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTTeamMemberPath, api.AddTeamMember))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.PUT(PUTTeamMemberPath, api.UpdateTeamMember))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.DELETE(DELETETeamMemberPath, api.RemoveTeamMember))
+	api.Middleware.RequireScope(models.ScopeCompanyRead, e.GET(GETUserTeamsPath, api.GetUserTeams))
+
+	api.Middleware.RequireScope(models.ScopeCompanyRead, e.GET(GETPermissionsPath, api.GetPermissions))
+	api.Middleware.RequireScope(models.ScopeCompanyRead, e.GET(GETUserPermissionsPath, api.GetUserPermissions))
+	api.Middleware.RequireScope(models.ScopeCompanyRead, e.GET(GETCompanyRolesPath, api.GetCompanyRoles))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTRolePath, api.CreateRole))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.PUT(PUTRolePath, api.UpdateRole))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.DELETE(DELETERolePath, api.DeleteRole))
+
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTOwnershipTransferPath, api.OfferOwnership))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTAcceptOwnershipPath, api.AcceptOwnership))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.DELETE(DELETEOwnershipTransferPath, api.CancelOwnershipOffer))
+
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTJoinRequestPath, api.RequestToJoinCompany))
+	api.Middleware.RequireScope(models.ScopeCompanyRead, e.GET(GETJoinRequestsPath, api.GetJoinRequests))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTApproveJoinRequestPath, api.ApproveJoinRequest))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTDenyJoinRequestPath, api.DenyJoinRequest))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.PUT(PUTCompanyDiscoverablePath, api.SetCompanyDiscoverable))
+
+	api.Middleware.RequireScope(models.ScopeCompanyRead, e.GET(GETDomainsPath, api.GetDomains))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTDomainPath, api.CreateDomain))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTVerifyDomainPath, api.VerifyDomain))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.PUT(PUTDomainPath, api.UpdateDomain))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.DELETE(DELETEDomainPath, api.DeleteDomain))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTJoinDomainCompanyPath, api.JoinDomainCompany))
+
+	api.Middleware.RequireScope(models.ScopeCompanyRead, e.GET(GETCompanySettingsPath, api.GetCompanySettings))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.PUT(PUTCompanySettingsPath, api.UpdateCompanySettings))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.PUT(PUTCompanyNamePath, api.RenameCompany))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTArchiveCompanyPath, api.ArchiveCompany))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.DELETE(DELETEArchiveCompanyPath, api.RestoreCompany))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTCompanyDeletionPath, api.CreateCompanyDeletion))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.DELETE(DELETECompanyPath, api.DeleteCompany))
+
+	api.Middleware.RequireScope(models.ScopeCompanyRead, e.GET(GETAuditLogPath, api.GetAuditLog))
+	api.Middleware.RequireScope(models.ScopeCompanyRead, e.GET(GETAuditExportPath, api.ExportAuditLog))
 }
 
 // CreateCompany creates a new company
@@ -66,7 +112,7 @@ func (api RestAPI) AddUserByEmailToCompany(c echo.Context) (err error) {
 		return c.String(http.StatusUnauthorized, "could not get userid")
 	}
 
-	err = api.SessionClient.IsAuthorized(c.Request().Context(), userID, companyID, models.Admin)
+	err = api.SessionClient.IsAuthorized(c.Request().Context(), userID, companyID, models.MembersManage)
 	if err != nil {
 		api.Logging.Unsuccessful("not authorized", err)
 		return c.String(http.StatusUnauthorized, "")
@@ -79,14 +125,34 @@ func (api RestAPI) AddUserByEmailToCompany(c echo.Context) (err error) {
 		return c.String(http.StatusBadRequest, "")
 	}
 
+	canGrant, err := api.CompanyClient.CanGrantRole(c.Request().Context(), companyID, userID, newUserRequest.Access)
+	if err == models.RoleNotFoundError {
+		return c.JSON(http.StatusBadRequest, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("could not check role", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if !canGrant {
+		return c.JSON(http.StatusForbidden, utils.NewWebError(models.RoleNotGrantableError.Error()))
+	}
+
 	if newUserRequest.Username != "" {
-		err = api.CompanyClient.AddUserToCompanyByUsername(c.Request().Context(), companyID, *newUserRequest)
+		err = api.CompanyClient.AddUserToCompanyByUsername(utils.WithClientInfo(c), companyID, *newUserRequest)
+		if err == models.UnverifiedEmailError {
+			api.Logging.Unsuccessful("could not add user", err)
+			return c.JSON(http.StatusBadRequest, utils.NewWebError("the user has not verified their email"))
+		}
 		if err != nil {
 			api.Logging.Unsuccessful("could not add user", err)
 			return c.String(http.StatusBadRequest, "")
 		}
 	} else if newUserRequest.Email != "" {
-		err = api.CompanyClient.AddUserToCompanyByEmail(c.Request().Context(), companyID, *newUserRequest)
+		err = api.CompanyClient.AddUserToCompanyByEmail(utils.WithClientInfo(c), companyID, *newUserRequest)
+		if err == models.UnverifiedEmailError {
+			api.Logging.Unsuccessful("could not add user", err)
+			return c.JSON(http.StatusBadRequest, utils.NewWebError("the user has not verified their email"))
+		}
 		if err != nil {
 			api.Logging.Unsuccessful("could not add user", err)
 			return c.String(http.StatusBadRequest, "")
@@ -111,7 +177,7 @@ func (api RestAPI) DeleteCompanyUser(c echo.Context) (err error) {
 		return errors.WithStack(errors.New("could not get user id"))
 	}
 
-	err = api.SessionClient.IsAuthorized(c.Request().Context(), userID, companyID, models.Admin)
+	err = api.SessionClient.IsAuthorized(c.Request().Context(), userID, companyID, models.MembersManage)
 	if err != nil {
 		api.Logging.Unsuccessful("not authorized  ", err)
 		return c.String(http.StatusUnauthorized, "")
@@ -128,7 +194,20 @@ func (api RestAPI) DeleteCompanyUser(c echo.Context) (err error) {
 		return c.String(http.StatusBadRequest, "")
 	}
 
-	err = api.CompanyClient.DeleteUser(c.Request().Context(), companyID, userIDToDelete)
+	canManage, err := api.CompanyClient.CanManageMember(c.Request().Context(), companyID, userID, userIDToDelete)
+	if err != nil {
+		api.Logging.Unsuccessful("could not check member", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if !canManage {
+		return c.JSON(http.StatusForbidden, utils.NewWebError(models.MemberNotManageableError.Error()))
+	}
+
+	err = api.CompanyClient.DeleteUser(utils.WithClientInfo(c), companyID, userIDToDelete)
+	if err == models.OwnerRemovalError || err == models.LastAdminError {
+		api.Logging.Unsuccessful("could not delete user", err)
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	}
 	if err != nil {
 		api.Logging.Unsuccessful("could not delete user", err)
 		return c.String(http.StatusInternalServerError, "")
@@ -150,7 +229,7 @@ func (api RestAPI) GetCompanyUsers(c echo.Context) (err error) {
 		return errors.WithStack(errors.New("could not get user id"))
 	}
 
-	err = api.SessionClient.IsAuthorized(c.Request().Context(), userID, companyID, models.Admin)
+	err = api.SessionClient.IsAuthorized(c.Request().Context(), userID, companyID, models.MembersManage)
 	if err != nil {
 		api.Logging.Unsuccessful("not authorized  ", err)
 		return c.String(http.StatusUnauthorized, "")
@@ -192,7 +271,7 @@ func (api RestAPI) ChangeUserPermission(c echo.Context) (err error) {
 		return errors.WithStack(errors.New("could not get user id"))
 	}
 
-	err = api.SessionClient.IsAuthorized(c.Request().Context(), userID, companyID, models.Admin)
+	err = api.SessionClient.IsAuthorized(c.Request().Context(), userID, companyID, models.MembersManage)
 	if err != nil {
 		api.Logging.Unsuccessful("not authorized  ", err)
 		return c.String(http.StatusUnauthorized, "")
@@ -210,7 +289,32 @@ func (api RestAPI) ChangeUserPermission(c echo.Context) (err error) {
 		return c.JSON(http.StatusBadRequest, utils.NewWebError("You are not allowed to change you own accesslevel"))
 	}
 
-	err = api.CompanyClient.UpdateUserPermission(c.Request().Context(), companyID, *newUserRequest)
+	canGrant, err := api.CompanyClient.CanGrantRole(c.Request().Context(), companyID, userID, newUserRequest.Access)
+	if err == models.RoleNotFoundError {
+		return c.JSON(http.StatusBadRequest, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("could not check role", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if !canGrant {
+		return c.JSON(http.StatusForbidden, utils.NewWebError(models.RoleNotGrantableError.Error()))
+	}
+
+	canManage, err := api.CompanyClient.CanManageMember(c.Request().Context(), companyID, userID, newUserRequest.UserID)
+	if err != nil {
+		api.Logging.Unsuccessful("could not check member", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if !canManage {
+		return c.JSON(http.StatusForbidden, utils.NewWebError(models.MemberNotManageableError.Error()))
+	}
+
+	err = api.CompanyClient.UpdateUserPermission(utils.WithClientInfo(c), companyID, *newUserRequest)
+	if err == models.OwnerRemovalError || err == models.LastAdminError {
+		api.Logging.Unsuccessful("could not update user accesslevel", err)
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	}
 	if err != nil {
 		api.Logging.Unsuccessful("could not update user accesslevel", err)
 		return c.String(http.StatusBadRequest, "")
@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/kristohberg/CreatixBackend/web"
+	"github.com/labstack/echo"
+)
+
+var (
+	GETCompanySettingsPath   = "/company/:company/settings"
+	PUTCompanySettingsPath   = "/company/:company/settings"
+	PUTCompanyNamePath       = "/company/:company/name"
+	POSTArchiveCompanyPath   = "/company/:company/archive"
+	DELETEArchiveCompanyPath = "/company/:company/archive"
+	POSTCompanyDeletionPath  = "/company/:company/deletion"
+	DELETECompanyPath        = "/company/:company"
+)
+
+// companyArchived responds with 403 when the company is archived. It is used
+// by endpoints which change the company but only need the read permission
+func (api RestAPI) companyArchived(c echo.Context) (bool, error) {
+	archived, err := api.CompanyClient.IsArchived(c.Request().Context(), c.Param("company"))
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.company.archived: not able to check company", err)
+		return true, c.String(http.StatusInternalServerError, "")
+	}
+	if archived {
+		return true, c.JSON(http.StatusForbidden, utils.NewWebError(models.CompanyArchivedError.Error()))
+	}
+	return false, nil
+}
+
+// GetCompanySettings gets the settings of the company for its members
+func (api RestAPI) GetCompanySettings(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.company.settings: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	settings, err := api.CompanyClient.GetSettings(c.Request().Context(), c.Param("company"))
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.company.settings: not able to get settings", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, settings)
+}
+
+// UpdateCompanySettings changes the settings of the company. Members can only
+// make roles with permissions they have the default for new members
+func (api RestAPI) UpdateCompanySettings(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.company.updatesettings: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	settingsRequest := new(models.SettingsRequest)
+	if err = c.Bind(settingsRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.company.updatesettings: could not bind settings", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	if err = settingsRequest.Valid(); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	canGrant, err := api.CompanyClient.CanGrantRole(c.Request().Context(), c.Param("company"), userID, settingsRequest.DefaultAccess)
+	if err == models.RoleNotFoundError {
+		return c.JSON(http.StatusBadRequest, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.company.updatesettings: not able to check role", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if !canGrant {
+		return c.JSON(http.StatusForbidden, utils.NewWebError(models.RoleNotGrantableError.Error()))
+	}
+
+	err = api.CompanyClient.UpdateSettings(c.Request().Context(), c.Param("company"), *settingsRequest)
+	if err == models.RoleNotFoundError {
+		return c.JSON(http.StatusBadRequest, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.company.updatesettings: not able to update settings", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "updated"})
+}
+
+// RenameCompany gives the company a new name
+func (api RestAPI) RenameCompany(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.company.rename: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	renameRequest := new(models.RenameCompanyRequest)
+	if err = c.Bind(renameRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.company.rename: could not bind name", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	if err = renameRequest.Valid(); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	err = api.CompanyClient.RenameCompany(c.Request().Context(), c.Param("company"), renameRequest.Name)
+	if err == models.CompanyNameTakenError {
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.company.rename: not able to rename company", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "renamed"})
+}
+
+// ArchiveCompany makes the company read-only
+func (api RestAPI) ArchiveCompany(c echo.Context) error {
+	return api.setCompanyArchived(c, true)
+}
+
+// RestoreCompany makes an archived company writable again
+func (api RestAPI) RestoreCompany(c echo.Context) error {
+	return api.setCompanyArchived(c, false)
+}
+
+func (api RestAPI) setCompanyArchived(c echo.Context, archived bool) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.company.archive: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	if err = api.CompanyClient.SetArchived(c.Request().Context(), c.Param("company"), archived); err != nil {
+		api.Logging.Unsuccessful("creatix.company.archive: not able to archive company", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if archived {
+		return c.JSON(http.StatusOK, web.HttpResponse{Message: "archived"})
+	}
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "restored"})
+}
+
+// CreateCompanyDeletion is the first step of deleting the company. The token
+// in the response confirms the deletion and expires after 15 minutes
+func (api RestAPI) CreateCompanyDeletion(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.company.deletion: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	deletion, err := api.CompanyClient.CreateCompanyDeletion(c.Request().Context(), c.Param("company"), userID)
+	if err == models.NotCompanyOwnerError {
+		return c.JSON(http.StatusForbidden, utils.NewWebError("only the owner can delete the company"))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.company.deletion: not able to create deletion", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, deletion)
+}
+
+// DeleteCompany permanently deletes the company and everything in it once the
+// deletion is confirmed
+func (api RestAPI) DeleteCompany(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.company.delete: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	deleteRequest := new(models.DeleteCompanyRequest)
+	if err = c.Bind(deleteRequest); err != nil || deleteRequest.Token == "" {
+		api.Logging.Unsuccessful("creatix.company.delete: could not bind confirmation", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	err = api.CompanyClient.DeleteCompany(c.Request().Context(), c.Param("company"), deleteRequest.Token)
+	if err == models.InvalidDeletionTokenError {
+		return c.JSON(http.StatusBadRequest, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.company.delete: not able to delete company", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "deleted"})
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompanySettings(t *testing.T) {
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	ctx := context.Background()
+
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "john@doe.no", Access: models.Write}))
+
+	settings := func() models.Settings {
+		code, body := callHandler(t, restAPI, restAPI.GetCompanySettings, "2", nil, "1")
+		require.Equal(t, http.StatusOK, code)
+		var settings models.Settings
+		require.NoError(t, json.Unmarshal(body, &settings))
+		return settings
+	}
+
+	// New companies give new members the read role
+	assert.Equal(t, models.Settings{ID: "1", Name: "MyCorp", DefaultAccess: models.Read, Timezone: "UTC"}, settings())
+
+	// Only members who manage the settings change them
+	settingsRequest := models.SettingsRequest{
+		Description:            "We make things",
+		LogoURL:                "https://mycorp.no/logo.png",
+		DefaultAccess:          models.Write,
+		AllowAnonymousFeedback: true,
+		Timezone:               "Europe/Oslo",
+	}
+	code, _ := callHandler(t, restAPI, restAPI.UpdateCompanySettings, "2", settingsRequest, "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = callHandler(t, restAPI, restAPI.UpdateCompanySettings, "1", models.SettingsRequest{LogoURL: "javascript:alert(1)", Timezone: "Mars/Olympus"}, "1")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = callHandler(t, restAPI, restAPI.UpdateCompanySettings, "1", settingsRequest, "1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, models.Settings{ID: "1", Name: "MyCorp", Description: "We make things", LogoURL: "https://mycorp.no/logo.png",
+		DefaultAccess: models.Write, AllowAnonymousFeedback: true, Timezone: "Europe/Oslo"}, settings())
+
+	// Approved join requests get the default role
+	request, err := restAPI.CompanyClient.CreateJoinRequest(ctx, "1", "3", models.JoinRequestRequest{})
+	require.NoError(t, err)
+	_, err = restAPI.CompanyClient.DecideJoinRequest(ctx, "1", request.ID, "1", true)
+	require.NoError(t, err)
+	assert.NoError(t, restAPI.SessionClient.IsAuthorized(ctx, "3", "1", models.FeedbackCreate))
+
+	// Company names stay unique
+	otherCompanyID, err := restAPI.CompanyClient.CreateCompany(ctx, "OtherCorp", "2")
+	require.NoError(t, err)
+	otherID := strconv.FormatInt(*otherCompanyID, 10)
+	code, _ = callHandler(t, restAPI, restAPI.RenameCompany, "1", models.RenameCompanyRequest{Name: "OtherCorp"}, "1")
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = callHandler(t, restAPI, restAPI.RenameCompany, "1", models.RenameCompanyRequest{Name: " NewCorp "}, "1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "NewCorp", settings().Name)
+
+	code, _ = callHandler(t, restAPI, restAPI.PostFeedback, "2", newFeedbackRequest(), "1")
+	require.Equal(t, http.StatusOK, code)
+	feedbacks, err := restAPI.FeedbackClient.GetCompanyFeedbackswData(ctx, "1", "2", models.FeedbackFilter{})
+	require.NoError(t, err)
+	require.Len(t, feedbacks, 1)
+	feedbackID := feedbacks[0].ID
+
+	// Archived companies can be read but not changed
+	code, _ = callHandler(t, restAPI, restAPI.ArchiveCompany, "2", nil, "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = callHandler(t, restAPI, restAPI.ArchiveCompany, "1", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	assert.NotNil(t, settings().ArchivedAt)
+
+	assert.Equal(t, models.CompanyArchivedError, restAPI.SessionClient.IsAuthorized(ctx, "2", "1", models.FeedbackCreate))
+	code, _ = callHandler(t, restAPI, restAPI.PostFeedback, "2", newFeedbackRequest(), "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = callHandler(t, restAPI, restAPI.ClapFeedback, "2", nil, "1", feedbackID)
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = callHandler(t, restAPI, restAPI.CommentFeedback, "2", models.CommentRequest{Comment: "archived"}, "1", feedbackID)
+	assert.Equal(t, http.StatusForbidden, code)
+	feedbacks, err = restAPI.FeedbackClient.GetCompanyFeedbackswData(ctx, "1", "2", models.FeedbackFilter{})
+	require.NoError(t, err)
+	assert.Len(t, feedbacks, 1)
+
+	code, _ = callHandler(t, restAPI, restAPI.RestoreCompany, "1", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Nil(t, settings().ArchivedAt)
+	code, _ = callHandler(t, restAPI, restAPI.ClapFeedback, "2", nil, "1", feedbackID)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.CommentFeedback, "2", models.CommentRequest{Comment: "restored"}, "1", feedbackID)
+	require.Equal(t, http.StatusOK, code)
+	teamID, err := restAPI.CompanyClient.CreateTeam(ctx, models.Team{CompanyID: "1", Name: "Backend"})
+	require.NoError(t, err)
+	require.NoError(t, restAPI.CompanyClient.AddUserToTeam(ctx, "1", teamID, models.TeamMemberRequest{UserID: "2"}))
+
+	// Only the owner can delete a company they own
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, otherID, models.AddUser{Email: "kristoffer@berg.no", Access: models.Admin}))
+	code, _ = callHandler(t, restAPI, restAPI.CreateCompanyDeletion, "1", nil, otherID)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// Deleting needs the confirmation and removes everything in the company
+	code, _ = callHandler(t, restAPI, restAPI.CreateCompanyDeletion, "2", nil, "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = callHandler(t, restAPI, restAPI.DeleteCompany, "1", models.DeleteCompanyRequest{Token: "guess"}, "1")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body := callHandler(t, restAPI, restAPI.CreateCompanyDeletion, "1", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	var deletion models.CompanyDeletion
+	require.NoError(t, json.Unmarshal(body, &deletion))
+	code, _ = callHandler(t, restAPI, restAPI.DeleteCompany, "1", models.DeleteCompanyRequest{Token: deletion.Token}, otherID)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = callHandler(t, restAPI, restAPI.DeleteCompany, "1", models.DeleteCompanyRequest{Token: deletion.Token}, "1")
+	require.Equal(t, http.StatusOK, code)
+
+	for _, query := range []string{
+		`SELECT COUNT(*) FROM COMPANY WHERE Id=1`,
+		`SELECT COUNT(*) FROM USER_COMPANY WHERE CompanyId=1`,
+		`SELECT COUNT(*) FROM TEAM WHERE CompanyId=1`,
+		`SELECT COUNT(*) FROM FEEDBACK WHERE CompanyId=1`,
+		`SELECT COUNT(*) FROM COMMENTS WHERE FeedbackId=` + feedbackID,
+		`SELECT COUNT(*) FROM CLAPS WHERE FeedbackId=` + feedbackID,
+	} {
+		var count int
+		require.NoError(t, db.QueryRow(query).Scan(&count))
+		assert.Zero(t, count, query)
+	}
+
+	companies, err := restAPI.CompanyClient.GetUserCompanies(ctx, "2")
+	require.NoError(t, err)
+	require.Len(t, companies, 1)
+	assert.Equal(t, "OtherCorp", companies[0].Name)
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/kristohberg/CreatixBackend/config"
 	"github.com/kristohberg/CreatixBackend/logging"
@@ -19,7 +20,7 @@ import (
 )
 
 func InitConfig() config.Config {
-	return config.Config{Env: "test"}
+	return config.Config{Env: "test", TokenSecret: "test"}
 }
 
 func SignupAndLoginUser(t *testing.T, e *echo.Echo, sessionAPI SessionAPI, logger *logging.StandardLogger) (cookie string) {
@@ -45,17 +46,27 @@ func SignupAndLoginUser(t *testing.T, e *echo.Echo, sessionAPI SessionAPI, logge
 	return rec.Header().Get("Set-Cookie")
 }
 
+func newTestKeyring(secret string) *utils.Keyring {
+	keyring, err := utils.NewKeyring(utils.NewHMACKey("test", []byte(secret)), time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	return keyring
+}
+
 func NewRestAPI(db *sql.DB, logger *logging.StandardLogger) RestAPI {
 	cfg := InitConfig()
+	sessionClient := models.NewSessionClient(db, newTestKeyring(cfg.TokenSecret), 30, 24, logger)
 	return RestAPI{
 		DB:             db,
 		Logging:        logger,
 		Cfg:            cfg,
 		Feedback:       models.Feedback{},
-		Middleware:     &middleware.Middleware{Cfg: cfg},
+		Middleware:     &middleware.Middleware{Cfg: cfg, SessionClient: sessionClient},
 		CompanyClient:  models.NewCompanyClient(db),
-		SessionClient:  models.NewSessionClient(db, []byte("test"), 30, logger),
+		SessionClient:  sessionClient,
 		FeedbackClient: models.NewFeedbackClient(db),
+		MailClient:     &mockMailClient{},
 	}
 }
 
@@ -63,8 +74,9 @@ func NewSessionAPI(db *sql.DB, logger *logging.StandardLogger) SessionAPI {
 	return SessionAPI{
 		DB:            db,
 		Logging:       logger,
-		Cfg:           config.Config{Env: "test"},
-		SessionClient: models.NewSessionClient(db, []byte("secret"), 20, logger),
+		Cfg:           config.Config{Env: "test", TokenSecret: "secret"},
+		SessionClient: models.NewSessionClient(db, newTestKeyring("secret"), 20, 24, logger),
+		MailClient:    &mockMailClient{},
 	}
 }
 
@@ -119,7 +131,7 @@ func TestCompany(t *testing.T) {
 	}
 
 	// Try to change access level for user
-	newUserLoad, err = json.Marshal(models.UserPermissionRequest{UserID: 2, Access: models.Read})
+	newUserLoad, err = json.Marshal(models.UserPermissionRequest{UserID: "2", Access: models.Read})
 	c, rec = newContext(e, newUserLoad, "/company/1/permission/")
 	c.SetPath("/company/:company/permission")
 	c.SetParamNames("company")
@@ -172,7 +184,7 @@ func TestCompany(t *testing.T) {
 	err = restAPI.GetUserCompanies(c)
 	if assert.NoError(t, err) {
 		assert.Equal(t, http.StatusOK, rec.Code)
-		assertStruct(t, rec, []models.Company{{ID: "1", Name: "coolio"}})
+		assertStruct(t, rec, []models.Company{{ID: "1", Name: "coolio", OwnerID: "1"}})
 	}
 }
 
@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/kristohberg/CreatixBackend/web"
+	"github.com/labstack/echo"
+)
+
+var (
+	GETDomainsPath            = "/company/:company/domains"
+	POSTDomainPath            = "/company/:company/domains"
+	POSTVerifyDomainPath      = "/company/:company/domains/:domain/verify"
+	PUTDomainPath             = "/company/:company/domains/:domain"
+	DELETEDomainPath          = "/company/:company/domains/:domain"
+	POSTJoinDomainCompanyPath = "/company/domain/join"
+)
+
+// canGrantDomainRole checks that the member can give the role to everyone
+// joining through the domain, and responds when they cannot
+func (api RestAPI) canGrantDomainRole(c echo.Context, access models.AccessLevel) (bool, error) {
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	canGrant, err := api.CompanyClient.CanGrantRole(c.Request().Context(), c.Param("company"), userID, access)
+	if err == models.RoleNotFoundError {
+		return false, c.JSON(http.StatusBadRequest, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.domain: not able to check role", err)
+		return false, c.String(http.StatusInternalServerError, "")
+	}
+	if !canGrant {
+		return false, c.JSON(http.StatusForbidden, utils.NewWebError(models.RoleNotGrantableError.Error()))
+	}
+	return true, nil
+}
+
+// CreateDomain claims an email domain for the company. The response holds the
+// TXT record which has to be added to the domain before it can be verified
+func (api RestAPI) CreateDomain(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.domain.create: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	domainRequest := new(models.DomainRequest)
+	if err = c.Bind(domainRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.domain.create: could not bind domain", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	if err = domainRequest.Valid(); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	if ok, err := api.canGrantDomainRole(c, domainRequest.Access); !ok {
+		return err
+	}
+
+	domain, err := api.CompanyClient.CreateDomain(c.Request().Context(), c.Param("company"), *domainRequest)
+	if err == models.DomainExistsError {
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	}
+	if err == models.RoleNotFoundError {
+		return c.JSON(http.StatusBadRequest, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.domain.create: not able to create domain", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, domain)
+}
+
+// GetDomains lists the domains claimed by the company
+func (api RestAPI) GetDomains(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.domain.list: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	domains, err := api.CompanyClient.GetDomains(c.Request().Context(), c.Param("company"))
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.domain.list: not able to get domains", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, domains)
+}
+
+// VerifyDomain checks the dns records of the domain for the verification
+// record. Verified users with an email on the domain can join from then on
+func (api RestAPI) VerifyDomain(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.domain.verify: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	domainID, err := strconv.Atoi(c.Param("domain"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.DomainNotFoundError.Error()))
+	}
+
+	domain, err := api.CompanyClient.VerifyDomain(c.Request().Context(), c.Param("company"), domainID)
+	switch err {
+	case nil:
+	case models.DomainNotFoundError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	case models.DomainTakenError:
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	case models.DomainNotVerifiedError:
+		return c.JSON(http.StatusUnprocessableEntity, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.domain.verify: not able to verify domain", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, domain)
+}
+
+// UpdateDomain changes the role given to users joining through the domain
+func (api RestAPI) UpdateDomain(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.domain.update: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	domainID, err := strconv.Atoi(c.Param("domain"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.DomainNotFoundError.Error()))
+	}
+
+	accessRequest := new(models.DomainAccessRequest)
+	if err = c.Bind(accessRequest); err != nil || accessRequest.Access == "" {
+		api.Logging.Unsuccessful("creatix.domain.update: could not bind domain", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	if ok, err := api.canGrantDomainRole(c, accessRequest.Access); !ok {
+		return err
+	}
+
+	err = api.CompanyClient.UpdateDomainAccess(c.Request().Context(), c.Param("company"), domainID, accessRequest.Access)
+	if err == models.DomainNotFoundError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	}
+	if err == models.RoleNotFoundError {
+		return c.JSON(http.StatusBadRequest, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.domain.update: not able to update domain", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "updated"})
+}
+
+// DeleteDomain releases the domain. Members who joined through it stay
+func (api RestAPI) DeleteDomain(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.domain.delete: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	domainID, err := strconv.Atoi(c.Param("domain"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.DomainNotFoundError.Error()))
+	}
+
+	err = api.CompanyClient.DeleteDomain(c.Request().Context(), c.Param("company"), domainID)
+	if err == models.DomainNotFoundError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.domain.delete: not able to delete domain", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "deleted"})
+}
+
+// JoinDomainCompany lets the user join the company which has verified the
+// domain of their email. Users joining after signup are added automatically,
+// this is for users who signed up before the domain was verified
+func (api RestAPI) JoinDomainCompany(c echo.Context) error {
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	if userID == "" {
+		api.Logging.Unsuccessful("creatix.domain.join: no permission", utils.NoPermission)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	company, err := api.CompanyClient.JoinDomainCompany(utils.WithClientInfo(c), userID)
+	switch err {
+	case nil:
+	case models.NoDomainCompanyError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	case models.AlreadyCompanyMemberError:
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	case models.UnverifiedEmailError:
+		return c.JSON(http.StatusForbidden, utils.NewWebError("verify your email before joining a company"))
+	default:
+		api.Logging.Unsuccessful("creatix.domain.join: not able to join company", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, company)
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeResolver answers TXT lookups from a map instead of the dns
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return records, nil
+}
+
+func TestDomains(t *testing.T) {
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	resolver := fakeResolver{}
+	restAPI.CompanyClient.Resolver = resolver
+	sessionAPI := NewSessionAPI(db, logger)
+	mailClient := sessionAPI.MailClient.(*mockMailClient)
+	ctx := context.Background()
+
+	// Only members who manage the company settings claim domains
+	code, _ := callHandler(t, restAPI, restAPI.CreateDomain, "2", models.DomainRequest{Domain: "doe.no", Access: models.Write}, "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = callHandler(t, restAPI, restAPI.CreateDomain, "1", models.DomainRequest{Domain: "localhost", Access: models.Write}, "1")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body := callHandler(t, restAPI, restAPI.CreateDomain, "1", models.DomainRequest{Domain: " Doe.no. ", Access: models.Write}, "1")
+	require.Equal(t, http.StatusOK, code)
+	var domain models.Domain
+	require.NoError(t, json.Unmarshal(body, &domain))
+	assert.Equal(t, "doe.no", domain.Domain)
+	assert.Equal(t, "_creatix.doe.no", domain.RecordName)
+	assert.Nil(t, domain.VerifiedAt)
+	domainID := strconv.Itoa(domain.ID)
+
+	code, _ = callHandler(t, restAPI, restAPI.CreateDomain, "1", models.DomainRequest{Domain: "doe.no", Access: models.Read}, "1")
+	assert.Equal(t, http.StatusConflict, code)
+
+	// Nobody joins through an unverified domain
+	code, _ = callHandler(t, restAPI, restAPI.JoinDomainCompany, "2", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// The domain is verified once the TXT record is found
+	code, _ = callHandler(t, restAPI, restAPI.VerifyDomain, "1", nil, "1", domainID)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	resolver[domain.RecordName] = []string{"v=spf1 -all", "creatix-verification=wrong"}
+	code, _ = callHandler(t, restAPI, restAPI.VerifyDomain, "1", nil, "1", domainID)
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	resolver[domain.RecordName] = append(resolver[domain.RecordName], domain.RecordValue)
+	code, body = callHandler(t, restAPI, restAPI.VerifyDomain, "1", nil, "1", domainID)
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal(body, &domain))
+	assert.NotNil(t, domain.VerifiedAt)
+
+	// Another company cannot verify the same domain
+	otherCompanyID, err := restAPI.CompanyClient.CreateCompany(ctx, "OtherCorp", "3")
+	require.NoError(t, err)
+	otherID := strconv.FormatInt(*otherCompanyID, 10)
+	code, body = callHandler(t, restAPI, restAPI.CreateDomain, "3", models.DomainRequest{Domain: "doe.no", Access: models.Read}, otherID)
+	require.Equal(t, http.StatusOK, code)
+	var otherDomain models.Domain
+	require.NoError(t, json.Unmarshal(body, &otherDomain))
+	resolver[otherDomain.RecordName] = []string{otherDomain.RecordValue}
+	code, _ = callHandler(t, restAPI, restAPI.VerifyDomain, "3", nil, otherID, strconv.Itoa(otherDomain.ID))
+	assert.Equal(t, http.StatusConflict, code)
+
+	// Existing users on the domain join with the configured role
+	code, _ = callHandler(t, restAPI, restAPI.JoinDomainCompany, "3", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+	code, body = callHandler(t, restAPI, restAPI.JoinDomainCompany, "2", nil)
+	require.Equal(t, http.StatusOK, code)
+	var company models.Company
+	require.NoError(t, json.Unmarshal(body, &company))
+	assert.Equal(t, "MyCorp", company.Name)
+	assert.NoError(t, restAPI.SessionClient.IsAuthorized(ctx, "2", "1", models.FeedbackCreate))
+	assert.Error(t, restAPI.SessionClient.IsAuthorized(ctx, "2", "1", models.FeedbackModerate))
+	code, _ = callHandler(t, restAPI, restAPI.JoinDomainCompany, "2", nil)
+	assert.Equal(t, http.StatusConflict, code)
+
+	// New users join when they verify their email
+	code, _ = callHandler(t, restAPI, restAPI.UpdateDomain, "1", models.DomainAccessRequest{Access: models.Read}, "1", domainID)
+	require.Equal(t, http.StatusOK, code)
+	newUser := models.User{Firstname: "Jane", Lastname: "Doe", Username: "janedoe", Email: "jane@DOE.no", Password: "MyPassword@123"}
+	signupByte, err := json.Marshal(models.Signup{User: newUser})
+	require.NoError(t, err)
+	c, rec := newContext(e, signupByte, POSTSignupNewUserPath)
+	require.NoError(t, sessionAPI.Signup(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	jane, err := utils.FindUserByEmail(ctx, db, newUser.Email)
+	require.NoError(t, err)
+	assert.Error(t, restAPI.SessionClient.IsAuthorized(ctx, jane.ID, "1", models.FeedbackRead))
+
+	verifyByte, err := json.Marshal(models.VerifyEmailRequest{Token: mailClient.lastToken()})
+	require.NoError(t, err)
+	c, rec = newContext(e, verifyByte, POSTVerifyEmailPath)
+	require.NoError(t, sessionAPI.VerifyEmail(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, restAPI.SessionClient.IsAuthorized(ctx, jane.ID, "1", models.FeedbackRead))
+	assert.Error(t, restAPI.SessionClient.IsAuthorized(ctx, jane.ID, "1", models.FeedbackCreate))
+
+	// Roles given through a domain cannot be deleted
+	code, body = callHandler(t, restAPI, restAPI.CreateRole, "1", models.RoleRequest{Name: "Colleague", Permissions: []models.Permission{models.FeedbackRead}}, "1")
+	require.Equal(t, http.StatusOK, code)
+	var role models.Role
+	require.NoError(t, json.Unmarshal(body, &role))
+	code, _ = callHandler(t, restAPI, restAPI.UpdateDomain, "1", models.DomainAccessRequest{Access: "colleague"}, "1", domainID)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, models.RoleInUseError, restAPI.CompanyClient.DeleteRole(ctx, "1", role.ID))
+
+	// Released domains no longer let users join
+	code, _ = callHandler(t, restAPI, restAPI.DeleteDomain, "1", nil, "1", domainID)
+	require.Equal(t, http.StatusOK, code)
+	code, body = callHandler(t, restAPI, restAPI.GetDomains, "1", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, "[]", string(body))
+	require.NoError(t, restAPI.CompanyClient.DeleteUser(ctx, "1", "2"))
+	code, _ = callHandler(t, restAPI, restAPI.JoinDomainCompany, "2", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"github.com/gorilla/websocket"
+	"github.com/kristohberg/CreatixBackend/internal/mail"
 	"github.com/kristohberg/CreatixBackend/middleware"
 	"github.com/kristohberg/CreatixBackend/models"
 	"github.com/kristohberg/CreatixBackend/utils"
@@ -26,32 +27,58 @@ type RestAPI struct {
 	CompanyClient  *models.CompanyClient
 	SessionClient  *models.SessionClient
 	FeedbackClient *models.FeedbackClient
+	MailClient     mail.MailClienter
 }
 
 var (
 	PostFeedbackPath              = "/user/:company/feedback"
 	GetFeedbackForUserCompanyPath = "/user/:company/feedback"
-	DeleteFeedbackForUser         = "/feedback/:fid"
-	PutFeedbackForUser            = "/feedback/:fid"
-	PostClapFeedbackForUser       = "/user/feedback/:fid/clap"
-	PostCommentFeedbackForUser    = "/user/feedback/:fid/comment"
+	DeleteFeedbackForUser         = "/company/:company/feedback/:fid"
+	PutFeedbackForUser            = "/company/:company/feedback/:fid"
+	PostClapFeedbackForUser       = "/company/:company/feedback/:fid/clap"
+	PostCommentFeedbackForUser    = "/company/:company/feedback/:fid/comment"
 	PostSearchFeedback            = "/feedback/:company/search/:query"
+	GETCompanyFeedbackPath        = "/company/:company/feedback"
 )
 
 func (api RestAPI) Handler(e *echo.Group) {
-	e.Use(api.Middleware.JwtVerify)
-	e.POST(PostFeedbackPath, api.PostFeedback)
-	e.GET(GetFeedbackForUserCompanyPath, api.GetUserFeedback)
-
-	e.DELETE(DeleteFeedbackForUser, api.DeleteFeedback)
-	e.PUT(PutFeedbackForUser, api.UpdateFeedback)
-	e.POST(PostClapFeedbackForUser, api.ClapFeedback)
-	e.POST(PostCommentFeedbackForUser, api.CommentFeedback)
-	e.POST(PostSearchFeedback, api.SearchFeedback)
+	e.Use(api.Middleware.JwtVerify, api.Middleware.Tenant)
+	api.Middleware.RequireScope(models.ScopeFeedbackWrite, e.POST(PostFeedbackPath, api.PostFeedback))
+	api.Middleware.RequireScope(models.ScopeFeedbackRead, e.GET(GetFeedbackForUserCompanyPath, api.GetUserFeedback))
+
+	api.Middleware.RequireScope(models.ScopeFeedbackWrite, e.DELETE(DeleteFeedbackForUser, api.DeleteFeedback))
+	api.Middleware.RequireScope(models.ScopeFeedbackWrite, e.PUT(PutFeedbackForUser, api.UpdateFeedback))
+	api.Middleware.RequireScope(models.ScopeFeedbackWrite, e.POST(PostClapFeedbackForUser, api.ClapFeedback))
+	api.Middleware.RequireScope(models.ScopeFeedbackWrite, e.POST(PostCommentFeedbackForUser, api.CommentFeedback))
+	api.Middleware.RequireScope(models.ScopeFeedbackWrite, e.PUT(PUTCommentPath, api.UpdateComment))
+	api.Middleware.RequireScope(models.ScopeFeedbackWrite, e.DELETE(DELETECommentPath, api.DeleteComment))
+	api.Middleware.RequireScope(models.ScopeFeedbackRead, e.POST(PostSearchFeedback, api.SearchFeedback))
+	api.Middleware.RequireScope(models.ScopeFeedbackRead, e.GET(GETCompanyFeedbackPath, api.GetCompanyFeedback))
+
+	api.Middleware.RequireScope(models.ScopeFeedbackRead, e.GET(GETFeedbackStatusesPath, api.GetFeedbackStatuses))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTFeedbackStatusPath, api.CreateFeedbackStatus))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.PUT(PUTFeedbackStatusPath, api.UpdateFeedbackStatus))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.DELETE(DELETEFeedbackStatusPath, api.DeleteFeedbackStatus))
+	api.Middleware.RequireScope(models.ScopeFeedbackWrite, e.PUT(PUTChangeFeedbackStatusPath, api.ChangeFeedbackStatus))
+	api.Middleware.RequireScope(models.ScopeFeedbackRead, e.GET(GETStatusHistoryPath, api.GetStatusHistory))
+
+	api.Middleware.RequireScope(models.ScopeFeedbackRead, e.GET(GETFeedbackCategoriesPath, api.GetFeedbackCategories))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTFeedbackCategoryPath, api.CreateFeedbackCategory))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.PUT(PUTFeedbackCategoryPath, api.UpdateFeedbackCategory))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.DELETE(DELETEFeedbackCategoryPath, api.DeleteFeedbackCategory))
+	api.Middleware.RequireScope(models.ScopeFeedbackRead, e.GET(GETFeedbackTagsPath, api.GetFeedbackTags))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTFeedbackTagPath, api.CreateFeedbackTag))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.PUT(PUTFeedbackTagPath, api.UpdateFeedbackTag))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.DELETE(DELETEFeedbackTagPath, api.DeleteFeedbackTag))
 
 	api.CompanyHandler(e)
+	api.MfaHandler(e)
+	api.AccessTokenHandler(e)
+	api.ActiveSessionHandler(e)
+	api.NotificationHandler(e)
+	api.InvitationHandler(e)
 
-	e.GET("/ws/:company/feedback", api.FeedbackWebSocket)
+	api.Middleware.RequireScope(models.ScopeFeedbackRead, e.GET("/ws/:company/feedback", api.FeedbackWebSocket))
 }
 
 func validateFeedback(feedback models.Feedback) error {
@@ -67,7 +94,7 @@ func validateFeedback(feedback models.Feedback) error {
 
 // Search feedback allows users to search through feedbacks
 func (api RestAPI) SearchFeedback(c echo.Context) error {
-	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.Write)
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackCreate)
 	if err != nil || !isAuthorized {
 
 		api.Logging.Unsuccessful("creatix.feedback.PostFeedback: no permission", utils.NoPermission)
@@ -88,17 +115,17 @@ func (api RestAPI) SearchFeedback(c echo.Context) error {
 
 	query := c.Param("query")
 
-	feedbacks, err := api.FeedbackClient.SearchFeedbackwData(c.Request().Context(), companyID, query)
+	feedbacks, err := api.FeedbackClient.SearchFeedbackwData(c.Request().Context(), companyID, userID, query, feedbackFilterFromContext(c))
 	if err != nil {
 		api.Logging.Unsuccessful("no search results ", err)
 		return c.String(http.StatusInternalServerError, "")
 	}
-	return c.JSON(http.StatusOK, feedbacks)
+	return feedbackResults(c, feedbacks)
 }
 
 // PostFeedback posts feedback from user
 func (api RestAPI) PostFeedback(c echo.Context) (err error) {
-	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.Write)
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackCreate)
 	if err != nil || !isAuthorized {
 
 		api.Logging.Unsuccessful("creatix.feedback.PostFeedback: no permission", utils.NoPermission)
@@ -125,21 +152,44 @@ func (api RestAPI) PostFeedback(c echo.Context) (err error) {
 
 	if err = api.FeedbackClient.CreateFeedback(c.Request().Context(), userID, companyID, *feedback); err != nil {
 		api.Logging.Unsuccessful("creatix.feedback.postfeedback: not able to save feedback", err)
+		if err == models.UnverifiedEmailError {
+			return c.JSON(http.StatusForbidden, web.HttpResponse{Message: "verify your email before posting feedback"})
+		}
+		if err == models.InvalidFeedbackTeamError || err == models.InvalidFeedbackCategoryError || err == models.InvalidTagError {
+			return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: err.Error()})
+		}
+		if err == models.AnonymousFeedbackDisabledError {
+			return c.JSON(http.StatusForbidden, web.HttpResponse{Message: err.Error()})
+		}
+		if err == models.FeedbackRateLimitError {
+			return c.JSON(http.StatusTooManyRequests, web.HttpResponse{Message: err.Error()})
+		}
 		return c.String(http.StatusInternalServerError, "")
 	}
 	return c.JSON(http.StatusOK, web.HttpResponse{Message: "posted feedback"})
 }
 
-// DeleteFeedback deletes feedback given an id
+// DeleteFeedback deletes feedback of the company given an id. Authors can
+// delete their own feedback while moderators can delete any feedback
 func (api RestAPI) DeleteFeedback(c echo.Context) error {
-	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.Write)
+	feedbackID := c.Param("fid")
+
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackModerate)
+	if err != nil || !isAuthorized {
+		isAuthorized, err = api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackCreate)
+		if err == nil && isAuthorized {
+			isAuthorized, err = api.FeedbackClient.IsUserOwnerOfFeedback(c.Request().Context(), feedbackID, c.Get(utils.UserIDContext.String()).(string))
+		}
+	}
 	if err != nil || !isAuthorized {
 		api.Logging.Unsuccessful("creatix.feedback.deletefeedback: user does not have permission", err)
 		return errors.New("user does not have permission")
 	}
 
-	feedbackID := c.Param("fid")
-	err = api.FeedbackClient.DeleteFeedback(c.Request().Context(), feedbackID)
+	err = api.FeedbackClient.DeleteFeedback(utils.WithClientInfo(c), c.Param("company"), feedbackID)
+	if err == models.FeedbackNotFoundError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	}
 	if err != nil {
 		api.Logging.Unsuccessful("creatix.feedback.deletefeedback: not able to delete feedback", err)
 		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "not able to delete feedback"})
@@ -149,12 +199,16 @@ func (api RestAPI) DeleteFeedback(c echo.Context) error {
 
 // UpdateFeedback updates feedback based on the id in the url
 func (api RestAPI) UpdateFeedback(c echo.Context) (err error) {
-	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.Read)
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead)
 	if err != nil || !isAuthorized {
 		api.Logging.Unsuccessful("creatix.feedback.updatefeedback: no permission", err)
 		return utils.NoPermission
 	}
 
+	if archived, err := api.companyArchived(c); archived {
+		return err
+	}
+
 	userID := c.Get(utils.UserIDContext.String()).(string)
 	if userID == "" {
 		api.Logging.Unsuccessful("creatix.feedback.updatefeedback: no permission", err)
@@ -184,7 +238,10 @@ func (api RestAPI) UpdateFeedback(c echo.Context) (err error) {
 		return err
 	}
 
-	err = api.FeedbackClient.UpdateFeedback(c.Request().Context(), feedbackID, *feedback)
+	err = api.FeedbackClient.UpdateFeedback(c.Request().Context(), c.Param("company"), feedbackID, *feedback)
+	if err == models.FeedbackNotFoundError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	}
 	if err != nil {
 		api.Logging.Unsuccessful("creatix.feedback.updatefeedback: not able to update feedback", err)
 		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "not able to update feedback"})
@@ -197,12 +254,16 @@ func (api RestAPI) UpdateFeedback(c echo.Context) (err error) {
 
 // ClapFeedback gives claps to a feedback given id
 func (api RestAPI) ClapFeedback(c echo.Context) error {
-	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.Read)
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead)
 	if err != nil || !isAuthorized {
 		api.Logging.Unsuccessful("creatix.feedback.ClapFeedback: no permission", err)
 		return utils.NoPermission
 	}
 
+	if archived, err := api.companyArchived(c); archived {
+		return err
+	}
+
 	userID := c.Get(utils.UserIDContext.String()).(string)
 	if userID == "" {
 		api.Logging.Unsuccessful("creatix.feedback.ClapFeedback: no permission", nil)
@@ -220,13 +281,20 @@ func (api RestAPI) ClapFeedback(c echo.Context) error {
 		api.Logging.Unsuccessful("creatix.feedback.updatefeedback: no feedback id provided", nil)
 		return errors.New("no feedback id provided")
 	}
+
+	isVisible, err := api.FeedbackClient.IsFeedbackVisible(c.Request().Context(), feedbackID, userID)
+	if err != nil || !isVisible {
+		api.Logging.Unsuccessful("creatix.feedback.clapfeedback: feedback not found", err)
+		return c.String(http.StatusNotFound, "")
+	}
+
 	err = api.FeedbackClient.ClapFeedback(c.Request().Context(), userID, feedbackID)
 	if err != nil {
 		api.Logging.Unsuccessful("creatix.feedback.clapfeedback: not able to clap feedback", err)
 		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "not able to clap feedback"})
 	}
 
-	feedbacks, err := api.FeedbackClient.GetCompanyFeedbackswData(c.Request().Context(), companyID)
+	feedbacks, err := api.FeedbackClient.GetCompanyFeedbackswData(c.Request().Context(), companyID, userID, models.FeedbackFilter{})
 	if err != nil {
 		api.Logging.Unsuccessful("creatix.feedback.getUserFeedback: not able to get feedback claps", err)
 		return err
@@ -236,7 +304,7 @@ func (api RestAPI) ClapFeedback(c echo.Context) error {
 
 // GetUserFeedback gets all the feedback for the given user
 func (api RestAPI) GetUserFeedback(c echo.Context) error {
-	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.Read)
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead)
 	if err != nil || !isAuthorized {
 		api.Logging.Unsuccessful("creatix.feedback.getuserfeedback: no permission", utils.NoPermission)
 		return c.String(http.StatusUnauthorized, "")
@@ -248,7 +316,7 @@ func (api RestAPI) GetUserFeedback(c echo.Context) error {
 		return c.String(http.StatusUnauthorized, "")
 	}
 
-	feedbacks, err := api.FeedbackClient.GetUserFeedbackwData(c.Request().Context(), userID)
+	feedbacks, err := api.FeedbackClient.GetUserFeedbackwData(c.Request().Context(), userID, feedbackFilterFromContext(c))
 	if err != nil {
 		api.Logging.Unsuccessful("creatix.feedback.getUserFeedback: not able to get feedback", err)
 		return c.String(http.StatusInternalServerError, "")
@@ -256,6 +324,24 @@ func (api RestAPI) GetUserFeedback(c echo.Context) error {
 	return c.JSON(http.StatusOK, feedbacks)
 }
 
+// GetCompanyFeedback lists the feedback of the company the user is allowed to
+// see, filtered by status, category and tags
+func (api RestAPI) GetCompanyFeedback(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.feedback.getcompanyfeedback: no permission", utils.NoPermission)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	feedbacks, err := api.FeedbackClient.GetCompanyFeedbackswData(c.Request().Context(), c.Param("company"), userID, feedbackFilterFromContext(c))
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.feedback.getcompanyfeedback: not able to get feedback", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	return feedbackResults(c, feedbacks)
+}
+
 var upgrader = websocket.Upgrader{ReadBufferSize: 4096,
 	WriteBufferSize:   4096,
 	EnableCompression: true,
@@ -264,7 +350,7 @@ var upgrader = websocket.Upgrader{ReadBufferSize: 4096,
 	}}
 
 func (api RestAPI) FeedbackWebSocket(c echo.Context) error {
-	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.Read)
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead)
 	if err != nil || !isAuthorized {
 		api.Logging.Unsuccessful("creatix.feedback.getuserfeedback: no permission", err)
 		return utils.NoPermission
@@ -290,7 +376,7 @@ func (api RestAPI) FeedbackWebSocket(c echo.Context) error {
 
 	for {
 		// SEND
-		feedbacks, err := api.FeedbackClient.GetCompanyFeedbackswData(c.Request().Context(), companyID)
+		feedbacks, err := api.FeedbackClient.GetCompanyFeedbackswData(c.Request().Context(), companyID, userID, models.FeedbackFilter{})
 		if err != nil {
 			api.Logging.Unsuccessful("creatix.feedback.FeedbackWebsocket: not able to get feedback", err)
 			break
@@ -308,24 +394,45 @@ func (api RestAPI) FeedbackWebSocket(c echo.Context) error {
 			break
 		}
 		api.Logging.Success(fmt.Sprint("successfully parsed ", wsRequest.FeedbackID, wsRequest.Comment))
+
+		// Every action writes, which archived companies do not allow
+		if !api.companyWritable(c, companyID) {
+			continue
+		}
+
 		switch wsRequest.Action {
 		case 1:
+			// Readers may follow the feedback, but only post with feedback.create
+			if err = api.SessionClient.IsAuthorized(c.Request().Context(), userID, companyID, models.FeedbackCreate); err != nil {
+				api.Logging.Unsuccessful("creatix.feedback.FeedbackWebsocket: no permission to create feedback", err)
+				break
+			}
 			err = api.FeedbackClient.CreateFeedback(c.Request().Context(), userID, companyID, wsRequest.Feedback)
 			if err != nil {
 				api.Logging.Unsuccessful("creatix.feedback.CreateFeedback", err)
 			}
 		case 2:
+			if !api.feedbackVisible(c, wsRequest.FeedbackID, userID) {
+				break
+			}
 			err = api.FeedbackClient.ClapFeedback(c.Request().Context(), userID, wsRequest.FeedbackID)
 			if err != nil {
 				api.Logging.Unsuccessful("creatix.feedback.ClapFeedback", err)
 			}
 		case 3:
-			err = api.FeedbackClient.CommentFeedback(c.Request().Context(), wsRequest.Comment.Comment, userID, wsRequest.FeedbackID)
+			if !api.feedbackVisible(c, wsRequest.FeedbackID, userID) {
+				break
+			}
+			comment := models.CommentRequest{Comment: wsRequest.Comment.Comment, ParentID: wsRequest.Comment.ParentID}
+			err = api.FeedbackClient.CommentFeedback(c.Request().Context(), comment, userID, wsRequest.FeedbackID)
 			if err != nil {
 				api.Logging.Unsuccessful("creatix.feedback.CommentFeedback", err)
 			}
 		case 4:
-			err = api.FeedbackClient.UpdateComment(c.Request().Context(), wsRequest.Comment.ID, wsRequest.Comment.Comment)
+			if !api.feedbackVisible(c, wsRequest.FeedbackID, userID) {
+				break
+			}
+			err = api.FeedbackClient.UpdateComment(c.Request().Context(), wsRequest.FeedbackID, wsRequest.Comment.ID, userID, wsRequest.Comment.Comment)
 			if err != nil {
 				api.Logging.Unsuccessful("creatix.feedback.UpdateComment", err)
 			}
@@ -338,14 +445,40 @@ func (api RestAPI) FeedbackWebSocket(c echo.Context) error {
 	return nil
 }
 
+// companyWritable checks that the company is not archived before the user
+// writes to it over the websocket, where companyArchived cannot respond
+func (api RestAPI) companyWritable(c echo.Context, companyID string) bool {
+	archived, err := api.CompanyClient.IsArchived(c.Request().Context(), companyID)
+	if err != nil || archived {
+		api.Logging.Unsuccessful("creatix.feedback.FeedbackWebsocket: company is archived", err)
+		return false
+	}
+	return true
+}
+
+// feedbackVisible checks that the user can see the feedback they act on over
+// the websocket
+func (api RestAPI) feedbackVisible(c echo.Context, feedbackID, userID string) bool {
+	isVisible, err := api.FeedbackClient.IsFeedbackVisible(c.Request().Context(), feedbackID, userID)
+	if err != nil || !isVisible {
+		api.Logging.Unsuccessful("creatix.feedback.FeedbackWebsocket: feedback not found", err)
+		return false
+	}
+	return true
+}
+
 // CommentFeedback comments a given feedback
 func (api RestAPI) CommentFeedback(c echo.Context) (err error) {
-	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.Read)
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead)
 	if err != nil || !isAuthorized {
 		api.Logging.Unsuccessful("creatix.feedback.getuserfeedback: no permission", err)
 		return utils.NoPermission
 	}
 
+	if archived, err := api.companyArchived(c); archived {
+		return err
+	}
+
 	userID := c.Get(utils.UserIDContext.String()).(string)
 	if userID == "" {
 		api.Logging.Unsuccessful("creatix.feedback.getuserfeedback: no permission", nil)
@@ -359,8 +492,17 @@ func (api RestAPI) CommentFeedback(c echo.Context) (err error) {
 	}
 
 	feedbackID := c.Param("fid")
-	if err = api.FeedbackClient.CommentFeedback(c.Request().Context(), comment.Comment, userID, feedbackID); err != nil {
+	isVisible, err := api.FeedbackClient.IsFeedbackVisible(c.Request().Context(), feedbackID, userID)
+	if err != nil || !isVisible {
+		api.Logging.Unsuccessful("creatix.feedback.commentfeedback: feedback not found", err)
+		return c.String(http.StatusNotFound, "")
+	}
+
+	if err = api.FeedbackClient.CommentFeedback(c.Request().Context(), *comment, userID, feedbackID); err != nil {
 		api.Logging.Unsuccessful("creatix.feedback.commentfeedback: not able to update comment", err)
+		if err == models.CommentNotFoundError {
+			return c.JSON(http.StatusNotFound, web.HttpResponse{Message: "the comment replied to was not found"})
+		}
 		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "not able to write comment"})
 	}
 
@@ -370,7 +512,7 @@ func (api RestAPI) CommentFeedback(c echo.Context) (err error) {
 		return c.String(http.StatusBadRequest, "")
 	}
 
-	feedbacks, err := api.FeedbackClient.GetCompanyFeedbackswData(c.Request().Context(), companyID)
+	feedbacks, err := api.FeedbackClient.GetCompanyFeedbackswData(c.Request().Context(), companyID, userID, models.FeedbackFilter{})
 	if err != nil {
 		api.Logging.Unsuccessful("creatix.feedback.getUserFeedback: not able to get feedback claps", err)
 		return err
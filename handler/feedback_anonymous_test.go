@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnonymousFeedback(t *testing.T) {
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	ctx := context.Background()
+
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "john@doe.no", Access: models.Write}))
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "user@read.no", Access: models.Read}))
+
+	anonymous := newFeedbackRequest()
+	anonymous.Anonymous = true
+
+	// Companies have to allow anonymous feedback
+	code, _ := callHandler(t, restAPI, restAPI.PostFeedback, "2", anonymous, "1")
+	assert.Equal(t, http.StatusForbidden, code)
+	require.NoError(t, restAPI.CompanyClient.UpdateSettings(ctx, "1", models.SettingsRequest{AllowAnonymousFeedback: true, Timezone: "UTC"}))
+	code, _ = callHandler(t, restAPI, restAPI.PostFeedback, "2", anonymous, "1")
+	require.Equal(t, http.StatusOK, code)
+
+	// Nobody sees who wrote it, not even admins
+	code, body := callHandler(t, restAPI, restAPI.GetCompanyFeedback, "1", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	assert.NotContains(t, string(body), "John")
+	var listed models.Feedbacks
+	require.NoError(t, json.Unmarshal(body, &listed))
+	require.Len(t, listed, 1)
+	assert.True(t, listed[0].Anonymous)
+	assert.Equal(t, "", listed[0].UserID)
+	assert.Equal(t, models.Person{}, listed[0].Person)
+
+	found, err := restAPI.FeedbackClient.SearchFeedbackwData(ctx, "1", "1", "title", models.FeedbackFilter{})
+	require.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, "", found[0].UserID)
+		assert.Equal(t, models.Person{}, found[0].Person)
+	}
+
+	code, body = callHandler(t, restAPI, restAPI.GetStatusHistory, "1", nil, "1", "1")
+	require.Equal(t, http.StatusOK, code)
+	var history []models.StatusChange
+	require.NoError(t, json.Unmarshal(body, &history))
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, models.Person{}, history[0].Person)
+	}
+
+	// Only the author finds it among their own feedback and edits it
+	code, body = callHandler(t, restAPI, restAPI.GetUserFeedback, "2", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	var own []models.Feedback
+	require.NoError(t, json.Unmarshal(body, &own))
+	assert.Len(t, own, 1)
+	code, body = callHandler(t, restAPI, restAPI.GetUserFeedback, "1", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	own = nil
+	require.NoError(t, json.Unmarshal(body, &own))
+	assert.Len(t, own, 0)
+
+	isOwner, err := restAPI.FeedbackClient.IsUserOwnerOfFeedback(ctx, "1", "2")
+	require.NoError(t, err)
+	assert.True(t, isOwner)
+	isOwner, err = restAPI.FeedbackClient.IsUserOwnerOfFeedback(ctx, "1", "1")
+	require.NoError(t, err)
+	assert.False(t, isOwner)
+
+	update := newFeedbackRequest()
+	update.Title = "A better title"
+	code, _ = callHandler(t, restAPI, restAPI.UpdateFeedback, "2", update, "1", "1")
+	require.Equal(t, http.StatusOK, code)
+	feedbacks, err := restAPI.FeedbackClient.GetCompanyFeedbacks(ctx, "1", "1", models.FeedbackFilter{})
+	require.NoError(t, err)
+	if assert.Len(t, feedbacks, 1) {
+		assert.Equal(t, "A better title", feedbacks[0].Title)
+		assert.True(t, feedbacks[0].Anonymous)
+	}
+
+	// The author deleting it is not traced in the audit log
+	code, _ = callHandler(t, restAPI, restAPI.DeleteFeedback, "2", nil, "1", "1")
+	require.Equal(t, http.StatusOK, code)
+	code, body = callHandlerWithQuery(t, restAPI, restAPI.GetAuditLog, "1", "action=feedback.deleted", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	var log models.AuditLog
+	require.NoError(t, json.Unmarshal(body, &log))
+	if assert.Len(t, log.Events, 1) {
+		assert.Equal(t, "1", log.Events[0].TargetID)
+		assert.Equal(t, "", log.Events[0].ActorID)
+		assert.Equal(t, "", log.Events[0].IP)
+	}
+
+	// Anonymous feedback counts towards the rate limit of its author
+	for i := 1; i < 20; i++ {
+		code, _ = callHandler(t, restAPI, restAPI.PostFeedback, "2", newFeedbackRequest(), "1")
+		require.Equal(t, http.StatusOK, code)
+	}
+	code, _ = callHandler(t, restAPI, restAPI.PostFeedback, "2", anonymous, "1")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	code, _ = callHandler(t, restAPI, restAPI.PostFeedback, "2", newFeedbackRequest(), "1")
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	code, _ = callHandler(t, restAPI, restAPI.PostFeedback, "1", anonymous, "1")
+	assert.Equal(t, http.StatusOK, code)
+}
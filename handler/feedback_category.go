@@ -0,0 +1,263 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/kristohberg/CreatixBackend/web"
+	"github.com/labstack/echo"
+)
+
+var (
+	GETFeedbackCategoriesPath  = "/company/:company/categories"
+	POSTFeedbackCategoryPath   = "/company/:company/categories"
+	PUTFeedbackCategoryPath    = "/company/:company/categories/:category"
+	DELETEFeedbackCategoryPath = "/company/:company/categories/:category"
+	GETFeedbackTagsPath        = "/company/:company/tags"
+	POSTFeedbackTagPath        = "/company/:company/tags"
+	PUTFeedbackTagPath         = "/company/:company/tags/:tag"
+	DELETEFeedbackTagPath      = "/company/:company/tags/:tag"
+)
+
+// feedbackResults responds with the list of feedback. Clients which also want
+// the counts of its tags ask for them with tagCounts=true
+func feedbackResults(c echo.Context, feedbacks models.Feedbacks) error {
+	if withTagCounts, _ := strconv.ParseBool(c.QueryParam("tagCounts")); withTagCounts {
+		return c.JSON(http.StatusOK, models.NewFeedbackResults(feedbacks))
+	}
+	return c.JSON(http.StatusOK, feedbacks)
+}
+
+// GetFeedbackCategories lists the categories of the company
+func (api RestAPI) GetFeedbackCategories(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.category.list: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	categories, err := api.FeedbackClient.GetFeedbackCategories(c.Request().Context(), c.Param("company"))
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.category.list: not able to get categories", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, categories)
+}
+
+func (api RestAPI) feedbackCategoryRequestFromContext(c echo.Context) (categoryRequest *models.FeedbackCategoryRequest, err error) {
+	categoryRequest = new(models.FeedbackCategoryRequest)
+	if err = c.Bind(categoryRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.category: could not bind category", err)
+		return nil, c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	if err = categoryRequest.Valid(); err != nil {
+		return nil, c.JSON(http.StatusBadRequest, err)
+	}
+	return categoryRequest, nil
+}
+
+// CreateFeedbackCategory adds a category to the company
+func (api RestAPI) CreateFeedbackCategory(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.category.create: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	categoryRequest, err := api.feedbackCategoryRequestFromContext(c)
+	if categoryRequest == nil {
+		return err
+	}
+
+	category, err := api.FeedbackClient.CreateFeedbackCategory(c.Request().Context(), c.Param("company"), *categoryRequest)
+	switch err {
+	case nil:
+	case models.CategoryExistsError:
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.category.create: not able to create category", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, category)
+}
+
+// UpdateFeedbackCategory renames a category of the company
+func (api RestAPI) UpdateFeedbackCategory(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.category.update: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	categoryID, err := strconv.Atoi(c.Param("category"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.CategoryNotFoundError.Error()))
+	}
+
+	categoryRequest, err := api.feedbackCategoryRequestFromContext(c)
+	if categoryRequest == nil {
+		return err
+	}
+
+	err = api.FeedbackClient.UpdateFeedbackCategory(c.Request().Context(), c.Param("company"), categoryID, *categoryRequest)
+	switch err {
+	case nil:
+	case models.CategoryExistsError:
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	case models.CategoryNotFoundError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.category.update: not able to update category", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "updated"})
+}
+
+// DeleteFeedbackCategory deletes a category of the company, its feedback is
+// kept without a category
+func (api RestAPI) DeleteFeedbackCategory(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.category.delete: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	categoryID, err := strconv.Atoi(c.Param("category"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.CategoryNotFoundError.Error()))
+	}
+
+	err = api.FeedbackClient.DeleteFeedbackCategory(c.Request().Context(), c.Param("company"), categoryID)
+	switch err {
+	case nil:
+	case models.CategoryNotFoundError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.category.delete: not able to delete category", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "deleted"})
+}
+
+// GetFeedbackTags lists the tags of the company
+func (api RestAPI) GetFeedbackTags(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.tag.list: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	tags, err := api.FeedbackClient.GetCompanyTags(c.Request().Context(), c.Param("company"))
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.tag.list: not able to get tags", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, tags)
+}
+
+func (api RestAPI) feedbackTagRequestFromContext(c echo.Context) (tagRequest *models.FeedbackTagRequest, err error) {
+	tagRequest = new(models.FeedbackTagRequest)
+	if err = c.Bind(tagRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.tag: could not bind tag", err)
+		return nil, c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	if err = tagRequest.Valid(); err != nil {
+		return nil, c.JSON(http.StatusBadRequest, err)
+	}
+	return tagRequest, nil
+}
+
+// CreateFeedbackTag adds a tag to the company
+func (api RestAPI) CreateFeedbackTag(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.tag.create: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	tagRequest, err := api.feedbackTagRequestFromContext(c)
+	if tagRequest == nil {
+		return err
+	}
+
+	tag, err := api.FeedbackClient.CreateFeedbackTag(c.Request().Context(), c.Param("company"), *tagRequest)
+	switch err {
+	case nil:
+	case models.TagExistsError:
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.tag.create: not able to create tag", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, tag)
+}
+
+// UpdateFeedbackTag renames a tag of the company
+func (api RestAPI) UpdateFeedbackTag(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.tag.update: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	tagID, err := strconv.Atoi(c.Param("tag"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.TagNotFoundError.Error()))
+	}
+
+	tagRequest, err := api.feedbackTagRequestFromContext(c)
+	if tagRequest == nil {
+		return err
+	}
+
+	err = api.FeedbackClient.UpdateFeedbackTag(c.Request().Context(), c.Param("company"), tagID, *tagRequest)
+	switch err {
+	case nil:
+	case models.TagExistsError:
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	case models.TagNotFoundError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.tag.update: not able to update tag", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "updated"})
+}
+
+// DeleteFeedbackTag deletes a tag of the company and removes it from all
+// feedback
+func (api RestAPI) DeleteFeedbackTag(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.tag.delete: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	tagID, err := strconv.Atoi(c.Param("tag"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.TagNotFoundError.Error()))
+	}
+
+	err = api.FeedbackClient.DeleteFeedbackTag(c.Request().Context(), c.Param("company"), tagID)
+	switch err {
+	case nil:
+	case models.TagNotFoundError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.tag.delete: not able to delete tag", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "deleted"})
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedbackCategories(t *testing.T) {
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	ctx := context.Background()
+
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "john@doe.no", Access: models.Write}))
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "user@read.no", Access: models.Read}))
+
+	companyFeedback := func(query string) models.FeedbackResults {
+		code, body := callHandlerWithQuery(t, restAPI, restAPI.GetCompanyFeedback, "3", "tagCounts=true&"+query, nil, "1")
+		require.Equal(t, http.StatusOK, code)
+		var results models.FeedbackResults
+		require.NoError(t, json.Unmarshal(body, &results))
+		return results
+	}
+
+	byID := func(feedbacks models.Feedbacks) map[string]models.Feedback {
+		feedbackByID := make(map[string]models.Feedback, len(feedbacks))
+		for _, feedback := range feedbacks {
+			feedbackByID[feedback.ID] = feedback
+		}
+		return feedbackByID
+	}
+
+	postFeedback := func(categoryID *int, tags ...string) int {
+		feedback := newFeedbackRequest()
+		feedback.CategoryID = categoryID
+		feedback.Tags = tags
+		code, _ := callHandler(t, restAPI, restAPI.PostFeedback, "2", feedback, "1")
+		return code
+	}
+
+	// Admins define the categories
+	code, _ := callHandler(t, restAPI, restAPI.CreateFeedbackCategory, "2", models.FeedbackCategoryRequest{Name: "Bugs"}, "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = callHandler(t, restAPI, restAPI.CreateFeedbackCategory, "1", models.FeedbackCategoryRequest{Name: " "}, "1")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, body := callHandler(t, restAPI, restAPI.CreateFeedbackCategory, "1", models.FeedbackCategoryRequest{Name: "Bugs"}, "1")
+	require.Equal(t, http.StatusOK, code)
+	var bugs models.FeedbackCategory
+	require.NoError(t, json.Unmarshal(body, &bugs))
+	code, body = callHandler(t, restAPI, restAPI.CreateFeedbackCategory, "1", models.FeedbackCategoryRequest{Name: "Ideas"}, "1")
+	require.Equal(t, http.StatusOK, code)
+	var ideas models.FeedbackCategory
+	require.NoError(t, json.Unmarshal(body, &ideas))
+	code, _ = callHandler(t, restAPI, restAPI.CreateFeedbackCategory, "1", models.FeedbackCategoryRequest{Name: "bugs"}, "1")
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, body = callHandler(t, restAPI, restAPI.GetFeedbackCategories, "3", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	var categories []models.FeedbackCategory
+	require.NoError(t, json.Unmarshal(body, &categories))
+	assert.Equal(t, []models.FeedbackCategory{bugs, ideas}, categories)
+
+	// Feedback is posted in a category with tags, which are lowercased
+	assert.Equal(t, http.StatusOK, postFeedback(&bugs.ID, "UI", " ux ", "ui"))
+	assert.Equal(t, http.StatusOK, postFeedback(&ideas.ID, "ui"))
+	assert.Equal(t, http.StatusOK, postFeedback(nil))
+	unknown := 9999
+	assert.Equal(t, http.StatusBadRequest, postFeedback(&unknown))
+	assert.Equal(t, http.StatusBadRequest, postFeedback(nil, "a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"))
+
+	results := companyFeedback("")
+	require.Len(t, results.Feedbacks, 3)
+	feedbacks := byID(results.Feedbacks)
+	assert.Equal(t, &bugs, feedbacks["1"].Category)
+	assert.Equal(t, []string{"ui", "ux"}, feedbacks["1"].Tags)
+	assert.Nil(t, feedbacks["3"].Category)
+	assert.Equal(t, []models.TagCount{{Tag: "ui", Count: 2}, {Tag: "ux", Count: 1}}, results.Tags)
+
+	// Without asking for the tag counts the feedback is listed as before
+	code, body = callHandlerWithQuery(t, restAPI, restAPI.GetCompanyFeedback, "3", "tag=ui", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	var listed models.Feedbacks
+	require.NoError(t, json.Unmarshal(body, &listed))
+	assert.Len(t, listed, 2)
+
+	// Lists and searches filter on category and tags
+	assert.Len(t, companyFeedback("category="+strconv.Itoa(ideas.ID)).Feedbacks, 1)
+	assert.Len(t, companyFeedback("category=unknown").Feedbacks, 0)
+	assert.Len(t, companyFeedback("tag=UI").Feedbacks, 2)
+	results = companyFeedback("tag=ui,ux")
+	if assert.Len(t, results.Feedbacks, 1) {
+		assert.Equal(t, "1", results.Feedbacks[0].ID)
+	}
+	assert.Len(t, companyFeedback("tag=ui&category="+strconv.Itoa(ideas.ID)).Feedbacks, 1)
+	found, err := restAPI.FeedbackClient.SearchFeedbackwData(ctx, "1", "1", "title", models.FeedbackFilter{Tags: []string{"ux"}})
+	require.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, "1", found[0].ID)
+	}
+
+	// Updates keep the category and tags which are left out, and a category
+	// of 0 clears it
+	update := newFeedbackRequest()
+	update.Tags = []string{"mobile"}
+	code, _ = callHandler(t, restAPI, restAPI.UpdateFeedback, "2", update, "1", "2")
+	require.Equal(t, http.StatusOK, code)
+	none := 0
+	update = newFeedbackRequest()
+	update.CategoryID = &none
+	code, _ = callHandler(t, restAPI, restAPI.UpdateFeedback, "2", update, "1", "1")
+	require.Equal(t, http.StatusOK, code)
+	feedbacks = byID(companyFeedback("").Feedbacks)
+	require.Len(t, feedbacks, 3)
+	assert.Nil(t, feedbacks["1"].Category)
+	assert.Equal(t, []string{"ui", "ux"}, feedbacks["1"].Tags)
+	assert.Equal(t, &ideas, feedbacks["2"].Category)
+	assert.Equal(t, []string{"mobile"}, feedbacks["2"].Tags)
+
+	// Admins rename and delete tags and categories
+	code, body = callHandler(t, restAPI, restAPI.GetFeedbackTags, "3", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	var tags []models.FeedbackTag
+	require.NoError(t, json.Unmarshal(body, &tags))
+	require.Len(t, tags, 3)
+	assert.Equal(t, "mobile", tags[0].Name)
+	uiID, uxID := strconv.Itoa(tags[1].ID), strconv.Itoa(tags[2].ID)
+
+	code, _ = callHandler(t, restAPI, restAPI.UpdateFeedbackTag, "2", models.FeedbackTagRequest{Name: "design"}, "1", uiID)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = callHandler(t, restAPI, restAPI.UpdateFeedbackTag, "1", models.FeedbackTagRequest{Name: "UX"}, "1", uiID)
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = callHandler(t, restAPI, restAPI.UpdateFeedbackTag, "1", models.FeedbackTagRequest{Name: "Design"}, "1", uiID)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.DeleteFeedbackTag, "1", nil, "1", uxID)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.DeleteFeedbackTag, "1", nil, "1", uxID)
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = callHandler(t, restAPI, restAPI.CreateFeedbackTag, "1", models.FeedbackTagRequest{Name: "Backlog"}, "1")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = callHandler(t, restAPI, restAPI.UpdateFeedbackCategory, "1", models.FeedbackCategoryRequest{Name: "Features"}, "1", strconv.Itoa(ideas.ID))
+	assert.Equal(t, http.StatusOK, code)
+	results = companyFeedback("tag=mobile")
+	if assert.Len(t, results.Feedbacks, 1) && assert.NotNil(t, results.Feedbacks[0].Category) {
+		assert.Equal(t, "Features", results.Feedbacks[0].Category.Name)
+	}
+	results = companyFeedback("")
+	assert.Equal(t, []string{"design"}, byID(results.Feedbacks)["1"].Tags)
+	assert.Equal(t, []models.TagCount{{Tag: "design", Count: 1}, {Tag: "mobile", Count: 1}}, results.Tags)
+
+	code, _ = callHandler(t, restAPI, restAPI.DeleteFeedbackCategory, "1", nil, "1", strconv.Itoa(ideas.ID))
+	assert.Equal(t, http.StatusOK, code)
+	feedbacks = byID(companyFeedback("").Feedbacks)
+	require.Len(t, feedbacks, 3)
+	assert.Nil(t, feedbacks["2"].Category)
+
+	// Categories of other companies cannot be used
+	otherCompanyID, err := restAPI.CompanyClient.CreateCompany(ctx, "OtherCorp", "2")
+	require.NoError(t, err)
+	other, err := restAPI.FeedbackClient.CreateFeedbackCategory(ctx, strconv.FormatInt(*otherCompanyID, 10), models.FeedbackCategoryRequest{Name: "Other"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, postFeedback(&other.ID))
+	code, _ = callHandler(t, restAPI, restAPI.DeleteFeedbackCategory, "1", nil, "1", strconv.Itoa(other.ID))
+	assert.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/kristohberg/CreatixBackend/web"
+	"github.com/labstack/echo"
+)
+
+var (
+	PUTCommentPath    = "/company/:company/feedback/:fid/comments/:comment"
+	DELETECommentPath = "/company/:company/feedback/:fid/comments/:comment"
+)
+
+// UpdateComment changes the text of a comment written by the user
+func (api RestAPI) UpdateComment(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.comment.update: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	if archived, err := api.companyArchived(c); archived {
+		return err
+	}
+
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	feedbackID := c.Param("fid")
+	isVisible, err := api.FeedbackClient.IsFeedbackVisible(c.Request().Context(), feedbackID, userID)
+	if err != nil || !isVisible {
+		api.Logging.Unsuccessful("creatix.comment.update: feedback not found", err)
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.FeedbackNotFoundError.Error()))
+	}
+
+	comment := new(models.CommentRequest)
+	if err = c.Bind(comment); err != nil {
+		api.Logging.Unsuccessful("creatix.comment.update: not able to bind comment", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "not able to bind comment"})
+	}
+
+	err = api.FeedbackClient.UpdateComment(c.Request().Context(), feedbackID, c.Param("comment"), userID, comment.Comment)
+	switch err {
+	case nil:
+	case models.NotCommentAuthorError:
+		return c.JSON(http.StatusForbidden, utils.NewWebError(err.Error()))
+	case models.CommentNotFoundError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.comment.update: not able to update comment", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "updated"})
+}
+
+// DeleteComment lets moderators delete a comment. Replies to it stay
+func (api RestAPI) DeleteComment(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackModerate)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.comment.delete: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	feedbackID := c.Param("fid")
+	isVisible, err := api.FeedbackClient.IsFeedbackVisible(c.Request().Context(), feedbackID, userID)
+	if err != nil || !isVisible {
+		api.Logging.Unsuccessful("creatix.comment.delete: feedback not found", err)
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.FeedbackNotFoundError.Error()))
+	}
+
+	err = api.FeedbackClient.DeleteComment(utils.WithClientInfo(c), feedbackID, c.Param("comment"))
+	switch err {
+	case nil:
+	case models.CommentNotFoundError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.comment.delete: not able to delete comment", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "deleted"})
+}
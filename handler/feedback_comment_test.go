@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommentReplies(t *testing.T) {
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	ctx := context.Background()
+
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "john@doe.no", Access: models.Write}))
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "user@read.no", Access: models.Read}))
+
+	comment := func(userID, text, parentID string) int {
+		code, _ := callHandler(t, restAPI, restAPI.CommentFeedback, userID, models.CommentRequest{Comment: text, ParentID: parentID}, "1", "1")
+		return code
+	}
+
+	comments := func() []models.Comment {
+		code, body := callHandler(t, restAPI, restAPI.GetCompanyFeedback, "3", nil, "1")
+		require.Equal(t, http.StatusOK, code)
+		var feedbacks models.Feedbacks
+		require.NoError(t, json.Unmarshal(body, &feedbacks))
+		for _, feedback := range feedbacks {
+			if feedback.ID == "1" {
+				return feedback.Comments
+			}
+		}
+		require.FailNow(t, "feedback not found")
+		return nil
+	}
+
+	code, _ := callHandler(t, restAPI, restAPI.PostFeedback, "2", newFeedbackRequest(), "1")
+	require.Equal(t, http.StatusOK, code)
+
+	// Replies are nested under the comment they answer
+	require.Equal(t, http.StatusOK, comment("2", "first", ""))
+	require.Equal(t, http.StatusOK, comment("3", "reply", "1"))
+	require.Equal(t, http.StatusOK, comment("1", "second", ""))
+	require.Equal(t, http.StatusOK, comment("2", "reply to reply", "2"))
+
+	thread := comments()
+	require.Len(t, thread, 2)
+	assert.Equal(t, "first", thread[0].Comment)
+	assert.Equal(t, "second", thread[1].Comment)
+	require.Len(t, thread[0].Replies, 1)
+	assert.Equal(t, "reply", thread[0].Replies[0].Comment)
+	assert.Equal(t, "1", thread[0].Replies[0].ParentID)
+	require.Len(t, thread[0].Replies[0].Replies, 1)
+	assert.Equal(t, "reply to reply", thread[0].Replies[0].Replies[0].Comment)
+
+	// Replies below the deepest level are listed next to each other
+	require.Equal(t, http.StatusOK, comment("3", "fourth level", "4"))
+	require.Equal(t, http.StatusOK, comment("2", "fifth level", "5"))
+	require.Equal(t, http.StatusOK, comment("3", "sixth level", "6"))
+	deepest := comments()[0].Replies[0].Replies[0].Replies
+	if assert.Len(t, deepest, 3) {
+		assert.Equal(t, "fourth level", deepest[0].Comment)
+		assert.Equal(t, "fifth level", deepest[1].Comment)
+		assert.Equal(t, "sixth level", deepest[2].Comment)
+		assert.Nil(t, deepest[0].Replies)
+	}
+
+	// Only the author edits a comment
+	edit := models.CommentRequest{Comment: "first, edited"}
+	code, _ = callHandler(t, restAPI, restAPI.UpdateComment, "1", edit, "1", "1", "1")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, models.NotCommentAuthorError, restAPI.FeedbackClient.UpdateComment(ctx, "1", "1", "3", "taken over"))
+
+	// Refused edits do not leave the comment locked
+	lockCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, restAPI.FeedbackClient.UpdateComment(lockCtx, "1", "1", "2", "first"))
+
+	code, _ = callHandler(t, restAPI, restAPI.UpdateComment, "2", edit, "1", "1", "99")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = callHandler(t, restAPI, restAPI.UpdateComment, "2", edit, "1", "1", "1")
+	require.Equal(t, http.StatusOK, code)
+	thread = comments()
+	assert.Equal(t, "first, edited", thread[0].Comment)
+	assert.NotNil(t, thread[0].UpdatedAt)
+
+	// Moderators delete comments, and deleted comments with replies are kept
+	// as placeholders
+	code, _ = callHandler(t, restAPI, restAPI.DeleteComment, "3", nil, "1", "1", "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = callHandler(t, restAPI, restAPI.DeleteComment, "1", nil, "1", "1", "1")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.DeleteComment, "1", nil, "1", "1", "1")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = callHandler(t, restAPI, restAPI.DeleteComment, "1", nil, "1", "1", "3")
+	require.Equal(t, http.StatusOK, code)
+
+	thread = comments()
+	require.Len(t, thread, 1)
+	assert.True(t, thread[0].Deleted)
+	assert.Equal(t, "", thread[0].Comment)
+	assert.Equal(t, models.Person{}, thread[0].Person)
+	require.Len(t, thread[0].Replies, 1)
+	assert.Equal(t, "reply", thread[0].Replies[0].Comment)
+
+	// Deleted comments cannot be replied to or edited
+	assert.Equal(t, http.StatusNotFound, comment("3", "too late", "1"))
+	code, _ = callHandler(t, restAPI, restAPI.UpdateComment, "2", edit, "1", "1", "1")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Replies stay on the feedback of the comment they answer
+	code, _ = callHandler(t, restAPI, restAPI.PostFeedback, "1", newFeedbackRequest(), "1")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.CommentFeedback, "1", models.CommentRequest{Comment: "wrong thread", ParentID: "2"}, "1", "2")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, body := callHandlerWithQuery(t, restAPI, restAPI.GetAuditLog, "1", "action=comment.deleted", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	var log models.AuditLog
+	require.NoError(t, json.Unmarshal(body, &log))
+	if assert.Len(t, log.Events, 2) {
+		assert.Equal(t, "1", log.Events[0].ActorID)
+	}
+}
@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/kristohberg/CreatixBackend/web"
+	"github.com/labstack/echo"
+)
+
+var (
+	GETFeedbackStatusesPath     = "/company/:company/statuses"
+	POSTFeedbackStatusPath      = "/company/:company/statuses"
+	PUTFeedbackStatusPath       = "/company/:company/statuses/:status"
+	DELETEFeedbackStatusPath    = "/company/:company/statuses/:status"
+	PUTChangeFeedbackStatusPath = "/company/:company/feedback/:fid/status"
+	GETStatusHistoryPath        = "/company/:company/feedback/:fid/status/history"
+)
+
+// feedbackFilterFromContext reads the filter of listed and searched feedback
+// from the query. Statuses and tags are given as repeated or comma separated
+// status and tag parameters. An unknown category matches no feedback
+func feedbackFilterFromContext(c echo.Context) (filter models.FeedbackFilter) {
+	for _, value := range c.QueryParams()["status"] {
+		filter.Statuses = append(filter.Statuses, strings.Split(value, ",")...)
+	}
+	for _, value := range c.QueryParams()["tag"] {
+		filter.Tags = append(filter.Tags, strings.Split(value, ",")...)
+	}
+	if category := c.QueryParam("category"); category != "" {
+		filter.CategoryID = -1
+		if categoryID, err := strconv.Atoi(category); err == nil && categoryID > 0 {
+			filter.CategoryID = categoryID
+		}
+	}
+	return filter
+}
+
+// GetFeedbackStatuses lists the statuses of the company with the statuses
+// feedback can move to from each of them
+func (api RestAPI) GetFeedbackStatuses(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.status.list: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	statuses, err := api.FeedbackClient.GetFeedbackStatuses(c.Request().Context(), c.Param("company"))
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.status.list: not able to get statuses", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, statuses)
+}
+
+func (api RestAPI) feedbackStatusRequestFromContext(c echo.Context) (statusRequest *models.FeedbackStatusRequest, err error) {
+	statusRequest = new(models.FeedbackStatusRequest)
+	if err = c.Bind(statusRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.status: could not bind status", err)
+		return nil, c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	if err = statusRequest.Valid(); err != nil {
+		return nil, c.JSON(http.StatusBadRequest, err)
+	}
+	return statusRequest, nil
+}
+
+// CreateFeedbackStatus adds a status to the workflow of the company
+func (api RestAPI) CreateFeedbackStatus(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.status.create: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	statusRequest, err := api.feedbackStatusRequestFromContext(c)
+	if statusRequest == nil {
+		return err
+	}
+
+	status, err := api.FeedbackClient.CreateFeedbackStatus(c.Request().Context(), c.Param("company"), *statusRequest)
+	switch err {
+	case nil:
+	case models.StatusExistsError:
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	case models.UnknownTransitionError:
+		return c.JSON(http.StatusBadRequest, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.status.create: not able to create status", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, status)
+}
+
+// UpdateFeedbackStatus renames a status of the company and replaces the
+// statuses feedback can move to from it
+func (api RestAPI) UpdateFeedbackStatus(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.status.update: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	statusID, err := strconv.Atoi(c.Param("status"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.StatusNotFoundError.Error()))
+	}
+
+	statusRequest, err := api.feedbackStatusRequestFromContext(c)
+	if statusRequest == nil {
+		return err
+	}
+
+	err = api.FeedbackClient.UpdateFeedbackStatus(c.Request().Context(), c.Param("company"), statusID, *statusRequest)
+	switch err {
+	case nil:
+	case models.StatusExistsError, models.InitialStatusError:
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	case models.UnknownTransitionError:
+		return c.JSON(http.StatusBadRequest, utils.NewWebError(err.Error()))
+	case models.StatusNotFoundError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.status.update: not able to update status", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "updated"})
+}
+
+// DeleteFeedbackStatus deletes a status of the company no feedback has
+func (api RestAPI) DeleteFeedbackStatus(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.status.delete: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	statusID, err := strconv.Atoi(c.Param("status"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.StatusNotFoundError.Error()))
+	}
+
+	err = api.FeedbackClient.DeleteFeedbackStatus(c.Request().Context(), c.Param("company"), statusID)
+	switch err {
+	case nil:
+	case models.StatusInUseError, models.InitialStatusError:
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	case models.StatusNotFoundError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.status.delete: not able to delete status", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "deleted"})
+}
+
+// ChangeFeedbackStatus moves feedback to one of the statuses its current
+// status allows
+func (api RestAPI) ChangeFeedbackStatus(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackTriage)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.status.change: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	feedbackID := c.Param("fid")
+	isVisible, err := api.FeedbackClient.IsFeedbackVisible(c.Request().Context(), feedbackID, userID)
+	if err != nil || !isVisible {
+		api.Logging.Unsuccessful("creatix.status.change: feedback not found", err)
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.FeedbackNotFoundError.Error()))
+	}
+
+	changeRequest := new(models.StatusChangeRequest)
+	if err = c.Bind(changeRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.status.change: could not bind status", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	err = api.FeedbackClient.ChangeFeedbackStatus(c.Request().Context(), c.Param("company"), feedbackID, userID, changeRequest.StatusID)
+	switch err {
+	case nil:
+	case models.InvalidStatusTransitionError:
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	case models.FeedbackNotFoundError, models.StatusNotFoundError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.status.change: not able to change status", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "status changed"})
+}
+
+// GetStatusHistory lists the status changes of feedback, oldest first
+func (api RestAPI) GetStatusHistory(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.status.history: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	feedbackID := c.Param("fid")
+	isVisible, err := api.FeedbackClient.IsFeedbackVisible(c.Request().Context(), feedbackID, userID)
+	if err != nil || !isVisible {
+		api.Logging.Unsuccessful("creatix.status.history: feedback not found", err)
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.FeedbackNotFoundError.Error()))
+	}
+
+	history, err := api.FeedbackClient.GetStatusHistory(c.Request().Context(), c.Param("company"), feedbackID)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.status.history: not able to get status history", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, history)
+}
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedbackStatus(t *testing.T) {
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	ctx := context.Background()
+
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "john@doe.no", Access: models.Write}))
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "user@read.no", Access: models.Read}))
+
+	statuses := func() map[string]models.FeedbackStatus {
+		code, body := callHandler(t, restAPI, restAPI.GetFeedbackStatuses, "3", nil, "1")
+		require.Equal(t, http.StatusOK, code)
+		var list []models.FeedbackStatus
+		require.NoError(t, json.Unmarshal(body, &list))
+		byName := make(map[string]models.FeedbackStatus, len(list))
+		for _, status := range list {
+			byName[status.Name] = status
+		}
+		return byName
+	}
+
+	changeStatus := func(userID, feedbackID string, statusID int) int {
+		code, _ := callHandler(t, restAPI, restAPI.ChangeFeedbackStatus, userID, models.StatusChangeRequest{StatusID: statusID}, "1", feedbackID)
+		return code
+	}
+
+	userFeedback := func(userID, query string) []models.Feedback {
+		code, body := callHandlerWithQuery(t, restAPI, restAPI.GetUserFeedback, userID, query, nil, "1")
+		require.Equal(t, http.StatusOK, code)
+		var feedbacks []models.Feedback
+		require.NoError(t, json.Unmarshal(body, &feedbacks))
+		return feedbacks
+	}
+
+	// Companies start out with the default workflow
+	workflow := statuses()
+	require.Len(t, workflow, 6)
+	open, planned, inProgress, done := workflow["open"], workflow["planned"], workflow["in progress"], workflow["done"]
+	assert.True(t, open.Initial)
+	assert.Equal(t, []int{workflow["under review"].ID, planned.ID, workflow["declined"].ID}, open.Transitions)
+
+	require.NoError(t, restAPI.FeedbackClient.CreateFeedback(ctx, "1", "1", newFeedbackRequest()))
+	require.NoError(t, restAPI.FeedbackClient.CreateFeedback(ctx, "2", "1", newFeedbackRequest()))
+	if feedbacks := userFeedback("1", ""); assert.Len(t, feedbacks, 1) {
+		assert.Equal(t, "open", feedbacks[0].Status)
+	}
+
+	// Only members who triage feedback change its status, along the
+	// transitions of the workflow
+	assert.Equal(t, http.StatusUnauthorized, changeStatus("3", "1", planned.ID))
+	assert.Equal(t, http.StatusConflict, changeStatus("2", "1", done.ID))
+	assert.Equal(t, http.StatusNotFound, changeStatus("2", "999", planned.ID))
+	assert.Equal(t, http.StatusOK, changeStatus("2", "1", planned.ID))
+	assert.Equal(t, http.StatusOK, changeStatus("1", "1", inProgress.ID))
+
+	code, body := callHandler(t, restAPI, restAPI.GetStatusHistory, "3", nil, "1", "1")
+	require.Equal(t, http.StatusOK, code)
+	var history []models.StatusChange
+	require.NoError(t, json.Unmarshal(body, &history))
+	require.Len(t, history, 3)
+	assert.Equal(t, "", history[0].From)
+	assert.Equal(t, "open", history[0].To)
+	assert.Equal(t, "1", history[0].Person.ID)
+	assert.Equal(t, "open", history[1].From)
+	assert.Equal(t, "planned", history[1].To)
+	assert.Equal(t, "John", history[1].Person.Firstname)
+	assert.Equal(t, "planned", history[2].From)
+	assert.Equal(t, "in progress", history[2].To)
+
+	// Lists and searches filter on the status
+	assert.Len(t, userFeedback("1", "status=in+progress"), 1)
+	assert.Len(t, userFeedback("1", "status=open&status=Done"), 0)
+	assert.Len(t, userFeedback("1", "status=open,In+Progress"), 1)
+	found, err := restAPI.FeedbackClient.SearchFeedbackwData(ctx, "1", "1", "title", models.FeedbackFilter{Statuses: []string{"open"}})
+	require.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, "2", found[0].ID)
+	}
+
+	// Admins change the workflow
+	shipped := models.FeedbackStatusRequest{Name: "Shipped", Transitions: []int{open.ID}}
+	code, _ = callHandler(t, restAPI, restAPI.CreateFeedbackStatus, "2", shipped, "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = callHandler(t, restAPI, restAPI.CreateFeedbackStatus, "1", models.FeedbackStatusRequest{Name: " "}, "1")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = callHandler(t, restAPI, restAPI.CreateFeedbackStatus, "1", models.FeedbackStatusRequest{Name: "Planned"}, "1")
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = callHandler(t, restAPI, restAPI.CreateFeedbackStatus, "1", models.FeedbackStatusRequest{Name: "Lost", Transitions: []int{9999}}, "1")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, body = callHandler(t, restAPI, restAPI.CreateFeedbackStatus, "1", shipped, "1")
+	require.Equal(t, http.StatusOK, code)
+	var created models.FeedbackStatus
+	require.NoError(t, json.Unmarshal(body, &created))
+	assert.Equal(t, models.FeedbackStatus{ID: created.ID, Name: "Shipped", Transitions: []int{open.ID}}, created)
+
+	inProgressID := strconv.Itoa(inProgress.ID)
+	code, _ = callHandler(t, restAPI, restAPI.UpdateFeedbackStatus, "1", models.FeedbackStatusRequest{Name: "Building", Transitions: []int{created.ID}}, "1", inProgressID)
+	require.Equal(t, http.StatusOK, code)
+	workflow = statuses()
+	assert.Equal(t, []int{created.ID}, workflow["Building"].Transitions)
+	assert.Equal(t, http.StatusOK, changeStatus("2", "1", created.ID))
+	if feedbacks := userFeedback("1", "status=shipped"); assert.Len(t, feedbacks, 1) {
+		assert.Equal(t, "Shipped", feedbacks[0].Status)
+	}
+
+	// Statuses in use and the initial status stay
+	statusID := strconv.Itoa(created.ID)
+	code, _ = callHandler(t, restAPI, restAPI.DeleteFeedbackStatus, "1", nil, "1", statusID)
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = callHandler(t, restAPI, restAPI.DeleteFeedbackStatus, "1", nil, "1", strconv.Itoa(open.ID))
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = callHandler(t, restAPI, restAPI.UpdateFeedbackStatus, "1", models.FeedbackStatusRequest{Name: "open"}, "1", strconv.Itoa(open.ID))
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = callHandler(t, restAPI, restAPI.DeleteFeedbackStatus, "1", nil, "1", strconv.Itoa(done.ID))
+	assert.Equal(t, http.StatusOK, code)
+
+	// New feedback gets the new initial status
+	code, _ = callHandler(t, restAPI, restAPI.UpdateFeedbackStatus, "1", models.FeedbackStatusRequest{Name: "New", Initial: true}, "1", strconv.Itoa(workflow["under review"].ID))
+	require.Equal(t, http.StatusOK, code)
+	workflow = statuses()
+	assert.False(t, workflow["open"].Initial)
+	assert.True(t, workflow["New"].Initial)
+	require.NoError(t, restAPI.FeedbackClient.CreateFeedback(ctx, "1", "1", newFeedbackRequest()))
+	assert.Len(t, userFeedback("1", "status=new"), 1)
+
+	// Statuses of other companies cannot be used
+	otherCompanyID, err := restAPI.CompanyClient.CreateCompany(ctx, "OtherCorp", "2")
+	require.NoError(t, err)
+	otherStatuses, err := restAPI.FeedbackClient.GetFeedbackStatuses(ctx, strconv.FormatInt(*otherCompanyID, 10))
+	require.NoError(t, err)
+	require.Len(t, otherStatuses, 6)
+	code, _ = callHandler(t, restAPI, restAPI.UpdateFeedbackStatus, "1", models.FeedbackStatusRequest{Name: "Shipped", Transitions: []int{otherStatuses[0].ID}}, "1", statusID)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, http.StatusNotFound, changeStatus("1", "2", otherStatuses[1].ID))
+}
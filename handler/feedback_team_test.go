@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTeamFeedback(t *testing.T) {
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	ctx := context.Background()
+
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "john@doe.no", Access: models.Write}))
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "user@read.no", Access: models.Read}))
+
+	teamID, err := restAPI.CompanyClient.CreateTeam(ctx, models.Team{CompanyID: "1", Name: "Backend"})
+	require.NoError(t, err)
+	require.NoError(t, restAPI.CompanyClient.AddUserToTeam(ctx, "1", teamID, models.TeamMemberRequest{UserID: "2"}))
+
+	visibleFeedback := func(userID string) []string {
+		feedbacks, err := restAPI.FeedbackClient.GetCompanyFeedbackswData(ctx, "1", userID, models.FeedbackFilter{})
+		require.NoError(t, err)
+		ids := []string{}
+		for _, feedback := range feedbacks {
+			ids = append(ids, feedback.ID)
+		}
+		return ids
+	}
+
+	// Feedback can only be shared with teams of the company
+	feedback := newFeedbackRequest()
+	feedback.TeamIDs = []string{"999"}
+	code, _ := callHandler(t, restAPI, restAPI.PostFeedback, "2", feedback, "1")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	feedback.TeamIDs = []string{teamID}
+	code, _ = callHandler(t, restAPI, restAPI.PostFeedback, "2", feedback, "1")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.PostFeedback, "1", newFeedbackRequest(), "1")
+	require.Equal(t, http.StatusOK, code)
+
+	feedbacks, err := restAPI.FeedbackClient.GetCompanyFeedbackswData(ctx, "1", "2", models.FeedbackFilter{})
+	require.NoError(t, err)
+	require.Len(t, feedbacks, 2)
+	teamFeedbackID := feedbacks[0].ID
+	if len(feedbacks[0].TeamIDs) == 0 {
+		teamFeedbackID = feedbacks[1].ID
+	}
+
+	// Team members and admins see the feedback, other members do not
+	assert.Len(t, visibleFeedback("1"), 2)
+	assert.Len(t, visibleFeedback("2"), 2)
+	assert.NotContains(t, visibleFeedback("3"), teamFeedbackID)
+	assert.Len(t, visibleFeedback("3"), 1)
+
+	found, err := restAPI.FeedbackClient.SearchFeedbackwData(ctx, "1", "3", "title", models.FeedbackFilter{})
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+	found, err = restAPI.FeedbackClient.SearchFeedbackwData(ctx, "1", "2", "title", models.FeedbackFilter{})
+	require.NoError(t, err)
+	assert.Len(t, found, 2)
+
+	code, _ = callHandler(t, restAPI, restAPI.ClapFeedback, "3", nil, "1", teamFeedbackID)
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = callHandler(t, restAPI, restAPI.CommentFeedback, "3", models.CommentRequest{Comment: "hidden"}, "1", teamFeedbackID)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Joining the team makes the feedback visible
+	require.NoError(t, restAPI.CompanyClient.AddUserToTeam(ctx, "1", teamID, models.TeamMemberRequest{UserID: "3"}))
+	assert.Contains(t, visibleFeedback("3"), teamFeedbackID)
+	require.NoError(t, restAPI.CompanyClient.RemoveUserFromTeam(ctx, "1", teamID, "3"))
+
+	// Deleting the team keeps the feedback hidden
+	require.NoError(t, restAPI.CompanyClient.DeleteTeam(ctx, "1", teamID))
+	assert.NotContains(t, visibleFeedback("3"), teamFeedbackID)
+	assert.Contains(t, visibleFeedback("2"), teamFeedbackID)
+
+	// The author can share it with the whole company again
+	feedback.TeamIDs = []string{}
+	code, _ = callHandler(t, restAPI, restAPI.UpdateFeedback, "2", feedback, "1", teamFeedbackID)
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, visibleFeedback("3"), teamFeedbackID)
+}
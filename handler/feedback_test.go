@@ -21,6 +21,7 @@ func newFeedback(ID, userID, firstname, lastname string) []models.Feedback {
 		Person:      models.Person{ID: "", Firstname: firstname, Lastname: lastname},
 		Title:       "This is my title",
 		Description: "A title says more than a thousand words",
+		Status:      "open",
 		Comments:    []models.Comment{},
 		Claps:       []models.Clap{},
 		UpdatedAt:   nil,
@@ -111,7 +112,15 @@ func TestFeedback(t *testing.T) {
 	// GET feedback as Reader
 	requester(GetFeedbackForUserCompanyPath, "3", parameterNameValue, nil, restAPI.GetUserFeedback, http.StatusUnauthorized, nil)
 
-	// Delete Feedback
+	// Delete Feedback through the router, which only finds feedback of the
+	// company in the path
+	restAPI.Handler(e.Group("/v0"))
+	writeToken := createAccessToken(t, e, restAPI, "1", models.ScopeFeedbackWrite)
+	rec := serveWithBearer(e, http.MethodDelete, "/v0/company/1/feedback/2", writeToken.Token)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serveWithBearer(e, http.MethodDelete, "/v0/company/1/feedback/2", writeToken.Token)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
 	parameterNameValue[0] = append(parameterNameValue[0], "fid")
 	parameterNameValue[1] = append(parameterNameValue[1], "1")
 	requester(DeleteFeedbackForUser, "1", parameterNameValue, nil, restAPI.DeleteFeedback, http.StatusOK, nil)
@@ -155,6 +164,7 @@ func TestClapFeedback(t *testing.T) {
 			},
 			Title:       "This is my title",
 			Description: "A title says more than a thousand words",
+			Status:      "open",
 			Comments:    []models.Comment{},
 			Claps:       []models.Clap{{ID: "1", UserID: "2", FeedbackID: "1"}},
 			UpdatedAt:   nil}}
@@ -212,6 +222,7 @@ func TestCommentFeedback(t *testing.T) {
 			},
 			Title:       "This is my title",
 			Description: "A title says more than a thousand words",
+			Status:      "open",
 			Comments:    []models.Comment{{ID: "1", FeedbackID: "1", Person: models.Person{ID: "2", Firstname: "John", Lastname: "Doe"}, Comment: newComment.Comment}},
 			Claps:       []models.Clap{},
 			UpdatedAt:   nil,
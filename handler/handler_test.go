@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/middleware"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/require"
+)
+
+const testUserAgent = "handler-test"
+
+// routeOf returns the route the handler is registered on by the rest api
+func routeOf(t *testing.T, restAPI RestAPI, handler echo.HandlerFunc) *echo.Route {
+	// Register on a copy so the scopes of the api under test are left alone
+	routesAPI := restAPI
+	routesAPI.Middleware = &middleware.Middleware{}
+	e := echo.New()
+	routesAPI.Handler(e.Group(""))
+
+	name := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+	for _, route := range e.Routes() {
+		if route.Name == name {
+			return route
+		}
+	}
+	require.FailNow(t, "handler is not registered", name)
+	return nil
+}
+
+// callHandler calls the handler as the user on the route it is registered
+// on. values are the path parameters in the order of the route path
+func callHandler(t *testing.T, restAPI RestAPI, handler echo.HandlerFunc, userID string, data interface{}, values ...string) (int, []byte) {
+	return callHandlerWithQuery(t, restAPI, handler, userID, "", data, values...)
+}
+
+// callHandlerWithQuery is callHandler with a query string added to the path
+func callHandlerWithQuery(t *testing.T, restAPI RestAPI, handler echo.HandlerFunc, userID, query string, data interface{}, values ...string) (int, []byte) {
+	route := routeOf(t, restAPI, handler)
+
+	var names []string
+	segments := strings.Split(route.Path, "/")
+	for _, segment := range segments {
+		if strings.HasPrefix(segment, ":") {
+			names = append(names, strings.TrimPrefix(segment, ":"))
+		}
+	}
+	require.Len(t, values, len(names), "parameters of %s %s", route.Method, route.Path)
+
+	path := make([]string, len(segments))
+	value := 0
+	for i, segment := range segments {
+		path[i] = segment
+		if strings.HasPrefix(segment, ":") {
+			path[i] = values[value]
+			value++
+		}
+	}
+	target := strings.Join(path, "/")
+	if query != "" {
+		target += "?" + query
+	}
+
+	body, err := json.Marshal(data)
+	require.NoError(t, err)
+	req := httptest.NewRequest(route.Method, target, strings.NewReader(string(body)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("User-Agent", testUserAgent)
+	rec := httptest.NewRecorder()
+
+	c := echo.New().NewContext(req, rec)
+	c.SetPath(route.Path)
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+	c.Set(utils.UserIDContext.String(), userID)
+	require.NoError(t, handler(c))
+	return rec.Code, rec.Body.Bytes()
+}
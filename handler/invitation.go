@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/kristohberg/CreatixBackend/web"
+	"github.com/labstack/echo"
+)
+
+var (
+	POSTInvitationPath       = "/company/:company/invitations"
+	GETInvitationsPath       = "/company/:company/invitations"
+	POSTResendInvitationPath = "/company/:company/invitations/:invitation/resend"
+	DELETEInvitationPath     = "/company/:company/invitations/:invitation"
+)
+
+func (api RestAPI) InvitationHandler(e *echo.Group) {
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTInvitationPath, api.CreateInvitation))
+	api.Middleware.RequireScope(models.ScopeCompanyRead, e.GET(GETInvitationsPath, api.GetInvitations))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.POST(POSTResendInvitationPath, api.ResendInvitation))
+	api.Middleware.RequireScope(models.ScopeCompanyWrite, e.DELETE(DELETEInvitationPath, api.RevokeInvitation))
+}
+
+// CreateInvitation invites someone to the company by email. Members can only
+// invite with roles they have every permission of. The invitation link lets them sign up or log in and join the company
+func (api RestAPI) CreateInvitation(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.MembersInvite)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.invitation.create: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	invitationRequest := new(models.InvitationRequest)
+	if err = c.Bind(invitationRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.invitation.create: could not bind invitation", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	if err = invitationRequest.Valid(); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	canGrant, err := api.CompanyClient.CanGrantRole(c.Request().Context(), c.Param("company"), userID, invitationRequest.Access)
+	if err == models.RoleNotFoundError {
+		return c.JSON(http.StatusBadRequest, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.invitation.create: not able to check role", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+	if !canGrant {
+		return c.JSON(http.StatusForbidden, utils.NewWebError(models.RoleNotGrantableError.Error()))
+	}
+
+	token, invitation, err := api.CompanyClient.CreateInvitation(c.Request().Context(), c.Param("company"), userID, *invitationRequest)
+	if err == models.AlreadyCompanyMemberError || err == models.InvitationExistsError {
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	}
+	if err == models.RoleNotFoundError {
+		return c.JSON(http.StatusBadRequest, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.invitation.create: not able to create invitation", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	api.sendInvitationMail(c.Request().Context(), invitation, token)
+	return c.JSON(http.StatusOK, invitation)
+}
+
+// GetInvitations lists the pending invitations of the company
+func (api RestAPI) GetInvitations(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.MembersInvite)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.invitation.list: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	invitations, err := api.CompanyClient.GetInvitations(c.Request().Context(), c.Param("company"))
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.invitation.list: not able to get invitations", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, invitations)
+}
+
+// ResendInvitation sends a pending invitation again with a new link
+func (api RestAPI) ResendInvitation(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.MembersInvite)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.invitation.resend: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	invitationID, err := strconv.Atoi(c.Param("invitation"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError("invitation not found"))
+	}
+
+	token, invitation, err := api.CompanyClient.ResendInvitation(c.Request().Context(), c.Param("company"), invitationID)
+	if err == models.InvitationNotFoundError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError("invitation not found"))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.invitation.resend: not able to renew invitation", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	api.sendInvitationMail(c.Request().Context(), invitation, token)
+	return c.JSON(http.StatusOK, invitation)
+}
+
+// RevokeInvitation withdraws a pending invitation
+func (api RestAPI) RevokeInvitation(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.MembersInvite)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.invitation.revoke: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	invitationID, err := strconv.Atoi(c.Param("invitation"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError("invitation not found"))
+	}
+
+	err = api.CompanyClient.RevokeInvitation(c.Request().Context(), c.Param("company"), invitationID)
+	if err == models.InvitationNotFoundError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError("invitation not found"))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.invitation.revoke: not able to revoke invitation", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "revoked"})
+}
+
+func (api RestAPI) sendInvitationMail(ctx context.Context, invitation models.Invitation, token string) {
+	inviter := "Someone"
+	if user, err := utils.FindUserByUserID(ctx, api.DB, invitation.InvitedBy); err == nil {
+		inviter = user.Firstname
+	}
+
+	content := fmt.Sprintf("Hi,\n\n%s has invited you to join %s on Creatix. Use the link below to sign up, "+
+		"or log in if you already have an account, with this email address. The link expires on %s.\n\n%s/invitation?token=%s",
+		inviter, invitation.CompanyName, invitation.ExpiresAt.UTC().Format("January 2"), api.Cfg.FrontendUrl, token)
+	_, err := api.MailClient.SendEmail(api.Cfg.FromEmail, "Creatix", invitation.Email, invitation.Email, "Creatix: You have been invited to "+invitation.CompanyName, content)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.invitation: not able to send email", err)
+	}
+}
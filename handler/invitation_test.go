@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvitation(t *testing.T) {
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	mailClient := restAPI.MailClient.(*mockMailClient)
+	sessionAPI := NewSessionAPI(db, logger)
+	sessionAPI.SessionClient = restAPI.SessionClient
+
+	invite := func(userID string, invitationRequest models.InvitationRequest) int {
+		data, err := json.Marshal(invitationRequest)
+		require.NoError(t, err)
+		c, rec := newContext(e, data, POSTInvitationPath)
+		c.Set(utils.UserIDContext.String(), userID)
+		c.SetParamNames("company")
+		c.SetParamValues("1")
+		require.NoError(t, restAPI.CreateInvitation(c))
+		return rec.Code
+	}
+
+	listInvitations := func() []models.Invitation {
+		c, rec := newContext(e, nil, GETInvitationsPath)
+		c.Set(utils.UserIDContext.String(), "1")
+		c.SetParamNames("company")
+		c.SetParamValues("1")
+		require.NoError(t, restAPI.GetInvitations(c))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var invitations []models.Invitation
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &invitations))
+		return invitations
+	}
+
+	// Only admins can invite and the request is validated
+	assert.Equal(t, http.StatusUnauthorized, invite("2", models.InvitationRequest{Email: "new@doe.com", Access: models.Write}))
+	assert.Equal(t, http.StatusBadRequest, invite("1", models.InvitationRequest{Email: "new@doe.com", Access: "owner"}))
+
+	require.Equal(t, http.StatusOK, invite("1", models.InvitationRequest{Email: "new@doe.com", Access: models.Write}))
+	require.Equal(t, []string{"new@doe.com"}, mailClient.To)
+	firstToken := mailClient.lastToken()
+	require.NotEmpty(t, firstToken)
+
+	// A pending invitation has to be resent instead
+	assert.Equal(t, http.StatusConflict, invite("1", models.InvitationRequest{Email: "New@doe.com", Access: models.Read}))
+
+	invitations := listInvitations()
+	require.Len(t, invitations, 1)
+	assert.Equal(t, "new@doe.com", invitations[0].Email)
+	assert.Equal(t, models.AccessLevel(models.Write), invitations[0].Access)
+	assert.False(t, invitations[0].Expired)
+
+	// Resending replaces the token
+	c, rec := newContext(e, nil, POSTResendInvitationPath)
+	c.Set(utils.UserIDContext.String(), "1")
+	c.SetParamNames("company", "invitation")
+	c.SetParamValues("1", strconv.Itoa(invitations[0].ID))
+	require.NoError(t, restAPI.ResendInvitation(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	secondToken := mailClient.lastToken()
+	require.NotEqual(t, firstToken, secondToken)
+
+	_, err = restAPI.CompanyClient.AcceptInvitation(context.Background(), firstToken, utils.SessionUser{ID: "2", Email: "new@doe.com"})
+	assert.Equal(t, models.InvalidInvitationError, err)
+
+	// Signing up with the invitation joins the company and verifies the email
+	newUser := models.User{Firstname: "New", Lastname: "Doe", Username: "newdoe", Email: "new@doe.com", Password: "MyPassword@123"}
+	signupByte, err := json.Marshal(models.Signup{User: newUser, InviteToken: secondToken})
+	require.NoError(t, err)
+	c, rec = newContext(e, signupByte, POSTSignupNewUserPath)
+	require.NoError(t, sessionAPI.Signup(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	sessionUser, err := utils.FindUserByEmail(context.Background(), db, newUser.Email)
+	require.NoError(t, err)
+	assert.True(t, sessionUser.EmailVerified)
+	require.NoError(t, restAPI.SessionClient.IsAuthorized(context.Background(), sessionUser.ID, "1", models.FeedbackCreate))
+	assert.Empty(t, listInvitations())
+
+	// The token can only be used once
+	_, err = restAPI.CompanyClient.AcceptInvitation(context.Background(), secondToken, sessionUser)
+	assert.Equal(t, models.InvalidInvitationError, err)
+
+	// Members cannot be invited again
+	assert.Equal(t, http.StatusConflict, invite("1", models.InvitationRequest{Email: "new@doe.com", Access: models.Read}))
+}
+
+func TestInvitationLogin(t *testing.T) {
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	sessionAPI := NewSessionAPI(db, logger)
+	sessionAPI.SessionClient = restAPI.SessionClient
+
+	user := models.User{Firstname: "John", Lastname: "Doe", Username: "johndoe", Email: "john@doe.com", Password: "MyPassword@123"}
+	require.NoError(t, sessionAPI.SessionClient.CreateUser(context.Background(), models.Signup{User: user}))
+
+	login := func(inviteToken string) models.UserSessionData {
+		loginRequest := newLoginRequest(user)
+		loginRequest.InviteToken = inviteToken
+		loginRequestByte, err := json.Marshal(loginRequest)
+		require.NoError(t, err)
+		c, rec := newContext(e, loginRequestByte, "/")
+		require.NoError(t, sessionAPI.Login(c))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var userSession models.UserSessionData
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &userSession))
+		return userSession
+	}
+
+	// A revoked invitation cannot be used
+	token, invitation, err := restAPI.CompanyClient.CreateInvitation(context.Background(), "1", "1", models.InvitationRequest{Email: user.Email, Access: models.Read})
+	require.NoError(t, err)
+	require.NoError(t, restAPI.CompanyClient.RevokeInvitation(context.Background(), "1", invitation.ID))
+	assert.Equal(t, models.InvitationNotFoundError, restAPI.CompanyClient.RevokeInvitation(context.Background(), "1", invitation.ID))
+	assert.Empty(t, login(token).Companies)
+
+	// An invitation for another email cannot be used
+	token, _, err = restAPI.CompanyClient.CreateInvitation(context.Background(), "1", "1", models.InvitationRequest{Email: "someone@else.com", Access: models.Read})
+	require.NoError(t, err)
+	assert.Empty(t, login(token).Companies)
+
+	// Logging in with the invitation joins the company
+	token, _, err = restAPI.CompanyClient.CreateInvitation(context.Background(), "1", "1", models.InvitationRequest{Email: user.Email, Access: models.Read})
+	require.NoError(t, err)
+	userSession := login(token)
+	require.Len(t, userSession.Companies, 1)
+	assert.Equal(t, "1", userSession.Companies[0].ID)
+	assert.True(t, userSession.SessionUser.EmailVerified)
+}
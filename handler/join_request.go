@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/kristohberg/CreatixBackend/web"
+	"github.com/labstack/echo"
+)
+
+var (
+	POSTJoinRequestPath        = "/company/:company/join-requests"
+	GETJoinRequestsPath        = "/company/:company/join-requests"
+	POSTApproveJoinRequestPath = "/company/:company/join-requests/:request/approve"
+	POSTDenyJoinRequestPath    = "/company/:company/join-requests/:request/deny"
+	PUTCompanyDiscoverablePath = "/company/:company/discoverable"
+)
+
+// RequestToJoinCompany lets a user ask to join a company they found in the
+// company search
+func (api RestAPI) RequestToJoinCompany(c echo.Context) error {
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	if userID == "" {
+		api.Logging.Unsuccessful("creatix.joinrequest.create: no permission", utils.NoPermission)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	joinRequest := new(models.JoinRequestRequest)
+	if err := c.Bind(joinRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.joinrequest.create: could not bind join request", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	if err := joinRequest.Valid(); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	request, err := api.CompanyClient.CreateJoinRequest(c.Request().Context(), c.Param("company"), userID, *joinRequest)
+	switch err {
+	case nil:
+	case models.CompanyNotFoundError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	case models.AlreadyCompanyMemberError, models.JoinRequestExistsError:
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	case models.UnverifiedEmailError:
+		return c.JSON(http.StatusForbidden, utils.NewWebError("verify your email before asking to join a company"))
+	default:
+		api.Logging.Unsuccessful("creatix.joinrequest.create: not able to create join request", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, request)
+}
+
+// GetJoinRequests lists the pending join requests of the company
+func (api RestAPI) GetJoinRequests(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.MembersManage)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.joinrequest.list: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	requests, err := api.CompanyClient.GetJoinRequests(c.Request().Context(), c.Param("company"))
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.joinrequest.list: not able to get join requests", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, requests)
+}
+
+// ApproveJoinRequest adds the user who asked to the company
+func (api RestAPI) ApproveJoinRequest(c echo.Context) error {
+	return api.decideJoinRequest(c, true)
+}
+
+// DenyJoinRequest turns down the request to join the company
+func (api RestAPI) DenyJoinRequest(c echo.Context) error {
+	return api.decideJoinRequest(c, false)
+}
+
+func (api RestAPI) decideJoinRequest(c echo.Context, approve bool) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.MembersManage)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.joinrequest.decide: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	requestID, err := strconv.Atoi(c.Param("request"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.JoinRequestNotFoundError.Error()))
+	}
+
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	request, err := api.CompanyClient.DecideJoinRequest(utils.WithClientInfo(c), c.Param("company"), requestID, userID, approve)
+	if err == models.JoinRequestNotFoundError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.joinrequest.decide: not able to decide join request", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	api.sendJoinRequestMail(c.Request().Context(), request, approve)
+	if approve {
+		return c.JSON(http.StatusOK, web.HttpResponse{Message: "approved"})
+	}
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "denied"})
+}
+
+func (api RestAPI) sendJoinRequestMail(ctx context.Context, request models.JoinRequest, approved bool) {
+	subject := "Creatix: Your request to join " + request.CompanyName
+	content := fmt.Sprintf("Hi %s,\n\nYour request to join %s on Creatix was declined.", request.Username, request.CompanyName)
+	if approved {
+		content = fmt.Sprintf("Hi %s,\n\nYour request to join %s on Creatix was approved. Log in to start giving feedback.\n\n%s",
+			request.Username, request.CompanyName, api.Cfg.FrontendUrl)
+	}
+
+	_, err := api.MailClient.SendEmail(api.Cfg.FromEmail, "Creatix", request.Email, request.Username, subject, content)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.joinrequest: not able to send email", err)
+	}
+}
+
+// SetCompanyDiscoverable chooses whether the company shows up in the company
+// search and can be asked to join
+func (api RestAPI) SetCompanyDiscoverable(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.company.discoverable: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	discoverableRequest := new(models.CompanyDiscoverableRequest)
+	if err = c.Bind(discoverableRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.company.discoverable: could not bind request", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "invalid body"})
+	}
+
+	err = api.CompanyClient.SetDiscoverable(c.Request().Context(), c.Param("company"), discoverableRequest.Discoverable)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.company.discoverable: not able to update company", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "ok"})
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoinRequests(t *testing.T) {
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	mailClient := restAPI.MailClient.(*mockMailClient)
+	ctx := context.Background()
+
+	search := func(query string) []models.Company {
+		c, rec := newContext(e, nil, GETSearchCompanyPath)
+		c.Set(utils.UserIDContext.String(), "2")
+		c.SetParamNames("query")
+		c.SetParamValues(query)
+		require.NoError(t, restAPI.SearchCompany(c))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var companies []models.Company
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &companies))
+		return companies
+	}
+
+	// Companies are found by part of their name
+	assert.Equal(t, []models.Company{{ID: "1", Name: "MyCorp"}}, search("corp"))
+	assert.Empty(t, search("%"))
+
+	// Members cannot ask and users only have one pending request
+	code, _ := callHandler(t, restAPI, restAPI.RequestToJoinCompany, "1", models.JoinRequestRequest{}, "1")
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = callHandler(t, restAPI, restAPI.RequestToJoinCompany, "2", models.JoinRequestRequest{Message: "I work here"}, "1")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.RequestToJoinCompany, "2", models.JoinRequestRequest{}, "1")
+	assert.Equal(t, http.StatusConflict, code)
+
+	// Admins get the requests in a queue
+	code, _ = callHandler(t, restAPI, restAPI.GetJoinRequests, "2", nil, "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, body := callHandler(t, restAPI, restAPI.GetJoinRequests, "1", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	var requests []models.JoinRequest
+	require.NoError(t, json.Unmarshal(body, &requests))
+	require.Len(t, requests, 1)
+	assert.Equal(t, "doeman", requests[0].Username)
+	assert.Equal(t, "I work here", requests[0].Message)
+
+	// A denied user is told and can ask again
+	code, _ = callHandler(t, restAPI, restAPI.DenyJoinRequest, "1", nil, "1", strconv.Itoa(requests[0].ID))
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"john@doe.no"}, mailClient.To)
+	assert.Error(t, restAPI.SessionClient.IsAuthorized(ctx, "2", "1", models.FeedbackRead))
+	code, _ = callHandler(t, restAPI, restAPI.ApproveJoinRequest, "1", nil, "1", strconv.Itoa(requests[0].ID))
+	assert.Equal(t, http.StatusNotFound, code)
+
+	request, err := restAPI.CompanyClient.CreateJoinRequest(ctx, "1", "2", models.JoinRequestRequest{})
+	require.NoError(t, err)
+
+	// Approved users join with the read role
+	code, _ = callHandler(t, restAPI, restAPI.ApproveJoinRequest, "1", nil, "1", strconv.Itoa(request.ID))
+	require.Equal(t, http.StatusOK, code)
+	assert.Len(t, mailClient.To, 2)
+	assert.NoError(t, restAPI.SessionClient.IsAuthorized(ctx, "2", "1", models.FeedbackRead))
+	assert.Error(t, restAPI.SessionClient.IsAuthorized(ctx, "2", "1", models.FeedbackCreate))
+
+	// Hidden companies cannot be found or asked
+	code, _ = callHandler(t, restAPI, restAPI.SetCompanyDiscoverable, "2", models.CompanyDiscoverableRequest{Discoverable: false}, "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = callHandler(t, restAPI, restAPI.SetCompanyDiscoverable, "1", models.CompanyDiscoverableRequest{Discoverable: false}, "1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Empty(t, search("corp"))
+	code, _ = callHandler(t, restAPI, restAPI.RequestToJoinCompany, "3", models.JoinRequestRequest{}, "1")
+	assert.Equal(t, http.StatusNotFound, code)
+}
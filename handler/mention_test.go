@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMentions(t *testing.T) {
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	ctx := context.Background()
+
+	// The reader is not a member of the company
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "john@doe.no", Access: models.Write}))
+
+	notifications := func(userID string) []models.Notification {
+		code, body := callHandler(t, restAPI, restAPI.GetNotifications, userID, nil)
+		require.Equal(t, http.StatusOK, code)
+		var notifications []models.Notification
+		require.NoError(t, json.Unmarshal(body, &notifications))
+		return notifications
+	}
+
+	feedbackByID := func(feedbackID string) models.Feedback {
+		code, body := callHandler(t, restAPI, restAPI.GetCompanyFeedback, "1", nil, "1")
+		require.Equal(t, http.StatusOK, code)
+		var feedbacks models.Feedbacks
+		require.NoError(t, json.Unmarshal(body, &feedbacks))
+		for _, feedback := range feedbacks {
+			if feedback.ID == feedbackID {
+				return feedback
+			}
+		}
+		require.FailNow(t, "feedback not found")
+		return models.Feedback{}
+	}
+
+	// Only members are mentioned, and email addresses are not mentions
+	feedback := newFeedbackRequest()
+	feedback.Description = "Thanks @doeman, ask @reader and @nobody. Mail kristoffer@doeman.no or @doeman."
+	code, _ := callHandler(t, restAPI, restAPI.PostFeedback, "1", feedback, "1")
+	require.Equal(t, http.StatusOK, code)
+
+	doeman := models.Mention{UserID: "2", Username: "doeman", Length: 7}
+	first, second := doeman, doeman
+	first.Start, second.Start = 7, 70
+	assert.Equal(t, []models.Mention{first, second}, feedbackByID("1").Mentions)
+
+	// Mentioned members are notified once
+	mentioned := notifications("2")
+	if assert.Len(t, mentioned, 1) {
+		assert.Equal(t, models.NotificationMention, mentioned[0].Kind)
+		assert.Equal(t, "1", mentioned[0].FeedbackID)
+		assert.Equal(t, "", mentioned[0].CommentID)
+		assert.Equal(t, feedback.Title, mentioned[0].Title)
+		assert.Equal(t, &models.Person{ID: "1", Firstname: "Kristoffer", Lastname: "Berg"}, mentioned[0].Actor)
+		assert.False(t, mentioned[0].Read)
+	}
+	assert.Len(t, notifications("3"), 0)
+
+	// Updates only notify members who were not mentioned before, and nobody
+	// is notified about mentioning themselves
+	feedback.Description = "@kristohb and @doeman"
+	code, _ = callHandler(t, restAPI, restAPI.UpdateFeedback, "1", feedback, "1", "1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Len(t, feedbackByID("1").Mentions, 2)
+	assert.Len(t, notifications("2"), 1)
+	assert.Len(t, notifications("1"), 0)
+
+	// Comments mention members as well
+	code, _ = callHandler(t, restAPI, restAPI.CommentFeedback, "2", models.CommentRequest{Comment: "What do you think @kristohb?"}, "1", "1")
+	require.Equal(t, http.StatusOK, code)
+	comments := feedbackByID("1").Comments
+	if assert.Len(t, comments, 1) {
+		assert.Equal(t, []models.Mention{{UserID: "1", Username: "kristohb", Start: 18, Length: 9}}, comments[0].Mentions)
+	}
+	mentioned = notifications("1")
+	if assert.Len(t, mentioned, 1) {
+		assert.Equal(t, "1", mentioned[0].CommentID)
+		assert.Equal(t, &models.Person{ID: "2", Firstname: "John", Lastname: "Doe"}, mentioned[0].Actor)
+	}
+
+	// Mentions in anonymous feedback do not tell who wrote it
+	require.NoError(t, restAPI.CompanyClient.UpdateSettings(ctx, "1", models.SettingsRequest{AllowAnonymousFeedback: true, Timezone: "UTC"}))
+	anonymous := newFeedbackRequest()
+	anonymous.Description = "Not sure about this @kristohb"
+	anonymous.Anonymous = true
+	code, _ = callHandler(t, restAPI, restAPI.PostFeedback, "2", anonymous, "1")
+	require.Equal(t, http.StatusOK, code)
+	mentioned = notifications("1")
+	if assert.Len(t, mentioned, 2) {
+		assert.Equal(t, "2", mentioned[0].FeedbackID)
+		assert.Nil(t, mentioned[0].Actor)
+	}
+
+	// Users only read their own notifications
+	code, _ = callHandler(t, restAPI, restAPI.ReadNotification, "2", nil, mentioned[0].ID)
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = callHandler(t, restAPI, restAPI.ReadNotification, "1", nil, mentioned[0].ID)
+	require.Equal(t, http.StatusOK, code)
+	mentioned = notifications("1")
+	require.Len(t, mentioned, 2)
+	assert.True(t, mentioned[0].Read)
+	assert.False(t, mentioned[1].Read)
+
+	code, _ = callHandler(t, restAPI, restAPI.ReadNotifications, "1", nil)
+	require.Equal(t, http.StatusOK, code)
+	for _, notification := range notifications("1") {
+		assert.True(t, notification.Read)
+	}
+}
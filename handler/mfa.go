@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/kristohberg/CreatixBackend/web"
+	"github.com/labstack/echo"
+)
+
+var (
+	POSTEnrollMfaPath  = "/user/mfa/enroll"
+	POSTConfirmMfaPath = "/user/mfa/confirm"
+	POSTCompanyMfaPath = "/company/:company/mfa"
+)
+
+func (api RestAPI) MfaHandler(e *echo.Group) {
+	e.POST(POSTEnrollMfaPath, api.EnrollMfa)
+	e.POST(POSTConfirmMfaPath, api.ConfirmMfa)
+	e.POST(POSTCompanyMfaPath, api.SetCompanyMfa)
+}
+
+// EnrollMfa creates a new TOTP secret for the user and returns it together
+// with the otpauth uri for authenticator apps
+func (api RestAPI) EnrollMfa(c echo.Context) error {
+	userID, err := utils.GetUserIDFromContext(c)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.mfa.enroll: could not get user", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	enrollment, err := api.SessionClient.EnrollTotp(c.Request().Context(), userID)
+	if err == models.MfaAlreadyEnabledError {
+		return c.JSON(http.StatusBadRequest, utils.NewWebError("two factor authentication is already enabled"))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.mfa.enroll: not able to enroll totp", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmMfa enables two factor authentication if the code matches the
+// enrolled secret and returns the one time recovery codes
+func (api RestAPI) ConfirmMfa(c echo.Context) error {
+	userID, err := utils.GetUserIDFromContext(c)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.mfa.confirm: could not get user", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	codeRequest := new(models.MfaCodeRequest)
+	if err = c.Bind(codeRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.mfa.confirm: could not bind code", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "invalid body"})
+	}
+
+	recoveryCodes, err := api.SessionClient.ConfirmTotp(c.Request().Context(), userID, codeRequest.Code)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.mfa.confirm: not able to confirm totp", err)
+		return c.JSON(http.StatusBadRequest, utils.NewWebError("not able to confirm code"))
+	}
+
+	return c.JSON(http.StatusOK, models.RecoveryCodesResponse{RecoveryCodes: recoveryCodes})
+}
+
+// SetCompanyMfa lets an admin require two factor authentication for every
+// member of the company. The admin must have it enabled first so they do not
+// lock themselves out
+func (api RestAPI) SetCompanyMfa(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.CompanySettings)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.mfa.setcompanymfa: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	companyMfaRequest := new(models.CompanyMfaRequest)
+	if err = c.Bind(companyMfaRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.mfa.setcompanymfa: could not bind request", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "invalid body"})
+	}
+
+	userID, err := utils.GetUserIDFromContext(c)
+	if err != nil {
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	if companyMfaRequest.Required {
+		mfaEnabled, err := api.SessionClient.IsMfaEnabled(c.Request().Context(), userID)
+		if err != nil || !mfaEnabled {
+			api.Logging.Unsuccessful("creatix.mfa.setcompanymfa: admin does not have mfa", err)
+			return c.JSON(http.StatusBadRequest, utils.NewWebError("enable two factor authentication on your own account first"))
+		}
+	}
+
+	err = api.CompanyClient.SetRequireMfa(c.Request().Context(), c.Param("company"), companyMfaRequest.Required)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.mfa.setcompanymfa: not able to update company", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "ok"})
+}
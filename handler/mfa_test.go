@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func enrollAndConfirmMfa(t *testing.T, e *echo.Echo, restAPI RestAPI, userID string) (secret string, recoveryCodes []string) {
+	c, rec := newContext(e, nil, POSTEnrollMfaPath)
+	c.Set(utils.UserIDContext.String(), userID)
+	require.NoError(t, restAPI.EnrollMfa(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var enrollment models.MfaEnrollment
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &enrollment))
+	require.NotEmpty(t, enrollment.Secret)
+	assert.Contains(t, enrollment.URI, "otpauth://totp/")
+
+	// A wrong code does not enable mfa
+	codeByte, err := json.Marshal(models.MfaCodeRequest{Code: "000000"})
+	require.NoError(t, err)
+	c, rec = newContext(e, codeByte, POSTConfirmMfaPath)
+	c.Set(utils.UserIDContext.String(), userID)
+	require.NoError(t, restAPI.ConfirmMfa(c))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	code, err := utils.TOTPCode(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	codeByte, err = json.Marshal(models.MfaCodeRequest{Code: code})
+	require.NoError(t, err)
+	c, rec = newContext(e, codeByte, POSTConfirmMfaPath)
+	c.Set(utils.UserIDContext.String(), userID)
+	require.NoError(t, restAPI.ConfirmMfa(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var recoveryCodesResponse models.RecoveryCodesResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &recoveryCodesResponse))
+	require.Len(t, recoveryCodesResponse.RecoveryCodes, 10)
+
+	return enrollment.Secret, recoveryCodesResponse.RecoveryCodes
+}
+
+func loginForMfaToken(t *testing.T, e *echo.Echo, sessionAPI SessionAPI, user models.User) string {
+	loginRequestByte, err := json.Marshal(newLoginRequest(user))
+	require.NoError(t, err)
+	c, rec := newContext(e, loginRequestByte, "/")
+	require.NoError(t, sessionAPI.Login(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Result().Cookies())
+
+	var challenge models.MfaChallengeResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &challenge))
+	require.True(t, challenge.MfaRequired)
+	return challenge.MfaToken
+}
+
+func TestMfaLogin(t *testing.T) {
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	sessionAPI := NewSessionAPI(db, logger)
+
+	user := models.User{Firstname: "John", Lastname: "Doe", Username: "johndoe", Email: "john@doe.com", Password: "MyPassword@123"}
+	require.NoError(t, sessionAPI.SessionClient.CreateUser(context.Background(), models.Signup{User: user}))
+	sessionUser, err := utils.FindUserByEmail(context.Background(), db, user.Email)
+	require.NoError(t, err)
+
+	secret, recoveryCodes := enrollAndConfirmMfa(t, e, restAPI, sessionUser.ID)
+
+	// The code used to confirm cannot be replayed
+	mfaToken := loginForMfaToken(t, e, sessionAPI, user)
+	code, err := utils.TOTPCode(secret, time.Now())
+	require.NoError(t, err)
+	mfaByte, err := json.Marshal(models.MfaLoginRequest{MfaToken: mfaToken, Code: code})
+	require.NoError(t, err)
+	c, rec := newContext(e, mfaByte, POSTLoginMfaPath)
+	if assert.NoError(t, sessionAPI.LoginMfa(c)) {
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+
+	code, err = utils.TOTPCode(secret, time.Now().Add(30*time.Second))
+	require.NoError(t, err)
+	mfaByte, err = json.Marshal(models.MfaLoginRequest{MfaToken: mfaToken, Code: code})
+	require.NoError(t, err)
+	c, rec = newContext(e, mfaByte, POSTLoginMfaPath)
+	if assert.NoError(t, sessionAPI.LoginMfa(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.NotEmpty(t, findCookie(rec.Result().Cookies(), accessTokenCookie).Value)
+	}
+
+	// The challenge is single use
+	c, rec = newContext(e, mfaByte, POSTLoginMfaPath)
+	if assert.NoError(t, sessionAPI.LoginMfa(c)) {
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+
+	// Recovery codes work once
+	mfaToken = loginForMfaToken(t, e, sessionAPI, user)
+	mfaByte, err = json.Marshal(models.MfaLoginRequest{MfaToken: mfaToken, RecoveryCode: recoveryCodes[0]})
+	require.NoError(t, err)
+	c, rec = newContext(e, mfaByte, POSTLoginMfaPath)
+	if assert.NoError(t, sessionAPI.LoginMfa(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	mfaToken = loginForMfaToken(t, e, sessionAPI, user)
+	mfaByte, err = json.Marshal(models.MfaLoginRequest{MfaToken: mfaToken, RecoveryCode: recoveryCodes[0]})
+	require.NoError(t, err)
+	c, rec = newContext(e, mfaByte, POSTLoginMfaPath)
+	if assert.NoError(t, sessionAPI.LoginMfa(c)) {
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+
+	// Wrong codes count as failed logins, and the right password alone does
+	// not forget them
+	failures := func() (failures int) {
+		err := db.QueryRow(`SELECT COALESCE(SUM(Failures),0) FROM LOGIN_THROTTLES WHERE Scope='account' AND Subject=$1`, user.Email).Scan(&failures)
+		require.NoError(t, err)
+		return failures
+	}
+	assert.Equal(t, 1, failures())
+
+	c, rec = newContext(e, mfaByte, POSTLoginMfaPath)
+	require.NoError(t, sessionAPI.LoginMfa(c))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, 2, failures())
+
+	mfaToken = loginForMfaToken(t, e, sessionAPI, user)
+	assert.Equal(t, 2, failures())
+
+	mfaByte, err = json.Marshal(models.MfaLoginRequest{MfaToken: mfaToken, RecoveryCode: recoveryCodes[1]})
+	require.NoError(t, err)
+	c, rec = newContext(e, mfaByte, POSTLoginMfaPath)
+	require.NoError(t, sessionAPI.LoginMfa(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 0, failures())
+}
+
+func TestCompanyRequireMfa(t *testing.T) {
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	requester := NewRequester(t, e, restAPI)
+	parameterNameValue := [][]string{{"company"}, {"1"}}
+
+	newUser, err := NewMockUserBytes("john@doe.no", models.Write)
+	require.NoError(t, err)
+	requester(AddNewUserByEmailToCompanyPath, "1", parameterNameValue, newUser, restAPI.AddUserByEmailToCompany, http.StatusOK, nil)
+
+	// The admin needs mfa before requiring it
+	requireMfa, err := json.Marshal(models.CompanyMfaRequest{Required: true})
+	require.NoError(t, err)
+	requester(POSTCompanyMfaPath, "1", parameterNameValue, requireMfa, restAPI.SetCompanyMfa, http.StatusBadRequest, nil)
+
+	enrollAndConfirmMfa(t, e, restAPI, "1")
+	requester(POSTCompanyMfaPath, "1", parameterNameValue, requireMfa, restAPI.SetCompanyMfa, http.StatusOK, nil)
+
+	// Members without mfa are locked out of the company
+	err = restAPI.SessionClient.IsAuthorized(context.Background(), "2", "1", models.FeedbackRead)
+	assert.Equal(t, models.MfaRequiredByCompanyError, err)
+	assert.NoError(t, restAPI.SessionClient.IsAuthorized(context.Background(), "1", "1", models.CompanySettings))
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/kristohberg/CreatixBackend/web"
+	"github.com/labstack/echo"
+)
+
+var (
+	GETNotificationsPath     = "/user/notifications"
+	PUTReadNotificationPath  = "/user/notifications/:notification/read"
+	PUTReadNotificationsPath = "/user/notifications/read"
+)
+
+func (api RestAPI) NotificationHandler(e *echo.Group) {
+	api.Middleware.RequireScope(models.ScopeFeedbackRead, e.GET(GETNotificationsPath, api.GetNotifications))
+	api.Middleware.RequireScope(models.ScopeFeedbackRead, e.PUT(PUTReadNotificationPath, api.ReadNotification))
+	api.Middleware.RequireScope(models.ScopeFeedbackRead, e.PUT(PUTReadNotificationsPath, api.ReadNotifications))
+}
+
+// GetNotifications lists the latest notifications of the user, such as being
+// mentioned in feedback or comments
+func (api RestAPI) GetNotifications(c echo.Context) error {
+	userID, err := utils.GetUserIDFromContext(c)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.notifications.list: could not get user", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	notifications, err := api.FeedbackClient.GetNotifications(c.Request().Context(), userID)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.notifications.list: not able to get notifications", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, notifications)
+}
+
+// ReadNotification marks one of the notifications of the user as read
+func (api RestAPI) ReadNotification(c echo.Context) error {
+	userID, err := utils.GetUserIDFromContext(c)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.notifications.read: could not get user", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	err = api.FeedbackClient.ReadNotification(c.Request().Context(), userID, c.Param("notification"))
+	if err == models.NotificationNotFoundError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.notifications.read: not able to read notification", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "read"})
+}
+
+// ReadNotifications marks all the notifications of the user as read
+func (api RestAPI) ReadNotifications(c echo.Context) error {
+	userID, err := utils.GetUserIDFromContext(c)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.notifications.readall: could not get user", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	if err = api.FeedbackClient.ReadNotifications(c.Request().Context(), userID); err != nil {
+		api.Logging.Unsuccessful("creatix.notifications.readall: not able to read notifications", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "read"})
+}
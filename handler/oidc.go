@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/kristohberg/CreatixBackend/internal/oidc"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+var (
+	GETOidcStartPath    = "/oidc/:provider/start"
+	GETOidcCallbackPath = "/oidc/:provider/callback"
+	GETOidcLinkPath     = "/oidc/:provider/link"
+)
+
+const (
+	oidcStateCookie     = "oidc_state"
+	oidcStateCookiePath = "/v0/auth/oidc"
+	oidcStateExpiration = time.Minute * 10
+)
+
+// oidcStateClaims keeps the state, nonce and PKCE verifier of a login in a
+// signed cookie while the user is away at the provider. LinkUserID is set
+// when a logged in user links the provider instead of logging in
+type oidcStateClaims struct {
+	Provider     string `json:"provider"`
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"codeVerifier"`
+	LinkUserID   string `json:"linkUserId,omitempty"`
+	jwt.StandardClaims
+}
+
+func (s SessionAPI) oidcProvider(c echo.Context) (*oidc.Provider, error) {
+	provider, ok := s.OidcProviders[c.Param("provider")]
+	if !ok {
+		return nil, errors.Errorf("unknown oidc provider %s", c.Param("provider"))
+	}
+	return provider, nil
+}
+
+// OidcStart redirects the user to the identity provider to log in
+func (s SessionAPI) OidcStart(c echo.Context) error {
+	return s.redirectToProvider(c, "")
+}
+
+// OidcLink redirects the logged in user to the identity provider to link it
+// to their account
+func (s SessionAPI) OidcLink(c echo.Context) error {
+	userID, err := utils.GetUserIDFromContext(c)
+	if err != nil {
+		s.Logging.Unsuccessful("creatix.oidc.link: could not get user", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+	return s.redirectToProvider(c, userID)
+}
+
+func (s SessionAPI) redirectToProvider(c echo.Context, linkUserID string) error {
+	provider, err := s.oidcProvider(c)
+	if err != nil {
+		s.Logging.Unsuccessful("creatix.oidc.start: no provider", err)
+		return c.String(http.StatusNotFound, "")
+	}
+
+	authRequest, err := oidc.NewAuthRequest()
+	if err != nil {
+		s.Logging.Unsuccessful("creatix.oidc.start: not able to create auth request", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	authCodeURL, err := provider.AuthCodeURL(c.Request().Context(), authRequest)
+	if err != nil {
+		s.Logging.Unsuccessful("creatix.oidc.start: not able to create auth url", err)
+		return c.String(http.StatusBadGateway, "")
+	}
+
+	expiresAt := time.Now().Add(oidcStateExpiration)
+	stateToken, err := s.SessionClient.Keyring.Sign(oidcStateClaims{
+		Provider:       provider.Name(),
+		State:          authRequest.State,
+		Nonce:          authRequest.Nonce,
+		CodeVerifier:   authRequest.CodeVerifier,
+		LinkUserID:     linkUserID,
+		StandardClaims: jwt.StandardClaims{ExpiresAt: expiresAt.Unix(), Issuer: "creatix"},
+	})
+	if err != nil {
+		s.Logging.Unsuccessful("creatix.oidc.start: not able to sign state", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	cookie := s.newCookie(oidcStateCookie, stateToken, oidcStateCookiePath, expiresAt)
+	cookie.HttpOnly = true
+	if s.Cfg.Env != "prod" {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	c.SetCookie(cookie)
+	return c.Redirect(http.StatusFound, authCodeURL)
+}
+
+// OidcCallback finishes the login when the provider redirects back, creates
+// the same session cookies as Login and sends the user to the frontend. Users
+// with mfa enabled are sent to the frontend with an mfa token instead, in the
+// fragment so it is not sent on to any server. Links are finished by linking
+// the identity to the user who started them
+func (s SessionAPI) OidcCallback(c echo.Context) error {
+	failed := func(message string, err error) error {
+		s.Logging.Unsuccessful("creatix.oidc.callback: "+message, err)
+		return c.Redirect(http.StatusFound, s.Cfg.FrontendUrl+"/login?error=oidc")
+	}
+
+	provider, err := s.oidcProvider(c)
+	if err != nil {
+		return failed("no provider", err)
+	}
+
+	if providerErr := c.QueryParam("error"); providerErr != "" {
+		return failed("provider returned error", errors.New(providerErr))
+	}
+
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		return failed("no state cookie", err)
+	}
+	expired := s.newCookie(oidcStateCookie, "", oidcStateCookiePath, time.Time{})
+	expired.MaxAge = -1
+	c.SetCookie(expired)
+
+	stateClaims := new(oidcStateClaims)
+	_, err = jwt.ParseWithClaims(cookie.Value, stateClaims, s.SessionClient.Keyring.Keyfunc)
+	if err != nil {
+		return failed("invalid state cookie", err)
+	}
+
+	if stateClaims.Provider != provider.Name() || subtle.ConstantTimeCompare([]byte(stateClaims.State), []byte(c.QueryParam("state"))) != 1 {
+		return failed("state mismatch", errors.New("state does not match"))
+	}
+
+	idTokenClaims, err := provider.Exchange(c.Request().Context(), c.QueryParam("code"), oidc.AuthRequest{
+		State:        stateClaims.State,
+		Nonce:        stateClaims.Nonce,
+		CodeVerifier: stateClaims.CodeVerifier,
+	})
+	if err != nil {
+		return failed("not able to exchange code", err)
+	}
+
+	identity := models.ExternalIdentity{
+		Provider:      provider.Name(),
+		Subject:       idTokenClaims.Subject,
+		Email:         idTokenClaims.Email,
+		EmailVerified: idTokenClaims.EmailVerified,
+		Firstname:     idTokenClaims.GivenName,
+		Lastname:      idTokenClaims.FamilyName,
+		Username:      idTokenClaims.PreferredUsername,
+	}
+
+	if stateClaims.LinkUserID != "" {
+		err = s.SessionClient.LinkExternalIdentity(c.Request().Context(), stateClaims.LinkUserID, identity)
+		if err != nil {
+			s.Logging.Unsuccessful("creatix.oidc.callback: not able to link identity", err)
+			return c.Redirect(http.StatusFound, s.Cfg.FrontendUrl+"/settings?error=oidc")
+		}
+		return c.Redirect(http.StatusFound, s.Cfg.FrontendUrl+"/settings?linked="+url.QueryEscape(provider.Name()))
+	}
+
+	resp, err := s.SessionClient.LoginExternalUser(utils.WithClientInfo(c), identity)
+	if err != nil {
+		return failed("not able to log in user", err)
+	}
+
+	if resp.MfaRequired {
+		return c.Redirect(http.StatusFound, s.Cfg.FrontendUrl+"/login/mfa#mfaToken="+url.QueryEscape(resp.MfaToken))
+	}
+
+	s.setSessionCookies(c, resp)
+	return c.Redirect(http.StatusFound, s.Cfg.FrontendUrl)
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/internal/oidc"
+	"github.com/kristohberg/CreatixBackend/internal/oidc/oidctest"
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newOidcContext(e *echo.Echo, path string, cookies []*http.Cookie) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("provider")
+	c.SetParamValues("mock")
+	return c, rec
+}
+
+// oidcLogin runs the start and callback handlers against the mock provider
+func oidcLogin(t *testing.T, e *echo.Echo, sessionAPI SessionAPI, server *oidctest.Server, user oidctest.User, state func(string) string) *httptest.ResponseRecorder {
+	c, rec := newOidcContext(e, "/v0/auth/oidc/mock/start", nil)
+	require.NoError(t, sessionAPI.OidcStart(c))
+	return oidcCallback(t, e, sessionAPI, server, user, state, rec)
+}
+
+// oidcLink runs the link and callback handlers for the logged in user
+func oidcLink(t *testing.T, e *echo.Echo, sessionAPI SessionAPI, server *oidctest.Server, userID string, user oidctest.User) *httptest.ResponseRecorder {
+	c, rec := newOidcContext(e, "/v0/auth/oidc/mock/link", nil)
+	c.Set(utils.UserIDContext.String(), userID)
+	require.NoError(t, sessionAPI.OidcLink(c))
+	return oidcCallback(t, e, sessionAPI, server, user, func(state string) string { return state }, rec)
+}
+
+func oidcCallback(t *testing.T, e *echo.Echo, sessionAPI SessionAPI, server *oidctest.Server, user oidctest.User, state func(string) string, rec *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+	require.Equal(t, http.StatusFound, rec.Code)
+
+	code, returnedState, err := server.Authorize(rec.Header().Get(echo.HeaderLocation), user)
+	require.NoError(t, err)
+
+	query := url.Values{}
+	query.Set("code", code)
+	query.Set("state", state(returnedState))
+	c, rec := newOidcContext(e, "/v0/auth/oidc/mock/callback?"+query.Encode(), rec.Result().Cookies())
+	require.NoError(t, sessionAPI.OidcCallback(c))
+	require.Equal(t, http.StatusFound, rec.Code)
+	return rec
+}
+
+func TestOidcLogin(t *testing.T) {
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	server, err := oidctest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+
+	logger := logging.NewLogger()
+	sessionAPI := NewSessionAPI(db, logger)
+	sessionAPI.Cfg.FrontendUrl = "http://frontend"
+	sessionAPI.OidcProviders = map[string]*oidc.Provider{
+		"mock": oidc.NewProvider(server.Config("mock", "http://localhost/v0/auth/oidc/mock/callback"), nil),
+	}
+	sameState := func(state string) string { return state }
+
+	// A tampered state is rejected
+	rec := oidcLogin(t, e, sessionAPI, server, oidctest.User{Subject: "1", Email: "new@doe.com", EmailVerified: true}, func(string) string { return "forged" })
+	assert.Equal(t, "http://frontend/login?error=oidc", rec.Header().Get(echo.HeaderLocation))
+	assert.Empty(t, findCookie(rec.Result().Cookies(), accessTokenCookie).Value)
+
+	// Unverified emails are not linked or created
+	rec = oidcLogin(t, e, sessionAPI, server, oidctest.User{Subject: "1", Email: "new@doe.com"}, sameState)
+	assert.Equal(t, "http://frontend/login?error=oidc", rec.Header().Get(echo.HeaderLocation))
+
+	// A new identity creates a verified user
+	rec = oidcLogin(t, e, sessionAPI, server, oidctest.User{Subject: "1", Email: "new@doe.com", EmailVerified: true, GivenName: "New", FamilyName: "Doe"}, sameState)
+	assert.Equal(t, "http://frontend", rec.Header().Get(echo.HeaderLocation))
+	assert.NotEmpty(t, findCookie(rec.Result().Cookies(), accessTokenCookie).Value)
+	assert.NotEmpty(t, findCookie(rec.Result().Cookies(), refreshTokenCookie).Value)
+
+	newUser, err := utils.FindUserByEmail(context.Background(), db, "new@doe.com")
+	require.NoError(t, err)
+	assert.True(t, newUser.EmailVerified)
+	assert.Equal(t, "New", newUser.Firstname)
+
+	// An identity with the email of an existing user is not linked to it
+	john := oidctest.User{Subject: "2", Email: "john@doe.no", EmailVerified: true}
+	rec = oidcLogin(t, e, sessionAPI, server, john, sameState)
+	assert.Equal(t, "http://frontend/login?error=oidc", rec.Header().Get(echo.HeaderLocation))
+	assert.Empty(t, findCookie(rec.Result().Cookies(), accessTokenCookie).Value)
+
+	// Logged in users link identities, which are only linked once
+	rec = oidcLink(t, e, sessionAPI, server, "2", john)
+	assert.Equal(t, "http://frontend/settings?linked=mock", rec.Header().Get(echo.HeaderLocation))
+	rec = oidcLink(t, e, sessionAPI, server, "3", john)
+	assert.Equal(t, "http://frontend/settings?error=oidc", rec.Header().Get(echo.HeaderLocation))
+
+	rec = oidcLogin(t, e, sessionAPI, server, john, sameState)
+	assert.Equal(t, "http://frontend", rec.Header().Get(echo.HeaderLocation))
+	claims, err := utils.GetClaims(findCookie(rec.Result().Cookies(), accessTokenCookie).Value, sessionAPI.SessionClient.Keyring)
+	require.NoError(t, err)
+	assert.Equal(t, "2", claims.UserID)
+
+	// Users with mfa get an mfa challenge instead of a session
+	enrollAndConfirmMfa(t, e, NewRestAPI(db, logger), "2")
+	rec = oidcLogin(t, e, sessionAPI, server, john, sameState)
+	location, err := url.Parse(rec.Header().Get(echo.HeaderLocation))
+	require.NoError(t, err)
+	assert.Equal(t, "/login/mfa", location.Path)
+	fragment, err := url.ParseQuery(location.Fragment)
+	require.NoError(t, err)
+	assert.NotEmpty(t, fragment.Get("mfaToken"))
+	assert.Empty(t, findCookie(rec.Result().Cookies(), accessTokenCookie).Value)
+}
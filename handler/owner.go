@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/kristohberg/CreatixBackend/web"
+	"github.com/labstack/echo"
+)
+
+var (
+	POSTOwnershipTransferPath   = "/company/:company/owner/transfer"
+	POSTAcceptOwnershipPath     = "/company/:company/owner/accept"
+	DELETEOwnershipTransferPath = "/company/:company/owner/transfer"
+)
+
+// OfferOwnership lets the owner offer the company to another member. The
+// ownership only changes once they accept
+func (api RestAPI) OfferOwnership(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.MembersManage)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.owner.offer: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	transferRequest := new(models.OwnershipTransferRequest)
+	if err = c.Bind(transferRequest); err != nil || transferRequest.UserID == "" {
+		api.Logging.Unsuccessful("creatix.owner.offer: could not bind transfer", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	companyName, err := api.CompanyClient.OfferOwnership(c.Request().Context(), c.Param("company"), userID, transferRequest.UserID)
+	switch err {
+	case nil:
+	case models.NotCompanyMemberError:
+		return c.JSON(http.StatusBadRequest, utils.NewWebError(err.Error()))
+	case models.NotCompanyOwnerError:
+		return c.JSON(http.StatusForbidden, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.owner.offer: not able to offer ownership", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	api.sendOwnershipOfferMail(c.Request().Context(), c.Param("company"), companyName, transferRequest.UserID)
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "offered"})
+}
+
+// AcceptOwnership makes the member the ownership was offered to the owner
+func (api RestAPI) AcceptOwnership(c echo.Context) error {
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	err := api.CompanyClient.AcceptOwnership(c.Request().Context(), c.Param("company"), userID)
+	if err == models.NoOwnershipOfferError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.owner.accept: not able to accept ownership", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "accepted"})
+}
+
+// CancelOwnershipOffer lets the owner withdraw a pending transfer, or the
+// member it was offered to decline it
+func (api RestAPI) CancelOwnershipOffer(c echo.Context) error {
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	err := api.CompanyClient.CancelOwnershipOffer(c.Request().Context(), c.Param("company"), userID)
+	if err == models.NoOwnershipOfferError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.owner.cancel: not able to cancel ownership transfer", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "cancelled"})
+}
+
+func (api RestAPI) sendOwnershipOfferMail(ctx context.Context, companyID, companyName, userID string) {
+	user, err := utils.FindUserByUserID(ctx, api.DB, userID)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.owner: not able to find new owner", err)
+		return
+	}
+
+	content := fmt.Sprintf("Hi %s,\n\nYou have been asked to take over the ownership of %s on Creatix. "+
+		"Use the link below to accept or decline.\n\n%s/company/%s/owner",
+		user.Firstname, companyName, api.Cfg.FrontendUrl, companyID)
+	_, err = api.MailClient.SendEmail(api.Cfg.FromEmail, "Creatix", user.Email, user.Firstname, "Creatix: Take over "+companyName, content)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.owner: not able to send email", err)
+	}
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompanyOwnership(t *testing.T) {
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	mailClient := restAPI.MailClient.(*mockMailClient)
+	ctx := context.Background()
+
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "john@doe.no", Access: models.Write}))
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "user@read.no", Access: models.Read}))
+
+	// The last admin cannot be demoted or removed
+	assert.Equal(t, models.LastAdminError, restAPI.CompanyClient.UpdateUserPermission(ctx, "1", models.UserPermissionRequest{UserID: "1", Access: models.Read}))
+	assert.Equal(t, models.LastAdminError, restAPI.CompanyClient.DeleteUser(ctx, "1", "1"))
+
+	// Without an owner any admin can offer the ownership
+	code, _ := callHandler(t, restAPI, restAPI.OfferOwnership, "2", models.OwnershipTransferRequest{UserID: "2"}, "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = callHandler(t, restAPI, restAPI.OfferOwnership, "1", models.OwnershipTransferRequest{UserID: "2"}, "1")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"john@doe.no"}, mailClient.To)
+
+	companies, err := restAPI.CompanyClient.GetUserCompanies(ctx, "2")
+	require.NoError(t, err)
+	assert.Equal(t, []models.Company{{ID: "1", Name: "MyCorp", PendingOwnerID: "2"}}, companies)
+
+	// Only the member it was offered to can accept
+	code, _ = callHandler(t, restAPI, restAPI.AcceptOwnership, "3", nil, "1")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = callHandler(t, restAPI, restAPI.AcceptOwnership, "2", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.AcceptOwnership, "2", nil, "1")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	companies, err = restAPI.CompanyClient.GetUserCompanies(ctx, "2")
+	require.NoError(t, err)
+	assert.Equal(t, []models.Company{{ID: "1", Name: "MyCorp", OwnerID: "2"}}, companies)
+	require.NoError(t, restAPI.SessionClient.IsAuthorized(ctx, "2", "1", models.MembersManage))
+
+	// The owner stays an admin and member, while other admins can now leave
+	code, _ = callHandler(t, restAPI, restAPI.DeleteCompanyUser, "1", nil, "1", "2")
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Equal(t, models.OwnerRemovalError, restAPI.CompanyClient.UpdateUserPermission(ctx, "1", models.UserPermissionRequest{UserID: "2", Access: models.Write}))
+	require.NoError(t, restAPI.CompanyClient.UpdateUserPermission(ctx, "1", models.UserPermissionRequest{UserID: "1", Access: models.Write}))
+
+	// Only the owner offers the ownership, and the offer can be declined
+	require.NoError(t, restAPI.CompanyClient.UpdateUserPermission(ctx, "1", models.UserPermissionRequest{UserID: "1", Access: models.Admin}))
+	code, _ = callHandler(t, restAPI, restAPI.OfferOwnership, "1", models.OwnershipTransferRequest{UserID: "3"}, "1")
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = callHandler(t, restAPI, restAPI.OfferOwnership, "2", models.OwnershipTransferRequest{UserID: "4"}, "1")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = callHandler(t, restAPI, restAPI.OfferOwnership, "2", models.OwnershipTransferRequest{UserID: "3"}, "1")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.CancelOwnershipOffer, "3", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.AcceptOwnership, "3", nil, "1")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = callHandler(t, restAPI, restAPI.CancelOwnershipOffer, "2", nil, "1")
+	assert.Equal(t, http.StatusNotFound, code)
+}
@@ -36,7 +36,7 @@ func (p PublicAPI) ContactUs(c echo.Context) error {
 
 	statusCode, err := p.MailClient.SendEmail(newMail.Email, "Contact Us", p.Cfg.ContactEmail, "TheCreatix", "Creatix: Contact us", newMail.Content)
 	if err != nil {
-		p.Logging.Fatalf("error when sending email: %v", err)
+		p.Logging.Errorf("error when sending email: %v", err)
 		return c.String(http.StatusBadRequest, "")
 	}
 	return c.JSON(statusCode, "")
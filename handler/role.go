@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/kristohberg/CreatixBackend/web"
+	"github.com/labstack/echo"
+)
+
+var (
+	GETPermissionsPath     = "/permissions"
+	GETCompanyRolesPath    = "/company/:company/roles"
+	POSTRolePath           = "/company/:company/roles"
+	PUTRolePath            = "/company/:company/roles/:role"
+	DELETERolePath         = "/company/:company/roles/:role"
+	GETUserPermissionsPath = "/company/:company/permissions"
+)
+
+// GetPermissions lists the permissions roles can be built from
+func (api RestAPI) GetPermissions(c echo.Context) error {
+	return c.JSON(http.StatusOK, models.Permissions)
+}
+
+// GetCompanyRoles lists the default and custom roles of the company
+func (api RestAPI) GetCompanyRoles(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.role.list: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	roles, err := api.CompanyClient.GetCompanyRoles(c.Request().Context(), c.Param("company"))
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.role.list: not able to get roles", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, roles)
+}
+
+// GetUserPermissions lists what the user is allowed to do in the company
+func (api RestAPI) GetUserPermissions(c echo.Context) error {
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	permissions, err := api.CompanyClient.GetUserPermissions(c.Request().Context(), c.Param("company"), userID)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.role.permissions: not able to get permissions", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	if len(permissions) == 0 {
+		return c.String(http.StatusUnauthorized, "")
+	}
+	return c.JSON(http.StatusOK, permissions)
+}
+
+// roleRequestFromContext binds and validates the role in the request. Members
+// can only build roles from permissions they have themselves
+func (api RestAPI) roleRequestFromContext(c echo.Context) (roleRequest *models.RoleRequest, err error) {
+	roleRequest = new(models.RoleRequest)
+	if err = c.Bind(roleRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.role: could not bind role", err)
+		return nil, c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	if err = roleRequest.Valid(); err != nil {
+		return nil, c.JSON(http.StatusBadRequest, err)
+	}
+
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	permissions, err := api.CompanyClient.GetUserPermissions(c.Request().Context(), c.Param("company"), userID)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.role: not able to get permissions", err)
+		return nil, c.String(http.StatusInternalServerError, "")
+	}
+
+	hasPermission := make(map[models.Permission]bool, len(permissions))
+	for _, permission := range permissions {
+		hasPermission[permission] = true
+	}
+	for _, permission := range roleRequest.Permissions {
+		if !hasPermission[permission] {
+			return nil, c.JSON(http.StatusForbidden, utils.NewWebError(models.RoleNotGrantableError.Error()))
+		}
+	}
+	return roleRequest, nil
+}
+
+// CreateRole creates a custom role for the company
+func (api RestAPI) CreateRole(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.RolesManage)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.role.create: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	roleRequest, err := api.roleRequestFromContext(c)
+	if roleRequest == nil {
+		return err
+	}
+
+	role, err := api.CompanyClient.CreateRole(c.Request().Context(), c.Param("company"), *roleRequest)
+	if err == models.RoleExistsError {
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.role.create: not able to create role", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, role)
+}
+
+// UpdateRole renames a custom role and replaces its permissions. The default
+// roles cannot be changed
+func (api RestAPI) UpdateRole(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.RolesManage)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.role.update: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	roleID, err := strconv.Atoi(c.Param("role"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.RoleNotFoundError.Error()))
+	}
+
+	roleRequest, err := api.roleRequestFromContext(c)
+	if roleRequest == nil {
+		return err
+	}
+
+	err = api.CompanyClient.UpdateRole(c.Request().Context(), c.Param("company"), roleID, *roleRequest)
+	switch err {
+	case nil:
+	case models.RoleExistsError:
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	case models.RoleNotFoundError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.role.update: not able to update role", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "updated"})
+}
+
+// DeleteRole deletes a custom role nobody has
+func (api RestAPI) DeleteRole(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.RolesManage)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.role.delete: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	roleID, err := strconv.Atoi(c.Param("role"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.RoleNotFoundError.Error()))
+	}
+
+	err = api.CompanyClient.DeleteRole(c.Request().Context(), c.Param("company"), roleID)
+	switch err {
+	case nil:
+	case models.RoleInUseError:
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	case models.RoleNotFoundError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.role.delete: not able to delete role", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "deleted"})
+}
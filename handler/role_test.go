@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoles(t *testing.T) {
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	ctx := context.Background()
+
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "john@doe.no", Access: models.Write}))
+
+	moderator := models.RoleRequest{Name: "Moderator", Permissions: []models.Permission{models.FeedbackRead, models.FeedbackModerate}}
+
+	// Only members who manage roles create them, from known permissions and
+	// without taking the name of a default role
+	code, _ := callHandler(t, restAPI, restAPI.CreateRole, "2", moderator, "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = callHandler(t, restAPI, restAPI.CreateRole, "1", models.RoleRequest{Name: "Admin", Permissions: []models.Permission{models.FeedbackRead}}, "1")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = callHandler(t, restAPI, restAPI.CreateRole, "1", models.RoleRequest{Name: "Owner", Permissions: []models.Permission{"company.delete"}}, "1")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body := callHandler(t, restAPI, restAPI.CreateRole, "1", moderator, "1")
+	require.Equal(t, http.StatusOK, code)
+	var role models.Role
+	require.NoError(t, json.Unmarshal(body, &role))
+	code, _ = callHandler(t, restAPI, restAPI.CreateRole, "1", models.RoleRequest{Name: "moderator", Permissions: []models.Permission{models.FeedbackRead}}, "1")
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, body = callHandler(t, restAPI, restAPI.GetCompanyRoles, "2", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	var roles []models.Role
+	require.NoError(t, json.Unmarshal(body, &roles))
+	require.Len(t, roles, 4)
+	assert.Equal(t, "admin", roles[0].Name)
+	assert.True(t, roles[0].Default)
+	assert.ElementsMatch(t, models.Permissions, roles[0].Permissions)
+	assert.Equal(t, models.Role{ID: role.ID, Name: "Moderator", Permissions: []models.Permission{models.FeedbackModerate, models.FeedbackRead}}, roles[3])
+
+	// Members get the permissions of their role
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "user@read.no", Access: "moderator"}))
+	assert.NoError(t, restAPI.SessionClient.IsAuthorized(ctx, "3", "1", models.FeedbackModerate))
+	assert.Error(t, restAPI.SessionClient.IsAuthorized(ctx, "3", "1", models.FeedbackCreate))
+	code, _ = callHandler(t, restAPI, restAPI.GetCompanyUsers, "3", nil, "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, body = callHandler(t, restAPI, restAPI.GetUserPermissions, "3", nil, "1")
+	require.Equal(t, http.StatusOK, code)
+	var permissions []models.Permission
+	require.NoError(t, json.Unmarshal(body, &permissions))
+	assert.Equal(t, []models.Permission{models.FeedbackModerate, models.FeedbackRead}, permissions)
+
+	// Changing the role changes the permissions of its members
+	moderator.Permissions = []models.Permission{models.FeedbackRead, models.FeedbackCreate}
+	code, _ = callHandler(t, restAPI, restAPI.UpdateRole, "1", moderator, "1", strconv.Itoa(role.ID))
+	require.Equal(t, http.StatusOK, code)
+	assert.NoError(t, restAPI.SessionClient.IsAuthorized(ctx, "3", "1", models.FeedbackCreate))
+	assert.Error(t, restAPI.SessionClient.IsAuthorized(ctx, "3", "1", models.FeedbackModerate))
+
+	// Default roles cannot be changed and roles in use cannot be deleted
+	code, _ = callHandler(t, restAPI, restAPI.UpdateRole, "1", moderator, "1", "1")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = callHandler(t, restAPI, restAPI.DeleteRole, "1", nil, "1", strconv.Itoa(role.ID))
+	assert.Equal(t, http.StatusConflict, code)
+
+	// Members can only hand out roles within their own permissions
+	inviter := models.RoleRequest{Name: "Inviter", Permissions: []models.Permission{models.FeedbackRead, models.MembersInvite}}
+	code, _ = callHandler(t, restAPI, restAPI.CreateRole, "1", inviter, "1")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, restAPI.CompanyClient.UpdateUserPermission(ctx, "1", models.UserPermissionRequest{UserID: "2", Access: "Inviter"}))
+
+	code, _ = callHandler(t, restAPI, restAPI.CreateInvitation, "2", models.InvitationRequest{Email: "new@doe.com", Access: models.Admin}, "1")
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = callHandler(t, restAPI, restAPI.CreateInvitation, "2", models.InvitationRequest{Email: "new@doe.com", Access: models.Read}, "1")
+	assert.Equal(t, http.StatusOK, code)
+
+	// Deleting a role nobody has
+	require.NoError(t, restAPI.CompanyClient.UpdateUserPermission(ctx, "1", models.UserPermissionRequest{UserID: "3", Access: models.Read}))
+	code, _ = callHandler(t, restAPI, restAPI.DeleteRole, "1", nil, "1", strconv.Itoa(role.ID))
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.DeleteRole, "1", nil, "1", strconv.Itoa(role.ID))
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Members can only remove or demote members whose permissions they have
+	manager := models.RoleRequest{Name: "Manager", Permissions: []models.Permission{models.FeedbackRead, models.MembersManage}}
+	code, _ = callHandler(t, restAPI, restAPI.CreateRole, "1", manager, "1")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, restAPI.CompanyClient.UpdateUserPermission(ctx, "1", models.UserPermissionRequest{UserID: "2", Access: "Manager"}))
+	require.NoError(t, restAPI.CompanyClient.UpdateUserPermission(ctx, "1", models.UserPermissionRequest{UserID: "3", Access: models.Admin}))
+
+	code, _ = callHandler(t, restAPI, restAPI.DeleteCompanyUser, "2", nil, "1", "3")
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = callHandler(t, restAPI, restAPI.ChangeUserPermission, "2", models.UserPermissionRequest{UserID: "3", Access: models.Read}, "1")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.NoError(t, restAPI.SessionClient.IsAuthorized(ctx, "3", "1", models.RolesManage))
+
+	require.NoError(t, restAPI.CompanyClient.UpdateUserPermission(ctx, "1", models.UserPermissionRequest{UserID: "3", Access: models.Read}))
+	code, _ = callHandler(t, restAPI, restAPI.DeleteCompanyUser, "2", nil, "1", "3")
+	assert.Equal(t, http.StatusOK, code)
+}
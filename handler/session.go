@@ -9,6 +9,9 @@ import (
 	"github.com/labstack/echo"
 
 	"github.com/kristohberg/CreatixBackend/config"
+	"github.com/kristohberg/CreatixBackend/internal/mail"
+	"github.com/kristohberg/CreatixBackend/internal/oidc"
+	"github.com/kristohberg/CreatixBackend/middleware"
 	"github.com/kristohberg/CreatixBackend/utils"
 	"github.com/kristohberg/CreatixBackend/web"
 
@@ -21,18 +24,43 @@ type SessionAPI struct {
 	Logging       *logging.StandardLogger
 	Cfg           config.Config
 	SessionClient *models.SessionClient
+	MailClient    mail.MailClienter
+	OidcProviders map[string]*oidc.Provider
 }
 
 var (
-	POSTSignupNewUserPath = "/user/signup"
+	POSTSignupNewUserPath  = "/user/signup"
+	POSTForgotPasswordPath = "/user/password/forgot"
+	POSTResetPasswordPath  = "/user/password/reset"
+	POSTVerifyEmailPath    = "/user/email/verify"
+	POSTResendVerifyPath   = "/user/email/resend"
+	POSTLoginMfaPath       = "/user/login/mfa"
+	GETJwksPath            = "/.well-known/jwks.json"
+)
+
+const (
+	accessTokenCookie      = "token"
+	refreshTokenCookie     = "refresh_token"
+	refreshTokenCookiePath = "/v0/auth"
 )
 
 // Handler sets up the session endpoints
 func (s SessionAPI) Handler(e *echo.Group) {
 	e.POST(POSTSignupNewUserPath, s.Signup)
 	e.POST("/user/login", s.Login)
+	e.POST(POSTLoginMfaPath, s.LoginMfa)
 	e.POST("/user/refresh", s.Refresh)
 	e.GET("/user/logout", s.Logout)
+	e.POST(POSTForgotPasswordPath, s.ForgotPassword)
+	e.POST(POSTResetPasswordPath, s.ResetPassword)
+	e.POST(POSTVerifyEmailPath, s.VerifyEmail)
+	e.POST(POSTResendVerifyPath, s.ResendVerification)
+	e.GET(GETOidcStartPath, s.OidcStart)
+	e.GET(GETOidcCallbackPath, s.OidcCallback)
+
+	// Linking needs a session, personal access tokens cannot link providers
+	verify := &middleware.Middleware{Cfg: s.Cfg, SessionClient: s.SessionClient}
+	e.GET(GETOidcLinkPath, s.OidcLink, verify.JwtVerify)
 
 }
 
@@ -50,15 +78,65 @@ func (s SessionAPI) Signup(c echo.Context) (err error) {
 		return c.JSON(http.StatusBadRequest, err)
 	}
 
-	err = s.SessionClient.CreateUser(c.Request().Context(), *signup)
+	err = s.SessionClient.CreateUser(utils.WithClientInfo(c), *signup)
 	if err != nil {
 		s.Logging.Unsuccessful("not able to create user ", err)
 		return c.String(http.StatusBadRequest, "could not create user")
 	}
 
+	s.sendVerificationEmail(c, signup.Email)
 	return c.String(http.StatusOK, "user created")
 }
 
+func (s SessionAPI) sendVerificationEmail(c echo.Context, email string) {
+	token, user, err := s.SessionClient.CreateEmailVerification(c.Request().Context(), email)
+	if err != nil {
+		s.Logging.Unsuccessful("not able to create email verification", err)
+		return
+	}
+
+	content := fmt.Sprintf("Hi %s,\n\nWelcome to Creatix! Please confirm your email address using the link below. "+
+		"The link expires in 48 hours.\n\n%s/verify-email?token=%s", user.Firstname, s.Cfg.FrontendUrl, token)
+	s.sendUserMail(user, "Creatix: Verify your email", content)
+}
+
+func (s SessionAPI) sendUserMail(user utils.SessionUser, subject, content string) {
+	_, err := s.MailClient.SendEmail(s.Cfg.FromEmail, "Creatix", user.Email, user.Firstname, subject, content)
+	if err != nil {
+		s.Logging.Unsuccessful("not able to send email", err)
+	}
+}
+
+// VerifyEmail marks the email of the user as verified using the token sent
+// on signup
+func (s SessionAPI) VerifyEmail(c echo.Context) (err error) {
+	verifyRequest := new(models.VerifyEmailRequest)
+	if err = c.Bind(verifyRequest); err != nil || verifyRequest.Token == "" {
+		s.Logging.Unsuccessful("not able to parse verify email request", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	if _, err = s.SessionClient.VerifyEmail(utils.WithClientInfo(c), verifyRequest.Token); err != nil {
+		s.Logging.Unsuccessful("not able to verify email", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "invalid or expired token"})
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "email verified"})
+}
+
+// ResendVerification sends a new verification email. The response is the
+// same whether or not the email belongs to an unverified user
+func (s SessionAPI) ResendVerification(c echo.Context) (err error) {
+	resendRequest := new(models.ResendVerificationRequest)
+	if err = c.Bind(resendRequest); err != nil || resendRequest.Email == "" {
+		s.Logging.Unsuccessful("not able to parse resend verification request", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	s.sendVerificationEmail(c, resendRequest.Email)
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "if the email is unverified a new link has been sent"})
+}
+
 // Login checks whether the user exists and creates a cookie
 func (s SessionAPI) Login(c echo.Context) (err error) {
 	loginRequest := new(models.LoginRequest)
@@ -68,77 +146,175 @@ func (s SessionAPI) Login(c echo.Context) (err error) {
 		return c.String(http.StatusBadRequest, "not able to parse user")
 	}
 
-	resp, err := s.SessionClient.LoginUser(c.Request().Context(), loginRequest)
+	resp, err := s.SessionClient.LoginUser(utils.WithClientInfo(c), loginRequest)
 	if err != nil {
 		s.Logging.Unsuccessful("not able to log in user", err)
-		return c.String(http.StatusBadRequest, "no user")
+		if lockedErr, ok := err.(*models.AccountLockedError); ok {
+			s.sendAccountLockedMail(lockedErr)
+		}
+		return c.JSON(http.StatusBadRequest, utils.NewWebError(models.InvalidCredentialsError.Error()))
 	}
 
-	cookie := &http.Cookie{
-		Name:    "token",
-		Value:   resp.Token,
-		Expires: resp.ExpiresAt,
-		Path:    "/v0",
-		Domain:  s.Cfg.AllowCookieDomain,
+	if resp.MfaRequired {
+		return c.JSON(http.StatusOK, models.MfaChallengeResponse{MfaRequired: true, MfaToken: resp.MfaToken, ExpiresAt: resp.ExpiresAt})
 	}
 
-	if s.Cfg.Env == "prod" {
-		cookie.SameSite = http.SameSiteNoneMode
-		cookie.Secure = true
+	s.setSessionCookies(c, resp)
+	return c.JSON(http.StatusOK, resp.UserSession)
+}
+
+func (s SessionAPI) sendAccountLockedMail(lockedErr *models.AccountLockedError) {
+	content := fmt.Sprintf("Hi %s,\n\nThere have been too many failed attempts to log in to your Creatix account, "+
+		"so logins are blocked until %s.\n\nIf this was not you, someone may be trying to guess your password. "+
+		"You can choose a new one from the login page at %s.", lockedErr.User.Firstname, lockedErr.LockedUntil.UTC().Format(time.RFC1123), s.Cfg.FrontendUrl)
+	s.sendUserMail(lockedErr.User, "Creatix: Your account has been locked", content)
+}
+
+// ForgotPassword emails a password reset link to the user. The response is the
+// same whether or not the email belongs to a user
+func (s SessionAPI) ForgotPassword(c echo.Context) (err error) {
+	forgotRequest := new(models.ForgotPasswordRequest)
+	if err = c.Bind(forgotRequest); err != nil || forgotRequest.Email == "" {
+		s.Logging.Unsuccessful("not able to parse forgot password request", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
 	}
 
-	c.SetCookie(cookie)
+	resp := web.HttpResponse{Message: "if the email exists a reset link has been sent"}
+	token, user, err := s.SessionClient.CreatePasswordReset(c.Request().Context(), forgotRequest.Email)
+	if err != nil {
+		s.Logging.Unsuccessful("not able to create password reset", err)
+		return c.JSON(http.StatusOK, resp)
+	}
+
+	content := fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your Creatix account. "+
+		"Use the link below to choose a new password. The link expires in one hour.\n\n%s/reset-password?token=%s\n\n"+
+		"If you did not ask for this you can ignore this email.", user.Firstname, s.Cfg.FrontendUrl, token)
+	s.sendUserMail(user, "Creatix: Reset your password", content)
+	return c.JSON(http.StatusOK, resp)
+}
+
+// ResetPassword sets a new password using a password reset token and ends
+// all the sessions of the user
+func (s SessionAPI) ResetPassword(c echo.Context) (err error) {
+	resetRequest := new(models.ResetPasswordRequest)
+	if err = c.Bind(resetRequest); err != nil {
+		s.Logging.Unsuccessful("not able to parse reset password request", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	if err = resetRequest.Valid(); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	err = s.SessionClient.ResetPassword(c.Request().Context(), *resetRequest)
+	if err != nil {
+		s.Logging.Unsuccessful("not able to reset password", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "invalid or expired token"})
+	}
+
+	s.clearSessionCookies(c)
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "password updated"})
+}
+
+// LoginMfa completes a login that required a second factor and creates the
+// session cookies
+func (s SessionAPI) LoginMfa(c echo.Context) (err error) {
+	mfaRequest := new(models.MfaLoginRequest)
+	if err = c.Bind(mfaRequest); err != nil || mfaRequest.MfaToken == "" {
+		s.Logging.Unsuccessful("not able to parse mfa login request", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	resp, err := s.SessionClient.CompleteMfaLogin(utils.WithClientInfo(c), *mfaRequest)
+	if err != nil {
+		s.Logging.Unsuccessful("not able to complete mfa login", err)
+		if lockedErr, ok := err.(*models.AccountLockedError); ok {
+			s.sendAccountLockedMail(lockedErr)
+		}
+		return c.JSON(http.StatusUnauthorized, web.HttpResponse{Message: "invalid code"})
+	}
+
+	s.setSessionCookies(c, resp)
 	return c.JSON(http.StatusOK, resp.UserSession)
 }
 
-// Logout will set a new invalid cookie
+// Logout revokes the session on the server and sets new invalid cookies
 func (s SessionAPI) Logout(c echo.Context) error {
-	cookie := &http.Cookie{
-		Name:   "token",
-		MaxAge: -1,
-		Path:   "/v0",
-	}
-	if s.Cfg.Env == "prod" {
-		cookie.SameSite = http.SameSiteNoneMode
-		cookie.Secure = true
+	if cookie, err := c.Cookie(refreshTokenCookie); err == nil {
+		err = s.SessionClient.RevokeSessionByRefreshToken(utils.WithClientInfo(c), cookie.Value)
+		if err != nil {
+			s.Logging.Unsuccessful("not able to revoke session", err)
+		}
+	} else if cookie, err := c.Cookie(accessTokenCookie); err == nil {
+		claims, err := utils.GetClaims(cookie.Value, s.SessionClient.Keyring)
+		if err == nil {
+			err = s.SessionClient.RevokeSession(utils.WithClientInfo(c), claims.SessionID)
+		}
+		if err != nil {
+			s.Logging.Unsuccessful("not able to revoke session", err)
+		}
 	}
-	c.SetCookie(cookie)
+
+	s.clearSessionCookies(c)
 	return c.String(http.StatusOK, "old cookie deleted, logged out")
 }
 
-// Refresh refreshes the cookie provided by generating a new one
-// and returning it
+// Refresh exchanges the refresh token cookie for a new access token and
+// a new refresh token
 func (s SessionAPI) Refresh(c echo.Context) error {
-	cookie, err := c.Cookie("token")
+	cookie, err := c.Cookie(refreshTokenCookie)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "cookie not found"})
 	}
 
-	tokenValue := cookie.Value
-	err = utils.IsTokenValid(tokenValue, []byte(s.Cfg.TokenSecret))
+	resp, err := s.SessionClient.RefreshSession(utils.WithClientInfo(c), cookie.Value)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: fmt.Sprintf("invalid cookie: %s", err.Error())})
+		s.Logging.Unsuccessful("not able to refresh session", err)
+		s.clearSessionCookies(c)
+		return c.JSON(http.StatusUnauthorized, web.HttpResponse{Message: fmt.Sprintf("invalid cookie: %s", err.Error())})
+	}
+
+	s.setSessionCookies(c, resp)
+	return c.JSON(http.StatusOK, resp.UserSession)
+}
+
+func (s SessionAPI) newCookie(name, value, path string, expires time.Time) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Expires:  expires,
+		Path:     path,
+		Domain:   s.Cfg.AllowCookieDomain,
+		HttpOnly: name == refreshTokenCookie,
 	}
 
-	expiresAt := time.Now().Add(time.Minute * time.Duration(s.Cfg.TokenExpirationTimeMinutes))
-	cookie.Expires = expiresAt
-	cookie.Path = "/v0"
 	if s.Cfg.Env == "prod" {
 		cookie.SameSite = http.SameSiteNoneMode
 		cookie.Secure = true
 	}
-	claims, err := utils.GetClaims(tokenValue, []byte(s.Cfg.TokenSecret))
-	if err != nil {
-		s.Logging.Unsuccessful("no claim", err)
-		return c.String(http.StatusBadRequest, "")
-	}
+	return cookie
+}
 
-	userSessionData, err := s.SessionClient.GetUserSessionFromUserId(c.Request().Context(), claims.UserID)
-	if err != nil {
-		s.Logging.Unsuccessful("failed to retrieve user session data", err)
-		return c.String(http.StatusBadRequest, "")
-	}
+// setSessionCookies sets the access token and refresh token cookies. The
+// refresh token is only sent to the auth endpoints
+func (s SessionAPI) setSessionCookies(c echo.Context, resp models.SessionResponse) {
+	c.SetCookie(s.newCookie(accessTokenCookie, resp.Token, "/v0", resp.ExpiresAt))
+	c.SetCookie(s.newCookie(refreshTokenCookie, resp.RefreshToken, refreshTokenCookiePath, resp.RefreshExpiresAt))
+}
+
+func (s SessionAPI) clearSessionCookies(c echo.Context) {
+	accessCookie := s.newCookie(accessTokenCookie, "", "/v0", time.Time{})
+	accessCookie.MaxAge = -1
+	c.SetCookie(accessCookie)
+
+	refreshCookie := s.newCookie(refreshTokenCookie, "", refreshTokenCookiePath, time.Time{})
+	refreshCookie.MaxAge = -1
+	c.SetCookie(refreshCookie)
+}
 
-	c.SetCookie(cookie)
-	return c.JSON(http.StatusOK, userSessionData)
+// Jwks publishes the public keys access tokens are signed with so other
+// services can verify them. It is served from the root of the api
+func (s SessionAPI) Jwks(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "public, max-age=300")
+	return c.JSON(http.StatusOK, s.SessionClient.Keyring.JWKS())
 }
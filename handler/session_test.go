@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -15,6 +16,7 @@ import (
 	"github.com/labstack/echo"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
 )
 
 func newContext(e *echo.Echo, data []byte, path string) (echo.Context, *httptest.ResponseRecorder) {
@@ -25,6 +27,47 @@ func newContext(e *echo.Echo, data []byte, path string) (echo.Context, *httptest
 
 }
 
+func newContextWithCookies(e *echo.Echo, data []byte, path string, cookies []*http.Cookie) (echo.Context, *httptest.ResponseRecorder) {
+	c, rec := newContext(e, data, path)
+	for _, cookie := range cookies {
+		c.Request().AddCookie(cookie)
+	}
+	return c, rec
+}
+
+func findCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return &http.Cookie{}
+}
+
+type mockMailClient struct {
+	To      []string
+	Content []string
+}
+
+func (m *mockMailClient) SendEmail(fromAddress, fromName, toAddress, toName, subject, content string) (int, error) {
+	m.To = append(m.To, toAddress)
+	m.Content = append(m.Content, content)
+	return http.StatusAccepted, nil
+}
+
+// lastToken returns the token query parameter of the last link sent by mail
+func (m *mockMailClient) lastToken() string {
+	if len(m.Content) == 0 {
+		return ""
+	}
+	content := m.Content[len(m.Content)-1]
+	idx := strings.Index(content, "token=")
+	if idx < 0 {
+		return ""
+	}
+	return strings.Fields(content[idx+len("token="):])[0]
+}
+
 func NewSignupUser(firstName, lastName, userName, email, pwd string) models.User {
 	return models.User{ID: "1", Firstname: "Kris", Lastname: "Berg", Username: "kristohb", Email: "ok@ok.com", Password: "olol"}
 }
@@ -34,7 +77,7 @@ func NewSignupUserByte(irstName, lastName, userName, email, pwd string) (signup
 }
 
 func NewMockUserBytes(email string, access models.AccessLevel) (user []byte, err error) {
-	return json.Marshal(models.AddUser{email, access})
+	return json.Marshal(models.AddUser{Email: email, Access: access})
 }
 
 func newCompany() models.Company {
@@ -74,7 +117,8 @@ func TestSession(t *testing.T) {
 		DB:            db,
 		Logging:       logger,
 		Cfg:           config.Config{Env: "test"},
-		SessionClient: models.NewSessionClient(db, []byte("secret"), 20, logger),
+		SessionClient: models.NewSessionClient(db, newTestKeyring("secret"), 20, 24, logger),
+		MailClient:    &mockMailClient{},
 	}
 
 	// Signup user
@@ -107,18 +151,38 @@ func TestSession(t *testing.T) {
 		assert.Equal(t, http.StatusOK, rec.Code)
 		assertStruct(t, rec, newSessionUser(NewSignupUser("john", "doe", "johndoe1", "john@doe.com", "lol")))
 	}
-	cookie := rec.Header().Get("Set-Cookie")
+	cookies := rec.Result().Cookies()
 
 	// Try to refresh cookie
 	t.Log("Test to refresh cookie")
-	c, rec = newContext(e, nil, "/")
-	c.Set("cookie", cookie)
+	c, rec = newContextWithCookies(e, nil, "/", cookies)
+	err = sessionAPI.Refresh(c)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	rotatedCookies := rec.Result().Cookies()
+	assert.NotEqual(t, findCookie(cookies, refreshTokenCookie).Value, findCookie(rotatedCookies, refreshTokenCookie).Value)
+
+	// Reusing the old refresh token revokes the whole token family
+	t.Log("Reuse old refresh token")
+	c, rec = newContextWithCookies(e, nil, "/", cookies)
 	err = sessionAPI.Refresh(c)
 	if assert.NoError(t, err) {
-		newCookie := rec.Header().Get("Set-Cookie")
-		assert.NotEqual(t, newCookie, cookie)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
 	}
 
+	c, rec = newContextWithCookies(e, nil, "/", rotatedCookies)
+	err = sessionAPI.Refresh(c)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+
+	// Login again to get a new session
+	c, rec = newContext(e, loginRequestByte, "/")
+	err = sessionAPI.Login(c)
+	require.NoError(t, err)
+	cookies = rec.Result().Cookies()
+
 	// Try to login with nonexisting user
 	t.Log("Login with nonexisting user")
 	loginRequest = models.LoginRequest{Email: "ok2@ok.com", Password: "olol"}
@@ -134,13 +198,19 @@ func TestSession(t *testing.T) {
 
 	// Try to logout
 	t.Log("Test to logout cookie")
-	c, rec = newContext(e, nil, "/")
-	c.Set("cookie", cookie)
+	c, rec = newContextWithCookies(e, nil, "/", cookies)
 	err = sessionAPI.Logout(c)
 	if assert.NoError(t, err) {
 		assert.Equal(t, http.StatusOK, rec.Code)
-		newCookie := rec.Header().Get("Set-Cookie")
-		assert.NotEqual(t, newCookie, cookie)
+		assert.Equal(t, "", findCookie(rec.Result().Cookies(), accessTokenCookie).Value)
+	}
+
+	// The session is revoked on the server so the refresh token is useless
+	t.Log("Try to refresh after logout")
+	c, rec = newContextWithCookies(e, nil, "/", cookies)
+	err = sessionAPI.Refresh(c)
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
 	}
 
 	// Try to refresh without cookie
@@ -152,3 +222,253 @@ func TestSession(t *testing.T) {
 	}
 
 }
+
+func TestPasswordReset(t *testing.T) {
+	var c echo.Context
+	var rec *httptest.ResponseRecorder
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	mailClient := &mockMailClient{}
+	sessionAPI := SessionAPI{
+		DB:            db,
+		Logging:       logger,
+		Cfg:           config.Config{Env: "test"},
+		SessionClient: models.NewSessionClient(db, newTestKeyring("secret"), 20, 24, logger),
+		MailClient:    mailClient,
+	}
+
+	user := models.User{Firstname: "John", Lastname: "Doe", Username: "johndoe", Email: "john@doe.com", Password: "MyPassword@123"}
+	err = sessionAPI.SessionClient.CreateUser(context.Background(), models.Signup{User: user})
+	require.NoError(t, err)
+
+	loginRequestByte, err := json.Marshal(newLoginRequest(user))
+	require.NoError(t, err)
+	c, rec = newContext(e, loginRequestByte, "/")
+	require.NoError(t, sessionAPI.Login(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+	cookies := rec.Result().Cookies()
+
+	// Unknown emails get the same response but no mail
+	forgotByte, err := json.Marshal(models.ForgotPasswordRequest{Email: "unknown@doe.com"})
+	require.NoError(t, err)
+	c, rec = newContext(e, forgotByte, POSTForgotPasswordPath)
+	if assert.NoError(t, sessionAPI.ForgotPassword(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, mailClient.To)
+	}
+
+	forgotByte, err = json.Marshal(models.ForgotPasswordRequest{Email: user.Email})
+	require.NoError(t, err)
+	c, rec = newContext(e, forgotByte, POSTForgotPasswordPath)
+	if assert.NoError(t, sessionAPI.ForgotPassword(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, []string{user.Email}, mailClient.To)
+	}
+	token := mailClient.lastToken()
+	require.NotEmpty(t, token)
+
+	// Weak passwords are rejected
+	resetByte, err := json.Marshal(models.ResetPasswordRequest{Token: token, Password: "weak"})
+	require.NoError(t, err)
+	c, rec = newContext(e, resetByte, POSTResetPasswordPath)
+	if assert.NoError(t, sessionAPI.ResetPassword(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+
+	resetByte, err = json.Marshal(models.ResetPasswordRequest{Token: token, Password: "MyNewPassword@123"})
+	require.NoError(t, err)
+	c, rec = newContext(e, resetByte, POSTResetPasswordPath)
+	if assert.NoError(t, sessionAPI.ResetPassword(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	// The token can only be used once
+	c, rec = newContext(e, resetByte, POSTResetPasswordPath)
+	if assert.NoError(t, sessionAPI.ResetPassword(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+
+	// The old session has been ended
+	c, rec = newContextWithCookies(e, nil, "/", cookies)
+	if assert.NoError(t, sessionAPI.Refresh(c)) {
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+
+	// Login with the new password
+	user.Password = "MyNewPassword@123"
+	loginRequestByte, err = json.Marshal(newLoginRequest(user))
+	require.NoError(t, err)
+	c, rec = newContext(e, loginRequestByte, "/")
+	if assert.NoError(t, sessionAPI.Login(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	var c echo.Context
+	var rec *httptest.ResponseRecorder
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	mailClient := &mockMailClient{}
+	sessionAPI := SessionAPI{
+		DB:            db,
+		Logging:       logger,
+		Cfg:           config.Config{Env: "test"},
+		SessionClient: models.NewSessionClient(db, newTestKeyring("secret"), 20, 24, logger),
+		MailClient:    mailClient,
+	}
+
+	user := models.User{Firstname: "John", Lastname: "Doe", Username: "johndoe", Email: "john@doe.com", Password: "MyPassword@123"}
+	signupByte, err := json.Marshal(models.Signup{User: user})
+	require.NoError(t, err)
+	c, rec = newContext(e, signupByte, POSTSignupNewUserPath)
+	if assert.NoError(t, sessionAPI.Signup(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, []string{user.Email}, mailClient.To)
+	}
+
+	sessionUser, err := utils.FindUserByEmail(context.Background(), db, user.Email)
+	require.NoError(t, err)
+	assert.False(t, sessionUser.EmailVerified)
+
+	// Resending invalidates the first token
+	firstToken := mailClient.lastToken()
+	resendByte, err := json.Marshal(models.ResendVerificationRequest{Email: user.Email})
+	require.NoError(t, err)
+	c, rec = newContext(e, resendByte, POSTResendVerifyPath)
+	if assert.NoError(t, sessionAPI.ResendVerification(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Len(t, mailClient.To, 2)
+	}
+
+	verifyByte, err := json.Marshal(models.VerifyEmailRequest{Token: firstToken})
+	require.NoError(t, err)
+	c, rec = newContext(e, verifyByte, POSTVerifyEmailPath)
+	if assert.NoError(t, sessionAPI.VerifyEmail(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+
+	verifyByte, err = json.Marshal(models.VerifyEmailRequest{Token: mailClient.lastToken()})
+	require.NoError(t, err)
+	c, rec = newContext(e, verifyByte, POSTVerifyEmailPath)
+	if assert.NoError(t, sessionAPI.VerifyEmail(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	sessionUser, err = utils.FindUserByEmail(context.Background(), db, user.Email)
+	require.NoError(t, err)
+	assert.True(t, sessionUser.EmailVerified)
+
+	// Verified users do not get new verification emails
+	c, rec = newContext(e, resendByte, POSTResendVerifyPath)
+	if assert.NoError(t, sessionAPI.ResendVerification(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Len(t, mailClient.To, 2)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	sessionAPI := NewSessionAPI(db, logger)
+	mailClient := sessionAPI.MailClient.(*mockMailClient)
+
+	user := models.User{Firstname: "John", Lastname: "Doe", Username: "johndoe", Email: "john@doe.com", Password: "MyPassword@123"}
+	require.NoError(t, sessionAPI.SessionClient.CreateUser(context.Background(), models.Signup{User: user}))
+
+	login := func(email, password string) *httptest.ResponseRecorder {
+		loginRequestByte, err := json.Marshal(models.LoginRequest{Email: email, Password: password})
+		require.NoError(t, err)
+		c, rec := newContext(e, loginRequestByte, "/")
+		require.NoError(t, sessionAPI.Login(c))
+		return rec
+	}
+	// forgetBackoff moves the failures back in time so the next attempt is
+	// not delayed, without forgetting the failures themselves
+	forgetBackoff := func() {
+		_, err := db.Exec(`UPDATE LOGIN_THROTTLES SET LastFailedAt=NOW() - INTERVAL '2 minutes'`)
+		require.NoError(t, err)
+	}
+
+	// Unknown emails and wrong passwords look the same
+	unknown := login("unknown@doe.com", user.Password)
+	wrong := login(user.Email, "Wrong@12345")
+	assert.Equal(t, http.StatusBadRequest, unknown.Code)
+	assert.Equal(t, unknown.Code, wrong.Code)
+	assert.Equal(t, unknown.Body.String(), wrong.Body.String())
+
+	// After the free attempts even the right password has to wait
+	login(user.Email, "Wrong@12345")
+	login(user.Email, "Wrong@12345")
+	rec := login(user.Email, user.Password)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, wrong.Body.String(), rec.Body.String())
+
+	forgetBackoff()
+	rec = login(user.Email, user.Password)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Too many failures lock the account and notify the owner
+	for i := 0; i < 10; i++ {
+		forgetBackoff()
+		login(user.Email, "Wrong@12345")
+	}
+	require.NotEmpty(t, mailClient.To)
+	assert.Equal(t, user.Email, mailClient.To[len(mailClient.To)-1])
+	assert.Contains(t, mailClient.Content[len(mailClient.Content)-1], "too many failed attempts")
+
+	forgetBackoff()
+	rec = login(user.Email, user.Password)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, wrong.Body.String(), rec.Body.String())
+}
+
+func TestPasswordRehash(t *testing.T) {
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	sessionAPI := NewSessionAPI(db, logger)
+
+	user := models.User{Firstname: "John", Lastname: "Doe", Username: "johndoe", Email: "john@doe.com", Password: "MyPassword@123"}
+	require.NoError(t, sessionAPI.SessionClient.CreateUser(context.Background(), models.Signup{User: user}))
+
+	// Users created before argon2id have bcrypt hashes
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	_, err = db.Exec("UPDATE USERS SET Password=$1 WHERE Email=$2", string(bcryptHash), user.Email)
+	require.NoError(t, err)
+
+	loginRequestByte, err := json.Marshal(newLoginRequest(user))
+	require.NoError(t, err)
+	c, rec := newContext(e, loginRequestByte, "/")
+	require.NoError(t, sessionAPI.Login(c))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var storedHash string
+	require.NoError(t, db.QueryRow("SELECT Password FROM USERS WHERE Email=$1", user.Email).Scan(&storedHash))
+	assert.True(t, strings.HasPrefix(storedHash, "$argon2id$"))
+
+	// The new hash still logs in
+	c, rec = newContext(e, loginRequestByte, "/")
+	require.NoError(t, sessionAPI.Login(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,292 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/kristohberg/CreatixBackend/web"
+	"github.com/labstack/echo"
+)
+
+var (
+	GETCompanyTeamsPath  = "/company/:company/teams"
+	POSTTeamPath         = "/company/:company/teams"
+	PUTTeamPath          = "/company/:company/teams/:team"
+	DELETETeamPath       = "/company/:company/teams/:team"
+	GETTeamMembersPath   = "/company/:company/teams/:team/members"
+	POSTTeamMemberPath   = "/company/:company/teams/:team/members"
+	PUTTeamMemberPath    = "/company/:company/teams/:team/members/:userid"
+	DELETETeamMemberPath = "/company/:company/teams/:team/members/:userid"
+	GETUserTeamsPath     = "/user/teams"
+)
+
+// teamManager reports whether the user can manage the team in the path.
+// Members whose role manages teams manage every team, while team leads manage
+// the members of their own team
+func (api RestAPI) teamManager(c echo.Context) (isManager, isLead bool) {
+	if _, err := strconv.Atoi(c.Param("team")); err != nil {
+		return false, false
+	}
+
+	if authorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.TeamsManage); err == nil && authorized {
+		return true, false
+	}
+
+	if authorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead); err != nil || !authorized {
+		return false, false
+	}
+
+	userID := c.Get(utils.UserIDContext.String()).(string)
+	isLead, err := api.CompanyClient.IsTeamLead(c.Request().Context(), c.Param("company"), c.Param("team"), userID)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.team: could not check team lead", err)
+		return false, false
+	}
+	return false, isLead
+}
+
+// GetCompanyTeams lists the teams of the company
+func (api RestAPI) GetCompanyTeams(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.team.list: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	teams, err := api.CompanyClient.GetCompanyTeams(c.Request().Context(), c.Param("company"))
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.team.list: not able to get teams", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, teams)
+}
+
+// CreateTeam lets a team manager create a team
+func (api RestAPI) CreateTeam(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.TeamsManage)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.team.create: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	teamRequest := new(models.TeamRequest)
+	if err = c.Bind(teamRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.team.create: could not bind team", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	if err = teamRequest.Valid(); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	team := models.Team{CompanyID: c.Param("company"), Name: teamRequest.Name}
+	team.ID, err = api.CompanyClient.CreateTeam(c.Request().Context(), team)
+	if err == models.TeamExistsError {
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.team.create: not able to create team", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, team)
+}
+
+// RenameTeam lets a team manager or the team lead rename the team
+func (api RestAPI) RenameTeam(c echo.Context) error {
+	isManager, isLead := api.teamManager(c)
+	if !isManager && !isLead {
+		api.Logging.Unsuccessful("creatix.team.rename: no permission", nil)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	teamRequest := new(models.TeamRequest)
+	if err := c.Bind(teamRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.team.rename: could not bind team", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	if err := teamRequest.Valid(); err != nil {
+		return c.JSON(http.StatusBadRequest, err)
+	}
+
+	err := api.CompanyClient.RenameTeam(c.Request().Context(), c.Param("company"), c.Param("team"), teamRequest.Name)
+	switch err {
+	case nil:
+	case models.TeamExistsError:
+		return c.JSON(http.StatusConflict, utils.NewWebError(err.Error()))
+	case models.TeamNotFoundError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.team.rename: not able to rename team", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "renamed"})
+}
+
+// DeleteTeam lets a team manager delete a team
+func (api RestAPI) DeleteTeam(c echo.Context) error {
+	isManager, _ := api.teamManager(c)
+	if !isManager {
+		api.Logging.Unsuccessful("creatix.team.delete: no permission", nil)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	err := api.CompanyClient.DeleteTeam(c.Request().Context(), c.Param("company"), c.Param("team"))
+	if err == models.TeamNotFoundError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.team.delete: not able to delete team", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "deleted"})
+}
+
+// GetTeamMembers lists the members of a team
+func (api RestAPI) GetTeamMembers(c echo.Context) error {
+	isAuthorized, err := api.SessionClient.IsAuthorizedFromEchoContext(c, models.FeedbackRead)
+	if err != nil || !isAuthorized {
+		api.Logging.Unsuccessful("creatix.team.members: no permission", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	if _, err = strconv.Atoi(c.Param("team")); err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.TeamNotFoundError.Error()))
+	}
+
+	members, err := api.CompanyClient.GetTeamMembers(c.Request().Context(), c.Param("company"), c.Param("team"))
+	if err == models.TeamNotFoundError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.team.members: not able to get team members", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, members)
+}
+
+// AddTeamMember adds a member of the company to the team. Team leads can add
+// members while only team managers can make someone a lead
+func (api RestAPI) AddTeamMember(c echo.Context) error {
+	isManager, isLead := api.teamManager(c)
+	if !isManager && !isLead {
+		api.Logging.Unsuccessful("creatix.team.addmember: no permission", nil)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	memberRequest := new(models.TeamMemberRequest)
+	if err := c.Bind(memberRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.team.addmember: could not bind member", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	if _, err := strconv.Atoi(memberRequest.UserID); err != nil {
+		return c.JSON(http.StatusBadRequest, utils.NewWebError("userId must be the id of a company member"))
+	}
+
+	if memberRequest.Lead && !isManager {
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	err := api.CompanyClient.AddUserToTeam(c.Request().Context(), c.Param("company"), c.Param("team"), *memberRequest)
+	switch err {
+	case nil:
+	case models.TeamNotFoundError:
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	case models.NotCompanyMemberError:
+		return c.JSON(http.StatusBadRequest, utils.NewWebError(err.Error()))
+	default:
+		api.Logging.Unsuccessful("creatix.team.addmember: not able to add member", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "added"})
+}
+
+// UpdateTeamMember lets a team manager choose whether a member leads the team
+func (api RestAPI) UpdateTeamMember(c echo.Context) error {
+	isManager, _ := api.teamManager(c)
+	if !isManager {
+		api.Logging.Unsuccessful("creatix.team.updatemember: no permission", nil)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	if _, err := strconv.Atoi(c.Param("userid")); err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.TeamMemberNotFoundError.Error()))
+	}
+
+	memberRequest := new(models.TeamMemberRequest)
+	if err := c.Bind(memberRequest); err != nil {
+		api.Logging.Unsuccessful("creatix.team.updatemember: could not bind member", err)
+		return c.JSON(http.StatusBadRequest, web.HttpResponse{Message: "could not bind data"})
+	}
+
+	err := api.CompanyClient.SetTeamLead(c.Request().Context(), c.Param("company"), c.Param("team"), c.Param("userid"), memberRequest.Lead)
+	if err == models.TeamMemberNotFoundError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.team.updatemember: not able to update member", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "updated"})
+}
+
+// RemoveTeamMember removes a member from the team. Team leads can remove
+// members and themselves, but only team managers can remove other leads
+func (api RestAPI) RemoveTeamMember(c echo.Context) error {
+	isManager, isLead := api.teamManager(c)
+	if !isManager && !isLead {
+		api.Logging.Unsuccessful("creatix.team.removemember: no permission", nil)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	memberID := c.Param("userid")
+	if _, err := strconv.Atoi(memberID); err != nil {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(models.TeamMemberNotFoundError.Error()))
+	}
+
+	if !isManager && memberID != c.Get(utils.UserIDContext.String()).(string) {
+		memberIsLead, err := api.CompanyClient.IsTeamLead(c.Request().Context(), c.Param("company"), c.Param("team"), memberID)
+		if err != nil || memberIsLead {
+			api.Logging.Unsuccessful("creatix.team.removemember: leads cannot remove other leads", err)
+			return c.String(http.StatusUnauthorized, "")
+		}
+	}
+
+	err := api.CompanyClient.RemoveUserFromTeam(c.Request().Context(), c.Param("company"), c.Param("team"), memberID)
+	if err == models.TeamMemberNotFoundError {
+		return c.JSON(http.StatusNotFound, utils.NewWebError(err.Error()))
+	}
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.team.removemember: not able to remove member", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, web.HttpResponse{Message: "removed"})
+}
+
+// GetUserTeams lists the teams the user is a member of
+func (api RestAPI) GetUserTeams(c echo.Context) error {
+	userID, err := utils.GetUserIDFromContext(c)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.team.user: could not get user", err)
+		return c.String(http.StatusUnauthorized, "")
+	}
+
+	teams, err := api.CompanyClient.GetUserTeams(c.Request().Context(), userID)
+	if err != nil {
+		api.Logging.Unsuccessful("creatix.team.user: not able to get teams", err)
+		return c.String(http.StatusInternalServerError, "")
+	}
+
+	return c.JSON(http.StatusOK, teams)
+}
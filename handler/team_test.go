@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTeams(t *testing.T) {
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	ctx := context.Background()
+
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "john@doe.no", Access: models.Write}))
+
+	// Only admins create teams and names are unique within the company
+	code, _ := callHandler(t, restAPI, restAPI.CreateTeam, "2", models.TeamRequest{Name: "Backend"}, "1")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, body := callHandler(t, restAPI, restAPI.CreateTeam, "1", models.TeamRequest{Name: "Backend"}, "1")
+	require.Equal(t, http.StatusOK, code)
+	var team models.Team
+	require.NoError(t, json.Unmarshal(body, &team))
+	assert.Equal(t, "1", team.CompanyID)
+	code, _ = callHandler(t, restAPI, restAPI.CreateTeam, "1", models.TeamRequest{Name: "Backend"}, "1")
+	assert.Equal(t, http.StatusConflict, code)
+
+	// Members have to belong to the company
+	code, _ = callHandler(t, restAPI, restAPI.AddTeamMember, "1", models.TeamMemberRequest{UserID: "3"}, "1", team.ID)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = callHandler(t, restAPI, restAPI.AddTeamMember, "1", models.TeamMemberRequest{UserID: "2", Lead: true}, "1", team.ID)
+	require.Equal(t, http.StatusOK, code)
+
+	// The lead manages the team but cannot make new leads
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "user@read.no", Access: models.Read}))
+	code, _ = callHandler(t, restAPI, restAPI.AddTeamMember, "2", models.TeamMemberRequest{UserID: "3", Lead: true}, "1", team.ID)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = callHandler(t, restAPI, restAPI.AddTeamMember, "2", models.TeamMemberRequest{UserID: "3"}, "1", team.ID)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.RenameTeam, "2", models.TeamRequest{Name: "Platform"}, "1", team.ID)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.DeleteTeam, "2", nil, "1", team.ID)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// Regular members cannot manage the team
+	code, _ = callHandler(t, restAPI, restAPI.RemoveTeamMember, "3", nil, "1", team.ID, "2")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, body = callHandler(t, restAPI, restAPI.GetTeamMembers, "3", nil, "1", team.ID)
+	require.Equal(t, http.StatusOK, code)
+	var members []models.TeamMember
+	require.NoError(t, json.Unmarshal(body, &members))
+	assert.Equal(t, []models.TeamMember{{UserID: "2", Username: "doeman", Lead: true}, {UserID: "3", Username: "reader"}}, members)
+
+	teams, err := restAPI.CompanyClient.GetUserTeams(ctx, "3")
+	require.NoError(t, err)
+	assert.Equal(t, []models.Team{{ID: team.ID, CompanyID: "1", Name: "Platform"}}, teams)
+
+	// Leaving the company leaves its teams
+	require.NoError(t, restAPI.CompanyClient.DeleteUser(ctx, "1", "3"))
+	teams, err = restAPI.CompanyClient.GetUserTeams(ctx, "3")
+	require.NoError(t, err)
+	assert.Empty(t, teams)
+
+	// Demoted leads lose their permissions
+	code, _ = callHandler(t, restAPI, restAPI.UpdateTeamMember, "1", models.TeamMemberRequest{Lead: false}, "1", team.ID, "2")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.RenameTeam, "2", models.TeamRequest{Name: "Backend"}, "1", team.ID)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = callHandler(t, restAPI, restAPI.DeleteTeam, "1", nil, "1", team.ID)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = callHandler(t, restAPI, restAPI.GetTeamMembers, "1", nil, "1", team.ID)
+	assert.Equal(t, http.StatusNotFound, code)
+}
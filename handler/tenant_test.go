@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/logging"
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/test"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/labstack/echo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantIsolation(t *testing.T) {
+	e := echo.New()
+
+	db, err := test.NewTestDB()
+	require.NoError(t, err)
+	err = test.TestMigrations(db)
+	require.NoError(t, err)
+	defer test.EmptyTestDB(t, db)
+
+	logger := logging.NewLogger()
+	restAPI := NewRestAPI(db, logger)
+	ctx := context.Background()
+
+	// John writes feedback in MyCorp, leaves and starts OtherCorp
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "john@doe.no", Access: models.Write}))
+	require.NoError(t, restAPI.FeedbackClient.CreateFeedback(ctx, "1", "1", newFeedbackRequest()))
+	require.NoError(t, restAPI.FeedbackClient.CreateFeedback(ctx, "2", "1", newFeedbackRequest()))
+	require.NoError(t, restAPI.CompanyClient.DeleteUser(ctx, "1", "2"))
+	otherCompanyID, err := restAPI.CompanyClient.CreateCompany(ctx, "OtherCorp", "2")
+	require.NoError(t, err)
+	otherID := strconv.FormatInt(*otherCompanyID, 10)
+
+	// asTenant runs queries the way a request of the user does, without any
+	// of the checks of the handlers
+	asTenant := func(userID string, query func(ctx context.Context) error) error {
+		c, _ := newContext(e, nil, "/")
+		c.Set(utils.UserIDContext.String(), userID)
+		return restAPI.Middleware.Tenant(func(c echo.Context) error {
+			return query(c.Request().Context())
+		})(c)
+	}
+
+	// Reads only return rows of the companies of the user
+	require.NoError(t, asTenant("2", func(ctx context.Context) error {
+		feedbacks, err := restAPI.FeedbackClient.GetCompanyFeedbacks(ctx, "1", "2", models.FeedbackFilter{})
+		assert.Empty(t, feedbacks)
+		return err
+	}))
+	require.NoError(t, asTenant("2", func(ctx context.Context) error {
+		feedbacks, err := restAPI.FeedbackClient.GetUserFeedback(ctx, "2", models.FeedbackFilter{})
+		assert.Empty(t, feedbacks)
+		return err
+	}))
+	require.NoError(t, asTenant("2", func(ctx context.Context) error {
+		isOwner, err := restAPI.FeedbackClient.IsUserOwnerOfFeedback(ctx, "2", "2")
+		assert.False(t, isOwner)
+		return err
+	}))
+	require.NoError(t, asTenant("2", func(ctx context.Context) error {
+		users, err := restAPI.CompanyClient.GetCompanyUsers(ctx, "1")
+		assert.Empty(t, users)
+		return err
+	}))
+
+	// Writes to rows of other companies fail
+	assert.Error(t, asTenant("2", func(ctx context.Context) error {
+		return restAPI.FeedbackClient.UpdateFeedback(ctx, "1", "1", models.FeedbackRequest{Title: "Taken over", Description: "By another company"})
+	}))
+	assert.Error(t, asTenant("2", func(ctx context.Context) error {
+		return restAPI.FeedbackClient.CommentFeedback(ctx, models.CommentRequest{Comment: "From another company"}, "2", "1")
+	}))
+	assert.Error(t, asTenant("2", func(ctx context.Context) error {
+		return restAPI.FeedbackClient.ClapFeedback(ctx, "2", "1")
+	}))
+	assert.Error(t, asTenant("2", func(ctx context.Context) error {
+		return restAPI.FeedbackClient.CreateFeedback(ctx, "2", "1", newFeedbackRequest())
+	}))
+	assert.Error(t, asTenant("2", func(ctx context.Context) error {
+		_, err := restAPI.CompanyClient.CreateTeam(ctx, models.Team{CompanyID: "1", Name: "Intruders"})
+		return err
+	}))
+	assert.Error(t, asTenant("2", func(ctx context.Context) error {
+		return restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "user@read.no", Access: models.Admin})
+	}))
+
+	// Users cannot add themselves to a company or change their own role, but
+	// still join the companies they create
+	assert.Error(t, asTenant("3", func(ctx context.Context) error {
+		return restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "user@read.no", Access: models.Admin})
+	}))
+	require.NoError(t, restAPI.CompanyClient.AddUserToCompanyByEmail(ctx, "1", models.AddUser{Email: "user@read.no", Access: models.Read}))
+	assert.Error(t, asTenant("3", func(ctx context.Context) error {
+		return restAPI.CompanyClient.UpdateUserPermission(ctx, "1", models.UserPermissionRequest{UserID: "3", Access: models.Admin})
+	}))
+	assert.Error(t, asTenant("3", func(ctx context.Context) error {
+		return restAPI.CompanyClient.DeleteUser(ctx, "1", "3")
+	}))
+	var readerCompanyID *int64
+	assert.NoError(t, asTenant("3", func(ctx context.Context) (err error) {
+		readerCompanyID, err = restAPI.CompanyClient.CreateCompany(ctx, "ReaderCorp", "3")
+		return err
+	}))
+	assert.NoError(t, asTenant("1", func(ctx context.Context) error {
+		return restAPI.CompanyClient.UpdateUserPermission(ctx, "1", models.UserPermissionRequest{UserID: "3", Access: models.Write})
+	}))
+
+	// John is authorized in OtherCorp and still owns his old feedback, but the
+	// feedback of MyCorp is out of reach for the handler
+	body, err := json.Marshal(models.FeedbackRequest{Title: "Taken over", Description: "By another company"})
+	require.NoError(t, err)
+	c, _ := newContext(e, body, "/")
+	c.Set(utils.UserIDContext.String(), "2")
+	c.SetParamNames("company", "fid")
+	c.SetParamValues(otherID, "2")
+	assert.Equal(t, utils.NoPermission, restAPI.Middleware.Tenant(restAPI.UpdateFeedback)(c))
+
+	// Moderating ReaderCorp does not reach the feedback of MyCorp, where the
+	// reader only writes
+	c, rec := newContext(e, nil, "/")
+	c.Set(utils.UserIDContext.String(), "3")
+	c.SetParamNames("company", "fid")
+	c.SetParamValues(strconv.FormatInt(*readerCompanyID, 10), "1")
+	require.NoError(t, restAPI.Middleware.Tenant(restAPI.DeleteFeedback)(c))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// Members of MyCorp see its feedback untouched
+	require.NoError(t, asTenant("1", func(ctx context.Context) error {
+		feedbacks, err := restAPI.FeedbackClient.GetCompanyFeedbackswData(ctx, "1", "1", models.FeedbackFilter{})
+		if assert.Len(t, feedbacks, 2) {
+			for _, feedback := range feedbacks {
+				assert.Equal(t, newFeedbackRequest().Title, feedback.Title)
+				assert.Empty(t, feedback.Comments)
+				assert.Empty(t, feedback.Claps)
+			}
+		}
+		return err
+	}))
+	require.NoError(t, asTenant("1", func(ctx context.Context) error {
+		teams, err := restAPI.CompanyClient.GetCompanyTeams(ctx, "1")
+		assert.Empty(t, teams)
+		return err
+	}))
+}
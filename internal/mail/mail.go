@@ -1,12 +1,13 @@
 package mail
 
 import (
+	"github.com/pkg/errors"
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
 )
 
 type MailClienter interface {
-	SendEmail(from, to, content string) error
+	SendEmail(fromAddress, fromName, toAddress, toName, subject, content string) (statusCode int, err error)
 }
 
 type MailClient struct {
@@ -23,6 +24,8 @@ func NewMailClient(sendgridKey string) *MailClient {
 	return &MailClient{SendgridClient: client}
 }
 
+// SendEmail sends the email through Sendgrid. Responses outside 2xx are
+// returned as errors along with their status code
 func (c *MailClient) SendEmail(fromAddress, fromName, toAddress, toName, subject, content string) (statusCode int, err error) {
 	from := mail.NewEmail(fromName, fromAddress)
 	to := mail.NewEmail(toName, toAddress)
@@ -31,11 +34,11 @@ func (c *MailClient) SendEmail(fromAddress, fromName, toAddress, toName, subject
 
 	resp, err := c.SendgridClient.Send(message)
 	if err != nil {
-		return resp.StatusCode, err
+		return 0, err
 	}
 
-	if resp.StatusCode > 300 {
-		return resp.StatusCode, err
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, errors.Errorf("sendgrid responded with status %d: %s", resp.StatusCode, resp.Body)
 	}
-	return resp.StatusCode, err
+	return resp.StatusCode, nil
 }
@@ -0,0 +1,361 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/pkg/errors"
+)
+
+// clockSkew is the leeway allowed when checking the time claims of an id token
+const clockSkew = time.Minute
+
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Discovery is the subset of the provider metadata Creatix needs
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+// Provider runs the authorization code flow with PKCE against a single
+// OpenID Connect provider. The provider metadata and signing keys are
+// fetched lazily and cached
+type Provider struct {
+	cfg        Config
+	httpClient *http.Client
+
+	mutex     sync.RWMutex
+	discovery *Discovery
+	keys      map[string]*rsa.PublicKey
+}
+
+func NewProvider(cfg Config, httpClient *http.Client) *Provider {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	return &Provider{cfg: cfg, httpClient: httpClient, keys: map[string]*rsa.PublicKey{}}
+}
+
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+func (p *Provider) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resp, err := p.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("%s returned status %d", endpoint, resp.StatusCode)
+	}
+	return errors.WithStack(json.NewDecoder(resp.Body).Decode(v))
+}
+
+// Discover returns the provider metadata, fetching it on first use
+func (p *Provider) Discover(ctx context.Context) (Discovery, error) {
+	p.mutex.RLock()
+	discovery := p.discovery
+	p.mutex.RUnlock()
+	if discovery != nil {
+		return *discovery, nil
+	}
+
+	discovery = new(Discovery)
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, discovery); err != nil {
+		return Discovery{}, errors.WithMessage(err, "could not discover provider")
+	}
+
+	if discovery.Issuer != p.cfg.Issuer {
+		return Discovery{}, errors.Errorf("issuer %s does not match configured issuer %s", discovery.Issuer, p.cfg.Issuer)
+	}
+
+	p.mutex.Lock()
+	p.discovery = discovery
+	p.mutex.Unlock()
+	return *discovery, nil
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+func (p *Provider) refreshKeys(ctx context.Context) error {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	var keySet jsonWebKeySet
+	if err = p.getJSON(ctx, discovery.JwksURI, &keySet); err != nil {
+		return errors.WithMessage(err, "could not fetch jwks")
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, key := range keySet.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			continue
+		}
+		keys[key.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	p.mutex.Lock()
+	p.keys = keys
+	p.mutex.Unlock()
+	return nil
+}
+
+// publicKey returns the signing key with the given kid. The key set is
+// fetched again when the kid is unknown since the provider may have rotated
+func (p *Provider) publicKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mutex.RLock()
+	key, ok := p.keys[kid]
+	p.mutex.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	if err := p.refreshKeys(ctx); err != nil {
+		return nil, err
+	}
+
+	p.mutex.RLock()
+	key, ok = p.keys[kid]
+	p.mutex.RUnlock()
+	if !ok {
+		return nil, errors.Errorf("no signing key with kid %s", kid)
+	}
+	return key, nil
+}
+
+// AuthRequest holds the per login secrets that must survive the redirect
+// to the provider and back
+type AuthRequest struct {
+	State        string
+	Nonce        string
+	CodeVerifier string
+}
+
+func NewAuthRequest() (authRequest AuthRequest, err error) {
+	if authRequest.State, err = utils.NewOpaqueToken(); err != nil {
+		return
+	}
+	if authRequest.Nonce, err = utils.NewOpaqueToken(); err != nil {
+		return
+	}
+	authRequest.CodeVerifier, err = utils.NewOpaqueToken()
+	return
+}
+
+// CodeChallenge returns the S256 PKCE challenge for the code verifier
+func CodeChallenge(codeVerifier string) string {
+	hash := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// AuthCodeURL returns the url the user is redirected to in order to sign in
+func (p *Provider) AuthCodeURL(ctx context.Context, authRequest AuthRequest) (string, error) {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", p.cfg.ClientID)
+	values.Set("redirect_uri", p.cfg.RedirectURL)
+	values.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	values.Set("state", authRequest.State)
+	values.Set("nonce", authRequest.Nonce)
+	values.Set("code_challenge", CodeChallenge(authRequest.CodeVerifier))
+	values.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + values.Encode(), nil
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+	Error       string `json:"error"`
+}
+
+// Exchange trades the authorization code for tokens and returns the
+// verified id token claims
+func (p *Provider) Exchange(ctx context.Context, code string, authRequest AuthRequest) (claims IDTokenClaims, err error) {
+	discovery, err := p.Discover(ctx)
+	if err != nil {
+		return
+	}
+
+	values := url.Values{}
+	values.Set("grant_type", "authorization_code")
+	values.Set("code", code)
+	values.Set("redirect_uri", p.cfg.RedirectURL)
+	values.Set("client_id", p.cfg.ClientID)
+	values.Set("code_verifier", authRequest.CodeVerifier)
+
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return claims, errors.WithStack(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return claims, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return claims, errors.Wrap(err, "could not decode token response")
+	}
+
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return claims, errors.Errorf("token endpoint returned status %d: %s", resp.StatusCode, token.Error)
+	}
+
+	if token.IDToken == "" {
+		return claims, errors.New("token response has no id_token")
+	}
+
+	return p.VerifyIDToken(ctx, token.IDToken, authRequest.Nonce)
+}
+
+// audience accepts both the string and the array form of the aud claim
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return err
+	}
+	*a = multiple
+	return nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+type IDTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	ExpiresAt         int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	GivenName         string   `json:"given_name"`
+	FamilyName        string   `json:"family_name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// Valid checks the time based claims, it is called by the jwt parser
+func (c IDTokenClaims) Valid() error {
+	now := time.Now()
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(clockSkew)) {
+		return errors.New("id token is expired")
+	}
+	if c.IssuedAt != 0 && now.Add(clockSkew).Before(time.Unix(c.IssuedAt, 0)) {
+		return errors.New("id token is issued in the future")
+	}
+	return nil
+}
+
+// VerifyIDToken checks the signature of the id token against the provider
+// keys together with the issuer, audience and nonce claims
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (IDTokenClaims, error) {
+	claims := IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.publicKey(ctx, kid)
+	})
+	if err != nil {
+		return claims, errors.Wrap(err, "invalid id token")
+	}
+
+	if claims.Issuer != p.cfg.Issuer {
+		return claims, errors.Errorf("unexpected issuer %s", claims.Issuer)
+	}
+	if !claims.Audience.contains(p.cfg.ClientID) {
+		return claims, errors.New("id token is not issued for this client")
+	}
+	if claims.Nonce != nonce {
+		return claims, errors.New("id token nonce does not match")
+	}
+	if claims.Subject == "" {
+		return claims, errors.New("id token has no subject")
+	}
+	return claims, nil
+}
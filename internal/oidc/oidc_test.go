@@ -0,0 +1,97 @@
+package oidc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/kristohberg/CreatixBackend/internal/oidc"
+	"github.com/kristohberg/CreatixBackend/internal/oidc/oidctest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	server, err := oidctest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx := context.Background()
+	provider := oidc.NewProvider(server.Config("mock", "http://localhost/callback"), nil)
+
+	authRequest, err := oidc.NewAuthRequest()
+	require.NoError(t, err)
+	authCodeURL, err := provider.AuthCodeURL(ctx, authRequest)
+	require.NoError(t, err)
+
+	user := oidctest.User{Subject: "42", Email: "john@doe.com", EmailVerified: true, GivenName: "John", FamilyName: "Doe"}
+	code, state, err := server.Authorize(authCodeURL, user)
+	require.NoError(t, err)
+	assert.Equal(t, authRequest.State, state)
+
+	// The code verifier must match the challenge
+	wrongVerifier := authRequest
+	wrongVerifier.CodeVerifier = "wrong"
+	_, err = provider.Exchange(ctx, code, wrongVerifier)
+	assert.Error(t, err)
+
+	code, _, err = server.Authorize(authCodeURL, user)
+	require.NoError(t, err)
+	claims, err := provider.Exchange(ctx, code, authRequest)
+	require.NoError(t, err)
+	assert.Equal(t, "42", claims.Subject)
+	assert.Equal(t, "john@doe.com", claims.Email)
+	assert.True(t, claims.EmailVerified)
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	server, err := oidctest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+
+	ctx := context.Background()
+	provider := oidc.NewProvider(server.Config("mock", "http://localhost/callback"), nil)
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   server.URL,
+			"sub":   "42",
+			"aud":   oidctest.ClientID,
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": "nonce",
+		}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(jwt.MapClaims)
+		nonce   string
+		wantErr bool
+	}{
+		{name: "valid", modify: func(jwt.MapClaims) {}, nonce: "nonce"},
+		{name: "wrong nonce", modify: func(jwt.MapClaims) {}, nonce: "other", wantErr: true},
+		{name: "wrong audience", modify: func(c jwt.MapClaims) { c["aud"] = "someone-else" }, nonce: "nonce", wantErr: true},
+		{name: "wrong issuer", modify: func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }, nonce: "nonce", wantErr: true},
+		{name: "expired", modify: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, nonce: "nonce", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims()
+			tt.modify(claims)
+			idToken, err := server.SignIDToken(claims)
+			require.NoError(t, err)
+
+			_, err = provider.VerifyIDToken(ctx, idToken, tt.nonce)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyIDToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Tokens signed with the shared secret are rejected
+	hsToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims()).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = provider.VerifyIDToken(ctx, hsToken, "nonce")
+	assert.Error(t, err)
+}
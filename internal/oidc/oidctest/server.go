@@ -0,0 +1,154 @@
+// Package oidctest runs a minimal OpenID Connect provider for tests
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/kristohberg/CreatixBackend/internal/oidc"
+)
+
+const (
+	ClientID = "creatix-test"
+	kid      = "test-key"
+)
+
+// User is the identity the mock provider signs in as
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+type pendingCode struct {
+	challenge string
+	nonce     string
+	user      User
+}
+
+type Server struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mutex sync.Mutex
+	codes map[string]pendingCode
+}
+
+// NewServer starts the mock provider, it must be closed by the caller
+func NewServer() (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{key: key, codes: map[string]pendingCode{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/token", s.token)
+	s.Server = httptest.NewServer(mux)
+	return s, nil
+}
+
+// Config returns a provider config pointing to the mock server
+func (s *Server) Config(name, redirectURL string) oidc.Config {
+	return oidc.Config{Name: name, Issuer: s.URL, ClientID: ClientID, RedirectURL: redirectURL}
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(oidc.Discovery{
+		Issuer:                s.URL,
+		AuthorizationEndpoint: s.URL + "/authorize",
+		TokenEndpoint:         s.URL + "/token",
+		JwksURI:               s.URL + "/jwks",
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": kid,
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}},
+	})
+}
+
+// Authorize plays the part of the user approving the login on the provider.
+// It takes the url returned by AuthCodeURL and returns the code the provider
+// would redirect back with
+func (s *Server) Authorize(authCodeURL string, user User) (code string, state string, err error) {
+	u, err := url.Parse(authCodeURL)
+	if err != nil {
+		return
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != ClientID {
+		return "", "", fmt.Errorf("invalid authorization request")
+	}
+
+	code = fmt.Sprintf("code-%d", time.Now().UnixNano())
+	s.mutex.Lock()
+	s.codes[code] = pendingCode{challenge: query.Get("code_challenge"), nonce: query.Get("nonce"), user: user}
+	s.mutex.Unlock()
+	return code, query.Get("state"), nil
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	pending, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mutex.Unlock()
+
+	if !ok || oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != pending.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, err := s.SignIDToken(jwt.MapClaims{
+		"iss":            s.URL,
+		"sub":            pending.user.Subject,
+		"aud":            []string{ClientID},
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          pending.nonce,
+		"email":          pending.user.Email,
+		"email_verified": pending.user.EmailVerified,
+		"given_name":     pending.user.GivenName,
+		"family_name":    pending.user.FamilyName,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "token_type": "Bearer", "id_token": idToken})
+}
+
+// SignIDToken signs arbitrary claims with the provider key
+func (s *Server) SignIDToken(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	return token.SignedString(s.key)
+}
@@ -0,0 +1,181 @@
+// Package password hashes and verifies user passwords. New hashes are argon2id
+// PHC strings, older bcrypt hashes are still verified and flagged for rehash
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var UnknownHashFormatError = errors.New("unknown password hash format")
+
+// Hasher hashes new passwords and verifies stored hashes
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the stored hash
+	Verify(hash, password string) (bool, error)
+	// NeedsRehash reports whether the stored hash should be replaced by a
+	// new hash, because it uses another algorithm or weaker parameters
+	NeedsRehash(hash string) bool
+}
+
+// Argon2idParams are the argon2id cost parameters, memory is in KiB
+type Argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams follow the OWASP recommendation for argon2id
+var DefaultArgon2idParams = Argon2idParams{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 2,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+type Argon2id struct {
+	Params Argon2idParams
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// Hash returns a PHC string, $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.Params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Params.Iterations, a.Params.Memory, a.Params.Parallelism, a.Params.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix,
+		argon2.Version,
+		a.Params.Memory,
+		a.Params.Iterations,
+		a.Params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func decodeArgon2id(hash string) (params Argon2idParams, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, UnknownHashFormatError
+	}
+
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, errors.Errorf("unsupported argon2 version %s", parts[2])
+	}
+
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, errors.WithMessage(err, "invalid argon2id parameters")
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return params, nil, nil, errors.WithMessage(err, "invalid argon2id salt")
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return params, nil, nil, errors.WithMessage(err, "invalid argon2id hash")
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+func (a Argon2id) Verify(hash, password string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+func (a Argon2id) NeedsRehash(hash string) bool {
+	params, _, _, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return params.Memory < a.Params.Memory ||
+		params.Iterations < a.Params.Iterations ||
+		params.Parallelism < a.Params.Parallelism ||
+		params.SaltLength < a.Params.SaltLength ||
+		params.KeyLength < a.Params.KeyLength
+}
+
+type Bcrypt struct {
+	Cost int
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", errors.WithMessage(err, "could not generate hashed password")
+	}
+	return string(hash), nil
+}
+
+func (b Bcrypt) Verify(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b Bcrypt) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost < b.Cost
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// multiHasher hashes with argon2id and verifies both argon2id and bcrypt
+// hashes. Every bcrypt hash needs a rehash
+type multiHasher struct {
+	argon2id Argon2id
+	bcrypt   Bcrypt
+}
+
+// NewHasher returns the hasher used for user passwords
+func NewHasher(params Argon2idParams) Hasher {
+	return multiHasher{argon2id: Argon2id{Params: params}, bcrypt: Bcrypt{Cost: bcrypt.DefaultCost}}
+}
+
+func (m multiHasher) Hash(password string) (string, error) {
+	return m.argon2id.Hash(password)
+}
+
+func (m multiHasher) Verify(hash, password string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, argon2idPrefix):
+		return m.argon2id.Verify(hash, password)
+	case isBcrypt(hash):
+		return m.bcrypt.Verify(hash, password)
+	}
+	return false, UnknownHashFormatError
+}
+
+func (m multiHasher) NeedsRehash(hash string) bool {
+	return m.argon2id.NeedsRehash(hash)
+}
@@ -0,0 +1,56 @@
+package password_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kristohberg/CreatixBackend/internal/password"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testParams = password.Argon2idParams{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHasher(t *testing.T) {
+	hasher := password.NewHasher(testParams)
+
+	hash, err := hasher.Hash("MyPassword@123")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$"))
+	assert.False(t, hasher.NeedsRehash(hash))
+
+	ok, err := hasher.Verify(hash, "MyPassword@123")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = hasher.Verify(hash, "Wrong@123")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Stronger parameters make existing hashes stale
+	stronger := testParams
+	stronger.Iterations = 2
+	assert.True(t, password.NewHasher(stronger).NeedsRehash(hash))
+
+	// Empty or unknown hashes never match
+	ok, err = hasher.Verify("", "")
+	assert.Error(t, err)
+	assert.False(t, ok)
+}
+
+func TestHasherVerifiesBcrypt(t *testing.T) {
+	hasher := password.NewHasher(testParams)
+
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("MyPassword@123"), bcrypt.MinCost)
+	require.NoError(t, err)
+
+	ok, err := hasher.Verify(string(bcryptHash), "MyPassword@123")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, hasher.NeedsRehash(string(bcryptHash)))
+
+	ok, err = hasher.Verify(string(bcryptHash), "Wrong@123")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/labstack/echo"
+)
+
+// ClientIP finds the ip address of the client, trusting X-Forwarded-For only
+// from the proxies, and passes it along for utils.WithClientInfo
+func ClientIP(proxies utils.TrustedProxies) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(utils.ClientIPContext.String(), proxies.ClientIP(c.Request()))
+			return next(c)
+		}
+	}
+}
@@ -1,12 +1,14 @@
 package middleware
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
-	"sync"
-	"time"
+	"strings"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/kristohberg/CreatixBackend/config"
+	"github.com/kristohberg/CreatixBackend/models"
 	"github.com/kristohberg/CreatixBackend/utils"
 	"github.com/labstack/echo"
 )
@@ -16,21 +18,60 @@ type Exception struct {
 }
 
 type Middleware struct {
-	Claim *MiddlewareClaim
-	Uid   string
-	mutex sync.RWMutex
-	Cfg   config.Config
+	Claim         *MiddlewareClaim
+	Uid           string
+	Cfg           config.Config
+	SessionClient *models.SessionClient
+	// TenantDB is the pool the tenant transactions of the requests are begun
+	// in, the database of the session client when not set
+	TenantDB *sql.DB
+
+	// tokenScopes maps the routes personal access tokens may call to the
+	// scope they need. Every other route needs a session
+	tokenScopes map[string]models.TokenScope
 }
 
 type MiddlewareClaim struct {
 	jwt.StandardClaims
 }
 
-func (m Middleware) JwtVerify(next echo.HandlerFunc) echo.HandlerFunc {
+func routeKey(method, path string) string {
+	return method + " " + path
+}
+
+// RequireScope opens a route to personal access tokens that have the scope
+func (m *Middleware) RequireScope(scope models.TokenScope, route *echo.Route) {
+	if m.tokenScopes == nil {
+		m.tokenScopes = map[string]models.TokenScope{}
+	}
+	m.tokenScopes[routeKey(route.Method, route.Path)] = scope
+}
+
+// bearerToken returns the token in the Authorization header, or the token
+// cookie when there is no header
+func bearerToken(c echo.Context) (string, error) {
+	authorization := c.Request().Header.Get(echo.HeaderAuthorization)
+	if authorization != "" {
+		if !strings.HasPrefix(authorization, "Bearer ") {
+			return "", errors.New("unsupported authorization scheme")
+		}
+		return strings.TrimPrefix(authorization, "Bearer "), nil
+	}
+
+	cookie, err := c.Cookie("token")
+	if err != nil {
+		return "", err
+	}
+	return cookie.Value, nil
+}
+
+// JwtVerify authenticates the request with either a session access token,
+// from the token cookie or the Authorization header, or a personal access
+// token in the Authorization header. Sessions must not have been revoked and
+// personal access tokens must have the scope the route requires
+func (m *Middleware) JwtVerify(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		m.mutex.Lock()
-		defer m.mutex.Unlock()
-		cookie, err := c.Cookie("token")
+		tokenValue, err := bearerToken(c)
 		if err != nil {
 			if err == http.ErrNoCookie {
 				return c.JSON(http.StatusUnauthorized, "unauthorized: missing token")
@@ -38,20 +79,48 @@ func (m Middleware) JwtVerify(next echo.HandlerFunc) echo.HandlerFunc {
 			return c.JSON(http.StatusBadRequest, Exception{Message: "bad request"})
 		}
 
-		tokenValue := cookie.Value
-		err = utils.IsTokenValid(tokenValue, []byte(m.Cfg.TokenSecret))
+		if strings.HasPrefix(tokenValue, models.AccessTokenPrefix) {
+			return m.verifyAccessToken(c, next, tokenValue)
+		}
+
+		err = utils.IsTokenValid(tokenValue, m.SessionClient.Keyring)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, Exception{Message: err.Error()})
+		}
+		claims, err := utils.GetClaims(tokenValue, m.SessionClient.Keyring)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, Exception{Message: err.Error()})
+			return c.JSON(http.StatusUnauthorized, Exception{Message: err.Error()})
 		}
-		claims, err := utils.GetClaims(tokenValue, []byte(m.Cfg.TokenSecret))
+
+		signedInAt, err := m.SessionClient.IsSessionActive(c.Request().Context(), claims.UserID, claims.SessionID)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, Exception{Message: err.Error()})
+			return c.JSON(http.StatusUnauthorized, Exception{Message: err.Error()})
 		}
-		expiresAt := time.Now().Add(time.Minute * time.Duration(m.Cfg.TokenExpirationTimeMinutes))
-		cookie.Expires = expiresAt
-		c.SetCookie(cookie)
+
+		c.SetRequest(c.Request().WithContext(utils.WithSignedInAt(c.Request().Context(), signedInAt)))
 		c.Set(utils.UserIDContext.String(), claims.UserID)
+		c.Set(utils.SessionIDContext.String(), claims.SessionID)
 
 		return next(c)
 	}
 }
+
+func (m *Middleware) verifyAccessToken(c echo.Context, next echo.HandlerFunc, token string) error {
+	scope, ok := m.tokenScopes[routeKey(c.Request().Method, c.Path())]
+	if !ok {
+		return c.JSON(http.StatusForbidden, Exception{Message: "route is not available to personal access tokens"})
+	}
+
+	userID, scopes, signedInAt, err := m.SessionClient.AuthenticateAccessToken(c.Request().Context(), token)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, Exception{Message: models.InvalidAccessTokenError.Error()})
+	}
+
+	if !models.HasScope(scopes, scope) {
+		return c.JSON(http.StatusForbidden, Exception{Message: "token is missing scope " + string(scope)})
+	}
+
+	c.SetRequest(c.Request().WithContext(utils.WithSignedInAt(c.Request().Context(), signedInAt)))
+	c.Set(utils.UserIDContext.String(), userID)
+	return next(c)
+}
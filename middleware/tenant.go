@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/kristohberg/CreatixBackend/models"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/labstack/echo"
+)
+
+// bufferedResponse holds back the response until the transaction of the
+// request is committed, so a failed commit is not reported as a success
+type bufferedResponse struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *bufferedResponse) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *bufferedResponse) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *bufferedResponse) flush() {
+	if r.status == 0 {
+		return
+	}
+	r.ResponseWriter.WriteHeader(r.status)
+	r.ResponseWriter.Write(r.body.Bytes())
+}
+
+// Tenant runs the queries of the request as the authenticated user, in a
+// transaction where the row level security policies limit the rows to the
+// companies of the user. It must run after JwtVerify. The transaction is
+// committed when the request succeeds and rolled back otherwise. Websockets
+// outlive any transaction and are left to check access themselves
+func (m *Middleware) Tenant(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, _ := c.Get(utils.UserIDContext.String()).(string)
+		if userID == "" || c.IsWebSocket() {
+			return next(c)
+		}
+
+		db := m.TenantDB
+		if db == nil {
+			db = m.SessionClient.DB
+		}
+		tenant := models.NewTenant(db, userID)
+		c.SetRequest(c.Request().WithContext(tenant.Context(c.Request().Context())))
+
+		res := c.Response()
+		writer := res.Writer
+		buffered := &bufferedResponse{ResponseWriter: writer}
+		res.Writer = buffered
+		err := next(c)
+		res.Writer = writer
+
+		if err != nil || res.Status >= http.StatusBadRequest {
+			tenant.Rollback()
+			buffered.flush()
+			return err
+		}
+
+		if err = tenant.Commit(); err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return json.NewEncoder(writer).Encode(Exception{Message: "could not save changes"})
+		}
+		buffered.flush()
+		return nil
+	}
+}
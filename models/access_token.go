@@ -0,0 +1,238 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/pkg/errors"
+)
+
+// AccessTokenPrefix marks personal access tokens so they can be told apart
+// from session jwts in the Authorization header
+const AccessTokenPrefix = "cpat_"
+
+const (
+	defaultAccessTokenExpirationDays = 30
+	maxAccessTokenExpirationDays     = 365
+)
+
+var (
+	InvalidAccessTokenError  = errors.New("invalid, expired or revoked access token")
+	AccessTokenNotFoundError = errors.New("access token not found")
+)
+
+// TokenScope limits what a personal access token can be used for
+type TokenScope string
+
+const (
+	ScopeFeedbackRead  TokenScope = "feedback:read"
+	ScopeFeedbackWrite TokenScope = "feedback:write"
+	ScopeCompanyRead   TokenScope = "company:read"
+	ScopeCompanyWrite  TokenScope = "company:write"
+)
+
+var tokenScopes = map[TokenScope]bool{
+	ScopeFeedbackRead:  true,
+	ScopeFeedbackWrite: true,
+	ScopeCompanyRead:   true,
+	ScopeCompanyWrite:  true,
+}
+
+// impliedScopes lets a write scope also read the same resource
+var impliedScopes = map[TokenScope]TokenScope{
+	ScopeFeedbackWrite: ScopeFeedbackRead,
+	ScopeCompanyWrite:  ScopeCompanyRead,
+}
+
+// HasScope reports whether scopes grants scope
+func HasScope(scopes []TokenScope, scope TokenScope) bool {
+	for _, s := range scopes {
+		if s == scope || impliedScopes[s] == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type AccessTokenRequest struct {
+	Name          string       `json:"name"`
+	Scopes        []TokenScope `json:"scopes"`
+	ExpiresInDays int          `json:"expiresInDays"`
+}
+
+func (r AccessTokenRequest) Valid() error {
+	errs := make(FieldErrors)
+
+	if r.Name == "" || len(r.Name) > 64 {
+		errs["name"] = "name must be between 1 and 64 characters"
+	}
+
+	if len(r.Scopes) == 0 {
+		errs["scopes"] = "at least one scope is required"
+	}
+	for _, scope := range r.Scopes {
+		if !tokenScopes[scope] {
+			errs["scopes"] = "unknown scope " + string(scope)
+		}
+	}
+
+	if r.ExpiresInDays < 0 || r.ExpiresInDays > maxAccessTokenExpirationDays {
+		errs["expiresInDays"] = "expiry must be at most 365 days"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+type AccessToken struct {
+	ID         int          `json:"id"`
+	Name       string       `json:"name"`
+	Scopes     []TokenScope `json:"scopes"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	ExpiresAt  time.Time    `json:"expiresAt"`
+	LastUsedAt *time.Time   `json:"lastUsedAt"`
+}
+
+// NewAccessTokenResponse is the only time the token itself is returned
+type NewAccessTokenResponse struct {
+	AccessToken
+	Token string `json:"token"`
+}
+
+func joinScopes(scopes []TokenScope) string {
+	s := make([]string, len(scopes))
+	for i, scope := range scopes {
+		s[i] = string(scope)
+	}
+	return strings.Join(s, " ")
+}
+
+func splitScopes(s string) []TokenScope {
+	fields := strings.Fields(s)
+	scopes := make([]TokenScope, len(fields))
+	for i, field := range fields {
+		scopes[i] = TokenScope(field)
+	}
+	return scopes
+}
+
+const createAccessTokenQuery = `
+	INSERT INTO PERSONAL_ACCESS_TOKENS(UserId,Name,TokenHash,Scopes,ExpiresAt)
+	VALUES ($1,$2,$3,$4,$5)
+	RETURNING Id,CreatedAt
+`
+
+// CreateAccessToken issues a new personal access token for the user. Only the
+// hash is stored so the token cannot be shown again
+func (c *SessionClient) CreateAccessToken(ctx context.Context, userID string, tokenRequest AccessTokenRequest) (resp NewAccessTokenResponse, err error) {
+	if err = tokenRequest.Valid(); err != nil {
+		return
+	}
+
+	token, err := utils.NewOpaqueToken()
+	if err != nil {
+		return
+	}
+	token = AccessTokenPrefix + token
+
+	expiresInDays := tokenRequest.ExpiresInDays
+	if expiresInDays == 0 {
+		expiresInDays = defaultAccessTokenExpirationDays
+	}
+
+	resp.Token = token
+	resp.Name = tokenRequest.Name
+	resp.Scopes = tokenRequest.Scopes
+	resp.ExpiresAt = time.Now().AddDate(0, 0, expiresInDays)
+	err = c.DB.QueryRowContext(ctx, createAccessTokenQuery, userID, tokenRequest.Name, utils.HashToken(token), joinScopes(tokenRequest.Scopes), resp.ExpiresAt).Scan(&resp.ID, &resp.CreatedAt)
+	if err != nil {
+		return resp, errors.WithMessage(err, "could not create access token")
+	}
+	return
+}
+
+const getAccessTokensQuery = `
+	SELECT
+	Id
+	,Name
+	,Scopes
+	,CreatedAt
+	,ExpiresAt
+	,LastUsedAt
+	FROM PERSONAL_ACCESS_TOKENS
+	WHERE UserId=$1 AND RevokedAt IS NULL AND ExpiresAt>NOW()
+	ORDER BY CreatedAt DESC
+`
+
+// GetAccessTokens lists the active personal access tokens of the user
+func (c *SessionClient) GetAccessTokens(ctx context.Context, userID string) (accessTokens []AccessToken, err error) {
+	rows, err := c.DB.QueryContext(ctx, getAccessTokensQuery, userID)
+	if err != nil {
+		return accessTokens, errors.WithMessage(err, "could not get access tokens")
+	}
+	defer rows.Close()
+
+	accessTokens = []AccessToken{}
+	for rows.Next() {
+		var (
+			accessToken AccessToken
+			scopes      string
+		)
+		err = rows.Scan(&accessToken.ID, &accessToken.Name, &scopes, &accessToken.CreatedAt, &accessToken.ExpiresAt, &accessToken.LastUsedAt)
+		if err != nil {
+			return
+		}
+		accessToken.Scopes = splitScopes(scopes)
+		accessTokens = append(accessTokens, accessToken)
+	}
+	return accessTokens, rows.Err()
+}
+
+const revokeAccessTokenQuery = `
+	UPDATE PERSONAL_ACCESS_TOKENS
+	SET RevokedAt=NOW()
+	WHERE Id=$1 AND UserId=$2 AND RevokedAt IS NULL
+`
+
+// RevokeAccessToken revokes one of the user's personal access tokens
+func (c *SessionClient) RevokeAccessToken(ctx context.Context, userID string, tokenID int) error {
+	res, err := c.DB.ExecContext(ctx, revokeAccessTokenQuery, tokenID, userID)
+	if err != nil {
+		return errors.WithMessage(err, "could not revoke access token")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return AccessTokenNotFoundError
+	}
+	return nil
+}
+
+const useAccessTokenQuery = `
+	UPDATE PERSONAL_ACCESS_TOKENS
+	SET LastUsedAt=NOW()
+	WHERE TokenHash=$1 AND RevokedAt IS NULL AND ExpiresAt>NOW()
+	RETURNING UserId,Scopes,CreatedAt
+`
+
+// AuthenticateAccessToken returns the user, scopes and creation time of a
+// valid personal access token and records that it was used
+func (c *SessionClient) AuthenticateAccessToken(ctx context.Context, token string) (userID string, scopes []TokenScope, createdAt time.Time, err error) {
+	var scopeString string
+	err = c.DB.QueryRowContext(ctx, useAccessTokenQuery, utils.HashToken(token)).Scan(&userID, &scopeString, &createdAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return userID, scopes, createdAt, InvalidAccessTokenError
+		}
+		return userID, scopes, createdAt, errors.WithMessage(err, "could not authenticate access token")
+	}
+	return userID, splitScopes(scopeString), createdAt, nil
+}
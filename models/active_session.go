@@ -0,0 +1,164 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	SessionNotFoundError    = errors.New("session not found")
+	NotCompanyMemberError   = errors.New("user is not a member of the company")
+	LoggedOutOfCompanyError = errors.New("logged out of the company, log in again")
+)
+
+// ActiveSession is a device the user is logged in on
+type ActiveSession struct {
+	ID         string     `json:"id"`
+	Device     string     `json:"device"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastSeenAt *time.Time `json:"lastSeenAt"`
+	Current    bool       `json:"current"`
+}
+
+func truncate(s string, length int) string {
+	if len(s) > length {
+		return s[:length]
+	}
+	return s
+}
+
+// A session is active while it is not revoked and has a refresh token that
+// can still be used
+const getActiveSessionsQuery = `
+	SELECT
+	s.Id
+	,s.UserAgent
+	,s.IP
+	,s.CreatedAt
+	,s.LastSeenAt
+	FROM SESSIONS as s
+	WHERE s.UserId=$1 AND s.RevokedAt IS NULL
+	AND EXISTS (
+		SELECT 1
+		FROM REFRESH_TOKENS as rt
+		WHERE rt.SessionId=s.Id AND rt.UsedAt IS NULL AND rt.ExpiresAt>NOW()
+	)
+	ORDER BY s.LastSeenAt DESC
+`
+
+// GetActiveSessions lists the sessions the user is logged in with and marks
+// the one the request is made from
+func (c *SessionClient) GetActiveSessions(ctx context.Context, userID, currentSessionID string) (sessions []ActiveSession, err error) {
+	rows, err := c.DB.QueryContext(ctx, getActiveSessionsQuery, userID)
+	if err != nil {
+		return sessions, errors.WithMessage(err, "could not get sessions")
+	}
+	defer rows.Close()
+
+	sessions = []ActiveSession{}
+	for rows.Next() {
+		var session ActiveSession
+		err = rows.Scan(&session.ID, &session.Device, &session.IP, &session.CreatedAt, &session.LastSeenAt)
+		if err != nil {
+			return
+		}
+		session.Current = session.ID == currentSessionID
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+const revokeUserSessionQuery = `
+	UPDATE SESSIONS
+	SET RevokedAt=NOW()
+	WHERE Id=$1 AND UserId=$2 AND RevokedAt IS NULL
+`
+
+// RevokeUserSession ends one of the user's sessions
+func (c *SessionClient) RevokeUserSession(ctx context.Context, userID, sessionID string) error {
+	res, err := c.DB.ExecContext(ctx, revokeUserSessionQuery, sessionID, userID)
+	if err != nil {
+		return errors.WithMessage(err, "could not revoke session")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return SessionNotFoundError
+	}
+	return nil
+}
+
+const revokeOtherSessionsQuery = `
+	UPDATE SESSIONS
+	SET RevokedAt=NOW()
+	WHERE UserId=$1 AND Id<>$2 AND RevokedAt IS NULL
+`
+
+// RevokeOtherSessions ends every session of the user except the current one
+func (c *SessionClient) RevokeOtherSessions(ctx context.Context, userID, currentSessionID string) error {
+	_, err := c.DB.ExecContext(ctx, revokeOtherSessionsQuery, userID, currentSessionID)
+	if err != nil {
+		return errors.WithMessage(err, "could not revoke sessions")
+	}
+	return nil
+}
+
+const isCompanyMemberQuery = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM USER_COMPANY
+		WHERE CompanyId=$1 AND UserId=$2
+	)
+`
+
+const forceLogoutMemberQuery = `
+	UPDATE USER_COMPANY
+	SET LoggedOutAt=NOW()
+	WHERE CompanyId=$1 AND UserId=$2
+	RETURNING LoggedOutAt
+`
+
+// ForceLogoutMember logs a member out of the company. Sessions and personal
+// access tokens created before are no longer authorized in the company, so
+// the member has to log in again, while their other companies are left alone
+func (c *SessionClient) ForceLogoutMember(ctx context.Context, companyID, memberID string) (err error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	var loggedOutAt time.Time
+	err = tx.QueryRowContext(ctx, forceLogoutMemberQuery, companyID, memberID).Scan(&loggedOutAt)
+	if err == sql.ErrNoRows {
+		return NotCompanyMemberError
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not log out member")
+	}
+
+	err = recordAuditEvent(ctx, tx, AuditEvent{
+		Action:     AuditMemberLoggedOut,
+		TargetType: auditTargetUser,
+		TargetID:   memberID,
+		CompanyID:  companyID,
+		After:      auditState(map[string]string{"loggedOutAt": loggedOutAt.Format(time.RFC3339)}),
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,373 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/pkg/errors"
+)
+
+// AuditAction is what happened in an audit event
+type AuditAction string
+
+const (
+	AuditLogin           AuditAction = "session.login"
+	AuditLoginFailed     AuditAction = "session.login_failed"
+	AuditLogout          AuditAction = "session.logout"
+	AuditMemberAdded     AuditAction = "member.added"
+	AuditMemberRemoved   AuditAction = "member.removed"
+	AuditMemberRole      AuditAction = "member.role_changed"
+	AuditMemberLoggedOut AuditAction = "member.logged_out"
+	AuditFeedbackDelete  AuditAction = "feedback.deleted"
+	AuditCommentDelete   AuditAction = "comment.deleted"
+)
+
+// CompanyAuditActions are the actions the audit log of a company can be
+// filtered by
+var CompanyAuditActions = []AuditAction{
+	AuditMemberAdded,
+	AuditMemberRemoved,
+	AuditMemberRole,
+	AuditMemberLoggedOut,
+	AuditFeedbackDelete,
+	AuditCommentDelete,
+}
+
+// SecurityLogActions are the actions the security log of a user can be
+// filtered by. Session events are not about a company, so only the user they
+// are about reads them
+var SecurityLogActions = []AuditAction{
+	AuditLogin,
+	AuditLoginFailed,
+	AuditLogout,
+}
+
+// in checks that the action is one of the given actions
+func (a AuditAction) in(actions []AuditAction) bool {
+	for _, action := range actions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	auditTargetUser     = "user"
+	auditTargetFeedback = "feedback"
+	auditTargetComment  = "comment"
+)
+
+const (
+	auditDefaultLimit = 50
+	auditMaxLimit     = 200
+	// AuditExportLimit is the most events an export contains
+	AuditExportLimit = 10000
+)
+
+// AuditEvent records who did what to whom. Before and After hold the fields
+// the event changed
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	Action     AuditAction     `json:"action"`
+	ActorID    string          `json:"actorId,omitempty"`
+	TargetType string          `json:"targetType,omitempty"`
+	TargetID   string          `json:"targetId,omitempty"`
+	CompanyID  string          `json:"companyId,omitempty"`
+	IP         string          `json:"ip"`
+	UserAgent  string          `json:"userAgent"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+}
+
+// AuditLog is a page of the audit log, newest first. NextCursor is passed as
+// before to get the next page
+type AuditLog struct {
+	Events     []AuditEvent `json:"events"`
+	NextCursor int64        `json:"nextCursor,omitempty"`
+}
+
+// AuditFilterRequest are the query parameters the audit log is filtered by.
+// From and To are RFC3339 times or dates, where To is inclusive for dates
+type AuditFilterRequest struct {
+	Actor  string
+	Action string
+	From   string
+	To     string
+	Before string
+	Limit  string
+}
+
+// AuditFilter limits the audit log to the events of an actor or an action in
+// a time range. Only events older than the Before cursor are returned
+type AuditFilter struct {
+	ActorID string
+	Action  AuditAction
+	From    *time.Time
+	To      *time.Time
+	Before  int64
+	Limit   int
+}
+
+func parseAuditTime(value string, endOfDay bool) (*time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, true
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, false
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, true
+}
+
+// Filter validates the request and turns it into a filter of the given
+// actions
+func (r AuditFilterRequest) Filter(actions []AuditAction) (filter AuditFilter, err error) {
+	errs := make(FieldErrors)
+
+	filter.ActorID = strings.TrimSpace(r.Actor)
+	if filter.ActorID != "" {
+		if _, err := strconv.Atoi(filter.ActorID); err != nil {
+			errs["actor"] = "actor must be a user id"
+		}
+	}
+
+	filter.Action = AuditAction(strings.TrimSpace(r.Action))
+	if filter.Action != "" && !filter.Action.in(actions) {
+		errs["action"] = "unknown action " + string(filter.Action)
+	}
+
+	var ok bool
+	if r.From != "" {
+		if filter.From, ok = parseAuditTime(r.From, false); !ok {
+			errs["from"] = "from must be a RFC3339 time or a date like 2006-01-02"
+		}
+	}
+	if r.To != "" {
+		if filter.To, ok = parseAuditTime(r.To, true); !ok {
+			errs["to"] = "to must be a RFC3339 time or a date like 2006-01-02"
+		}
+	}
+
+	if r.Before != "" {
+		if filter.Before, err = strconv.ParseInt(r.Before, 10, 64); err != nil || filter.Before <= 0 {
+			errs["before"] = "before must be a cursor from a previous page"
+		}
+	}
+
+	filter.Limit = auditDefaultLimit
+	if r.Limit != "" {
+		if filter.Limit, err = strconv.Atoi(r.Limit); err != nil || filter.Limit < 1 || filter.Limit > auditMaxLimit {
+			errs["limit"] = "limit must be between 1 and 200"
+		}
+	}
+
+	if len(errs) > 0 {
+		return filter, errs
+	}
+	return filter, nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// auditState describes the fields an event changed
+func auditState(fields map[string]string) json.RawMessage {
+	state, _ := json.Marshal(fields)
+	return state
+}
+
+func nullJSON(state json.RawMessage) interface{} {
+	if len(state) == 0 {
+		return nil
+	}
+	return string(state)
+}
+
+const createAuditEventQuery = `
+	INSERT INTO AUDIT_EVENTS(Action,ActorId,TargetType,TargetId,CompanyId,Ip,UserAgent,Before,After)
+	VALUES ($1,NULLIF($2,'')::INT,$3,$4,NULLIF($5,'')::INT,$6,$7,$8::JSONB,$9::JSONB)
+`
+
+// recordAuditEvent appends the event to the audit log. The client the
+// request came from is taken from the context, and so is the actor unless
+// the event has one
+func recordAuditEvent(ctx context.Context, db execer, event AuditEvent) error {
+	clientInfo := utils.ClientInfoFromContext(ctx)
+	if event.ActorID == "" {
+		event.ActorID = clientInfo.UserID
+	}
+
+	_, err := db.ExecContext(ctx, createAuditEventQuery, event.Action, event.ActorID, event.TargetType, event.TargetID, event.CompanyID,
+		truncate(clientInfo.IP, 64), truncate(clientInfo.UserAgent, 512), nullJSON(event.Before), nullJSON(event.After))
+	if err != nil {
+		return errors.WithMessage(err, "could not record audit event")
+	}
+	return nil
+}
+
+const findRoleNameQuery = `
+	SELECT Name
+	FROM ROLE
+	WHERE Id=$1
+`
+
+const findMemberRoleNameQuery = `
+	SELECT r.Name
+	FROM USER_COMPANY as uc
+	INNER JOIN ROLE as r
+	ON r.Id=uc.RoleId
+	WHERE uc.CompanyId=$1 AND uc.UserId=$2
+`
+
+// memberRole returns the name of the role of the member
+func memberRole(ctx context.Context, tx *sql.Tx, companyID, userID string) (role string, err error) {
+	err = tx.QueryRowContext(ctx, findMemberRoleNameQuery, companyID, userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return role, errors.New("user is not a member of the company")
+	}
+	if err != nil {
+		return role, errors.WithMessage(err, "could not find member role")
+	}
+	return role, nil
+}
+
+// recordMemberAdded records that the user joined the company with the role
+func recordMemberAdded(ctx context.Context, tx *sql.Tx, companyID, userID string, roleID int, actorID string) error {
+	var role string
+	if err := tx.QueryRowContext(ctx, findRoleNameQuery, roleID).Scan(&role); err != nil {
+		return errors.WithMessage(err, "could not find role")
+	}
+
+	return recordAuditEvent(ctx, tx, AuditEvent{
+		Action:     AuditMemberAdded,
+		ActorID:    actorID,
+		TargetType: auditTargetUser,
+		TargetID:   userID,
+		CompanyID:  companyID,
+		After:      auditState(map[string]string{"role": role}),
+	})
+}
+
+const auditEventColumns = `
+	e.Id
+	,e.Action
+	,COALESCE(CAST(e.ActorId AS VARCHAR),'')
+	,e.TargetType
+	,e.TargetId
+	,COALESCE(CAST(e.CompanyId AS VARCHAR),'')
+	,e.Ip
+	,e.UserAgent
+	,e.Before
+	,e.After
+	,e.CreatedAt
+`
+
+const auditFilterConditions = `
+	AND ($2='' OR CAST(e.ActorId AS VARCHAR)=$2)
+	AND ($3='' OR e.Action=$3)
+	AND (CAST($4 AS TIMESTAMP WITH TIME ZONE) IS NULL OR e.CreatedAt>=$4)
+	AND (CAST($5 AS TIMESTAMP WITH TIME ZONE) IS NULL OR e.CreatedAt<$5)
+	AND (CAST($6 AS BIGINT)=0 OR e.Id<$6)
+	ORDER BY e.Id DESC
+	LIMIT $7
+`
+
+// Session events are not about a company and are left out. They would tell
+// the company where members log in from, even outside their membership
+const getAuditEventsQuery = `
+	SELECT ` + auditEventColumns + `
+	FROM AUDIT_EVENTS as e
+	WHERE e.CompanyId=$1
+` + auditFilterConditions
+
+// The security log of a user are the session events about them
+const getSecurityLogQuery = `
+	SELECT ` + auditEventColumns + `
+	FROM AUDIT_EVENTS as e
+	WHERE e.CompanyId IS NULL AND e.TargetType='` + auditTargetUser + `' AND e.TargetId=$1
+` + auditFilterConditions
+
+func scanAuditEvent(row interface{ Scan(...interface{}) error }) (event AuditEvent, err error) {
+	var before, after []byte
+	err = row.Scan(&event.ID, &event.Action, &event.ActorID, &event.TargetType, &event.TargetID, &event.CompanyID,
+		&event.IP, &event.UserAgent, &before, &after, &event.CreatedAt)
+	if err != nil {
+		return
+	}
+
+	event.Before = before
+	event.After = after
+	return event, nil
+}
+
+// querier is satisfied by both *sql.DB and *DB
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// getAuditEvents gets the events of the query, which selects the events of
+// the id and filters them
+func getAuditEvents(ctx context.Context, db querier, query, id string, filter AuditFilter) (events []AuditEvent, err error) {
+	rows, err := db.QueryContext(ctx, query, id, filter.ActorID, filter.Action, filter.From, filter.To, filter.Before, filter.Limit)
+	if err != nil {
+		return events, errors.WithMessage(err, "could not get audit events")
+	}
+	defer rows.Close()
+
+	events = []AuditEvent{}
+	for rows.Next() {
+		event, err := scanAuditEvent(rows)
+		if err != nil {
+			return events, errors.WithStack(err)
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// getAuditLog gets a page of the events of the query
+func getAuditLog(ctx context.Context, db querier, query, id string, filter AuditFilter) (log AuditLog, err error) {
+	limit := filter.Limit
+	filter.Limit++
+	log.Events, err = getAuditEvents(ctx, db, query, id, filter)
+	if err != nil {
+		return
+	}
+
+	if len(log.Events) > limit {
+		log.Events = log.Events[:limit]
+		log.NextCursor = log.Events[limit-1].ID
+	}
+	return log, nil
+}
+
+// GetAuditLog gets a page of the audit log of the company
+func (c *CompanyClient) GetAuditLog(ctx context.Context, companyID string, filter AuditFilter) (AuditLog, error) {
+	return getAuditLog(ctx, c.DB, getAuditEventsQuery, companyID, filter)
+}
+
+// GetSecurityLog gets a page of the logins, failed logins and logouts of the
+// user
+func (c *SessionClient) GetSecurityLog(ctx context.Context, userID string, filter AuditFilter) (AuditLog, error) {
+	return getAuditLog(ctx, c.DB, getSecurityLogQuery, userID, filter)
+}
+
+// ExportAuditLog gets the events of the audit log of the company matching
+// the filter, up to AuditExportLimit
+func (c *CompanyClient) ExportAuditLog(ctx context.Context, companyID string, filter AuditFilter) ([]AuditEvent, error) {
+	filter.Before = 0
+	filter.Limit = AuditExportLimit
+	return getAuditEvents(ctx, c.DB, getAuditEventsQuery, companyID, filter)
+}
@@ -3,19 +3,28 @@ package models
 import (
 	"context"
 	"database/sql"
+	"net"
+	"strings"
+	"time"
 
 	"github.com/kristohberg/CreatixBackend/utils"
 	"github.com/pkg/errors"
 )
 
 type Company struct {
-	ID   string `json:"id"`
-	Name string `json:"companyName"`
+	ID             string `json:"id"`
+	Name           string `json:"companyName"`
+	RequireMfa     bool   `json:"requireMfa"`
+	OwnerID        string `json:"ownerId,omitempty"`
+	PendingOwnerID string `json:"pendingOwnerId,omitempty"`
+	Archived       bool   `json:"archived,omitempty"`
 }
 
 type Team struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID        string `json:"id"`
+	CompanyID string `json:"companyId"`
+	Name      string `json:"name"`
+	Lead      bool   `json:"lead,omitempty"`
 }
 type CompanyClienter interface {
 	// Company
@@ -27,27 +36,89 @@ type CompanyClienter interface {
 	SearchCompany(ctx context.Context, query string) (queryResult []Company, err error)
 	GetCompanyUsers(ctx context.Context, companyID string) ([]CompanyUserResponse, error)
 	GetUserCompanies(ctx context.Context, userID string) (companies []Company, err error)
+	SetRequireMfa(ctx context.Context, companyID string, required bool) error
+
+	// Invitation
+	CreateInvitation(ctx context.Context, companyID, invitedBy string, invitationRequest InvitationRequest) (token string, invitation Invitation, err error)
+	GetInvitations(ctx context.Context, companyID string) ([]Invitation, error)
+	ResendInvitation(ctx context.Context, companyID string, invitationID int) (token string, invitation Invitation, err error)
+	RevokeInvitation(ctx context.Context, companyID string, invitationID int) error
+	AcceptInvitation(ctx context.Context, token string, user utils.SessionUser) (companyID string, err error)
 
 	// Team
-	CreateTeam(ctx context.Context, team Team) (err error)
-	AddUserToTeam(ctx context.Context) error
+	CreateTeam(ctx context.Context, team Team) (teamID string, err error)
+	RenameTeam(ctx context.Context, companyID, teamID, name string) error
+	DeleteTeam(ctx context.Context, companyID, teamID string) error
+	GetCompanyTeams(ctx context.Context, companyID string) ([]Team, error)
+	GetUserTeams(ctx context.Context, userID string) ([]Team, error)
+	GetTeamMembers(ctx context.Context, companyID, teamID string) ([]TeamMember, error)
+	AddUserToTeam(ctx context.Context, companyID, teamID string, memberRequest TeamMemberRequest) error
+	RemoveUserFromTeam(ctx context.Context, companyID, teamID, userID string) error
+	SetTeamLead(ctx context.Context, companyID, teamID, userID string, lead bool) error
+	IsTeamLead(ctx context.Context, companyID, teamID, userID string) (bool, error)
+
+	// Role
+	GetCompanyRoles(ctx context.Context, companyID string) ([]Role, error)
+	CreateRole(ctx context.Context, companyID string, roleRequest RoleRequest) (Role, error)
+	UpdateRole(ctx context.Context, companyID string, roleID int, roleRequest RoleRequest) error
+	DeleteRole(ctx context.Context, companyID string, roleID int) error
+	CanGrantRole(ctx context.Context, companyID, userID string, role AccessLevel) (bool, error)
+	GetUserPermissions(ctx context.Context, companyID, userID string) ([]Permission, error)
+
+	// Owner
+	OfferOwnership(ctx context.Context, companyID, userID, newOwnerID string) (companyName string, err error)
+	AcceptOwnership(ctx context.Context, companyID, userID string) error
+	CancelOwnershipOffer(ctx context.Context, companyID, userID string) error
+
+	// Join request
+	CreateJoinRequest(ctx context.Context, companyID, userID string, joinRequest JoinRequestRequest) (JoinRequest, error)
+	GetJoinRequests(ctx context.Context, companyID string) ([]JoinRequest, error)
+	DecideJoinRequest(ctx context.Context, companyID string, requestID int, decidedBy string, approve bool) (JoinRequest, error)
+	SetDiscoverable(ctx context.Context, companyID string, discoverable bool) error
+
+	// Domain
+	CreateDomain(ctx context.Context, companyID string, domainRequest DomainRequest) (Domain, error)
+	GetDomains(ctx context.Context, companyID string) ([]Domain, error)
+	VerifyDomain(ctx context.Context, companyID string, domainID int) (Domain, error)
+	UpdateDomainAccess(ctx context.Context, companyID string, domainID int, access AccessLevel) error
+	DeleteDomain(ctx context.Context, companyID string, domainID int) error
+	JoinDomainCompany(ctx context.Context, userID string) (Company, error)
+
+	// Settings
+	GetSettings(ctx context.Context, companyID string) (Settings, error)
+	UpdateSettings(ctx context.Context, companyID string, settingsRequest SettingsRequest) error
+	RenameCompany(ctx context.Context, companyID, name string) error
+	SetArchived(ctx context.Context, companyID string, archived bool) error
+	IsArchived(ctx context.Context, companyID string) (bool, error)
+	CreateCompanyDeletion(ctx context.Context, companyID, userID string) (CompanyDeletion, error)
+	DeleteCompany(ctx context.Context, companyID, token string) error
+
+	// Audit
+	GetAuditLog(ctx context.Context, companyID string, filter AuditFilter) (AuditLog, error)
+	ExportAuditLog(ctx context.Context, companyID string, filter AuditFilter) ([]AuditEvent, error)
 }
 
 type CompanyClient struct {
-	DB *sql.DB
+	DB       *DB
+	Resolver TXTResolver
 }
 
-// NewCompanyClient creates a new company client
+// NewCompanyClient creates a new company client which verifies domains with
+// the default dns resolver
 func NewCompanyClient(DB *sql.DB) *CompanyClient {
-	return &CompanyClient{DB: DB}
+	return &CompanyClient{DB: NewDB(DB), Resolver: net.DefaultResolver}
 }
 
 const getUserCompaniesQuery = `
 	SELECT 
 	c.Id, 
-	c.Name
+	c.Name,
+	c.RequireMfa,
+	COALESCE(CAST(c.OwnerId AS VARCHAR),''),
+	CASE WHEN c.OwnershipOfferedAt>$2 THEN CAST(c.PendingOwnerId AS VARCHAR) ELSE '' END,
+	c.ArchivedAt IS NOT NULL
 	FROM COMPANY c
-	LEFT JOIN (
+	INNER JOIN (
 		SELECT CompanyId
 		FROM USER_COMPANY 
 		WHERE UserId=$1
@@ -57,7 +128,7 @@ const getUserCompaniesQuery = `
 
 func (c *CompanyClient) GetUserCompanies(ctx context.Context, userID string) (companies []Company, err error) {
 	companies = []Company{}
-	rows, err := c.DB.QueryContext(ctx, getUserCompaniesQuery, userID)
+	rows, err := c.DB.QueryContext(ctx, getUserCompaniesQuery, userID, time.Now().Add(-ownershipOfferExpirationTime))
 	if err != nil {
 		return companies, err
 	}
@@ -65,7 +136,7 @@ func (c *CompanyClient) GetUserCompanies(ctx context.Context, userID string) (co
 
 	for rows.Next() {
 		var company Company
-		if err = rows.Scan(&company.ID, &company.Name); err != nil {
+		if err = rows.Scan(&company.ID, &company.Name, &company.RequireMfa, &company.OwnerID, &company.PendingOwnerID, &company.Archived); err != nil {
 			return
 		}
 		companies = append(companies, company)
@@ -83,7 +154,7 @@ func (c *CompanyClient) GetUserCompanies(ctx context.Context, userID string) (co
 }
 
 const addUserToCompanyByEmailQuery = `
-	INSERT INTO USER_COMPANY(CompanyId,UserId,AccessId)
+	INSERT INTO USER_COMPANY(CompanyId,UserId,RoleId)
 	VALUES ($1,$2,$3)
 `
 
@@ -94,36 +165,43 @@ func (c *CompanyClient) AddUserToCompanyByEmail(ctx context.Context, companyID s
 	if err != nil {
 		return
 	}
+	return c.addMember(ctx, companyID, user, newUserRequest.Access)
+}
 
-	accessID, err := newUserRequest.Access.ToAccessID()
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	res, err := c.DB.ExecContext(ctx, addUserToCompanyByEmailQuery, companyID, user.ID, accessID)
+// AddUserToCompanyByUsername adds a user by username
+func (c *CompanyClient) AddUserToCompanyByUsername(ctx context.Context, companyID string, newUserRequest AddUser) (err error) {
+
+	user, err := utils.FindUserByUsername(ctx, c.DB, newUserRequest.Username)
 	if err != nil {
 		return
 	}
-
-	nrows, err := res.RowsAffected()
-	if err != nil || nrows == 0 {
-		return errors.New("not able to add user")
-	}
-	return nil
+	return c.addMember(ctx, companyID, user, newUserRequest.Access)
 }
 
-// AddUserToCompanyByUsername adds a user by username
-func (c *CompanyClient) AddUserToCompanyByUsername(ctx context.Context, companyID string, newUserRequest AddUser) (err error) {
+// addMember adds the user to the company with the role for the access level.
+// Only users with a verified email can be added
+func (c *CompanyClient) addMember(ctx context.Context, companyID string, user utils.SessionUser, access AccessLevel) (err error) {
+	if !user.EmailVerified {
+		return UnverifiedEmailError
+	}
 
-	user, err := utils.FindUserByUsername(ctx, c.DB, newUserRequest.Username)
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
 		return
 	}
 
-	accessID, err := newUserRequest.Access.ToAccessID()
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	roleID, err := findRoleID(ctx, tx, companyID, access)
 	if err != nil {
-		return errors.WithStack(err)
+		return
 	}
-	res, err := c.DB.ExecContext(ctx, addUserToCompanyByEmailQuery, companyID, user.ID, accessID)
+	res, err := tx.ExecContext(ctx, addUserToCompanyByEmailQuery, companyID, user.ID, roleID)
 	if err != nil {
 		return
 	}
@@ -132,22 +210,51 @@ func (c *CompanyClient) AddUserToCompanyByUsername(ctx context.Context, companyI
 	if err != nil || nrows == 0 {
 		return errors.New("not able to add user")
 	}
-	return nil
+
+	if err = recordMemberAdded(ctx, tx, companyID, user.ID, roleID, ""); err != nil {
+		return
+	}
+	return tx.Commit()
 }
 
 const updateUserPermissionQuery = `
 	UPDATE USER_COMPANY
-	SET AccessId=$3
+	SET RoleId=$3
 	WHERE CompanyId=$1 AND UserId=$2
 `
 
+// UpdateUserPermission gives the member another role. The owner and the last
+// admin of the company cannot lose the admin role
 func (c *CompanyClient) UpdateUserPermission(ctx context.Context, companyID string, userPermissionRequest UserPermissionRequest) (err error) {
-	accessID, err := userPermissionRequest.Access.ToAccessID()
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
-		return errors.WithStack(err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	roleID, err := findRoleID(ctx, tx, companyID, userPermissionRequest.Access)
+	if err != nil {
+		return
+	}
+
+	if roleID != adminRoleID {
+		if err = guardAdminRemoval(ctx, tx, companyID, userPermissionRequest.UserID); err != nil {
+			return
+		}
+	}
+
+	before, err := memberRole(ctx, tx, companyID, userPermissionRequest.UserID)
+	if err != nil {
+		return
 	}
 
-	res, err := c.DB.ExecContext(ctx, updateUserPermissionQuery, companyID, userPermissionRequest.UserID, accessID)
+	res, err := tx.ExecContext(ctx, updateUserPermissionQuery, companyID, userPermissionRequest.UserID, roleID)
 	if err != nil {
 		return
 	}
@@ -156,7 +263,24 @@ func (c *CompanyClient) UpdateUserPermission(ctx context.Context, companyID stri
 	if err != nil || nrows == 0 {
 		return errors.New("not able to add user")
 	}
-	return nil
+
+	after, err := memberRole(ctx, tx, companyID, userPermissionRequest.UserID)
+	if err != nil {
+		return
+	}
+
+	err = recordAuditEvent(ctx, tx, AuditEvent{
+		Action:     AuditMemberRole,
+		TargetType: auditTargetUser,
+		TargetID:   userPermissionRequest.UserID,
+		CompanyID:  companyID,
+		Before:     auditState(map[string]string{"role": before}),
+		After:      auditState(map[string]string{"role": after}),
+	})
+	if err != nil {
+		return
+	}
+	return tx.Commit()
 }
 
 const deleteUserQuery = `
@@ -164,8 +288,40 @@ const deleteUserQuery = `
 	WHERE CompanyId=$1 AND UserId=$2;
 `
 
+const deleteUserTeamsQuery = `
+	DELETE FROM USER_TEAM
+	WHERE UserId=$2 AND TeamId IN (SELECT Id FROM TEAM WHERE CompanyID=$1)
+`
+
+// DeleteUser removes the user from the company and its teams. The owner and
+// the last admin of the company cannot be removed
 func (c *CompanyClient) DeleteUser(ctx context.Context, companyID, UserID string) (err error) {
-	res, err := c.DB.ExecContext(ctx, deleteUserQuery, companyID, UserID)
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	if err = guardAdminRemoval(ctx, tx, companyID, UserID); err != nil {
+		return
+	}
+
+	role, err := memberRole(ctx, tx, companyID, UserID)
+	if err != nil {
+		return
+	}
+
+	if _, err = tx.ExecContext(ctx, deleteUserTeamsQuery, companyID, UserID); err != nil {
+		return errors.WithStack(err)
+	}
+
+	res, err := tx.ExecContext(ctx, deleteUserQuery, companyID, UserID)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -173,14 +329,25 @@ func (c *CompanyClient) DeleteUser(ctx context.Context, companyID, UserID string
 	if err != nil || nrows == 0 {
 		return errors.New("not able to delete user")
 	}
-	return
+
+	err = recordAuditEvent(ctx, tx, AuditEvent{
+		Action:     AuditMemberRemoved,
+		TargetType: auditTargetUser,
+		TargetID:   UserID,
+		CompanyID:  companyID,
+		Before:     auditState(map[string]string{"role": role}),
+	})
+	if err != nil {
+		return
+	}
+	return tx.Commit()
 }
 
 const getCompanyUsersQuery = `
 	SELECT 
 	uc.UserId
 	,u.Username
-	,ca.AccessLevel
+	,r.Name
 	FROM USER_COMPANY uc
 	LEFT JOIN(
 		SELECT 
@@ -189,12 +356,8 @@ const getCompanyUsersQuery = `
 		FROM Users
 	) as u
 	ON u.ID=uc.UserId
-	LEFT JOIN(
-		SELECT AccessLevel,
-		AccessID
-		FROM COMPANY_ACCESS
-	) as ca
-	ON ca.AccessID=uc.AccessID
+	INNER JOIN ROLE as r
+	ON r.Id=uc.RoleId
 	WHERE uc.CompanyId=$1
 `
 
@@ -226,11 +389,16 @@ const searchCompanyQuery = `
 	SELECT ID
 	,Name
 	FROM COMPANY
-	WHERE Name like $1
+	WHERE Discoverable AND ArchivedAt IS NULL AND Name ILIKE '%' || $1 || '%'
+	ORDER BY Name
+	LIMIT 20
 `
 
-// SearchCompany receives a string query and returns a list of company results
+// SearchCompany receives a string query and returns the discoverable companies
+// whose name contains it
 func (c *CompanyClient) SearchCompany(ctx context.Context, query string) (queryResult []Company, err error) {
+	query = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query)
+	queryResult = []Company{}
 	rows, err := c.DB.QueryContext(ctx, searchCompanyQuery, query)
 	if err != nil {
 		return
@@ -239,7 +407,7 @@ func (c *CompanyClient) SearchCompany(ctx context.Context, query string) (queryR
 
 	for rows.Next() {
 		var company Company
-		if err = rows.Scan(&company); err != nil {
+		if err = rows.Scan(&company.ID, &company.Name); err != nil {
 			return
 		}
 		queryResult = append(queryResult, company)
@@ -256,16 +424,17 @@ func (c *CompanyClient) SearchCompany(ctx context.Context, query string) (queryR
 }
 
 const createCompanyQuery = `
-	INSERT INTO COMPANY(Name)
-	VALUES ($1)
+	INSERT INTO COMPANY(Name,OwnerId)
+	VALUES ($1,$2)
 	RETURNING ID
 `
 const addUserToCompanyByID = `
-	INSERT INTO USER_COMPANY(CompanyId,UserId,AccessID)
+	INSERT INTO USER_COMPANY(CompanyId,UserId,RoleId)
 	VALUES ($1,$2,1)
 `
 
-// CreateCompany creates a new company and adds the current user to it
+// CreateCompany creates a new company owned by the current user and adds them
+// to it as admin
 func (c *CompanyClient) CreateCompany(ctx context.Context, companyName, userID string) (companyID *int64, err error) {
 	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
@@ -279,7 +448,7 @@ func (c *CompanyClient) CreateCompany(ctx context.Context, companyName, userID s
 		}
 	}()
 
-	err = tx.QueryRowContext(ctx, createCompanyQuery, companyName).Scan(&companyID)
+	err = tx.QueryRowContext(ctx, createCompanyQuery, companyName, userID).Scan(&companyID)
 	if err != nil {
 		return
 	}
@@ -303,28 +472,3 @@ func (c *CompanyClient) CreateCompany(ctx context.Context, companyName, userID s
 
 	return companyID, err
 }
-
-const createTeamQuery = `
-	INSERT INTO TEAM(Name)
-	VALUES ($1)
-`
-
-func (c *CompanyClient) CreateTeam(ctx context.Context, team Team) (err error) {
-
-	res, err := c.DB.ExecContext(ctx, createTeamQuery, team.Name)
-	if err != nil {
-		return
-	}
-
-	if rowsAffected, err := res.RowsAffected(); err != nil || rowsAffected == 0 {
-		return errors.New("not able to update teams table")
-	}
-
-	return nil
-}
-
-// AssignUserToTeam assigns a userID to a teamID as long as that
-// user is a part om the mother company
-func (c *CompanyClient) AddUserToTeam(ctx context.Context) error {
-	return nil
-}
@@ -0,0 +1,405 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// The TXT record proving that a company controls a domain is added on the
+// _creatix subdomain with the verification token of the domain as its value
+const (
+	domainRecordPrefix      = "_creatix."
+	domainRecordValuePrefix = "creatix-verification="
+)
+
+var (
+	DomainNotFoundError    = errors.New("domain not found")
+	DomainExistsError      = errors.New("the company has already claimed the domain")
+	DomainTakenError       = errors.New("the domain is already verified by another company")
+	DomainNotVerifiedError = errors.New("the verification record was not found in the dns records of the domain")
+	NoDomainCompanyError   = errors.New("no company has verified the domain of your email")
+)
+
+var domainLabelRegexp = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// TXTResolver looks up the TXT records of a domain. *net.Resolver satisfies
+// it, tests can use a fake
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// DomainRequest claims an email domain for a company. Verified users with an
+// email on the domain join the company with the given role
+type DomainRequest struct {
+	Domain string      `json:"domain"`
+	Access AccessLevel `json:"accessLevel"`
+}
+
+func (r *DomainRequest) Valid() error {
+	errs := make(FieldErrors)
+
+	r.Domain = normalizeDomain(r.Domain)
+	if !validDomain(r.Domain) {
+		errs["domain"] = "domain must be a valid domain name like example.com"
+	}
+
+	if strings.TrimSpace(string(r.Access)) == "" {
+		errs["accessLevel"] = "accessLevel cannot be empty"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// DomainAccessRequest changes the role users joining through a domain get
+type DomainAccessRequest struct {
+	Access AccessLevel `json:"accessLevel"`
+}
+
+// Domain is an email domain claimed by a company. Until it is verified the
+// TXT record described by RecordName and RecordValue has to be added to the
+// dns records of the domain
+type Domain struct {
+	ID          int         `json:"id"`
+	CompanyID   string      `json:"companyId"`
+	Domain      string      `json:"domain"`
+	Access      AccessLevel `json:"accessLevel"`
+	RecordName  string      `json:"recordName"`
+	RecordValue string      `json:"recordValue"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	VerifiedAt  *time.Time  `json:"verifiedAt,omitempty"`
+}
+
+func normalizeDomain(domain string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+}
+
+func validDomain(domain string) bool {
+	if len(domain) > 253 {
+		return false
+	}
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if !domainLabelRegexp.MatchString(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// emailDomain returns the normalized domain of an email address
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+	return normalizeDomain(email[at+1:])
+}
+
+const createDomainQuery = `
+	INSERT INTO COMPANY_DOMAINS(CompanyId,Domain,RoleId,VerificationToken)
+	VALUES ($1,$2,$3,$4)
+	ON CONFLICT (CompanyId,Domain) DO NOTHING
+	RETURNING Id
+`
+
+// CreateDomain claims the domain for the company. The domain is only used
+// for joining once it has been verified
+func (c *CompanyClient) CreateDomain(ctx context.Context, companyID string, domainRequest DomainRequest) (domain Domain, err error) {
+	roleID, err := findRoleID(ctx, c.DB, companyID, domainRequest.Access)
+	if err != nil {
+		return
+	}
+
+	token, err := utils.NewOpaqueToken()
+	if err != nil {
+		return
+	}
+
+	var domainID int
+	err = c.DB.QueryRowContext(ctx, createDomainQuery, companyID, domainRequest.Domain, roleID, token).Scan(&domainID)
+	if err == sql.ErrNoRows {
+		return domain, DomainExistsError
+	}
+	if err != nil {
+		return domain, errors.WithMessage(err, "could not create domain")
+	}
+
+	return c.getDomain(ctx, companyID, domainID)
+}
+
+const getDomainsQuery = `
+	SELECT
+	d.Id
+	,d.CompanyId
+	,d.Domain
+	,r.Name
+	,d.VerificationToken
+	,d.CreatedAt
+	,d.VerifiedAt
+	FROM COMPANY_DOMAINS as d
+	INNER JOIN ROLE as r
+	ON r.Id=d.RoleId
+	WHERE d.CompanyId=$1
+`
+
+const getDomainQuery = getDomainsQuery + ` AND d.Id=$2`
+
+func scanDomain(row interface{ Scan(...interface{}) error }) (domain Domain, err error) {
+	var (
+		token      string
+		verifiedAt sql.NullTime
+	)
+	err = row.Scan(&domain.ID, &domain.CompanyID, &domain.Domain, &domain.Access, &token, &domain.CreatedAt, &verifiedAt)
+	if err != nil {
+		return
+	}
+
+	domain.RecordName = domainRecordPrefix + domain.Domain
+	domain.RecordValue = domainRecordValuePrefix + token
+	if verifiedAt.Valid {
+		domain.VerifiedAt = &verifiedAt.Time
+	}
+	return domain, nil
+}
+
+func (c *CompanyClient) getDomain(ctx context.Context, companyID string, domainID int) (domain Domain, err error) {
+	domain, err = scanDomain(c.DB.QueryRowContext(ctx, getDomainQuery, companyID, domainID))
+	if err == sql.ErrNoRows {
+		return domain, DomainNotFoundError
+	}
+	if err != nil {
+		return domain, errors.WithMessage(err, "could not get domain")
+	}
+	return domain, nil
+}
+
+// GetDomains lists the domains claimed by the company
+func (c *CompanyClient) GetDomains(ctx context.Context, companyID string) (domains []Domain, err error) {
+	rows, err := c.DB.QueryContext(ctx, getDomainsQuery+` ORDER BY d.Domain`, companyID)
+	if err != nil {
+		return domains, errors.WithMessage(err, "could not get domains")
+	}
+	defer rows.Close()
+
+	domains = []Domain{}
+	for rows.Next() {
+		domain, err := scanDomain(rows)
+		if err != nil {
+			return domains, errors.WithStack(err)
+		}
+		domains = append(domains, domain)
+	}
+	return domains, rows.Err()
+}
+
+const verifyDomainQuery = `
+	UPDATE COMPANY_DOMAINS
+	SET VerifiedAt=NOW()
+	WHERE Id=$1 AND CompanyId=$2 AND VerifiedAt IS NULL AND NOT EXISTS (
+		SELECT 1
+		FROM COMPANY_DOMAINS
+		WHERE Domain=$3 AND VerifiedAt IS NOT NULL
+	)
+`
+
+// VerifyDomain looks up the TXT records of the domain and marks it as
+// verified when the verification record is found. Verifying an already
+// verified domain does nothing
+func (c *CompanyClient) VerifyDomain(ctx context.Context, companyID string, domainID int) (domain Domain, err error) {
+	domain, err = c.getDomain(ctx, companyID, domainID)
+	if err != nil || domain.VerifiedAt != nil {
+		return
+	}
+
+	records, err := c.Resolver.LookupTXT(ctx, domain.RecordName)
+	if err != nil {
+		return domain, DomainNotVerifiedError
+	}
+
+	found := false
+	for _, record := range records {
+		if strings.TrimSpace(record) == domain.RecordValue {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return domain, DomainNotVerifiedError
+	}
+
+	res, err := c.DB.ExecContext(ctx, verifyDomainQuery, domainID, companyID, domain.Domain)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return domain, DomainTakenError
+		}
+		return domain, errors.WithMessage(err, "could not verify domain")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return domain, DomainTakenError
+	}
+
+	return c.getDomain(ctx, companyID, domainID)
+}
+
+const updateDomainRoleQuery = `
+	UPDATE COMPANY_DOMAINS
+	SET RoleId=$3
+	WHERE Id=$1 AND CompanyId=$2
+`
+
+// UpdateDomainAccess changes the role given to users joining through the
+// domain. Members who already joined keep their role
+func (c *CompanyClient) UpdateDomainAccess(ctx context.Context, companyID string, domainID int, access AccessLevel) error {
+	roleID, err := findRoleID(ctx, c.DB, companyID, access)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.DB.ExecContext(ctx, updateDomainRoleQuery, domainID, companyID, roleID)
+	if err != nil {
+		return errors.WithMessage(err, "could not update domain")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return DomainNotFoundError
+	}
+	return nil
+}
+
+const deleteDomainQuery = `
+	DELETE FROM COMPANY_DOMAINS
+	WHERE Id=$1 AND CompanyId=$2
+`
+
+// DeleteDomain stops users on the domain from joining the company. Members
+// who already joined stay in the company
+func (c *CompanyClient) DeleteDomain(ctx context.Context, companyID string, domainID int) error {
+	res, err := c.DB.ExecContext(ctx, deleteDomainQuery, domainID, companyID)
+	if err != nil {
+		return errors.WithMessage(err, "could not delete domain")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return DomainNotFoundError
+	}
+	return nil
+}
+
+const findDomainCompanyQuery = `
+	SELECT
+	c.Id
+	,c.Name
+	,d.RoleId
+	FROM COMPANY_DOMAINS as d
+	INNER JOIN COMPANY as c
+	ON c.Id=d.CompanyId
+	WHERE d.Domain=$1 AND d.VerifiedAt IS NOT NULL AND c.ArchivedAt IS NULL
+`
+
+const findVerifiedUserEmailQuery = `
+	SELECT Email
+	FROM USERS
+	WHERE ID=$1 AND EmailVerifiedAt IS NOT NULL
+`
+
+// joinDomainCompany adds the user to the company which has verified the
+// domain of their email, with the role configured for the domain. Nothing
+// happens if the email is unverified, no company has verified the domain or
+// the user is already a member
+func joinDomainCompany(ctx context.Context, tx *sql.Tx, userID string) (company Company, joined bool, err error) {
+	var email string
+	err = tx.QueryRowContext(ctx, findVerifiedUserEmailQuery, userID).Scan(&email)
+	if err == sql.ErrNoRows {
+		return company, false, nil
+	}
+	if err != nil {
+		return company, false, errors.WithMessage(err, "could not find user email")
+	}
+
+	var roleID int
+	err = tx.QueryRowContext(ctx, findDomainCompanyQuery, emailDomain(email)).Scan(&company.ID, &company.Name, &roleID)
+	if err == sql.ErrNoRows {
+		return company, false, nil
+	}
+	if err != nil {
+		return company, false, errors.WithMessage(err, "could not find domain company")
+	}
+
+	res, err := tx.ExecContext(ctx, joinCompanyQuery, company.ID, userID, roleID)
+	if err != nil {
+		return company, false, errors.WithMessage(err, "could not join company")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil || affected == 0 {
+		return company, false, err
+	}
+
+	if err = recordMemberAdded(ctx, tx, company.ID, userID, roleID, userID); err != nil {
+		return
+	}
+	return company, true, nil
+}
+
+// JoinDomainCompany lets a user join the company which has verified the
+// domain of their email, for users who signed up before the domain was
+// verified
+func (c *CompanyClient) JoinDomainCompany(ctx context.Context, userID string) (company Company, err error) {
+	user, err := utils.FindUserByUserID(ctx, c.DB, userID)
+	if err != nil {
+		return company, errors.WithMessage(err, "could not find user")
+	}
+	if !user.EmailVerified {
+		return company, UnverifiedEmailError
+	}
+
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	company, joined, err := joinDomainCompany(ctx, tx, userID)
+	if err != nil {
+		return
+	}
+	if company.ID == "" {
+		return company, NoDomainCompanyError
+	}
+	if !joined {
+		return company, AlreadyCompanyMemberError
+	}
+	return company, tx.Commit()
+}
@@ -0,0 +1,340 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+const companyDeletionExpirationTime = time.Minute * 15
+
+var (
+	CompanyArchivedError      = errors.New("the company is archived and read-only")
+	CompanyNameTakenError     = errors.New("a company with that name already exists")
+	InvalidDeletionTokenError = errors.New("invalid or expired deletion confirmation")
+)
+
+// Settings describes a company and how it works
+type Settings struct {
+	ID                     string      `json:"id"`
+	Name                   string      `json:"companyName"`
+	Description            string      `json:"description"`
+	LogoURL                string      `json:"logoUrl"`
+	DefaultAccess          AccessLevel `json:"defaultAccessLevel"`
+	AllowAnonymousFeedback bool        `json:"allowAnonymousFeedback"`
+	Timezone               string      `json:"timezone"`
+	ArchivedAt             *time.Time  `json:"archivedAt,omitempty"`
+}
+
+// SettingsRequest changes the settings of a company. New members get the
+// default access level unless another role is chosen for them
+type SettingsRequest struct {
+	Description            string      `json:"description"`
+	LogoURL                string      `json:"logoUrl"`
+	DefaultAccess          AccessLevel `json:"defaultAccessLevel"`
+	AllowAnonymousFeedback bool        `json:"allowAnonymousFeedback"`
+	Timezone               string      `json:"timezone"`
+}
+
+func (r *SettingsRequest) Valid() error {
+	errs := make(FieldErrors)
+
+	r.Description = strings.TrimSpace(r.Description)
+	if len(r.Description) > 1000 {
+		errs["description"] = "description cannot be longer than 1000 characters"
+	}
+
+	r.LogoURL = strings.TrimSpace(r.LogoURL)
+	if r.LogoURL != "" {
+		logoURL, err := url.Parse(r.LogoURL)
+		if err != nil || logoURL.Host == "" || (logoURL.Scheme != "https" && logoURL.Scheme != "http") || len(r.LogoURL) > 2048 {
+			errs["logoUrl"] = "logoUrl must be a http or https url"
+		}
+	}
+
+	if strings.TrimSpace(string(r.DefaultAccess)) == "" {
+		r.DefaultAccess = Read
+	}
+
+	r.Timezone = strings.TrimSpace(r.Timezone)
+	if r.Timezone == "" {
+		r.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(r.Timezone); err != nil || len(r.Timezone) > 64 {
+		errs["timezone"] = "timezone must be an IANA timezone like Europe/Oslo"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+type RenameCompanyRequest struct {
+	Name string `json:"companyName"`
+}
+
+func (r *RenameCompanyRequest) Valid() error {
+	errs := make(FieldErrors)
+
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" || len(r.Name) > 256 {
+		errs["companyName"] = "companyName must be between 1 and 256 characters"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// CompanyDeletion is the confirmation needed to delete a company
+type CompanyDeletion struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type DeleteCompanyRequest struct {
+	Token string `json:"token"`
+}
+
+const getSettingsQuery = `
+	SELECT
+	c.Id
+	,c.Name
+	,c.Description
+	,c.LogoUrl
+	,r.Name
+	,c.AllowAnonymousFeedback
+	,c.Timezone
+	,c.ArchivedAt
+	FROM COMPANY as c
+	INNER JOIN ROLE as r
+	ON r.Id=COALESCE(c.DefaultRoleId,$2)
+	WHERE c.Id=$1
+`
+
+// GetSettings gets the settings of the company
+func (c *CompanyClient) GetSettings(ctx context.Context, companyID string) (settings Settings, err error) {
+	var archivedAt sql.NullTime
+	err = c.DB.QueryRowContext(ctx, getSettingsQuery, companyID, readRoleID).Scan(&settings.ID, &settings.Name, &settings.Description,
+		&settings.LogoURL, &settings.DefaultAccess, &settings.AllowAnonymousFeedback, &settings.Timezone, &archivedAt)
+	if err == sql.ErrNoRows {
+		return settings, CompanyNotFoundError
+	}
+	if err != nil {
+		return settings, errors.WithMessage(err, "could not get company settings")
+	}
+
+	if archivedAt.Valid {
+		settings.ArchivedAt = &archivedAt.Time
+	}
+	return settings, nil
+}
+
+const updateSettingsQuery = `
+	UPDATE COMPANY
+	SET Description=$2, LogoUrl=$3, DefaultRoleId=$4, AllowAnonymousFeedback=$5, Timezone=$6
+	WHERE Id=$1
+`
+
+// UpdateSettings changes the settings of the company
+func (c *CompanyClient) UpdateSettings(ctx context.Context, companyID string, settingsRequest SettingsRequest) error {
+	roleID, err := findRoleID(ctx, c.DB, companyID, settingsRequest.DefaultAccess)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.DB.ExecContext(ctx, updateSettingsQuery, companyID, settingsRequest.Description, settingsRequest.LogoURL,
+		roleID, settingsRequest.AllowAnonymousFeedback, settingsRequest.Timezone)
+	if err != nil {
+		return errors.WithMessage(err, "could not update company settings")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return CompanyNotFoundError
+	}
+	return nil
+}
+
+const renameCompanyQuery = `
+	UPDATE COMPANY
+	SET Name=$2
+	WHERE Id=$1
+`
+
+// RenameCompany gives the company a new name, which must not be used by
+// another company
+func (c *CompanyClient) RenameCompany(ctx context.Context, companyID, name string) error {
+	res, err := c.DB.ExecContext(ctx, renameCompanyQuery, companyID, name)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return CompanyNameTakenError
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not rename company")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return CompanyNotFoundError
+	}
+	return nil
+}
+
+const archiveCompanyQuery = `
+	UPDATE COMPANY
+	SET ArchivedAt=CASE WHEN $2 THEN COALESCE(ArchivedAt,NOW()) END
+	WHERE Id=$1
+`
+
+// SetArchived archives or restores the company. Members of an archived
+// company can read the feedback, but only the company settings can change
+func (c *CompanyClient) SetArchived(ctx context.Context, companyID string, archived bool) error {
+	res, err := c.DB.ExecContext(ctx, archiveCompanyQuery, companyID, archived)
+	if err != nil {
+		return errors.WithMessage(err, "could not archive company")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return CompanyNotFoundError
+	}
+	return nil
+}
+
+const isCompanyArchivedQuery = `
+	SELECT ArchivedAt IS NOT NULL
+	FROM COMPANY
+	WHERE Id=$1
+`
+
+// IsArchived checks whether the company is archived
+func (c *CompanyClient) IsArchived(ctx context.Context, companyID string) (archived bool, err error) {
+	err = c.DB.QueryRowContext(ctx, isCompanyArchivedQuery, companyID).Scan(&archived)
+	if err == sql.ErrNoRows {
+		return false, CompanyNotFoundError
+	}
+	if err != nil {
+		return false, errors.WithMessage(err, "could not check if company is archived")
+	}
+	return archived, nil
+}
+
+const createCompanyDeletionQuery = `
+	UPDATE COMPANY as c
+	SET DeletionTokenHash=$3, DeletionExpiresAt=$4
+	WHERE c.Id=$1 AND (
+		c.OwnerId=$2
+		OR (c.OwnerId IS NULL AND EXISTS (
+			SELECT 1
+			FROM USER_COMPANY as uc
+			WHERE uc.CompanyId=c.Id AND uc.UserId=$2 AND uc.RoleId=$5
+		))
+	)
+`
+
+// CreateCompanyDeletion issues the confirmation needed to delete the company.
+// Only the owner can delete a company, or any admin of a company without an
+// owner
+func (c *CompanyClient) CreateCompanyDeletion(ctx context.Context, companyID, userID string) (deletion CompanyDeletion, err error) {
+	deletion.Token, err = utils.NewOpaqueToken()
+	if err != nil {
+		return
+	}
+	deletion.ExpiresAt = time.Now().Add(companyDeletionExpirationTime)
+
+	res, err := c.DB.ExecContext(ctx, createCompanyDeletionQuery, companyID, userID, utils.HashToken(deletion.Token), deletion.ExpiresAt, adminRoleID)
+	if err != nil {
+		return deletion, errors.WithMessage(err, "could not create company deletion")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return CompanyDeletion{}, NotCompanyOwnerError
+	}
+	return deletion, nil
+}
+
+const lockCompanyDeletionQuery = `
+	SELECT Id
+	FROM COMPANY
+	WHERE Id=$1 AND DeletionTokenHash=$2 AND DeletionExpiresAt>NOW()
+	FOR UPDATE
+`
+
+// Everything belonging to the company, children before their parents
+var deleteCompanyQueries = []string{
+	`DELETE FROM NOTIFICATION WHERE CompanyId=$1`,
+	`DELETE FROM MENTION WHERE FeedbackId IN (SELECT Id FROM FEEDBACK WHERE CompanyId=$1)`,
+	`DELETE FROM CLAPS WHERE FeedbackId IN (SELECT Id FROM FEEDBACK WHERE CompanyId=$1)`,
+	`DELETE FROM COMMENTS WHERE FeedbackId IN (SELECT Id FROM FEEDBACK WHERE CompanyId=$1)`,
+	`DELETE FROM FEEDBACK_TEAM WHERE FeedbackId IN (SELECT Id FROM FEEDBACK WHERE CompanyId=$1)`,
+	`DELETE FROM FEEDBACK_STATUS_HISTORY WHERE FeedbackId IN (SELECT Id FROM FEEDBACK WHERE CompanyId=$1)`,
+	`DELETE FROM FEEDBACK_TAGS WHERE FeedbackId IN (SELECT Id FROM FEEDBACK WHERE CompanyId=$1)`,
+	`DELETE FROM FEEDBACK WHERE CompanyId=$1`,
+	`DELETE FROM FEEDBACK_STATUS WHERE CompanyId=$1`,
+	`DELETE FROM FEEDBACK_CATEGORY WHERE CompanyId=$1`,
+	`DELETE FROM FEEDBACK_TAG WHERE CompanyId=$1`,
+	`DELETE FROM USER_TEAM WHERE TeamId IN (SELECT Id FROM TEAM WHERE CompanyId=$1)`,
+	`DELETE FROM TEAM WHERE CompanyId=$1`,
+	`DELETE FROM USER_COMPANY WHERE CompanyId=$1`,
+	`DELETE FROM COMPANY_INVITATIONS WHERE CompanyId=$1`,
+	`DELETE FROM COMPANY_JOIN_REQUESTS WHERE CompanyId=$1`,
+	`DELETE FROM COMPANY_DOMAINS WHERE CompanyId=$1`,
+	`DELETE FROM ROLE_PERMISSION WHERE RoleId IN (SELECT Id FROM ROLE WHERE CompanyId=$1)`,
+	`DELETE FROM ROLE WHERE CompanyId=$1`,
+	`DELETE FROM COMPANY WHERE Id=$1`,
+}
+
+// DeleteCompany permanently deletes the company with its members, teams,
+// feedback, comments, claps and statuses. The deletion must be confirmed with
+// the token from CreateCompanyDeletion, and either everything or nothing is
+// deleted
+func (c *CompanyClient) DeleteCompany(ctx context.Context, companyID, token string) (err error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	var lockedID string
+	err = tx.QueryRowContext(ctx, lockCompanyDeletionQuery, companyID, utils.HashToken(token)).Scan(&lockedID)
+	if err == sql.ErrNoRows {
+		return InvalidDeletionTokenError
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not find company deletion")
+	}
+
+	for _, query := range deleteCompanyQueries {
+		if _, err = tx.ExecContext(ctx, query, companyID); err != nil {
+			return errors.WithMessage(err, "could not delete company")
+		}
+	}
+	return tx.Commit()
+}
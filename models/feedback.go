@@ -5,8 +5,11 @@ import (
 	"database/sql"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 )
 
@@ -21,33 +24,58 @@ const (
 
 type FeedbackClienter interface {
 	CreateFeedback(ctx context.Context, UserID, companyID string, feedback FeedbackRequest) (err error)
-	DeleteFeedback(ctx context.Context, feedbackID string) error
-	UpdateFeedback(ctx context.Context, feedbackID string, feedback FeedbackRequest) error
+	DeleteFeedback(ctx context.Context, companyID, feedbackID string) error
+	UpdateFeedback(ctx context.Context, companyID, feedbackID string, feedback FeedbackRequest) error
 
 	IsUserOwnerOfFeedback(ctx context.Context, feedbackID, userID string) (isOwner bool, err error)
 
-	GetUserFeedback(ctx context.Context, userID string) (Feedbacks, error)
-	GetUserFeedbackwData(ctx context.Context, userID string) (feedbacks Feedbacks, err error)
+	GetUserFeedback(ctx context.Context, userID string, filter FeedbackFilter) (Feedbacks, error)
+	GetUserFeedbackwData(ctx context.Context, userID string, filter FeedbackFilter) (feedbacks Feedbacks, err error)
 
-	GetCompanyFeedbacks(ctx context.Context, companyID string) (feedbacks []Feedback, err error)
-	GetCompanyFeedbackswData(ctx context.Context, companyID string) (feedbacks Feedbacks, err error)
+	GetCompanyFeedbacks(ctx context.Context, companyID, userID string, filter FeedbackFilter) (feedbacks []Feedback, err error)
+	GetCompanyFeedbackswData(ctx context.Context, companyID, userID string, filter FeedbackFilter) (feedbacks Feedbacks, err error)
+	IsFeedbackVisible(ctx context.Context, feedbackID, userID string) (isVisible bool, err error)
+	GetFeedbackTeams(ctx context.Context, feedbacks []Feedback) error
+	GetFeedbackTags(ctx context.Context, feedbacks []Feedback) error
+	GetFeedbackMentions(ctx context.Context, feedbacks []Feedback) error
 
 	ClapFeedback(ctx context.Context, userID string, feedbackID string) error
 	GetUserClaps(ctx context.Context) error
 
-	CommentFeedback(ctx context.Context, comment, userID, feedbackID string) (err error)
-	UpdateComment(ctx context.Context, commentID, comment string)
+	CommentFeedback(ctx context.Context, comment CommentRequest, userID, feedbackID string) (err error)
+	UpdateComment(ctx context.Context, feedbackID, commentID, userID, comment string) error
+	DeleteComment(ctx context.Context, feedbackID, commentID string) error
 	GetUserComments(ctx context.Context, feedbacks []Feedback) error
 
-	SearchFeedbackwData(ctx context.Context, companyID, query string) (feedbacks Feedbacks, err error)
+	SearchFeedbackwData(ctx context.Context, companyID, userID, query string, filter FeedbackFilter) (feedbacks Feedbacks, err error)
+
+	GetFeedbackStatuses(ctx context.Context, companyID string) ([]FeedbackStatus, error)
+	CreateFeedbackStatus(ctx context.Context, companyID string, statusRequest FeedbackStatusRequest) (FeedbackStatus, error)
+	UpdateFeedbackStatus(ctx context.Context, companyID string, statusID int, statusRequest FeedbackStatusRequest) error
+	DeleteFeedbackStatus(ctx context.Context, companyID string, statusID int) error
+	ChangeFeedbackStatus(ctx context.Context, companyID, feedbackID, userID string, statusID int) error
+	GetStatusHistory(ctx context.Context, companyID, feedbackID string) ([]StatusChange, error)
+
+	GetFeedbackCategories(ctx context.Context, companyID string) ([]FeedbackCategory, error)
+	CreateFeedbackCategory(ctx context.Context, companyID string, categoryRequest FeedbackCategoryRequest) (FeedbackCategory, error)
+	UpdateFeedbackCategory(ctx context.Context, companyID string, categoryID int, categoryRequest FeedbackCategoryRequest) error
+	DeleteFeedbackCategory(ctx context.Context, companyID string, categoryID int) error
+	GetCompanyTags(ctx context.Context, companyID string) ([]FeedbackTag, error)
+	CreateFeedbackTag(ctx context.Context, companyID string, tagRequest FeedbackTagRequest) (FeedbackTag, error)
+	UpdateFeedbackTag(ctx context.Context, companyID string, tagID int, tagRequest FeedbackTagRequest) error
+	DeleteFeedbackTag(ctx context.Context, companyID string, tagID int) error
+
+	GetNotifications(ctx context.Context, userID string) ([]Notification, error)
+	ReadNotification(ctx context.Context, userID, notificationID string) error
+	ReadNotifications(ctx context.Context, userID string) error
 }
 
 type FeedbackClient struct {
-	db *sql.DB
+	db *DB
 }
 
 func NewFeedbackClient(db *sql.DB) *FeedbackClient {
-	return &FeedbackClient{db}
+	return &FeedbackClient{NewDB(db)}
 }
 
 type WebSocketRequest struct {
@@ -58,35 +86,92 @@ type WebSocketRequest struct {
 }
 
 type Feedback struct {
-	ID          string    `json:"id"`
-	UserID      string    `json:"userId"`
-	Person      Person    `json:"person"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Comments    []Comment `json:"comments"`
-	Claps       []Clap    `json:"claps"`
-	UpdatedAt   *string   `json:"updatedAt`
+	ID          string            `json:"id"`
+	UserID      string            `json:"userId"`
+	Person      Person            `json:"person"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	Mentions    []Mention         `json:"mentions,omitempty"`
+	Status      string            `json:"status"`
+	Anonymous   bool              `json:"anonymous"`
+	Category    *FeedbackCategory `json:"category,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Comments    []Comment         `json:"comments"`
+	Claps       []Clap            `json:"claps"`
+	TeamIDs     []string          `json:"teamIds,omitempty"`
+	UpdatedAt   *string           `json:"updatedAt"`
 }
 
+// FeedbackRequest creates or updates feedback. Feedback shared with teams is
+// only visible to their members and the company admins. When updating, leaving
+// out the teams, category or tags keeps the ones the feedback has. A category
+// of 0 takes the feedback out of its category. Feedback is posted anonymously
+// when the company allows it, and stays anonymous
 type FeedbackRequest struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	TeamIDs     []string `json:"teamIds"`
+	CategoryID  *int     `json:"categoryId"`
+	Tags        []string `json:"tags"`
+	Anonymous   bool     `json:"anonymous"`
+}
+
+// FeedbackFilter limits the feedback which is listed or searched. Empty
+// fields do not limit anything. Feedback must have all of the tags
+type FeedbackFilter struct {
+	Statuses   []string
+	CategoryID int
+	Tags       []string
+}
+
+// statusNames are the lowercased names of the statuses to filter by
+func (f FeedbackFilter) statusNames() pq.StringArray {
+	names := make(pq.StringArray, 0, len(f.Statuses))
+	for _, status := range f.Statuses {
+		if name := strings.ToLower(strings.TrimSpace(status)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// feedbackHasStatus is the condition for feedback f having one of the
+// statuses in the given query parameter, or any status if it is empty
+func feedbackHasStatus(param string) string {
+	return fmt.Sprintf(`(
+		CARDINALITY(CAST(%[1]s AS VARCHAR[]))=0
+		OR EXISTS (
+			SELECT 1
+			FROM FEEDBACK_STATUS as hs
+			WHERE hs.Id=f.StatusId AND LOWER(hs.Name)=ANY(%[1]s)
+		)
+	)`, param)
 }
 
 type Clap struct {
 	ID         string `json:"id"`
 	UserID     string `json:"userId"`
-	FeedbackID string `json:"feedbackId`
+	FeedbackID string `json:"feedbackId"`
 }
+
+// CommentRequest writes a comment, or a reply to the comment with ParentID
 type CommentRequest struct {
-	Comment string `json:"comment"`
+	Comment  string `json:"comment"`
+	ParentID string `json:"parentId"`
 }
 
+// Comment is a comment on feedback with its replies. Deleted comments are
+// kept without their text and author while they have replies
 type Comment struct {
-	ID         string `json:"id"`
-	FeedbackID string `json:"feedbackId"`
-	Person     Person `json:"person"`
-	Comment    string `json:"comment"`
+	ID         string    `json:"id"`
+	FeedbackID string    `json:"feedbackId"`
+	ParentID   string    `json:"parentId,omitempty"`
+	Person     Person    `json:"person"`
+	Comment    string    `json:"comment"`
+	Mentions   []Mention `json:"mentions,omitempty"`
+	Deleted    bool      `json:"deleted,omitempty"`
+	UpdatedAt  *string   `json:"updatedAt,omitempty"`
+	Replies    []Comment `json:"replies,omitempty"`
 }
 
 type Person struct {
@@ -97,8 +182,8 @@ type Person struct {
 
 var EmptyFeedbackError = errors.New("empty feedbacks")
 
-func (c *FeedbackClient) SearchFeedbackwData(ctx context.Context, companyID, query string) (feedbacks Feedbacks, err error) {
-	feedbacks, err = c.searchFeedback(ctx, companyID, query)
+func (c *FeedbackClient) SearchFeedbackwData(ctx context.Context, companyID, userID, query string, filter FeedbackFilter) (feedbacks Feedbacks, err error) {
+	feedbacks, err = c.searchFeedback(ctx, companyID, userID, query, filter)
 	if err != nil {
 		return
 	}
@@ -116,17 +201,36 @@ func (c *FeedbackClient) SearchFeedbackwData(ctx context.Context, companyID, que
 	if err != nil {
 		return
 	}
+
+	err = c.GetFeedbackTeams(ctx, feedbacks)
+	if err != nil {
+		return
+	}
+
+	err = c.GetFeedbackTags(ctx, feedbacks)
+	if err != nil {
+		return
+	}
+
+	err = c.GetFeedbackMentions(ctx, feedbacks)
+	if err != nil {
+		return
+	}
 	return feedbacks, nil
 }
 
 var searchFeedbackQuery = `
 SELECT 
     f.ID
-	,f.UserID
-	,u.Firstname
-	,u.Lastname
+	,COALESCE(CAST(f.UserID AS VARCHAR),'')
+	,COALESCE(u.Firstname,'')
+	,COALESCE(u.Lastname,'')
 	,f.Title
 	,f.Description
+	,COALESCE(s.Name,'')
+	,f.Anonymous
+	,fc.Id
+	,fc.Name
 	,f.UpdatedAt
 FROM (
     SELECT 
@@ -135,12 +239,16 @@ FROM (
         companyid, 
         title,
         description,
+        statusid,
+        categoryid,
+        anonymous,
         createdat,
         updatedat,
         ts_rank_cd(textsearch, query) AS rank
-    FROM feedback,
+    FROM feedback as f,
     to_tsquery($1) query, to_tsvector(coalesce(description,'') || ' ' || coalesce(title,'')) textsearch
-    WHERE query @@ textsearch AND companyid=$2
+    WHERE query @@ textsearch AND companyid=$2 AND deletedat IS NULL AND ` + feedbackVisibleTo("$3") + ` AND ` + feedbackHasStatus("$4") + `
+        AND ` + feedbackInCategory("$5") + ` AND ` + feedbackHasTags("$6") + `
     ORDER BY rank DESC
 ) as f 
 LEFT JOIN (
@@ -151,28 +259,42 @@ LEFT JOIN (
     FROM USERS
 ) as u 
 ON u.ID=f.UserID
+LEFT JOIN FEEDBACK_STATUS as s
+ON s.Id=f.StatusId
+LEFT JOIN FEEDBACK_CATEGORY as fc
+ON fc.Id=f.CategoryId
+ORDER BY f.rank DESC
 `
 
-func (c *FeedbackClient) searchFeedback(ctx context.Context, companyID, query string) (feedbacks Feedbacks, err error) {
-	rows, err := c.db.QueryContext(ctx, searchFeedbackQuery, query, companyID)
+func (c *FeedbackClient) searchFeedback(ctx context.Context, companyID, userID, query string, filter FeedbackFilter) (feedbacks Feedbacks, err error) {
+	rows, err := c.db.QueryContext(ctx, searchFeedbackQuery, query, companyID, userID, filter.statusNames(), filter.CategoryID, normalizeTags(filter.Tags))
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 	for rows.Next() {
-		var feedback Feedback
-		rows.Scan(
+		var (
+			feedback     Feedback
+			categoryID   sql.NullInt64
+			categoryName sql.NullString
+		)
+		err = rows.Scan(
 			&feedback.ID,
 			&feedback.UserID,
 			&feedback.Person.Firstname,
 			&feedback.Person.Lastname,
 			&feedback.Title,
 			&feedback.Description,
+			&feedback.Status,
+			&feedback.Anonymous,
+			&categoryID,
+			&categoryName,
 			&feedback.UpdatedAt,
 		)
 		if err != nil {
 			return feedbacks, err
 		}
+		feedback.Category = feedbackCategory(categoryID, categoryName)
 		feedbacks = append(feedbacks, feedback)
 	}
 
@@ -188,12 +310,35 @@ func (c *FeedbackClient) searchFeedback(ctx context.Context, companyID, query st
 }
 
 var createFeedback = `
-	INSERT INTO FEEDBACK(UserID,CompanyID,Title,Description)
-	VALUES ( $1, $2, $3, $4 );
+	INSERT INTO FEEDBACK(UserID,CompanyID,Title,Description,StatusId,Anonymous)
+	VALUES ( $1, $2, $3, $4, (SELECT Id FROM FEEDBACK_STATUS WHERE CompanyId=$2 AND Initial), $5 )
+	RETURNING Id
 `
 
-// CreateFeedback inserts the feedback into the database
+const addInitialStatusHistoryQuery = `
+	INSERT INTO FEEDBACK_STATUS_HISTORY(FeedbackId,UserId,ToStatus)
+	SELECT f.Id, f.UserID, s.Name
+	FROM FEEDBACK as f
+	INNER JOIN FEEDBACK_STATUS as s
+	ON s.Id=f.StatusId
+	WHERE f.Id=$1
+`
+
+// CreateFeedback inserts the feedback into the database with the initial
+// status of the company. Only users with a verified email can post feedback,
+// and only to teams and categories in the company. Anonymous feedback has no
+// user, its author is only kept for the author to find it again. Members
+// mentioned in the description are notified
 func (c *FeedbackClient) CreateFeedback(ctx context.Context, UserID, companyID string, feedback FeedbackRequest) (err error) {
+	user, err := utils.FindUserByUserID(ctx, c.db, UserID)
+	if err != nil {
+		return err
+	}
+
+	if !user.EmailVerified {
+		return UnverifiedEmailError
+	}
+
 	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
 		return err
@@ -205,34 +350,61 @@ func (c *FeedbackClient) CreateFeedback(ctx context.Context, UserID, companyID s
 		}
 	}()
 
-	res, err := tx.Exec(createFeedback, UserID, companyID, feedback.Title, feedback.Description)
+	err = checkFeedbackAuthor(ctx, tx, UserID, companyID, feedback.Anonymous)
 	if err != nil {
 		return err
 	}
 
-	err = tx.Commit()
+	author := sql.NullString{String: UserID, Valid: !feedback.Anonymous}
+	var feedbackID int
+	err = tx.QueryRowContext(ctx, createFeedback, author, companyID, feedback.Title, feedback.Description, feedback.Anonymous).Scan(&feedbackID)
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := res.RowsAffected()
+	err = addFeedbackAuthor(ctx, tx, feedbackID, UserID)
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return errors.New("0 rows affected")
+	err = setFeedbackTeams(ctx, tx, feedbackID, companyID, feedback.TeamIDs)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	if feedback.CategoryID != nil {
+		err = setFeedbackCategory(ctx, tx, feedbackID, companyID, *feedback.CategoryID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = setFeedbackTags(ctx, tx, feedbackID, companyID, feedback.Tags)
+	if err != nil {
+		return err
+	}
+
+	err = c.setMentions(ctx, tx, feedbackID, sql.NullInt64{}, UserID, feedback.Anonymous, feedback.Description)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, addInitialStatusHistoryQuery, feedbackID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 var deleteFeedback = `
-	UPDATE FEEDBACK SET DeletedAt=$1 WHERE ID=$2
+	UPDATE FEEDBACK as f SET DeletedAt=$1 WHERE f.ID=$2 AND f.CompanyID=$4
+	RETURNING f.Anonymous AND ` + feedbackWrittenBy("NULLIF($3,'')::INT") + `
 `
 
-// DeleteFeedback deletes feedback written by user
-func (c *FeedbackClient) DeleteFeedback(ctx context.Context, feedbackID string) error {
+// DeleteFeedback deletes feedback of the company. Authors deleting their
+// anonymous feedback are left out of the audit log
+func (c *FeedbackClient) DeleteFeedback(ctx context.Context, companyID, feedbackID string) error {
 	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
 		return err
@@ -244,55 +416,59 @@ func (c *FeedbackClient) DeleteFeedback(ctx context.Context, feedbackID string)
 		}
 	}()
 
-	currentTime := time.Now()
-	res, err := tx.Exec(deleteFeedback, currentTime.Format(time.RFC3339), feedbackID)
+	var anonymousAuthor bool
+	deletedAt := time.Now().Format(time.RFC3339)
+	err = tx.QueryRowContext(ctx, deleteFeedback, deletedAt, feedbackID, utils.ClientInfoFromContext(ctx).UserID, companyID).Scan(&anonymousAuthor)
+	if err == sql.ErrNoRows {
+		return FeedbackNotFoundError
+	}
 	if err != nil {
 		return err
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		return err
+	if anonymousAuthor {
+		ctx = utils.WithoutClientInfo(ctx)
 	}
 
-	rowsAffected, err := res.RowsAffected()
+	err = recordAuditEvent(ctx, tx, AuditEvent{
+		Action:     AuditFeedbackDelete,
+		TargetType: auditTargetFeedback,
+		TargetID:   feedbackID,
+		CompanyID:  companyID,
+		After:      auditState(map[string]string{"deletedAt": deletedAt}),
+	})
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return errors.New("0 rows affected")
-	}
-
-	return nil
+	return tx.Commit()
 }
 
 var isUserOwnerOfFeedbackQuery = `
-	SELECT UserID
-	FROM FEEDBACK 
-	WHERE UserID=$1 AND Id=$2
+	SELECT EXISTS (
+		SELECT 1
+		FROM FEEDBACK_AUTHOR
+		WHERE UserId=$1 AND FeedbackId=$2
+	)
 `
 
+// IsUserOwnerOfFeedback checks whether the user wrote the feedback, also when
+// it was posted anonymously
 func (c *FeedbackClient) IsUserOwnerOfFeedback(ctx context.Context, feedbackID, userID string) (isOwner bool, err error) {
-	var exists bool
-	err = c.db.QueryRowContext(ctx, isUserOwnerOfFeedbackQuery, userID, feedbackID).Scan(&exists)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return false, errors.New("user is not owner of feedback")
-		}
-		return
-	}
+	err = c.db.QueryRowContext(ctx, isUserOwnerOfFeedbackQuery, userID, feedbackID).Scan(&isOwner)
 	return
 }
 
 var updateFeedback = `
 	UPDATE FEEDBACK
 	SET Title=$2,Description=$3,UpdatedAt=$4
-	WHERE ID=$1
+	WHERE ID=$1 AND CompanyID=$5
+	RETURNING Anonymous, COALESCE(CAST((SELECT UserId FROM FEEDBACK_AUTHOR WHERE FeedbackId=$1) AS VARCHAR),'')
 `
 
-// UpdateFeedback updates the database
-func (c *FeedbackClient) UpdateFeedback(ctx context.Context, feedbackID string, feedback FeedbackRequest) error {
+// UpdateFeedback updates the feedback of the company. Only its author can
+// update feedback, and members newly mentioned in the description are notified
+func (c *FeedbackClient) UpdateFeedback(ctx context.Context, companyID, feedbackID string, feedback FeedbackRequest) error {
 	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
 		return err
@@ -304,27 +480,51 @@ func (c *FeedbackClient) UpdateFeedback(ctx context.Context, feedbackID string,
 		}
 	}()
 
-	currentTime := time.Now()
-	res, err := tx.Exec(updateFeedback, feedbackID, feedback.Title, feedback.Description, currentTime.Format(time.RFC3339))
+	fid, err := strconv.Atoi(feedbackID)
 	if err != nil {
 		return err
 	}
 
-	err = tx.Commit()
+	var (
+		authorID  string
+		anonymous bool
+	)
+	currentTime := time.Now()
+	err = tx.QueryRowContext(ctx, updateFeedback, fid, feedback.Title, feedback.Description, currentTime.Format(time.RFC3339), companyID).Scan(&anonymous, &authorID)
+	if err == sql.ErrNoRows {
+		return FeedbackNotFoundError
+	}
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := res.RowsAffected()
-	if err != nil {
-		return err
+	if feedback.TeamIDs != nil {
+		err = setFeedbackTeams(ctx, tx, fid, companyID, feedback.TeamIDs)
+		if err != nil {
+			return err
+		}
 	}
 
-	if rowsAffected == 0 {
-		return errors.New("0 rows affected")
+	if feedback.CategoryID != nil {
+		err = setFeedbackCategory(ctx, tx, fid, companyID, *feedback.CategoryID)
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
+	if feedback.Tags != nil {
+		err = setFeedbackTags(ctx, tx, fid, companyID, feedback.Tags)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.setMentions(ctx, tx, fid, sql.NullInt64{}, authorID, anonymous, feedback.Description)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 var clapFeedback = ` 
@@ -437,14 +637,18 @@ func getClaps(ctx context.Context, tx *sql.Tx, feedbackID string) ([]Clap, error
 	return claps, nil
 }
 
-const getUserFeedback = `
+var getUserFeedback = `
 	SELECT
 	f.ID
-	,f.UserID
-	,u.Firstname
-	,u.Lastname
+	,COALESCE(CAST(f.UserID AS VARCHAR),'')
+	,COALESCE(u.Firstname,'')
+	,COALESCE(u.Lastname,'')
 	,f.Title
 	,f.Description
+	,COALESCE(s.Name,'')
+	,f.Anonymous
+	,fc.Id
+	,fc.Name
 	,f.UpdatedAt
 	FROM FEEDBACK as f
 	LEFT JOIN (
@@ -456,11 +660,17 @@ const getUserFeedback = `
 		WHERE ID=$1
 	) as u 
 	ON u.ID=f.UserID
-	WHERE UserID=$1 AND DeletedAt IS NULL
+	LEFT JOIN FEEDBACK_STATUS as s
+	ON s.Id=f.StatusId
+	LEFT JOIN FEEDBACK_CATEGORY as fc
+	ON fc.Id=f.CategoryId
+	WHERE ` + feedbackWrittenBy("$1") + ` AND f.DeletedAt IS NULL AND ` + feedbackHasStatus("$2") + `
+		AND ` + feedbackInCategory("$3") + ` AND ` + feedbackHasTags("$4") + `
 `
 
-// GetUserFeedback returns the feedback created by the given user
-func (c *FeedbackClient) GetUserFeedback(ctx context.Context, userID string) (Feedbacks, error) {
+// GetUserFeedback returns the feedback created by the given user matching
+// the filter, including the feedback they posted anonymously
+func (c *FeedbackClient) GetUserFeedback(ctx context.Context, userID string, filter FeedbackFilter) (Feedbacks, error) {
 	var feedbacks Feedbacks
 	uid, err := strconv.Atoi(userID)
 	if err != nil {
@@ -477,25 +687,34 @@ func (c *FeedbackClient) GetUserFeedback(ctx context.Context, userID string) (Fe
 		}
 	}()
 
-	rows, err := tx.QueryContext(ctx, getUserFeedback, uid)
+	rows, err := tx.QueryContext(ctx, getUserFeedback, uid, filter.statusNames(), filter.CategoryID, normalizeTags(filter.Tags))
 	if err != nil {
 		return feedbacks, err
 	}
 
 	defer rows.Close()
 	for rows.Next() {
-		var feedback Feedback
+		var (
+			feedback     Feedback
+			categoryID   sql.NullInt64
+			categoryName sql.NullString
+		)
 		err = rows.Scan(&feedback.ID,
 			&feedback.UserID,
 			&feedback.Person.Firstname,
 			&feedback.Person.Lastname,
 			&feedback.Title,
 			&feedback.Description,
+			&feedback.Status,
+			&feedback.Anonymous,
+			&categoryID,
+			&categoryName,
 			&feedback.UpdatedAt,
 		)
 		if err != nil {
 			return feedbacks, err
 		}
+		feedback.Category = feedbackCategory(categoryID, categoryName)
 		feedbacks = append(feedbacks, feedback)
 	}
 
@@ -515,8 +734,10 @@ func (c *FeedbackClient) GetUserFeedback(ctx context.Context, userID string) (Fe
 	return feedbacks, nil
 }
 
-func (c *FeedbackClient) GetCompanyFeedbackswData(ctx context.Context, companyID string) (feedbacks Feedbacks, err error) {
-	feedbacks, err = c.GetCompanyFeedbacks(ctx, companyID)
+// GetCompanyFeedbackswData gets the feedback of the company the user is
+// allowed to see together with its comments, claps, teams, tags and mentions
+func (c *FeedbackClient) GetCompanyFeedbackswData(ctx context.Context, companyID, userID string, filter FeedbackFilter) (feedbacks Feedbacks, err error) {
+	feedbacks, err = c.GetCompanyFeedbacks(ctx, companyID, userID, filter)
 	if err != nil {
 		return
 	}
@@ -530,11 +751,26 @@ func (c *FeedbackClient) GetCompanyFeedbackswData(ctx context.Context, companyID
 	if err != nil {
 		return
 	}
+
+	err = c.GetFeedbackTeams(ctx, feedbacks)
+	if err != nil {
+		return
+	}
+
+	err = c.GetFeedbackTags(ctx, feedbacks)
+	if err != nil {
+		return
+	}
+
+	err = c.GetFeedbackMentions(ctx, feedbacks)
+	if err != nil {
+		return
+	}
 	return feedbacks, nil
 }
 
-func (c *FeedbackClient) GetUserFeedbackwData(ctx context.Context, userID string) (feedbacks Feedbacks, err error) {
-	feedbacks, err = c.GetUserFeedback(ctx, userID)
+func (c *FeedbackClient) GetUserFeedbackwData(ctx context.Context, userID string, filter FeedbackFilter) (feedbacks Feedbacks, err error) {
+	feedbacks, err = c.GetUserFeedback(ctx, userID, filter)
 	if err != nil {
 		return
 	}
@@ -548,17 +784,36 @@ func (c *FeedbackClient) GetUserFeedbackwData(ctx context.Context, userID string
 	if err != nil {
 		return
 	}
+
+	err = c.GetFeedbackTeams(ctx, feedbacks)
+	if err != nil {
+		return
+	}
+
+	err = c.GetFeedbackTags(ctx, feedbacks)
+	if err != nil {
+		return
+	}
+
+	err = c.GetFeedbackMentions(ctx, feedbacks)
+	if err != nil {
+		return
+	}
 	return feedbacks, nil
 }
 
-const getFeedbackQuery = `
+var getFeedbackQuery = `
 	SELECT
 	f.ID
-	,f.UserID
-	,u.Firstname
-	,u.Lastname
+	,COALESCE(CAST(f.UserID AS VARCHAR),'')
+	,COALESCE(u.Firstname,'')
+	,COALESCE(u.Lastname,'')
 	,f.Title
 	,f.Description
+	,COALESCE(s.Name,'')
+	,f.Anonymous
+	,fc.Id
+	,fc.Name
 	,f.UpdatedAt
 	FROM FEEDBACK as f
 	LEFT JOIN (
@@ -569,18 +824,28 @@ const getFeedbackQuery = `
 		FROM USERS
 	) as u 
 	ON u.ID=f.UserID
-	WHERE CompanyID=$1 AND DeletedAt IS NULL
+	LEFT JOIN FEEDBACK_STATUS as s
+	ON s.Id=f.StatusId
+	LEFT JOIN FEEDBACK_CATEGORY as fc
+	ON fc.Id=f.CategoryId
+	WHERE f.CompanyID=$1 AND f.DeletedAt IS NULL AND ` + feedbackVisibleTo("$2") + `
+		AND ` + feedbackHasStatus("$3") + ` AND ` + feedbackInCategory("$4") + ` AND ` + feedbackHasTags("$5") + `
 `
 
-// GetCompanyFeedbacks get the feedbacks for the given companyID
-func (c *FeedbackClient) GetCompanyFeedbacks(ctx context.Context, companyID string) (feedbacks []Feedback, err error) {
-	rows, err := c.db.QueryContext(ctx, getFeedbackQuery, companyID)
+// GetCompanyFeedbacks get the feedbacks for the given companyID that the user
+// is allowed to see and that match the filter
+func (c *FeedbackClient) GetCompanyFeedbacks(ctx context.Context, companyID, userID string, filter FeedbackFilter) (feedbacks []Feedback, err error) {
+	rows, err := c.db.QueryContext(ctx, getFeedbackQuery, companyID, userID, filter.statusNames(), filter.CategoryID, normalizeTags(filter.Tags))
 	if err != nil {
 		return
 	}
 	defer rows.Close()
 
-	var feedback Feedback
+	var (
+		feedback     Feedback
+		categoryID   sql.NullInt64
+		categoryName sql.NullString
+	)
 	for rows.Next() {
 		err = rows.Scan(&feedback.ID,
 			&feedback.UserID,
@@ -588,11 +853,16 @@ func (c *FeedbackClient) GetCompanyFeedbacks(ctx context.Context, companyID stri
 			&feedback.Person.Lastname,
 			&feedback.Title,
 			&feedback.Description,
+			&feedback.Status,
+			&feedback.Anonymous,
+			&categoryID,
+			&categoryName,
 			&feedback.UpdatedAt,
 		)
 		if err != nil {
 			return
 		}
+		feedback.Category = feedbackCategory(categoryID, categoryName)
 		feedbacks = append(feedbacks, feedback)
 	}
 
@@ -610,12 +880,20 @@ func (c *FeedbackClient) GetCompanyFeedbacks(ctx context.Context, companyID stri
 }
 
 const commentFeedback = `
-	INSERT INTO COMMENTS(UserID,FeedbackID,Comment)
-	SELECT $1,$2,$3
+	INSERT INTO COMMENTS(UserID,FeedbackID,Comment,ParentId)
+	SELECT $1,$2,$3,p.Id
+	FROM (SELECT NULLIF($4,'')::INT as Id) as p
+	WHERE p.Id IS NULL OR EXISTS (
+		SELECT 1
+		FROM COMMENTS
+		WHERE Id=p.Id AND FeedbackId=$2 AND DeletedAt IS NULL
+	)
+	RETURNING Id
 `
 
-// CommentFeedback writes a comment on the feedback
-func (c *FeedbackClient) CommentFeedback(ctx context.Context, comment, userID, feedbackID string) (err error) {
+// CommentFeedback writes a comment on the feedback, or a reply to another
+// comment on it. Members mentioned in the comment are notified
+func (c *FeedbackClient) CommentFeedback(ctx context.Context, comment CommentRequest, userID, feedbackID string) (err error) {
 	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
 		return
@@ -638,33 +916,37 @@ func (c *FeedbackClient) CommentFeedback(ctx context.Context, comment, userID, f
 		}
 	}()
 
-	res, err := tx.ExecContext(ctx, commentFeedback, uid, fid, comment)
+	var commentID int64
+	err = tx.QueryRowContext(ctx, commentFeedback, uid, fid, comment.Comment, comment.ParentID).Scan(&commentID)
+	if err == sql.ErrNoRows {
+		return CommentNotFoundError
+	}
 	if err != nil {
 		return errors.WithMessage(err, "could not comment feedback")
 	}
 
-	err = tx.Commit()
+	err = c.setMentions(ctx, tx, fid, sql.NullInt64{Int64: commentID, Valid: true}, userID, false, comment.Comment)
 	if err != nil {
 		return err
 	}
 
-	nrows, err := res.RowsAffected()
-	if err != nil {
-		return
-	}
-	if nrows == 0 {
-		return errors.New("0 rows affected")
-	}
-
-	return
+	return tx.Commit()
 }
 
+const lockCommentQuery = `
+	SELECT UserId, FeedbackId
+	FROM COMMENTS
+	WHERE Id=$1 AND FeedbackId=$2 AND DeletedAt IS NULL
+	FOR UPDATE
+`
+
 const updateComment = `
 UPDATE COMMENTS SET Comment=$2,UpdatedAt=$3 WHERE ID=$1
 `
 
-// UpdateComment updates a comment based on the comment ID
-func (c *FeedbackClient) UpdateComment(ctx context.Context, commentID, comment string) error {
+// UpdateComment updates a comment on the feedback based on the comment ID.
+// Only its author can change it, and members newly mentioned are notified
+func (c *FeedbackClient) UpdateComment(ctx context.Context, feedbackID, commentID, userID, comment string) (err error) {
 	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
 		return err
@@ -679,28 +961,36 @@ func (c *FeedbackClient) UpdateComment(ctx context.Context, commentID, comment s
 
 	cid, err := strconv.Atoi(commentID)
 	if err != nil {
-		return err
+		return CommentNotFoundError
+	}
+
+	var (
+		authorID string
+		fid      int
+	)
+	err = tx.QueryRowContext(ctx, lockCommentQuery, cid, feedbackID).Scan(&authorID, &fid)
+	if err == sql.ErrNoRows {
+		return CommentNotFoundError
 	}
-	currentTime := time.Now()
-	res, err := tx.ExecContext(ctx, updateComment, cid, comment, currentTime.Format(time.RFC3339))
 	if err != nil {
-		return err
+		return errors.WithMessage(err, "could not find comment")
+	}
+	if authorID != userID {
+		return NotCommentAuthorError
 	}
 
-	err = tx.Commit()
+	currentTime := time.Now()
+	_, err = tx.ExecContext(ctx, updateComment, cid, comment, currentTime.Format(time.RFC3339))
 	if err != nil {
 		return err
 	}
 
-	nrows, err := res.RowsAffected()
+	err = c.setMentions(ctx, tx, fid, sql.NullInt64{Int64: int64(cid), Valid: true}, userID, false, comment)
 	if err != nil {
 		return err
 	}
-	if nrows == 0 {
-		return errors.New("0 rows affected")
-	}
 
-	return nil
+	return tx.Commit()
 }
 
 func (c *FeedbackClient) GetUserComments(ctx context.Context, feedbacks []Feedback) error {
@@ -734,15 +1024,19 @@ func (c *FeedbackClient) GetUserComments(ctx context.Context, feedbacks []Feedba
 const getCommentsQuery = `
 SELECT 
 c.ID
-,c.comment
+,CASE WHEN c.DeletedAt IS NULL THEN c.comment ELSE '' END
 ,c.FeedbackId 
-,c.userid
-,u.firstname
-,u.lastname
+,COALESCE(CAST(c.ParentId AS VARCHAR),'')
+,CASE WHEN c.DeletedAt IS NULL THEN CAST(c.userid AS VARCHAR) ELSE '' END
+,CASE WHEN c.DeletedAt IS NULL THEN u.firstname ELSE '' END
+,CASE WHEN c.DeletedAt IS NULL THEN u.lastname ELSE '' END
+,c.DeletedAt IS NOT NULL
+,c.UpdatedAt
 FROM comments as c
 LEFT JOIN 
 (SELECT id,firstname,lastname FROM users ) as u on u.id = c.userid 
 WHERE feedbackid=$1
+ORDER BY c.ID
 `
 
 func getComments(ctx context.Context, tx *sql.Tx, feedbackID string) ([]Comment, error) {
@@ -759,12 +1053,13 @@ func getComments(ctx context.Context, tx *sql.Tx, feedbackID string) ([]Comment,
 	defer rows.Close()
 	for rows.Next() {
 		var comment Comment
-		if err := rows.Scan(&comment.ID, &comment.Comment, &comment.FeedbackID, &comment.Person.ID, &comment.Person.Firstname, &comment.Person.Lastname); err != nil {
+		if err := rows.Scan(&comment.ID, &comment.Comment, &comment.FeedbackID, &comment.ParentID, &comment.Person.ID, &comment.Person.Firstname, &comment.Person.Lastname,
+			&comment.Deleted, &comment.UpdatedAt); err != nil {
 			return comments, err
 		}
 
 		comments = append(comments, comment)
 	}
 
-	return comments, nil
+	return commentTree(comments), nil
 }
@@ -0,0 +1,85 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Authors can post this much feedback to a company in the window, whether it
+// is anonymous or not
+const (
+	feedbackRateLimit  = 20
+	feedbackRateWindow = time.Hour
+)
+
+var (
+	AnonymousFeedbackDisabledError = errors.New("the company does not allow anonymous feedback")
+	FeedbackRateLimitError         = errors.New("too much feedback posted, try again later")
+)
+
+const allowsAnonymousFeedbackQuery = `
+	SELECT AllowAnonymousFeedback
+	FROM COMPANY
+	WHERE Id=$1
+`
+
+const countRecentFeedbackQuery = `
+	SELECT COUNT(*)
+	FROM FEEDBACK_AUTHOR as a
+	INNER JOIN FEEDBACK as f
+	ON f.Id=a.FeedbackId
+	WHERE a.UserId=$1 AND f.CompanyID=$2 AND f.CreatedAt > NOW() - $3 * INTERVAL '1 second'
+`
+
+// checkFeedbackAuthor makes sure the user may post the feedback to the
+// company, anonymously if asked for
+func checkFeedbackAuthor(ctx context.Context, tx *sql.Tx, userID, companyID string, anonymous bool) error {
+	if anonymous {
+		var allowed bool
+		err := tx.QueryRowContext(ctx, allowsAnonymousFeedbackQuery, companyID).Scan(&allowed)
+		if err != nil && err != sql.ErrNoRows {
+			return errors.WithMessage(err, "could not find company")
+		}
+		if !allowed {
+			return AnonymousFeedbackDisabledError
+		}
+	}
+
+	var posted int
+	err := tx.QueryRowContext(ctx, countRecentFeedbackQuery, userID, companyID, feedbackRateWindow.Seconds()).Scan(&posted)
+	if err != nil {
+		return errors.WithMessage(err, "could not count recent feedback")
+	}
+	if posted >= feedbackRateLimit {
+		return FeedbackRateLimitError
+	}
+	return nil
+}
+
+const addFeedbackAuthorQuery = `
+	INSERT INTO FEEDBACK_AUTHOR(FeedbackId,UserId)
+	VALUES ($1,$2)
+`
+
+// addFeedbackAuthor records who wrote the feedback. Only the author can read
+// it back, so it is kept for anonymous feedback as well
+func addFeedbackAuthor(ctx context.Context, tx *sql.Tx, feedbackID int, userID string) error {
+	if _, err := tx.ExecContext(ctx, addFeedbackAuthorQuery, feedbackID, userID); err != nil {
+		return errors.WithMessage(err, "could not add feedback author")
+	}
+	return nil
+}
+
+// feedbackWrittenBy is the condition for feedback f being written by the user
+// in the given query parameter
+func feedbackWrittenBy(param string) string {
+	return fmt.Sprintf(`EXISTS (
+		SELECT 1
+		FROM FEEDBACK_AUTHOR as wa
+		WHERE wa.FeedbackId=f.Id AND wa.UserId=%s
+	)`, param)
+}
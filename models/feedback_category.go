@@ -0,0 +1,461 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+const (
+	maxTagLength       = 32
+	maxTagsPerFeedback = 10
+)
+
+var (
+	CategoryNotFoundError        = errors.New("category not found")
+	CategoryExistsError          = errors.New("the company already has a category with that name")
+	InvalidFeedbackCategoryError = errors.New("feedback can only be put in categories of the company")
+	TagNotFoundError             = errors.New("tag not found")
+	TagExistsError               = errors.New("the company already has a tag with that name")
+	InvalidTagError              = errors.New(fmt.Sprintf("feedback can have at most %d tags of 1 to %d characters", maxTagsPerFeedback, maxTagLength))
+)
+
+// FeedbackCategory groups the feedback of a company. Feedback is in at most
+// one category
+type FeedbackCategory struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// FeedbackCategoryRequest creates or renames a category
+type FeedbackCategoryRequest struct {
+	Name string `json:"name"`
+}
+
+func (r *FeedbackCategoryRequest) Valid() error {
+	errs := make(FieldErrors)
+
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" || len(r.Name) > 64 {
+		errs["name"] = "name must be between 1 and 64 characters"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// FeedbackTag is a free-form label of feedback. Tags are lowercase and
+// created when feedback is first tagged with them
+type FeedbackTag struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// FeedbackTagRequest creates or renames a tag
+type FeedbackTagRequest struct {
+	Name string `json:"name"`
+}
+
+func (r *FeedbackTagRequest) Valid() error {
+	errs := make(FieldErrors)
+
+	r.Name = normalizeTag(r.Name)
+	if r.Name == "" || len(r.Name) > maxTagLength {
+		errs["name"] = fmt.Sprintf("name must be between 1 and %d characters", maxTagLength)
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// normalizeTags lowercases the tags and removes empty and repeated ones
+func normalizeTags(tags []string) pq.StringArray {
+	names := make(pq.StringArray, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		if name := normalizeTag(tag); name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// TagCount is the number of listed feedback with the tag
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+// TagCounts counts the tags of the feedback, most used first
+func (feedbacks Feedbacks) TagCounts() []TagCount {
+	counts := make(map[string]int)
+	for _, feedback := range feedbacks {
+		for _, tag := range feedback.Tags {
+			counts[tag]++
+		}
+	}
+
+	tagCounts := make([]TagCount, 0, len(counts))
+	for tag, count := range counts {
+		tagCounts = append(tagCounts, TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(tagCounts, func(i, j int) bool {
+		if tagCounts[i].Count != tagCounts[j].Count {
+			return tagCounts[i].Count > tagCounts[j].Count
+		}
+		return tagCounts[i].Tag < tagCounts[j].Tag
+	})
+	return tagCounts
+}
+
+// FeedbackResults is listed or searched feedback with the counts of its tags
+type FeedbackResults struct {
+	Feedbacks Feedbacks  `json:"feedbacks"`
+	Tags      []TagCount `json:"tags"`
+}
+
+func NewFeedbackResults(feedbacks Feedbacks) FeedbackResults {
+	if feedbacks == nil {
+		feedbacks = Feedbacks{}
+	}
+	return FeedbackResults{Feedbacks: feedbacks, Tags: feedbacks.TagCounts()}
+}
+
+// feedbackInCategory is the condition for feedback f being in the category in
+// the given query parameter, or in any category if it is 0
+func feedbackInCategory(param string) string {
+	return fmt.Sprintf(`(CAST(%[1]s AS INT)=0 OR f.CategoryId=%[1]s)`, param)
+}
+
+// feedbackHasTags is the condition for feedback f having all the tags in the
+// given query parameter
+func feedbackHasTags(param string) string {
+	return fmt.Sprintf(`NOT EXISTS (
+		SELECT 1
+		FROM UNNEST(CAST(%s AS VARCHAR[])) as wt (Name)
+		WHERE NOT EXISTS (
+			SELECT 1
+			FROM FEEDBACK_TAGS as hft
+			INNER JOIN FEEDBACK_TAG as ht
+			ON ht.Id=hft.TagId
+			WHERE hft.FeedbackId=f.Id AND ht.Name=wt.Name
+		)
+	)`, param)
+}
+
+// feedbackCategory is the category scanned from the left joined category
+// columns of feedback
+func feedbackCategory(id sql.NullInt64, name sql.NullString) *FeedbackCategory {
+	if !id.Valid {
+		return nil
+	}
+	return &FeedbackCategory{ID: int(id.Int64), Name: name.String}
+}
+
+const setFeedbackCategoryQuery = `
+	UPDATE FEEDBACK
+	SET CategoryId=NULLIF(CAST($3 AS INT),0)
+	WHERE Id=$1 AND (
+		CAST($3 AS INT)=0
+		OR EXISTS (
+			SELECT 1
+			FROM FEEDBACK_CATEGORY
+			WHERE Id=$3 AND CompanyId=$2
+		)
+	)
+`
+
+// setFeedbackCategory puts the feedback in a category of the company, or in
+// no category when it is 0
+func setFeedbackCategory(ctx context.Context, tx *sql.Tx, feedbackID int, companyID string, categoryID int) error {
+	res, err := tx.ExecContext(ctx, setFeedbackCategoryQuery, feedbackID, companyID, categoryID)
+	if err != nil {
+		return errors.WithMessage(err, "could not set feedback category")
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		return InvalidFeedbackCategoryError
+	}
+	return nil
+}
+
+const addTagsQuery = `
+	INSERT INTO FEEDBACK_TAG(CompanyId,Name)
+	SELECT $1, UNNEST(CAST($2 AS VARCHAR[]))
+	ON CONFLICT (CompanyId,Name) DO NOTHING
+`
+
+const deleteFeedbackTagsQuery = `
+	DELETE FROM FEEDBACK_TAGS
+	WHERE FeedbackId=$1
+`
+
+const addFeedbackTagsQuery = `
+	INSERT INTO FEEDBACK_TAGS(FeedbackId,TagId)
+	SELECT $1, t.Id
+	FROM FEEDBACK_TAG as t
+	WHERE t.CompanyId=$2 AND t.Name=ANY($3)
+`
+
+// setFeedbackTags replaces the tags of the feedback. Tags the company does not
+// have yet are added to it
+func setFeedbackTags(ctx context.Context, tx *sql.Tx, feedbackID int, companyID string, tags []string) error {
+	names := normalizeTags(tags)
+	if len(names) > maxTagsPerFeedback {
+		return InvalidTagError
+	}
+	for _, name := range names {
+		if len(name) > maxTagLength {
+			return InvalidTagError
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, deleteFeedbackTagsQuery, feedbackID); err != nil {
+		return errors.WithMessage(err, "could not remove feedback tags")
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, addTagsQuery, companyID, names); err != nil {
+		return errors.WithMessage(err, "could not add tags")
+	}
+	if _, err := tx.ExecContext(ctx, addFeedbackTagsQuery, feedbackID, companyID, names); err != nil {
+		return errors.WithMessage(err, "could not add feedback tags")
+	}
+	return nil
+}
+
+const getFeedbackTagsQuery = `
+	SELECT ft.FeedbackId, t.Name
+	FROM FEEDBACK_TAGS as ft
+	INNER JOIN FEEDBACK_TAG as t
+	ON t.Id=ft.TagId
+	WHERE ft.FeedbackId = ANY($1::int[])
+	ORDER BY t.Name
+`
+
+// GetFeedbackTags adds the tags of each feedback
+func (c *FeedbackClient) GetFeedbackTags(ctx context.Context, feedbacks []Feedback) error {
+	if len(feedbacks) == 0 {
+		return nil
+	}
+
+	feedbackIdx := make(map[string]int, len(feedbacks))
+	ids := make([]string, 0, len(feedbacks))
+	for idx, feedback := range feedbacks {
+		feedbackIdx[feedback.ID] = idx
+		ids = append(ids, feedback.ID)
+	}
+
+	rows, err := c.db.QueryContext(ctx, getFeedbackTagsQuery, pq.Array(ids))
+	if err != nil {
+		return errors.WithMessage(err, "could not get feedback tags")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var feedbackID, tag string
+		if err = rows.Scan(&feedbackID, &tag); err != nil {
+			return err
+		}
+		if idx, ok := feedbackIdx[feedbackID]; ok {
+			feedbacks[idx].Tags = append(feedbacks[idx].Tags, tag)
+		}
+	}
+	return rows.Err()
+}
+
+const getFeedbackCategoriesQuery = `
+	SELECT Id, Name
+	FROM FEEDBACK_CATEGORY
+	WHERE CompanyId=$1
+	ORDER BY LOWER(Name), Id
+`
+
+// GetFeedbackCategories lists the categories of the company by name
+func (c *FeedbackClient) GetFeedbackCategories(ctx context.Context, companyID string) (categories []FeedbackCategory, err error) {
+	rows, err := c.db.QueryContext(ctx, getFeedbackCategoriesQuery, companyID)
+	if err != nil {
+		return categories, errors.WithMessage(err, "could not get categories")
+	}
+	defer rows.Close()
+
+	categories = []FeedbackCategory{}
+	for rows.Next() {
+		var category FeedbackCategory
+		if err = rows.Scan(&category.ID, &category.Name); err != nil {
+			return categories, errors.WithStack(err)
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+const createFeedbackCategoryQuery = `
+	INSERT INTO FEEDBACK_CATEGORY(CompanyId,Name)
+	VALUES ($1,$2)
+	RETURNING Id
+`
+
+// CreateFeedbackCategory adds a category to the company
+func (c *FeedbackClient) CreateFeedbackCategory(ctx context.Context, companyID string, categoryRequest FeedbackCategoryRequest) (category FeedbackCategory, err error) {
+	err = c.db.QueryRowContext(ctx, createFeedbackCategoryQuery, companyID, categoryRequest.Name).Scan(&category.ID)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return category, CategoryExistsError
+	}
+	if err != nil {
+		return category, errors.WithMessage(err, "could not create category")
+	}
+
+	category.Name = categoryRequest.Name
+	return category, nil
+}
+
+const updateFeedbackCategoryQuery = `
+	UPDATE FEEDBACK_CATEGORY
+	SET Name=$3
+	WHERE Id=$1 AND CompanyId=$2
+`
+
+// UpdateFeedbackCategory renames the category of the company
+func (c *FeedbackClient) UpdateFeedbackCategory(ctx context.Context, companyID string, categoryID int, categoryRequest FeedbackCategoryRequest) error {
+	res, err := c.db.ExecContext(ctx, updateFeedbackCategoryQuery, categoryID, companyID, categoryRequest.Name)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return CategoryExistsError
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not update category")
+	}
+	return categoryAffected(res)
+}
+
+const deleteFeedbackCategoryQuery = `
+	DELETE FROM FEEDBACK_CATEGORY
+	WHERE Id=$1 AND CompanyId=$2
+`
+
+// DeleteFeedbackCategory deletes the category of the company. Its feedback is
+// left without a category
+func (c *FeedbackClient) DeleteFeedbackCategory(ctx context.Context, companyID string, categoryID int) error {
+	res, err := c.db.ExecContext(ctx, deleteFeedbackCategoryQuery, categoryID, companyID)
+	if err != nil {
+		return errors.WithMessage(err, "could not delete category")
+	}
+	return categoryAffected(res)
+}
+
+func categoryAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return CategoryNotFoundError
+	}
+	return nil
+}
+
+const getCompanyTagsQuery = `
+	SELECT Id, Name
+	FROM FEEDBACK_TAG
+	WHERE CompanyId=$1
+	ORDER BY Name
+`
+
+// GetCompanyTags lists the tags of the company by name
+func (c *FeedbackClient) GetCompanyTags(ctx context.Context, companyID string) (tags []FeedbackTag, err error) {
+	rows, err := c.db.QueryContext(ctx, getCompanyTagsQuery, companyID)
+	if err != nil {
+		return tags, errors.WithMessage(err, "could not get tags")
+	}
+	defer rows.Close()
+
+	tags = []FeedbackTag{}
+	for rows.Next() {
+		var tag FeedbackTag
+		if err = rows.Scan(&tag.ID, &tag.Name); err != nil {
+			return tags, errors.WithStack(err)
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+const createFeedbackTagQuery = `
+	INSERT INTO FEEDBACK_TAG(CompanyId,Name)
+	VALUES ($1,$2)
+	RETURNING Id
+`
+
+// CreateFeedbackTag adds a tag to the company before any feedback has it
+func (c *FeedbackClient) CreateFeedbackTag(ctx context.Context, companyID string, tagRequest FeedbackTagRequest) (tag FeedbackTag, err error) {
+	err = c.db.QueryRowContext(ctx, createFeedbackTagQuery, companyID, tagRequest.Name).Scan(&tag.ID)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return tag, TagExistsError
+	}
+	if err != nil {
+		return tag, errors.WithMessage(err, "could not create tag")
+	}
+
+	tag.Name = tagRequest.Name
+	return tag, nil
+}
+
+const updateFeedbackTagQuery = `
+	UPDATE FEEDBACK_TAG
+	SET Name=$3
+	WHERE Id=$1 AND CompanyId=$2
+`
+
+// UpdateFeedbackTag renames the tag of the company on all its feedback
+func (c *FeedbackClient) UpdateFeedbackTag(ctx context.Context, companyID string, tagID int, tagRequest FeedbackTagRequest) error {
+	res, err := c.db.ExecContext(ctx, updateFeedbackTagQuery, tagID, companyID, tagRequest.Name)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return TagExistsError
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not update tag")
+	}
+	return tagAffected(res)
+}
+
+const deleteFeedbackTagQuery = `
+	DELETE FROM FEEDBACK_TAG
+	WHERE Id=$1 AND CompanyId=$2
+`
+
+// DeleteFeedbackTag deletes the tag of the company and removes it from its
+// feedback
+func (c *FeedbackClient) DeleteFeedbackTag(ctx context.Context, companyID string, tagID int) error {
+	res, err := c.db.ExecContext(ctx, deleteFeedbackTagQuery, tagID, companyID)
+	if err != nil {
+		return errors.WithMessage(err, "could not delete tag")
+	}
+	return tagAffected(res)
+}
+
+func tagAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return TagNotFoundError
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxCommentDepth is how deeply replies are nested. Replies to comments at
+// the deepest level are listed next to them instead
+const maxCommentDepth = 4
+
+var (
+	CommentNotFoundError  = errors.New("comment not found")
+	NotCommentAuthorError = errors.New("only the author can change the comment")
+)
+
+// commentTree nests the comments of feedback under the comments they reply
+// to. The comments must be ordered oldest first, as they are by id. Deleted
+// comments are only kept as placeholders for replies which are not deleted
+func commentTree(comments []Comment) []Comment {
+	replies := make(map[string][]Comment)
+	order := make(map[string]int, len(comments))
+	for idx, comment := range comments {
+		replies[comment.ParentID] = append(replies[comment.ParentID], comment)
+		order[comment.ID] = idx
+	}
+
+	// flatten lists all the replies below the comment for the deepest level
+	var flatten func(parentID string) []Comment
+	flatten = func(parentID string) []Comment {
+		var flat []Comment
+		for _, reply := range replies[parentID] {
+			if !reply.Deleted {
+				flat = append(flat, reply)
+			}
+			flat = append(flat, flatten(reply.ID)...)
+		}
+		return flat
+	}
+
+	var nest func(parentID string, depth int) []Comment
+	nest = func(parentID string, depth int) []Comment {
+		nested := make([]Comment, 0, len(replies[parentID]))
+		for _, comment := range replies[parentID] {
+			if depth == maxCommentDepth {
+				if !comment.Deleted {
+					nested = append(nested, comment)
+				}
+				below := flatten(comment.ID)
+				sort.Slice(below, func(i, j int) bool { return order[below[i].ID] < order[below[j].ID] })
+				nested = append(nested, below...)
+				continue
+			}
+
+			if children := nest(comment.ID, depth+1); len(children) > 0 {
+				comment.Replies = children
+			}
+			if comment.Deleted && comment.Replies == nil {
+				continue
+			}
+			nested = append(nested, comment)
+		}
+		return nested
+	}
+
+	return nest("", 1)
+}
+
+const deleteCommentQuery = `
+	UPDATE COMMENTS
+	SET DeletedAt=$3
+	WHERE Id=$1 AND FeedbackId=$2 AND DeletedAt IS NULL
+	RETURNING (SELECT CompanyID FROM FEEDBACK WHERE Id=$2)
+`
+
+// DeleteComment deletes the comment on the feedback. Its replies are kept
+func (c *FeedbackClient) DeleteComment(ctx context.Context, feedbackID, commentID string) (err error) {
+	cid, err := strconv.Atoi(commentID)
+	if err != nil {
+		return CommentNotFoundError
+	}
+
+	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	var companyID string
+	deletedAt := time.Now().Format(time.RFC3339)
+	err = tx.QueryRowContext(ctx, deleteCommentQuery, cid, feedbackID, deletedAt).Scan(&companyID)
+	if err == sql.ErrNoRows {
+		return CommentNotFoundError
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not delete comment")
+	}
+
+	err = recordAuditEvent(ctx, tx, AuditEvent{
+		Action:     AuditCommentDelete,
+		TargetType: auditTargetComment,
+		TargetID:   commentID,
+		CompanyID:  companyID,
+		After:      auditState(map[string]string{"feedbackId": feedbackID, "deletedAt": deletedAt}),
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,467 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var (
+	FeedbackNotFoundError        = errors.New("feedback not found")
+	StatusNotFoundError          = errors.New("status not found")
+	StatusExistsError            = errors.New("the company already has a status with that name")
+	StatusInUseError             = errors.New("the status is still given to feedback")
+	InitialStatusError           = errors.New("the company needs an initial status, make another status initial instead")
+	InvalidStatusTransitionError = errors.New("the feedback cannot be moved to that status from its current status")
+	UnknownTransitionError       = errors.New("transitions can only be made to other statuses of the company")
+)
+
+// FeedbackStatus is a step in the workflow of the feedback of a company. New
+// feedback gets the initial status and moves on to the statuses listed in
+// Transitions
+type FeedbackStatus struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Initial     bool   `json:"initial"`
+	Transitions []int  `json:"transitions"`
+}
+
+// FeedbackStatusRequest creates or updates a status of a company. Making a
+// status initial takes it from the status which was initial before
+type FeedbackStatusRequest struct {
+	Name        string `json:"name"`
+	Initial     bool   `json:"initial"`
+	Transitions []int  `json:"transitions"`
+}
+
+func (r *FeedbackStatusRequest) Valid() error {
+	errs := make(FieldErrors)
+
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" || len(r.Name) > 64 {
+		errs["name"] = "name must be between 1 and 64 characters"
+	}
+
+	transitions := make([]int, 0, len(r.Transitions))
+	seen := make(map[int]bool)
+	for _, statusID := range r.Transitions {
+		if !seen[statusID] {
+			seen[statusID] = true
+			transitions = append(transitions, statusID)
+		}
+	}
+	r.Transitions = transitions
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// StatusChangeRequest moves feedback to another status
+type StatusChangeRequest struct {
+	StatusID int `json:"statusId"`
+}
+
+// StatusChange is a move of feedback from one status to another. From is
+// empty for the first status of feedback, and Person is empty when anonymous
+// feedback was posted
+type StatusChange struct {
+	ID         string    `json:"id"`
+	FeedbackID string    `json:"feedbackId"`
+	Person     Person    `json:"person"`
+	From       string    `json:"from,omitempty"`
+	To         string    `json:"to"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+const getFeedbackStatusesQuery = `
+	SELECT
+	s.Id
+	,s.Name
+	,s.Initial
+	,ARRAY(
+		SELECT t.ToStatusId
+		FROM FEEDBACK_STATUS_TRANSITION as t
+		INNER JOIN FEEDBACK_STATUS as ts
+		ON ts.Id=t.ToStatusId
+		WHERE t.FromStatusId=s.Id
+		ORDER BY ts.Position, ts.Id
+	)
+	FROM FEEDBACK_STATUS as s
+	WHERE s.CompanyId=$1
+	ORDER BY s.Position, s.Id
+`
+
+// GetFeedbackStatuses lists the statuses of the company in the order of its
+// workflow
+func (c *FeedbackClient) GetFeedbackStatuses(ctx context.Context, companyID string) (statuses []FeedbackStatus, err error) {
+	rows, err := c.db.QueryContext(ctx, getFeedbackStatusesQuery, companyID)
+	if err != nil {
+		return statuses, errors.WithMessage(err, "could not get statuses")
+	}
+	defer rows.Close()
+
+	statuses = []FeedbackStatus{}
+	for rows.Next() {
+		var (
+			status      FeedbackStatus
+			transitions []int64
+		)
+		if err = rows.Scan(&status.ID, &status.Name, &status.Initial, pq.Array(&transitions)); err != nil {
+			return statuses, errors.WithStack(err)
+		}
+		status.Transitions = make([]int, 0, len(transitions))
+		for _, statusID := range transitions {
+			status.Transitions = append(status.Transitions, int(statusID))
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, rows.Err()
+}
+
+const unsetInitialStatusQuery = `
+	UPDATE FEEDBACK_STATUS
+	SET Initial=FALSE
+	WHERE CompanyId=$1 AND Initial AND Id<>$2
+`
+
+const setInitialStatusQuery = `
+	UPDATE FEEDBACK_STATUS
+	SET Initial=TRUE
+	WHERE CompanyId=$1 AND Id=$2
+`
+
+func setInitialStatus(ctx context.Context, tx *sql.Tx, companyID string, statusID int) error {
+	if _, err := tx.ExecContext(ctx, unsetInitialStatusQuery, companyID, statusID); err != nil {
+		return errors.WithMessage(err, "could not unset initial status")
+	}
+	if _, err := tx.ExecContext(ctx, setInitialStatusQuery, companyID, statusID); err != nil {
+		return errors.WithMessage(err, "could not set initial status")
+	}
+	return nil
+}
+
+const deleteStatusTransitionsQuery = `
+	DELETE FROM FEEDBACK_STATUS_TRANSITION
+	WHERE FromStatusId=$1
+`
+
+const addStatusTransitionsQuery = `
+	INSERT INTO FEEDBACK_STATUS_TRANSITION(FromStatusId,ToStatusId)
+	SELECT $1, s.Id
+	FROM FEEDBACK_STATUS as s
+	WHERE s.CompanyId=$2 AND s.Id<>$1 AND s.Id=ANY($3)
+`
+
+// setStatusTransitions replaces the statuses feedback can move to from the
+// status. They must be other statuses of the company
+func setStatusTransitions(ctx context.Context, tx *sql.Tx, companyID string, statusID int, transitions []int) error {
+	if _, err := tx.ExecContext(ctx, deleteStatusTransitionsQuery, statusID); err != nil {
+		return errors.WithMessage(err, "could not remove status transitions")
+	}
+
+	ids := make([]int64, 0, len(transitions))
+	for _, transition := range transitions {
+		ids = append(ids, int64(transition))
+	}
+	res, err := tx.ExecContext(ctx, addStatusTransitionsQuery, statusID, companyID, pq.Array(ids))
+	if err != nil {
+		return errors.WithMessage(err, "could not add status transitions")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if int(affected) != len(transitions) {
+		return UnknownTransitionError
+	}
+	return nil
+}
+
+const createFeedbackStatusQuery = `
+	INSERT INTO FEEDBACK_STATUS(CompanyId,Name,Position)
+	SELECT CAST($1 AS INT), CAST($2 AS VARCHAR), COALESCE(MAX(Position),0)+1
+	FROM FEEDBACK_STATUS
+	WHERE CompanyId=$1
+	RETURNING Id
+`
+
+// CreateFeedbackStatus adds a status to the end of the workflow of the
+// company
+func (c *FeedbackClient) CreateFeedbackStatus(ctx context.Context, companyID string, statusRequest FeedbackStatusRequest) (status FeedbackStatus, err error) {
+	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	err = tx.QueryRowContext(ctx, createFeedbackStatusQuery, companyID, statusRequest.Name).Scan(&status.ID)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return status, StatusExistsError
+	}
+	if err != nil {
+		return status, errors.WithMessage(err, "could not create status")
+	}
+
+	if statusRequest.Initial {
+		if err = setInitialStatus(ctx, tx, companyID, status.ID); err != nil {
+			return
+		}
+	}
+
+	if err = setStatusTransitions(ctx, tx, companyID, status.ID, statusRequest.Transitions); err != nil {
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	status.Name = statusRequest.Name
+	status.Initial = statusRequest.Initial
+	status.Transitions = statusRequest.Transitions
+	return status, nil
+}
+
+const updateFeedbackStatusQuery = `
+	UPDATE FEEDBACK_STATUS
+	SET Name=$3
+	WHERE Id=$1 AND CompanyId=$2
+	RETURNING Initial
+`
+
+// UpdateFeedbackStatus renames the status of the company and replaces its
+// transitions. The initial status stays initial until another status is
+// made initial
+func (c *FeedbackClient) UpdateFeedbackStatus(ctx context.Context, companyID string, statusID int, statusRequest FeedbackStatusRequest) (err error) {
+	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	var initial bool
+	err = tx.QueryRowContext(ctx, updateFeedbackStatusQuery, statusID, companyID, statusRequest.Name).Scan(&initial)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return StatusExistsError
+	}
+	if err == sql.ErrNoRows {
+		return StatusNotFoundError
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not update status")
+	}
+
+	if initial && !statusRequest.Initial {
+		return InitialStatusError
+	}
+	if statusRequest.Initial && !initial {
+		if err = setInitialStatus(ctx, tx, companyID, statusID); err != nil {
+			return
+		}
+	}
+
+	if err = setStatusTransitions(ctx, tx, companyID, statusID, statusRequest.Transitions); err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+const lockFeedbackStatusQuery = `
+	SELECT
+	Initial
+	,EXISTS (
+		SELECT 1
+		FROM FEEDBACK
+		WHERE StatusId=$1
+	)
+	FROM FEEDBACK_STATUS
+	WHERE Id=$1 AND CompanyId=$2
+	FOR UPDATE
+`
+
+const deleteFeedbackStatusQuery = `
+	DELETE FROM FEEDBACK_STATUS
+	WHERE Id=$1 AND CompanyId=$2
+`
+
+// DeleteFeedbackStatus deletes a status of the company no feedback has. The
+// initial status cannot be deleted
+func (c *FeedbackClient) DeleteFeedbackStatus(ctx context.Context, companyID string, statusID int) (err error) {
+	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	var initial, inUse bool
+	err = tx.QueryRowContext(ctx, lockFeedbackStatusQuery, statusID, companyID).Scan(&initial, &inUse)
+	if err == sql.ErrNoRows {
+		return StatusNotFoundError
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not find status")
+	}
+	if initial {
+		return InitialStatusError
+	}
+	if inUse {
+		return StatusInUseError
+	}
+
+	if _, err = tx.ExecContext(ctx, deleteFeedbackStatusQuery, statusID, companyID); err != nil {
+		return errors.WithMessage(err, "could not delete status")
+	}
+	return tx.Commit()
+}
+
+const lockFeedbackForStatusQuery = `
+	SELECT
+	f.StatusId
+	,s.Name
+	FROM FEEDBACK as f
+	LEFT JOIN FEEDBACK_STATUS as s
+	ON s.Id=f.StatusId
+	WHERE f.Id=$1 AND f.CompanyId=$2 AND f.DeletedAt IS NULL
+	FOR UPDATE OF f
+`
+
+const findStatusTransitionQuery = `
+	SELECT
+	s.Name
+	,$3::INT IS NULL OR EXISTS (
+		SELECT 1
+		FROM FEEDBACK_STATUS_TRANSITION
+		WHERE FromStatusId=$3 AND ToStatusId=s.Id
+	)
+	FROM FEEDBACK_STATUS as s
+	WHERE s.Id=$1 AND s.CompanyId=$2
+`
+
+const changeFeedbackStatusQuery = `
+	UPDATE FEEDBACK
+	SET StatusId=$2
+	WHERE Id=$1
+`
+
+const addStatusHistoryQuery = `
+	INSERT INTO FEEDBACK_STATUS_HISTORY(FeedbackId,UserId,FromStatus,ToStatus)
+	VALUES ($1,$2,$3,$4)
+`
+
+// ChangeFeedbackStatus moves the feedback of the company to the status, which
+// must be one of the transitions of its current status. The change is kept
+// in the status history of the feedback
+func (c *FeedbackClient) ChangeFeedbackStatus(ctx context.Context, companyID, feedbackID, userID string, statusID int) (err error) {
+	tx, err := c.db.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	var (
+		currentID   sql.NullInt64
+		currentName sql.NullString
+	)
+	err = tx.QueryRowContext(ctx, lockFeedbackForStatusQuery, feedbackID, companyID).Scan(&currentID, &currentName)
+	if err == sql.ErrNoRows {
+		return FeedbackNotFoundError
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not find feedback")
+	}
+
+	var (
+		name    string
+		allowed bool
+	)
+	err = tx.QueryRowContext(ctx, findStatusTransitionQuery, statusID, companyID, currentID).Scan(&name, &allowed)
+	if err == sql.ErrNoRows {
+		return StatusNotFoundError
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not find status")
+	}
+	if !allowed {
+		return InvalidStatusTransitionError
+	}
+
+	if _, err = tx.ExecContext(ctx, changeFeedbackStatusQuery, feedbackID, statusID); err != nil {
+		return errors.WithMessage(err, "could not change status")
+	}
+	if _, err = tx.ExecContext(ctx, addStatusHistoryQuery, feedbackID, userID, currentName, name); err != nil {
+		return errors.WithMessage(err, "could not add status history")
+	}
+	return tx.Commit()
+}
+
+const getStatusHistoryQuery = `
+	SELECT
+	h.Id
+	,h.FeedbackId
+	,COALESCE(CAST(h.UserId AS VARCHAR),'')
+	,COALESCE(u.Firstname,'')
+	,COALESCE(u.Lastname,'')
+	,COALESCE(h.FromStatus,'')
+	,h.ToStatus
+	,h.CreatedAt
+	FROM FEEDBACK_STATUS_HISTORY as h
+	INNER JOIN FEEDBACK as f
+	ON f.Id=h.FeedbackId
+	LEFT JOIN USERS as u
+	ON u.ID=h.UserId
+	WHERE h.FeedbackId=$1 AND f.CompanyId=$2
+	ORDER BY h.Id
+`
+
+// GetStatusHistory lists the status changes of the feedback of the company,
+// oldest first
+func (c *FeedbackClient) GetStatusHistory(ctx context.Context, companyID, feedbackID string) (history []StatusChange, err error) {
+	rows, err := c.db.QueryContext(ctx, getStatusHistoryQuery, feedbackID, companyID)
+	if err != nil {
+		return history, errors.WithMessage(err, "could not get status history")
+	}
+	defer rows.Close()
+
+	history = []StatusChange{}
+	for rows.Next() {
+		var change StatusChange
+		err = rows.Scan(&change.ID, &change.FeedbackID, &change.Person.ID, &change.Person.Firstname, &change.Person.Lastname,
+			&change.From, &change.To, &change.CreatedAt)
+		if err != nil {
+			return history, errors.WithStack(err)
+		}
+		history = append(history, change)
+	}
+	return history, rows.Err()
+}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var InvalidFeedbackTeamError = errors.New("feedback can only be shared with teams in the company")
+
+// feedbackVisibleTo is the condition for feedback f being visible to the user
+// in the given query parameter. Feedback shared with teams is only visible to
+// the members of those teams, its author and the members who moderate
+// feedback. Feedback whose teams have all been deleted stays hidden from
+// everyone else
+func feedbackVisibleTo(param string) string {
+	return fmt.Sprintf(`(
+		NOT f.TeamScoped
+		OR `+feedbackWrittenBy("%[1]s")+`
+		OR EXISTS (
+			SELECT 1
+			FROM USER_COMPANY as vuc
+			INNER JOIN ROLE_PERMISSION as vrp
+			ON vrp.RoleId=vuc.RoleId
+			WHERE vuc.CompanyId=f.CompanyID AND vuc.UserId=%[1]s AND vrp.Permission='feedback.moderate'
+		)
+		OR EXISTS (
+			SELECT 1
+			FROM FEEDBACK_TEAM as vft
+			INNER JOIN USER_TEAM as vut
+			ON vut.TeamId=vft.TeamId
+			WHERE vft.FeedbackId=f.Id AND vut.UserId=%[1]s
+		)
+	)`, param)
+}
+
+var isFeedbackVisibleQuery = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM FEEDBACK as f
+		WHERE f.Id=$1 AND ` + feedbackVisibleTo("$2") + `
+	)
+`
+
+// IsFeedbackVisible checks whether the user is allowed to see the feedback
+func (c *FeedbackClient) IsFeedbackVisible(ctx context.Context, feedbackID, userID string) (isVisible bool, err error) {
+	fid, err := strconv.Atoi(feedbackID)
+	if err != nil {
+		return false, errors.WithMessage(err, "could not parse fid")
+	}
+
+	err = c.db.QueryRowContext(ctx, isFeedbackVisibleQuery, fid, userID).Scan(&isVisible)
+	if err != nil {
+		return false, errors.WithMessage(err, "could not check feedback visibility")
+	}
+	return isVisible, nil
+}
+
+const deleteFeedbackTeamsQuery = `
+	DELETE FROM FEEDBACK_TEAM
+	WHERE FeedbackId=$1
+`
+
+const addFeedbackTeamsQuery = `
+	INSERT INTO FEEDBACK_TEAM(FeedbackId,TeamId)
+	SELECT $1, t.Id
+	FROM TEAM as t
+	WHERE t.CompanyID=$2 AND t.Id = ANY($3)
+`
+
+const setFeedbackTeamScopedQuery = `
+	UPDATE FEEDBACK
+	SET TeamScoped=$2
+	WHERE Id=$1
+`
+
+// setFeedbackTeams replaces the teams the feedback is shared with. Without
+// teams the feedback is visible to the whole company
+func setFeedbackTeams(ctx context.Context, tx *sql.Tx, feedbackID int, companyID string, teamIDs []string) error {
+	ids := make([]int64, 0, len(teamIDs))
+	seen := make(map[int64]bool)
+	for _, teamID := range teamIDs {
+		id, err := strconv.ParseInt(teamID, 10, 64)
+		if err != nil {
+			return InvalidFeedbackTeamError
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, deleteFeedbackTeamsQuery, feedbackID); err != nil {
+		return errors.WithMessage(err, "could not remove feedback teams")
+	}
+
+	if len(ids) > 0 {
+		res, err := tx.ExecContext(ctx, addFeedbackTeamsQuery, feedbackID, companyID, pq.Array(ids))
+		if err != nil {
+			return errors.WithMessage(err, "could not add feedback teams")
+		}
+		if affected, err := res.RowsAffected(); err != nil || affected != int64(len(ids)) {
+			return InvalidFeedbackTeamError
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, setFeedbackTeamScopedQuery, feedbackID, len(ids) > 0); err != nil {
+		return errors.WithMessage(err, "could not update feedback visibility")
+	}
+	return nil
+}
+
+const getFeedbackTeamsQuery = `
+	SELECT FeedbackId, TeamId
+	FROM FEEDBACK_TEAM
+	WHERE FeedbackId = ANY($1::int[])
+	ORDER BY TeamId
+`
+
+// GetFeedbackTeams adds the teams each feedback is shared with
+func (c *FeedbackClient) GetFeedbackTeams(ctx context.Context, feedbacks []Feedback) error {
+	if len(feedbacks) == 0 {
+		return nil
+	}
+
+	feedbackIdx := make(map[string]int, len(feedbacks))
+	ids := make([]string, 0, len(feedbacks))
+	for idx, feedback := range feedbacks {
+		feedbackIdx[feedback.ID] = idx
+		ids = append(ids, feedback.ID)
+	}
+
+	rows, err := c.db.QueryContext(ctx, getFeedbackTeamsQuery, pq.Array(ids))
+	if err != nil {
+		return errors.WithMessage(err, "could not get feedback teams")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var feedbackID, teamID string
+		if err = rows.Scan(&feedbackID, &teamID); err != nil {
+			return err
+		}
+		if idx, ok := feedbackIdx[feedbackID]; ok {
+			feedbacks[idx].TeamIDs = append(feedbacks[idx].TeamIDs, teamID)
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+var (
+	UnverifiedIdentityEmailError = errors.New("identity provider has not verified the email")
+	IdentityEmailInUseError      = errors.New("an account with this email already exists, log in and link the identity provider to it")
+	IdentityLinkedError          = errors.New("the identity is already linked to an account")
+)
+
+// ExternalIdentity is a user as described by an identity provider
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Firstname     string
+	Lastname      string
+	Username      string
+}
+
+const findIdentityUserQuery = `
+	SELECT UserId
+	FROM USER_IDENTITIES
+	WHERE Provider=$1 AND Subject=$2
+`
+
+const findUserIDByEmailQuery = `
+	SELECT ID
+	FROM USERS
+	WHERE Email=$1
+`
+
+// Users created through an identity provider get an empty password hash which
+// never matches, they can set a password with the password reset flow
+const createIdentityUserQuery = `
+	INSERT INTO USERS(Firstname,Lastname,Username,Email,Password,EmailVerifiedAt)
+	VALUES ($1,$2,$3,$4,'',NOW())
+	RETURNING ID
+`
+
+const createIdentityQuery = `
+	INSERT INTO USER_IDENTITIES(UserId,Provider,Subject,Email)
+	VALUES ($1,$2,$3,$4)
+`
+
+// LoginExternalUser starts a session for a user authenticated by an identity
+// provider. Known identities log in to the linked user, and users with mfa
+// enabled get an mfa challenge like on a password login. New identities
+// create a new user when the provider has verified the email. They are never
+// linked to an existing user with the same email, that user has to link the
+// identity while logged in. Like on email verification the new user joins the
+// company which has verified their email domain
+func (c *SessionClient) LoginExternalUser(ctx context.Context, identity ExternalIdentity) (resp SessionResponse, err error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	var userID string
+	err = tx.QueryRowContext(ctx, findIdentityUserQuery, identity.Provider, identity.Subject).Scan(&userID)
+	if err != nil && err != sql.ErrNoRows {
+		return resp, errors.WithMessage(err, "could not find identity")
+	}
+
+	if err == sql.ErrNoRows {
+		userID, err = createExternalUser(ctx, tx, identity)
+		if err != nil {
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	mfaEnabled, err := c.IsMfaEnabled(ctx, userID)
+	if err != nil {
+		return
+	}
+	if mfaEnabled {
+		return c.newMfaChallenge(ctx, userID)
+	}
+
+	userSessionData, err := c.GetUserSessionFromUserId(ctx, userID)
+	if err != nil {
+		return
+	}
+	return c.newSession(ctx, userSessionData)
+}
+
+// createExternalUser creates a verified user for a new identity. Identities
+// with the email of an existing user are refused
+func createExternalUser(ctx context.Context, tx *sql.Tx, identity ExternalIdentity) (userID string, err error) {
+	if !identity.EmailVerified || identity.Email == "" {
+		return userID, UnverifiedIdentityEmailError
+	}
+
+	err = tx.QueryRowContext(ctx, findUserIDByEmailQuery, identity.Email).Scan(&userID)
+	if err == nil {
+		return "", IdentityEmailInUseError
+	}
+	if err != sql.ErrNoRows {
+		return userID, errors.WithMessage(err, "could not find user for identity")
+	}
+
+	username := identity.Username
+	if username == "" {
+		username = strings.Split(identity.Email, "@")[0]
+	}
+	err = tx.QueryRowContext(ctx, createIdentityUserQuery, identity.Firstname, identity.Lastname, username, identity.Email).Scan(&userID)
+	if err != nil {
+		return userID, errors.WithMessage(err, "could not create user for identity")
+	}
+
+	if _, err = tx.ExecContext(ctx, createIdentityQuery, userID, identity.Provider, identity.Subject, identity.Email); err != nil {
+		return userID, errors.WithMessage(err, "could not link identity")
+	}
+
+	if _, _, err = joinDomainCompany(ctx, tx, userID); err != nil {
+		return
+	}
+	return userID, nil
+}
+
+// LinkExternalIdentity links an identity from a provider to the logged in
+// user, so they can log in with the provider as well
+func (c *SessionClient) LinkExternalIdentity(ctx context.Context, userID string, identity ExternalIdentity) error {
+	_, err := c.DB.ExecContext(ctx, createIdentityQuery, userID, identity.Provider, identity.Subject, identity.Email)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+		return IdentityLinkedError
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not link identity")
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/pkg/errors"
+)
+
+const invitationExpirationTime = time.Hour * 24 * 7
+
+var (
+	InvitationNotFoundError      = errors.New("invitation not found")
+	InvitationExistsError        = errors.New("the email already has a pending invitation")
+	AlreadyCompanyMemberError    = errors.New("user is already a member of the company")
+	InvalidInvitationError       = errors.New("invalid or expired invitation")
+	InvitationEmailMismatchError = errors.New("invitation was sent to another email address")
+)
+
+// InvitationRequest invites someone to a company by email, whether or not
+// they have an account yet
+type InvitationRequest struct {
+	Email  string      `json:"email"`
+	Access AccessLevel `json:"accessLevel"`
+}
+
+func (r *InvitationRequest) Valid() error {
+	errs := make(FieldErrors)
+
+	r.Email = strings.TrimSpace(r.Email)
+	if r.Email == "" || !strings.Contains(r.Email, "@") {
+		errs["email"] = "email must be a valid email address"
+	}
+
+	if strings.TrimSpace(string(r.Access)) == "" {
+		errs["accessLevel"] = "accessLevel cannot be empty"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Invitation is a pending invitation to join a company
+type Invitation struct {
+	ID          int         `json:"id"`
+	CompanyID   string      `json:"companyId"`
+	CompanyName string      `json:"companyName"`
+	Email       string      `json:"email"`
+	Access      AccessLevel `json:"accessLevel"`
+	InvitedBy   string      `json:"invitedBy"`
+	CreatedAt   time.Time   `json:"createdAt"`
+	ExpiresAt   time.Time   `json:"expiresAt"`
+	Expired     bool        `json:"expired"`
+}
+
+const isCompanyMemberByEmailQuery = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM USER_COMPANY as uc
+		INNER JOIN USERS as u
+		ON u.ID=uc.UserId
+		WHERE uc.CompanyId=$1 AND LOWER(u.Email)=LOWER($2)
+	)
+`
+
+const findPendingInvitationQuery = `
+	SELECT Id, ExpiresAt
+	FROM COMPANY_INVITATIONS
+	WHERE CompanyId=$1 AND LOWER(Email)=LOWER($2) AND AcceptedAt IS NULL AND RevokedAt IS NULL
+	FOR UPDATE
+`
+
+const revokeInvitationByIDQuery = `
+	UPDATE COMPANY_INVITATIONS
+	SET RevokedAt=NOW()
+	WHERE Id=$1
+`
+
+const createInvitationQuery = `
+	INSERT INTO COMPANY_INVITATIONS(CompanyId,Email,RoleId,InvitedBy,TokenHash,ExpiresAt)
+	VALUES ($1,$2,$3,$4,$5,$6)
+	RETURNING Id
+`
+
+// CreateInvitation invites the email to the company with the given role.
+// The returned token is only known to the caller and has to be sent to the
+// invited email. An expired invitation for the same email is replaced, while
+// a pending one has to be resent instead
+func (c *CompanyClient) CreateInvitation(ctx context.Context, companyID, invitedBy string, invitationRequest InvitationRequest) (token string, invitation Invitation, err error) {
+	token, err = utils.NewOpaqueToken()
+	if err != nil {
+		return
+	}
+
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	roleID, err := findRoleID(ctx, tx, companyID, invitationRequest.Access)
+	if err != nil {
+		return
+	}
+
+	var isMember bool
+	if err = tx.QueryRowContext(ctx, isCompanyMemberByEmailQuery, companyID, invitationRequest.Email).Scan(&isMember); err != nil {
+		return token, invitation, errors.WithMessage(err, "could not check company membership")
+	}
+	if isMember {
+		return token, invitation, AlreadyCompanyMemberError
+	}
+
+	var (
+		pendingID        int
+		pendingExpiresAt time.Time
+	)
+	err = tx.QueryRowContext(ctx, findPendingInvitationQuery, companyID, invitationRequest.Email).Scan(&pendingID, &pendingExpiresAt)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return token, invitation, errors.WithMessage(err, "could not find pending invitation")
+	case time.Now().Before(pendingExpiresAt):
+		return token, invitation, InvitationExistsError
+	default:
+		if _, err = tx.ExecContext(ctx, revokeInvitationByIDQuery, pendingID); err != nil {
+			return token, invitation, errors.WithMessage(err, "could not replace expired invitation")
+		}
+	}
+
+	var invitationID int
+	expiresAt := time.Now().Add(invitationExpirationTime)
+	err = tx.QueryRowContext(ctx, createInvitationQuery, companyID, invitationRequest.Email, roleID, invitedBy, utils.HashToken(token), expiresAt).Scan(&invitationID)
+	if err != nil {
+		return token, invitation, errors.WithMessage(err, "could not create invitation")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	invitation, err = c.getInvitation(ctx, companyID, invitationID)
+	return token, invitation, err
+}
+
+const getInvitationsQuery = `
+	SELECT
+	ci.Id
+	,ci.CompanyId
+	,c.Name
+	,ci.Email
+	,r.Name
+	,ci.InvitedBy
+	,ci.CreatedAt
+	,ci.ExpiresAt
+	FROM COMPANY_INVITATIONS as ci
+	INNER JOIN COMPANY as c
+	ON c.Id=ci.CompanyId
+	INNER JOIN ROLE as r
+	ON r.Id=ci.RoleId
+	WHERE ci.CompanyId=$1 AND ci.AcceptedAt IS NULL AND ci.RevokedAt IS NULL
+`
+
+const getInvitationQuery = getInvitationsQuery + ` AND ci.Id=$2`
+
+func scanInvitation(row interface{ Scan(...interface{}) error }) (invitation Invitation, err error) {
+	err = row.Scan(&invitation.ID, &invitation.CompanyID, &invitation.CompanyName, &invitation.Email, &invitation.Access, &invitation.InvitedBy, &invitation.CreatedAt, &invitation.ExpiresAt)
+	if err != nil {
+		return
+	}
+	invitation.Expired = time.Now().After(invitation.ExpiresAt)
+	return invitation, nil
+}
+
+func (c *CompanyClient) getInvitation(ctx context.Context, companyID string, invitationID int) (Invitation, error) {
+	invitation, err := scanInvitation(c.DB.QueryRowContext(ctx, getInvitationQuery, companyID, invitationID))
+	if err == sql.ErrNoRows {
+		return invitation, InvitationNotFoundError
+	}
+	if err != nil {
+		return invitation, errors.WithMessage(err, "could not get invitation")
+	}
+	return invitation, nil
+}
+
+// GetInvitations lists the invitations of the company that are neither
+// accepted nor revoked. Expired invitations are included so they can be resent
+func (c *CompanyClient) GetInvitations(ctx context.Context, companyID string) (invitations []Invitation, err error) {
+	rows, err := c.DB.QueryContext(ctx, getInvitationsQuery+` ORDER BY ci.CreatedAt DESC`, companyID)
+	if err != nil {
+		return invitations, errors.WithMessage(err, "could not get invitations")
+	}
+	defer rows.Close()
+
+	invitations = []Invitation{}
+	for rows.Next() {
+		invitation, err := scanInvitation(rows)
+		if err != nil {
+			return invitations, errors.WithStack(err)
+		}
+		invitations = append(invitations, invitation)
+	}
+	return invitations, rows.Err()
+}
+
+const renewInvitationQuery = `
+	UPDATE COMPANY_INVITATIONS
+	SET TokenHash=$3, ExpiresAt=$4
+	WHERE Id=$1 AND CompanyId=$2 AND AcceptedAt IS NULL AND RevokedAt IS NULL
+`
+
+// ResendInvitation issues a new token for a pending invitation and extends
+// its expiry. The token sent earlier can no longer be used
+func (c *CompanyClient) ResendInvitation(ctx context.Context, companyID string, invitationID int) (token string, invitation Invitation, err error) {
+	token, err = utils.NewOpaqueToken()
+	if err != nil {
+		return
+	}
+
+	expiresAt := time.Now().Add(invitationExpirationTime)
+	res, err := c.DB.ExecContext(ctx, renewInvitationQuery, invitationID, companyID, utils.HashToken(token), expiresAt)
+	if err != nil {
+		return token, invitation, errors.WithMessage(err, "could not renew invitation")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return token, invitation, InvitationNotFoundError
+	}
+
+	invitation, err = c.getInvitation(ctx, companyID, invitationID)
+	return token, invitation, err
+}
+
+const revokeInvitationQuery = `
+	UPDATE COMPANY_INVITATIONS
+	SET RevokedAt=NOW()
+	WHERE Id=$1 AND CompanyId=$2 AND AcceptedAt IS NULL AND RevokedAt IS NULL
+`
+
+// RevokeInvitation withdraws a pending invitation so its token can no longer
+// be used
+func (c *CompanyClient) RevokeInvitation(ctx context.Context, companyID string, invitationID int) error {
+	res, err := c.DB.ExecContext(ctx, revokeInvitationQuery, invitationID, companyID)
+	if err != nil {
+		return errors.WithMessage(err, "could not revoke invitation")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return InvitationNotFoundError
+	}
+	return nil
+}
+
+const findInvitationByTokenQuery = `
+	SELECT Id, CompanyId, Email, RoleId
+	FROM COMPANY_INVITATIONS
+	WHERE TokenHash=$1 AND AcceptedAt IS NULL AND RevokedAt IS NULL AND ExpiresAt > NOW()
+	FOR UPDATE
+`
+
+const acceptInvitationQuery = `
+	UPDATE COMPANY_INVITATIONS
+	SET AcceptedAt=NOW(), AcceptedBy=$2
+	WHERE Id=$1
+`
+
+const joinCompanyQuery = `
+	INSERT INTO USER_COMPANY(CompanyId,UserId,RoleId)
+	VALUES ($1,$2,$3)
+	ON CONFLICT (CompanyId,UserId) DO NOTHING
+`
+
+// AcceptInvitation adds the user to the company the invitation token was
+// issued for. The invitation has to be for the user's email address. Since
+// the token was sent to that address the email is marked as verified
+func (c *CompanyClient) AcceptInvitation(ctx context.Context, token string, user utils.SessionUser) (companyID string, err error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	var (
+		invitationID int
+		email        string
+		roleID       int
+	)
+	err = tx.QueryRowContext(ctx, findInvitationByTokenQuery, utils.HashToken(token)).Scan(&invitationID, &companyID, &email, &roleID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return companyID, InvalidInvitationError
+		}
+		return companyID, errors.WithMessage(err, "could not find invitation")
+	}
+
+	if !strings.EqualFold(email, user.Email) {
+		return companyID, InvitationEmailMismatchError
+	}
+
+	if _, err = tx.ExecContext(ctx, verifyUserEmailQuery, user.ID); err != nil {
+		return companyID, errors.WithMessage(err, "could not verify email")
+	}
+
+	res, err := tx.ExecContext(ctx, joinCompanyQuery, companyID, user.ID, roleID)
+	if err != nil {
+		return companyID, errors.WithMessage(err, "could not join company")
+	}
+
+	joined, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if joined > 0 {
+		if err = recordMemberAdded(ctx, tx, companyID, user.ID, roleID, user.ID); err != nil {
+			return
+		}
+	}
+
+	if _, err = tx.ExecContext(ctx, acceptInvitationQuery, invitationID, user.ID); err != nil {
+		return companyID, errors.WithMessage(err, "could not accept invitation")
+	}
+
+	// The invitation verified the email, so the user also joins the company of
+	// their email domain. The role from the invitation wins if it is the same
+	if _, _, err = joinDomainCompany(ctx, tx, user.ID); err != nil {
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	return companyID, nil
+}
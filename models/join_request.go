@@ -0,0 +1,245 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/pkg/errors"
+)
+
+var (
+	CompanyNotFoundError     = errors.New("company not found")
+	JoinRequestNotFoundError = errors.New("join request not found")
+	JoinRequestExistsError   = errors.New("you have already asked to join the company")
+)
+
+// JoinRequestRequest asks to join a company found through the company search
+type JoinRequestRequest struct {
+	Message string `json:"message"`
+}
+
+func (r *JoinRequestRequest) Valid() error {
+	errs := make(FieldErrors)
+
+	r.Message = strings.TrimSpace(r.Message)
+	if len(r.Message) > 500 {
+		errs["message"] = "message cannot be longer than 500 characters"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// JoinRequest is a pending request from a user to join a company
+type JoinRequest struct {
+	ID          int       `json:"id"`
+	CompanyID   string    `json:"companyId"`
+	CompanyName string    `json:"companyName"`
+	UserID      string    `json:"userId"`
+	Username    string    `json:"username"`
+	Email       string    `json:"email"`
+	Message     string    `json:"message"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type CompanyDiscoverableRequest struct {
+	Discoverable bool `json:"discoverable"`
+}
+
+const isCompanyDiscoverableQuery = `
+	SELECT Discoverable AND ArchivedAt IS NULL
+	FROM COMPANY
+	WHERE Id=$1
+`
+
+const createJoinRequestQuery = `
+	INSERT INTO COMPANY_JOIN_REQUESTS(CompanyId,UserId,Message)
+	VALUES ($1,$2,$3)
+	ON CONFLICT (CompanyId,UserId) WHERE DecidedAt IS NULL DO NOTHING
+	RETURNING Id
+`
+
+// CreateJoinRequest asks for the user to join the company. Only companies that
+// show up in the search can be asked, and the user needs a verified email like
+// when they are added by an admin
+func (c *CompanyClient) CreateJoinRequest(ctx context.Context, companyID, userID string, joinRequest JoinRequestRequest) (request JoinRequest, err error) {
+	user, err := utils.FindUserByUserID(ctx, c.DB, userID)
+	if err != nil {
+		return request, errors.WithMessage(err, "could not find user")
+	}
+	if !user.EmailVerified {
+		return request, UnverifiedEmailError
+	}
+
+	var discoverable bool
+	err = c.DB.QueryRowContext(ctx, isCompanyDiscoverableQuery, companyID).Scan(&discoverable)
+	if err == sql.ErrNoRows || (err == nil && !discoverable) {
+		return request, CompanyNotFoundError
+	}
+	if err != nil {
+		return request, errors.WithMessage(err, "could not find company")
+	}
+
+	var isMember bool
+	if err = c.DB.QueryRowContext(ctx, isCompanyMemberQuery, companyID, userID).Scan(&isMember); err != nil {
+		return request, errors.WithMessage(err, "could not check company membership")
+	}
+	if isMember {
+		return request, AlreadyCompanyMemberError
+	}
+
+	var requestID int
+	err = c.DB.QueryRowContext(ctx, createJoinRequestQuery, companyID, userID, joinRequest.Message).Scan(&requestID)
+	if err == sql.ErrNoRows {
+		return request, JoinRequestExistsError
+	}
+	if err != nil {
+		return request, errors.WithMessage(err, "could not create join request")
+	}
+
+	return c.getJoinRequest(ctx, c.DB, companyID, requestID)
+}
+
+const getJoinRequestsQuery = `
+	SELECT
+	jr.Id
+	,jr.CompanyId
+	,c.Name
+	,jr.UserId
+	,u.Username
+	,u.Email
+	,jr.Message
+	,jr.CreatedAt
+	FROM COMPANY_JOIN_REQUESTS as jr
+	INNER JOIN COMPANY as c
+	ON c.Id=jr.CompanyId
+	INNER JOIN USERS as u
+	ON u.ID=jr.UserId
+	WHERE jr.CompanyId=$1 AND jr.DecidedAt IS NULL
+`
+
+const getJoinRequestQuery = getJoinRequestsQuery + ` AND jr.Id=$2`
+
+func scanJoinRequest(row interface{ Scan(...interface{}) error }) (request JoinRequest, err error) {
+	err = row.Scan(&request.ID, &request.CompanyID, &request.CompanyName, &request.UserID, &request.Username, &request.Email, &request.Message, &request.CreatedAt)
+	return
+}
+
+func (c *CompanyClient) getJoinRequest(ctx context.Context, db queryRower, companyID string, requestID int) (request JoinRequest, err error) {
+	request, err = scanJoinRequest(db.QueryRowContext(ctx, getJoinRequestQuery, companyID, requestID))
+	if err == sql.ErrNoRows {
+		return request, JoinRequestNotFoundError
+	}
+	if err != nil {
+		return request, errors.WithMessage(err, "could not get join request")
+	}
+	return request, nil
+}
+
+// GetJoinRequests lists the pending join requests of the company, oldest first
+func (c *CompanyClient) GetJoinRequests(ctx context.Context, companyID string) (requests []JoinRequest, err error) {
+	rows, err := c.DB.QueryContext(ctx, getJoinRequestsQuery+` ORDER BY jr.CreatedAt`, companyID)
+	if err != nil {
+		return requests, errors.WithMessage(err, "could not get join requests")
+	}
+	defer rows.Close()
+
+	requests = []JoinRequest{}
+	for rows.Next() {
+		request, err := scanJoinRequest(rows)
+		if err != nil {
+			return requests, errors.WithStack(err)
+		}
+		requests = append(requests, request)
+	}
+	return requests, rows.Err()
+}
+
+const decideJoinRequestQuery = `
+	UPDATE COMPANY_JOIN_REQUESTS
+	SET DecidedAt=NOW(), DecidedBy=$3, Approved=$4
+	WHERE Id=$1 AND CompanyId=$2 AND DecidedAt IS NULL
+`
+
+// DecideJoinRequest approves or denies a pending join request. Approved users
+// join the company with its default role
+func (c *CompanyClient) DecideJoinRequest(ctx context.Context, companyID string, requestID int, decidedBy string, approve bool) (request JoinRequest, err error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	request, err = c.getJoinRequest(ctx, tx, companyID, requestID)
+	if err != nil {
+		return
+	}
+
+	res, err := tx.ExecContext(ctx, decideJoinRequestQuery, requestID, companyID, decidedBy, approve)
+	if err != nil {
+		return request, errors.WithMessage(err, "could not decide join request")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return request, JoinRequestNotFoundError
+	}
+
+	if approve {
+		roleID, err := findRoleID(ctx, tx, companyID, "")
+		if err != nil {
+			return request, err
+		}
+
+		res, err := tx.ExecContext(ctx, joinCompanyQuery, companyID, request.UserID, roleID)
+		if err != nil {
+			return request, errors.WithMessage(err, "could not join company")
+		}
+
+		joined, err := res.RowsAffected()
+		if err != nil {
+			return request, err
+		}
+		if joined > 0 {
+			if err = recordMemberAdded(ctx, tx, companyID, request.UserID, roleID, decidedBy); err != nil {
+				return request, err
+			}
+		}
+	}
+
+	return request, tx.Commit()
+}
+
+const setCompanyDiscoverableQuery = `
+	UPDATE COMPANY
+	SET Discoverable=$2
+	WHERE Id=$1
+`
+
+// SetDiscoverable chooses whether the company shows up in the company search
+// and can be asked to join
+func (c *CompanyClient) SetDiscoverable(ctx context.Context, companyID string, discoverable bool) error {
+	res, err := c.DB.ExecContext(ctx, setCompanyDiscoverableQuery, companyID, discoverable)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	nrows, err := res.RowsAffected()
+	if err != nil || nrows == 0 {
+		return errors.New("not able to update company")
+	}
+	return nil
+}
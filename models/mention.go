@@ -0,0 +1,229 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// maxMentions is how many different usernames are looked up in one text. Any
+// more are left as plain text
+const maxMentions = 20
+
+// mentionPattern matches @username where the @ does not follow a word
+// character, so email addresses are not taken for mentions
+var mentionPattern = regexp.MustCompile(`\B@([\p{L}\p{N}_][\p{L}\p{N}_.-]*)`)
+
+// Mention marks a member mentioned in the description of feedback or in a
+// comment. Start and Length count characters, and include the @
+type Mention struct {
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+	Start    int    `json:"start"`
+	Length   int    `json:"length"`
+}
+
+// parseMentions finds the @usernames in the text. Punctuation ending a
+// sentence is not part of the username
+func parseMentions(text string) []Mention {
+	var mentions []Mention
+	for _, match := range mentionPattern.FindAllStringSubmatchIndex(text, -1) {
+		username := strings.TrimRight(text[match[2]:match[3]], ".-")
+		mentions = append(mentions, Mention{
+			Username: username,
+			Start:    utf8.RuneCountInString(text[:match[0]]),
+			Length:   utf8.RuneCountInString(username) + 1,
+		})
+	}
+	return mentions
+}
+
+var isMentionableQuery = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM FEEDBACK as f
+		INNER JOIN USER_COMPANY as muc
+		ON muc.CompanyId=f.CompanyID
+		WHERE f.Id=$1 AND muc.UserId=$2 AND ` + feedbackVisibleTo("$2") + `
+	)
+`
+
+// mentionableUser returns the id of the user with the username when they are
+// a member of the company of the feedback and can see it, and nothing
+// otherwise
+func (c *FeedbackClient) mentionableUser(ctx context.Context, tx *sql.Tx, feedbackID int, username string) (string, error) {
+	user, err := utils.FindUserByUsername(ctx, tx, username)
+	if errors.Cause(err) == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var mentionable bool
+	err = tx.QueryRowContext(ctx, isMentionableQuery, feedbackID, user.ID).Scan(&mentionable)
+	if err != nil {
+		return "", errors.WithMessage(err, "could not check mentioned user")
+	}
+	if !mentionable {
+		return "", nil
+	}
+	return user.ID, nil
+}
+
+const getMentionedUsersQuery = `
+	SELECT DISTINCT UserId
+	FROM MENTION
+	WHERE FeedbackId=$1 AND CommentId IS NOT DISTINCT FROM $2
+`
+
+const deleteMentionsQuery = `
+	DELETE FROM MENTION
+	WHERE FeedbackId=$1 AND CommentId IS NOT DISTINCT FROM $2
+`
+
+const addMentionQuery = `
+	INSERT INTO MENTION(FeedbackId,CommentId,UserId,Start,Length)
+	VALUES ($1,$2,$3,$4,$5)
+`
+
+// setMentions replaces the mentions in the description of the feedback, or in
+// the comment on it when commentID is set, with the ones in the text. Members
+// mentioned for the first time are notified, but not about mentioning
+// themselves, and not about who wrote anonymous feedback
+func (c *FeedbackClient) setMentions(ctx context.Context, tx *sql.Tx, feedbackID int, commentID sql.NullInt64, authorID string, anonymous bool, text string) error {
+	rows, err := tx.QueryContext(ctx, getMentionedUsersQuery, feedbackID, commentID)
+	if err != nil {
+		return errors.WithMessage(err, "could not get mentions")
+	}
+	mentionedBefore := make(map[string]bool)
+	for rows.Next() {
+		var userID string
+		if err = rows.Scan(&userID); err != nil {
+			rows.Close()
+			return err
+		}
+		mentionedBefore[userID] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, deleteMentionsQuery, feedbackID, commentID); err != nil {
+		return errors.WithMessage(err, "could not delete mentions")
+	}
+
+	actorID := sql.NullString{String: authorID, Valid: !anonymous}
+	users := make(map[string]string)
+	notified := make(map[string]bool)
+	for _, mention := range parseMentions(text) {
+		userID, ok := users[mention.Username]
+		if !ok {
+			if len(users) == maxMentions {
+				continue
+			}
+			userID, err = c.mentionableUser(ctx, tx, feedbackID, mention.Username)
+			if err != nil {
+				return err
+			}
+			users[mention.Username] = userID
+		}
+		if userID == "" {
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, addMentionQuery, feedbackID, commentID, userID, mention.Start, mention.Length)
+		if err != nil {
+			return errors.WithMessage(err, "could not add mention")
+		}
+
+		if userID == authorID || mentionedBefore[userID] || notified[userID] {
+			continue
+		}
+		err = notify(ctx, tx, userID, NotificationMention, feedbackID, commentID, actorID)
+		if err != nil {
+			return err
+		}
+		notified[userID] = true
+	}
+	return nil
+}
+
+const getFeedbackMentionsQuery = `
+	SELECT
+	m.FeedbackId
+	,COALESCE(CAST(m.CommentId AS VARCHAR),'')
+	,m.UserId
+	,u.Username
+	,m.Start
+	,m.Length
+	FROM MENTION as m
+	INNER JOIN USERS as u
+	ON u.ID=m.UserId
+	WHERE m.FeedbackId = ANY($1::int[])
+	ORDER BY m.Start
+`
+
+// GetFeedbackMentions adds the mentions in the description of each feedback
+// and in its comments. The comments must have been added already, and
+// deleted comments get none
+func (c *FeedbackClient) GetFeedbackMentions(ctx context.Context, feedbacks []Feedback) error {
+	if len(feedbacks) == 0 {
+		return nil
+	}
+
+	feedbackIdx := make(map[string]int, len(feedbacks))
+	ids := make([]string, 0, len(feedbacks))
+	for idx, feedback := range feedbacks {
+		feedbackIdx[feedback.ID] = idx
+		ids = append(ids, feedback.ID)
+	}
+
+	rows, err := c.db.QueryContext(ctx, getFeedbackMentionsQuery, pq.Array(ids))
+	if err != nil {
+		return errors.WithMessage(err, "could not get feedback mentions")
+	}
+	defer rows.Close()
+
+	commentMentions := make(map[string][]Mention)
+	for rows.Next() {
+		var (
+			feedbackID, commentID string
+			mention               Mention
+		)
+		if err = rows.Scan(&feedbackID, &commentID, &mention.UserID, &mention.Username, &mention.Start, &mention.Length); err != nil {
+			return err
+		}
+		if commentID != "" {
+			commentMentions[commentID] = append(commentMentions[commentID], mention)
+			continue
+		}
+		if idx, ok := feedbackIdx[feedbackID]; ok {
+			feedbacks[idx].Mentions = append(feedbacks[idx].Mentions, mention)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for idx := range feedbacks {
+		addCommentMentions(feedbacks[idx].Comments, commentMentions)
+	}
+	return nil
+}
+
+func addCommentMentions(comments []Comment, mentions map[string][]Mention) {
+	for idx := range comments {
+		if !comments[idx].Deleted {
+			comments[idx].Mentions = mentions[comments[idx].ID]
+		}
+		addCommentMentions(comments[idx].Replies, mentions)
+	}
+}
@@ -0,0 +1,432 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	mfaIssuer                 = "Creatix"
+	mfaChallengeExpiration    = time.Minute * 5
+	mfaChallengeMaxAttempts   = 5
+	numberOfRecoveryCodes     = 10
+	recoveryCodeHalfByteCount = 5
+)
+
+var (
+	MfaAlreadyEnabledError    = errors.New("two factor authentication is already enabled")
+	MfaNotEnrolledError       = errors.New("two factor authentication is not enrolled")
+	InvalidMfaCodeError       = errors.New("invalid two factor code")
+	InvalidMfaChallengeError  = errors.New("invalid or expired two factor challenge")
+	MfaRequiredByCompanyError = errors.New("company requires two factor authentication")
+)
+
+type MfaEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type MfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// MfaLoginRequest completes a login that returned an mfa challenge, either
+// with a TOTP code or with one of the recovery codes
+type MfaLoginRequest struct {
+	MfaToken     string `json:"mfaToken"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+	InviteToken  string `json:"inviteToken"`
+}
+
+type MfaChallengeResponse struct {
+	MfaRequired bool      `json:"mfaRequired"`
+	MfaToken    string    `json:"mfaToken"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type CompanyMfaRequest struct {
+	Required bool `json:"required"`
+}
+
+const findUserTotpQuery = `
+	SELECT
+	Email
+	,TotpSecret
+	,TotpEnabledAt IS NOT NULL
+	,TotpLastStep
+	FROM USERS
+	WHERE ID=$1
+`
+
+type userTotp struct {
+	email    string
+	secret   *string
+	enabled  bool
+	lastStep int64
+}
+
+func findUserTotp(ctx context.Context, q queryRower, userID string) (totp userTotp, err error) {
+	err = q.QueryRowContext(ctx, findUserTotpQuery, userID).Scan(&totp.email, &totp.secret, &totp.enabled, &totp.lastStep)
+	if err != nil {
+		return totp, errors.WithMessage(err, "could not find user totp")
+	}
+	return
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+const setPendingTotpSecretQuery = `
+	UPDATE USERS
+	SET TotpSecret=$2
+	WHERE ID=$1 AND TotpEnabledAt IS NULL
+`
+
+// EnrollTotp creates a new pending TOTP secret for the user. It is not used
+// for logins before it is confirmed with ConfirmTotp
+func (c *SessionClient) EnrollTotp(ctx context.Context, userID string) (enrollment MfaEnrollment, err error) {
+	totp, err := findUserTotp(ctx, c.DB, userID)
+	if err != nil {
+		return
+	}
+
+	if totp.enabled {
+		return enrollment, MfaAlreadyEnabledError
+	}
+
+	secret, err := utils.NewTOTPSecret()
+	if err != nil {
+		return
+	}
+
+	if _, err = c.DB.ExecContext(ctx, setPendingTotpSecretQuery, userID, secret); err != nil {
+		return enrollment, errors.WithMessage(err, "could not store totp secret")
+	}
+
+	return MfaEnrollment{Secret: secret, URI: utils.TOTPURI(mfaIssuer, totp.email, secret)}, nil
+}
+
+const enableTotpQuery = `
+	UPDATE USERS
+	SET TotpEnabledAt=NOW(), TotpLastStep=$2
+	WHERE ID=$1 AND TotpEnabledAt IS NULL
+`
+
+// ConfirmTotp enables two factor authentication once the user proves that the
+// authenticator app has the secret. The returned recovery codes are only
+// shown this once
+func (c *SessionClient) ConfirmTotp(ctx context.Context, userID, code string) (recoveryCodes []string, err error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	totp, err := findUserTotp(ctx, tx, userID)
+	if err != nil {
+		return
+	}
+
+	if totp.enabled {
+		return recoveryCodes, MfaAlreadyEnabledError
+	}
+
+	if totp.secret == nil {
+		return recoveryCodes, MfaNotEnrolledError
+	}
+
+	step, ok, err := utils.ValidateTOTP(*totp.secret, code, time.Now())
+	if err != nil {
+		return
+	}
+	if !ok {
+		return recoveryCodes, InvalidMfaCodeError
+	}
+
+	if _, err = tx.ExecContext(ctx, enableTotpQuery, userID, step); err != nil {
+		return recoveryCodes, errors.WithMessage(err, "could not enable totp")
+	}
+
+	recoveryCodes, err = newRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	return recoveryCodes, nil
+}
+
+const deleteRecoveryCodesQuery = `
+	DELETE FROM RECOVERY_CODES
+	WHERE UserId=$1
+`
+
+const createRecoveryCodeQuery = `
+	INSERT INTO RECOVERY_CODES(UserId,CodeHash)
+	VALUES ($1,$2)
+`
+
+func newRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string) (recoveryCodes []string, err error) {
+	if _, err = tx.ExecContext(ctx, deleteRecoveryCodesQuery, userID); err != nil {
+		return recoveryCodes, errors.WithMessage(err, "could not delete old recovery codes")
+	}
+
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < numberOfRecoveryCodes; i++ {
+		b := make([]byte, 2*recoveryCodeHalfByteCount)
+		if _, err = rand.Read(b); err != nil {
+			return recoveryCodes, errors.WithStack(err)
+		}
+		code := strings.ToLower(encoding.EncodeToString(b[:recoveryCodeHalfByteCount]) + "-" + encoding.EncodeToString(b[recoveryCodeHalfByteCount:]))
+
+		if _, err = tx.ExecContext(ctx, createRecoveryCodeQuery, userID, hashRecoveryCode(code)); err != nil {
+			return recoveryCodes, errors.WithMessage(err, "could not store recovery code")
+		}
+		recoveryCodes = append(recoveryCodes, code)
+	}
+	return recoveryCodes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	return utils.HashToken(strings.ToLower(strings.TrimSpace(code)))
+}
+
+const isMfaEnabledQuery = `
+	SELECT TotpEnabledAt IS NOT NULL
+	FROM USERS
+	WHERE ID=$1
+`
+
+// IsMfaEnabled reports whether the user has confirmed a TOTP secret
+func (c *SessionClient) IsMfaEnabled(ctx context.Context, userID string) (enabled bool, err error) {
+	err = c.DB.QueryRowContext(ctx, isMfaEnabledQuery, userID).Scan(&enabled)
+	if err != nil {
+		return enabled, errors.WithMessage(err, "could not check mfa status")
+	}
+	return
+}
+
+const createMfaChallengeQuery = `
+	INSERT INTO MFA_CHALLENGES(UserId,TokenHash,ExpiresAt)
+	VALUES ($1,$2,$3)
+`
+
+// newMfaChallenge is returned by LoginUser instead of a session when the
+// password is correct but a second factor is still needed
+func (c *SessionClient) newMfaChallenge(ctx context.Context, userID string) (resp SessionResponse, err error) {
+	token, err := utils.NewOpaqueToken()
+	if err != nil {
+		return
+	}
+
+	expiresAt := time.Now().Add(mfaChallengeExpiration)
+	if _, err = c.DB.ExecContext(ctx, createMfaChallengeQuery, userID, utils.HashToken(token), expiresAt); err != nil {
+		return resp, errors.WithMessage(err, "could not create mfa challenge")
+	}
+
+	resp.Message = "mfa required"
+	resp.MfaRequired = true
+	resp.MfaToken = token
+	resp.ExpiresAt = expiresAt
+	return resp, nil
+}
+
+const findMfaChallengeQuery = `
+	SELECT
+	Id
+	,UserId
+	FROM MFA_CHALLENGES
+	WHERE TokenHash=$1 AND UsedAt IS NULL AND ExpiresAt > NOW() AND Attempts < $2
+	FOR UPDATE
+`
+
+const failMfaChallengeQuery = `
+	UPDATE MFA_CHALLENGES
+	SET Attempts=Attempts+1
+	WHERE Id=$1
+`
+
+const useMfaChallengeQuery = `
+	UPDATE MFA_CHALLENGES
+	SET UsedAt=NOW()
+	WHERE Id=$1
+`
+
+const updateTotpLastStepQuery = `
+	UPDATE USERS
+	SET TotpLastStep=$2
+	WHERE ID=$1 AND TotpLastStep < $2
+`
+
+const useRecoveryCodeQuery = `
+	UPDATE RECOVERY_CODES
+	SET UsedAt=NOW()
+	WHERE Id = (
+		SELECT Id FROM RECOVERY_CODES
+		WHERE UserId=$1 AND CodeHash=$2 AND UsedAt IS NULL
+		LIMIT 1
+	)
+`
+
+// CompleteMfaLogin checks the second factor for an mfa challenge and starts
+// a session if it is valid. A challenge can only be used once and is
+// discarded after too many wrong codes. Wrong codes count towards the login
+// throttle of the account like wrong passwords, and the throttle is only
+// reset once the code is valid
+func (c *SessionClient) CompleteMfaLogin(ctx context.Context, mfaRequest MfaLoginRequest) (resp SessionResponse, err error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	var challengeID int
+	var userID string
+	err = tx.QueryRowContext(ctx, findMfaChallengeQuery, utils.HashToken(mfaRequest.MfaToken), mfaChallengeMaxAttempts).Scan(&challengeID, &userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return resp, InvalidMfaChallengeError
+		}
+		return resp, errors.WithMessage(err, "could not find mfa challenge")
+	}
+
+	userSessionData, err := c.GetUserSessionFromUserId(ctx, userID)
+	if err != nil {
+		return
+	}
+	throttleSubject := accountThrottleSubject(userSessionData.SessionUser.Email)
+	throttled, err := accountLoginThrottle.isThrottled(ctx, c.DB, throttleSubject)
+	if err != nil {
+		return
+	}
+	if throttled {
+		return resp, InvalidMfaCodeError
+	}
+
+	valid, err := c.verifySecondFactor(ctx, tx, userID, mfaRequest)
+	if err != nil {
+		return
+	}
+
+	if !valid {
+		if _, err = tx.ExecContext(ctx, failMfaChallengeQuery, challengeID); err != nil {
+			return resp, errors.WithMessage(err, "could not update mfa challenge")
+		}
+		err = recordAuditEvent(ctx, tx, AuditEvent{
+			Action:     AuditLoginFailed,
+			TargetType: auditTargetUser,
+			TargetID:   userID,
+			After:      auditState(map[string]string{"reason": "mfa"}),
+		})
+		if err != nil {
+			return
+		}
+		if err = tx.Commit(); err != nil {
+			return
+		}
+		if lockedUntil := c.recordLoginFailure(ctx, userSessionData.SessionUser.Email); !lockedUntil.IsZero() {
+			return resp, &AccountLockedError{User: userSessionData.SessionUser, LockedUntil: lockedUntil}
+		}
+		return resp, InvalidMfaCodeError
+	}
+
+	if _, err = tx.ExecContext(ctx, useMfaChallengeQuery, challengeID); err != nil {
+		return resp, errors.WithMessage(err, "could not use mfa challenge")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	if err = accountLoginThrottle.reset(ctx, c.DB, throttleSubject); err != nil {
+		c.logger.Unsuccessful("could not reset login throttle", err)
+	}
+
+	c.acceptInvitation(ctx, mfaRequest.InviteToken, &userSessionData)
+	return c.newSession(ctx, userSessionData)
+}
+
+func (c *SessionClient) verifySecondFactor(ctx context.Context, tx *sql.Tx, userID string, mfaRequest MfaLoginRequest) (bool, error) {
+	if mfaRequest.RecoveryCode != "" {
+		res, err := tx.ExecContext(ctx, useRecoveryCodeQuery, userID, hashRecoveryCode(mfaRequest.RecoveryCode))
+		if err != nil {
+			return false, errors.WithMessage(err, "could not use recovery code")
+		}
+		nrows, err := res.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+		return nrows == 1, nil
+	}
+
+	totp, err := findUserTotp(ctx, tx, userID)
+	if err != nil {
+		return false, err
+	}
+	if !totp.enabled || totp.secret == nil {
+		return false, MfaNotEnrolledError
+	}
+
+	step, ok, err := utils.ValidateTOTP(*totp.secret, mfaRequest.Code, time.Now())
+	if err != nil || !ok {
+		return false, err
+	}
+
+	// A code can only be used once within its time window
+	res, err := tx.ExecContext(ctx, updateTotpLastStepQuery, userID, step)
+	if err != nil {
+		return false, errors.WithMessage(err, "could not update totp step")
+	}
+	nrows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return nrows == 1, nil
+}
+
+const setCompanyRequireMfaQuery = `
+	UPDATE COMPANY
+	SET RequireMfa=$2
+	WHERE Id=$1
+`
+
+// SetRequireMfa turns the two factor requirement for all company members on or off
+func (c *CompanyClient) SetRequireMfa(ctx context.Context, companyID string, required bool) error {
+	res, err := c.DB.ExecContext(ctx, setCompanyRequireMfaQuery, companyID, required)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	nrows, err := res.RowsAffected()
+	if err != nil || nrows == 0 {
+		return errors.New("not able to update company")
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const NotificationMention = "mention"
+
+// maxNotifications is how many of the latest notifications are listed
+const maxNotifications = 50
+
+var NotificationNotFoundError = errors.New("notification not found")
+
+// Notification tells a user about something happening to feedback in one of
+// their companies. Actor is who did it, and is left out when it was done
+// anonymously
+type Notification struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	CompanyID  string    `json:"companyId"`
+	FeedbackID string    `json:"feedbackId"`
+	CommentID  string    `json:"commentId,omitempty"`
+	Title      string    `json:"title"`
+	Actor      *Person   `json:"actor,omitempty"`
+	Read       bool      `json:"read"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+const addNotificationQuery = `
+	INSERT INTO NOTIFICATION(UserId,CompanyId,Kind,FeedbackId,CommentId,ActorId)
+	SELECT $1, CompanyID, $2, Id, $3, $4
+	FROM FEEDBACK
+	WHERE Id=$5
+`
+
+// notify adds a notification about the feedback, or the comment on it, for
+// the user
+func notify(ctx context.Context, tx *sql.Tx, userID, kind string, feedbackID int, commentID sql.NullInt64, actorID sql.NullString) error {
+	_, err := tx.ExecContext(ctx, addNotificationQuery, userID, kind, commentID, actorID, feedbackID)
+	if err != nil {
+		return errors.WithMessage(err, "could not add notification")
+	}
+	return nil
+}
+
+var getNotificationsQuery = `
+	SELECT
+	n.Id
+	,n.Kind
+	,n.CompanyId
+	,n.FeedbackId
+	,COALESCE(CAST(n.CommentId AS VARCHAR),'')
+	,f.Title
+	,COALESCE(CAST(a.ID AS VARCHAR),'')
+	,COALESCE(a.Firstname,'')
+	,COALESCE(a.Lastname,'')
+	,n.ReadAt IS NOT NULL
+	,n.CreatedAt
+	FROM NOTIFICATION as n
+	INNER JOIN FEEDBACK as f
+	ON f.Id=n.FeedbackId
+	LEFT JOIN COMMENTS as c
+	ON c.Id=n.CommentId
+	LEFT JOIN USERS as a
+	ON a.ID=n.ActorId
+	WHERE n.UserId=$1 AND f.DeletedAt IS NULL AND c.DeletedAt IS NULL AND ` + feedbackVisibleTo("$1") + `
+	ORDER BY n.Id DESC
+	LIMIT $2
+`
+
+// GetNotifications lists the latest notifications of the user, newest first.
+// Notifications about feedback or comments which have been deleted, or which
+// the user can no longer see, are left out
+func (c *FeedbackClient) GetNotifications(ctx context.Context, userID string) (notifications []Notification, err error) {
+	notifications = make([]Notification, 0)
+	rows, err := c.db.QueryContext(ctx, getNotificationsQuery, userID, maxNotifications)
+	if err != nil {
+		return notifications, errors.WithMessage(err, "could not get notifications")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			notification Notification
+			actor        Person
+		)
+		err = rows.Scan(&notification.ID,
+			&notification.Kind,
+			&notification.CompanyID,
+			&notification.FeedbackID,
+			&notification.CommentID,
+			&notification.Title,
+			&actor.ID,
+			&actor.Firstname,
+			&actor.Lastname,
+			&notification.Read,
+			&notification.CreatedAt,
+		)
+		if err != nil {
+			return notifications, err
+		}
+		if actor.ID != "" {
+			notification.Actor = &actor
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, rows.Err()
+}
+
+const readNotificationQuery = `
+	UPDATE NOTIFICATION
+	SET ReadAt=COALESCE(ReadAt,NOW())
+	WHERE UserId=$1 AND Id=$2
+`
+
+const readNotificationsQuery = `
+	UPDATE NOTIFICATION
+	SET ReadAt=NOW()
+	WHERE UserId=$1 AND ReadAt IS NULL
+`
+
+// ReadNotification marks the notification of the user as read. Notifications
+// which were read already are left as they are
+func (c *FeedbackClient) ReadNotification(ctx context.Context, userID, notificationID string) error {
+	nid, err := strconv.Atoi(notificationID)
+	if err != nil {
+		return NotificationNotFoundError
+	}
+
+	res, err := c.db.ExecContext(ctx, readNotificationQuery, userID, nid)
+	if err != nil {
+		return errors.WithMessage(err, "could not read notification")
+	}
+	if affected, err := res.RowsAffected(); err != nil || affected == 0 {
+		return NotificationNotFoundError
+	}
+	return nil
+}
+
+// ReadNotifications marks all the notifications of the user as read
+func (c *FeedbackClient) ReadNotifications(ctx context.Context, userID string) error {
+	if _, err := c.db.ExecContext(ctx, readNotificationsQuery, userID); err != nil {
+		return errors.WithMessage(err, "could not read notifications")
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const ownershipOfferExpirationTime = time.Hour * 24 * 7
+
+var (
+	LastAdminError        = errors.New("the company must keep at least one admin")
+	OwnerRemovalError     = errors.New("the owner cannot be removed or demoted, transfer the ownership first")
+	NotCompanyOwnerError  = errors.New("only the owner can transfer the ownership of the company")
+	NoOwnershipOfferError = errors.New("no pending ownership transfer")
+)
+
+// OwnershipTransferRequest offers the ownership of a company to one of its
+// members
+type OwnershipTransferRequest struct {
+	UserID string `json:"userId"`
+}
+
+const lockCompanyOwnerQuery = `
+	SELECT OwnerId
+	FROM COMPANY
+	WHERE Id=$1
+	FOR UPDATE
+`
+
+const memberRoleQuery = `
+	SELECT RoleId
+	FROM USER_COMPANY
+	WHERE CompanyId=$1 AND UserId=$2
+`
+
+const countOtherAdminsQuery = `
+	SELECT COUNT(*)
+	FROM USER_COMPANY
+	WHERE CompanyId=$1 AND RoleId=$2 AND UserId<>$3
+`
+
+// guardAdminRemoval makes sure the member can lose the admin role without
+// leaving the company without an owner or admins. The company row is locked
+// so concurrent changes cannot remove the last two admins at once
+func guardAdminRemoval(ctx context.Context, tx *sql.Tx, companyID, userID string) error {
+	var ownerID sql.NullString
+	err := tx.QueryRowContext(ctx, lockCompanyOwnerQuery, companyID).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not find company owner")
+	}
+	if ownerID.Valid && ownerID.String == userID {
+		return OwnerRemovalError
+	}
+
+	var roleID int
+	err = tx.QueryRowContext(ctx, memberRoleQuery, companyID, userID).Scan(&roleID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not find member role")
+	}
+	if roleID != adminRoleID {
+		return nil
+	}
+
+	var otherAdmins int
+	if err = tx.QueryRowContext(ctx, countOtherAdminsQuery, companyID, adminRoleID, userID).Scan(&otherAdmins); err != nil {
+		return errors.WithMessage(err, "could not count admins")
+	}
+	if otherAdmins == 0 {
+		return LastAdminError
+	}
+	return nil
+}
+
+const offerOwnershipQuery = `
+	UPDATE COMPANY as c
+	SET PendingOwnerId=$3, OwnershipOfferedAt=NOW()
+	WHERE c.Id=$1 AND (
+		c.OwnerId=$2
+		OR (c.OwnerId IS NULL AND EXISTS (
+			SELECT 1
+			FROM USER_COMPANY as uc
+			WHERE uc.CompanyId=c.Id AND uc.UserId=$2 AND uc.RoleId=$4
+		))
+	)
+	RETURNING c.Name
+`
+
+// OfferOwnership lets the owner offer the company to another member, who has
+// to accept before they become the owner. Companies without an owner can be
+// offered by any of their admins, who can also offer it to themselves
+func (c *CompanyClient) OfferOwnership(ctx context.Context, companyID, userID, newOwnerID string) (companyName string, err error) {
+	var isMember bool
+	if err = c.DB.QueryRowContext(ctx, isCompanyMemberQuery, companyID, newOwnerID).Scan(&isMember); err != nil {
+		return companyName, errors.WithMessage(err, "could not find member")
+	}
+	if !isMember {
+		return companyName, NotCompanyMemberError
+	}
+
+	err = c.DB.QueryRowContext(ctx, offerOwnershipQuery, companyID, userID, newOwnerID, adminRoleID).Scan(&companyName)
+	if err == sql.ErrNoRows {
+		return companyName, NotCompanyOwnerError
+	}
+	if err != nil {
+		return companyName, errors.WithMessage(err, "could not offer ownership")
+	}
+	return companyName, nil
+}
+
+const acceptOwnershipQuery = `
+	UPDATE COMPANY as c
+	SET OwnerId=c.PendingOwnerId, PendingOwnerId=NULL, OwnershipOfferedAt=NULL
+	WHERE c.Id=$1 AND c.PendingOwnerId=$2 AND c.OwnershipOfferedAt>$3 AND EXISTS (
+		SELECT 1
+		FROM USER_COMPANY as uc
+		WHERE uc.CompanyId=c.Id AND uc.UserId=$2
+	)
+`
+
+const makeAdminQuery = `
+	UPDATE USER_COMPANY
+	SET RoleId=$3
+	WHERE CompanyId=$1 AND UserId=$2
+`
+
+// AcceptOwnership makes the member the ownership was offered to the owner of
+// the company. The new owner becomes an admin while the previous owner keeps
+// their role
+func (c *CompanyClient) AcceptOwnership(ctx context.Context, companyID, userID string) (err error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	res, err := tx.ExecContext(ctx, acceptOwnershipQuery, companyID, userID, time.Now().Add(-ownershipOfferExpirationTime))
+	if err != nil {
+		return errors.WithMessage(err, "could not accept ownership")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return NoOwnershipOfferError
+	}
+
+	if _, err = tx.ExecContext(ctx, makeAdminQuery, companyID, userID, adminRoleID); err != nil {
+		return errors.WithMessage(err, "could not make owner admin")
+	}
+	return tx.Commit()
+}
+
+const cancelOwnershipOfferQuery = `
+	UPDATE COMPANY as c
+	SET PendingOwnerId=NULL, OwnershipOfferedAt=NULL
+	WHERE c.Id=$1 AND c.PendingOwnerId IS NOT NULL AND (
+		c.OwnerId=$2
+		OR c.PendingOwnerId=$2
+		OR (c.OwnerId IS NULL AND EXISTS (
+			SELECT 1
+			FROM USER_COMPANY as uc
+			WHERE uc.CompanyId=c.Id AND uc.UserId=$2 AND uc.RoleId=$3
+		))
+	)
+`
+
+// CancelOwnershipOffer withdraws a pending ownership transfer. The member it
+// was offered to can decline it the same way
+func (c *CompanyClient) CancelOwnershipOffer(ctx context.Context, companyID, userID string) error {
+	res, err := c.DB.ExecContext(ctx, cancelOwnershipOfferQuery, companyID, userID, adminRoleID)
+	if err != nil {
+		return errors.WithMessage(err, "could not cancel ownership transfer")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return NoOwnershipOfferError
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/pkg/errors"
+)
+
+const passwordResetExpirationTime = time.Hour
+
+var InvalidPasswordResetTokenError = errors.New("invalid or expired password reset token")
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (r ResetPasswordRequest) Valid() error {
+	errs := make(FieldErrors)
+
+	if r.Token == "" {
+		errs["token"] = "token cannot be empty"
+	}
+
+	if _, err := utils.IsValidPassword(r.Password); err != nil {
+		errs["password"] = err.Error()
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+const invalidatePasswordResetsQuery = `
+	UPDATE PASSWORD_RESETS
+	SET UsedAt=NOW()
+	WHERE UserId=$1 AND UsedAt IS NULL
+`
+
+const createPasswordResetQuery = `
+	INSERT INTO PASSWORD_RESETS(UserId,TokenHash,ExpiresAt)
+	VALUES ($1,$2,$3)
+`
+
+// CreatePasswordReset issues a new single use password reset token for the user
+// with the given email. Any earlier tokens for the user are invalidated
+func (c *SessionClient) CreatePasswordReset(ctx context.Context, email string) (token string, user utils.SessionUser, err error) {
+	user, err = utils.FindUserByEmail(ctx, c.DB, email)
+	if err != nil {
+		return
+	}
+
+	token, err = utils.NewOpaqueToken()
+	if err != nil {
+		return
+	}
+
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, invalidatePasswordResetsQuery, user.ID); err != nil {
+		return token, user, errors.WithMessage(err, "could not invalidate old password resets")
+	}
+
+	expiresAt := time.Now().Add(passwordResetExpirationTime)
+	if _, err = tx.ExecContext(ctx, createPasswordResetQuery, user.ID, utils.HashToken(token), expiresAt); err != nil {
+		return token, user, errors.WithMessage(err, "could not create password reset")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	return token, user, nil
+}
+
+const usePasswordResetQuery = `
+	UPDATE PASSWORD_RESETS
+	SET UsedAt=NOW()
+	WHERE TokenHash=$1 AND UsedAt IS NULL AND ExpiresAt > NOW()
+	RETURNING UserId
+`
+
+const updateUserPasswordQuery = `
+	UPDATE users
+	SET Password=$2
+	WHERE ID=$1
+`
+
+// ResetPassword consumes the password reset token, sets the new password and
+// ends all existing sessions for the user
+func (c *SessionClient) ResetPassword(ctx context.Context, resetRequest ResetPasswordRequest) (err error) {
+	hashedPassword, err := c.PasswordHasher.Hash(resetRequest.Password)
+	if err != nil {
+		return
+	}
+
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	var userID string
+	err = tx.QueryRowContext(ctx, usePasswordResetQuery, utils.HashToken(resetRequest.Token)).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return InvalidPasswordResetTokenError
+		}
+		return errors.WithMessage(err, "could not use password reset token")
+	}
+
+	if _, err = tx.ExecContext(ctx, updateUserPasswordQuery, userID, hashedPassword); err != nil {
+		return errors.WithMessage(err, "could not update password")
+	}
+
+	if _, err = tx.ExecContext(ctx, revokeUserSessionsQuery, userID); err != nil {
+		return errors.WithMessage(err, "could not revoke user sessions")
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,319 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/pkg/errors"
+)
+
+var (
+	InvalidRefreshTokenError = errors.New("invalid refresh token")
+	RefreshTokenReusedError  = errors.New("refresh token has already been used")
+	SessionRevokedError      = errors.New("session is revoked")
+)
+
+const createSessionQuery = `
+	INSERT INTO SESSIONS(Id,UserId,UserAgent,IP)
+	VALUES ($1,$2,$3,$4)
+`
+
+const createRefreshTokenQuery = `
+	INSERT INTO REFRESH_TOKENS(SessionId,TokenHash,ExpiresAt)
+	VALUES ($1,$2,$3)
+`
+
+// newSession starts a new token family for the user and issues the first
+// access and refresh token pair
+func (c *SessionClient) newSession(ctx context.Context, userSessionData UserSessionData) (resp SessionResponse, err error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	sessionID := uuid.New().String()
+	clientInfo := utils.ClientInfoFromContext(ctx)
+	_, err = tx.ExecContext(ctx, createSessionQuery, sessionID, userSessionData.SessionUser.ID, truncate(clientInfo.UserAgent, 512), truncate(clientInfo.IP, 64))
+	if err != nil {
+		return resp, errors.WithMessage(err, "could not create session")
+	}
+
+	refreshToken, refreshExpiresAt, err := c.newRefreshToken(ctx, tx, sessionID)
+	if err != nil {
+		return
+	}
+
+	err = recordAuditEvent(ctx, tx, AuditEvent{
+		Action:     AuditLogin,
+		ActorID:    userSessionData.SessionUser.ID,
+		TargetType: auditTargetUser,
+		TargetID:   userSessionData.SessionUser.ID,
+		After:      auditState(map[string]string{"sessionId": sessionID}),
+	})
+	if err != nil {
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	return c.newSessionResponse(sessionID, refreshToken, refreshExpiresAt, userSessionData)
+}
+
+func (c *SessionClient) newRefreshToken(ctx context.Context, tx *sql.Tx, sessionID string) (refreshToken string, expiresAt time.Time, err error) {
+	refreshToken, err = utils.NewOpaqueToken()
+	if err != nil {
+		return
+	}
+
+	expiresAt = time.Now().Add(time.Hour * time.Duration(c.RefreshTokenExpirationTime))
+	_, err = tx.ExecContext(ctx, createRefreshTokenQuery, sessionID, utils.HashToken(refreshToken), expiresAt)
+	if err != nil {
+		return refreshToken, expiresAt, errors.WithMessage(err, "could not store refresh token")
+	}
+	return
+}
+
+func (c *SessionClient) newSessionResponse(sessionID, refreshToken string, refreshExpiresAt time.Time, userSessionData UserSessionData) (resp SessionResponse, err error) {
+	expiresAt := time.Now().Local().Add(time.Minute * time.Duration(c.TokenExpirationTime))
+	tokenString, err := c.newToken(expiresAt, userSessionData.SessionUser.ID, sessionID)
+	if err != nil {
+		c.logger.Unsuccessful("not able to generate token", err)
+		return
+	}
+
+	resp.Status = true
+	resp.Message = "logged in"
+	resp.Token = tokenString
+	resp.ExpiresAt = expiresAt
+	resp.RefreshToken = refreshToken
+	resp.RefreshExpiresAt = refreshExpiresAt
+	resp.UserSession = userSessionData
+	return resp, nil
+}
+
+const findRefreshTokenQuery = `
+	SELECT
+	rt.Id
+	,rt.SessionId
+	,rt.ExpiresAt
+	,rt.UsedAt
+	,s.UserId
+	,s.RevokedAt
+	FROM REFRESH_TOKENS as rt
+	INNER JOIN SESSIONS as s
+	ON s.Id=rt.SessionId
+	WHERE rt.TokenHash=$1
+	FOR UPDATE OF rt
+`
+
+const useRefreshTokenQuery = `
+	UPDATE REFRESH_TOKENS
+	SET UsedAt=NOW()
+	WHERE Id=$1
+`
+
+const touchSessionQuery = `
+	UPDATE SESSIONS
+	SET LastSeenAt=NOW(), IP=COALESCE(NULLIF($2,''),IP)
+	WHERE Id=$1
+`
+
+// RefreshSession exchanges a refresh token for a new access and refresh token
+// pair. A refresh token can only be used once, presenting it a second time
+// revokes the whole token family since it is likely to have been stolen
+func (c *SessionClient) RefreshSession(ctx context.Context, refreshToken string) (resp SessionResponse, err error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	var (
+		tokenID   int
+		sessionID string
+		userID    string
+		expiresAt time.Time
+		usedAt    *time.Time
+		revokedAt *time.Time
+	)
+	err = tx.QueryRowContext(ctx, findRefreshTokenQuery, utils.HashToken(refreshToken)).Scan(&tokenID, &sessionID, &expiresAt, &usedAt, &userID, &revokedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return resp, InvalidRefreshTokenError
+		}
+		return resp, errors.WithMessage(err, "could not find refresh token")
+	}
+
+	if revokedAt != nil {
+		return resp, SessionRevokedError
+	}
+
+	if usedAt != nil {
+		if _, err = tx.ExecContext(ctx, revokeSessionQuery, sessionID); err != nil {
+			return resp, errors.WithMessage(err, "could not revoke token family")
+		}
+		if err = tx.Commit(); err != nil {
+			return
+		}
+		c.logger.Unsuccessful("refresh token reused, session "+sessionID+" revoked", RefreshTokenReusedError)
+		return resp, RefreshTokenReusedError
+	}
+
+	if time.Now().After(expiresAt) {
+		return resp, InvalidRefreshTokenError
+	}
+
+	if _, err = tx.ExecContext(ctx, useRefreshTokenQuery, tokenID); err != nil {
+		return resp, errors.WithMessage(err, "could not mark refresh token as used")
+	}
+
+	if _, err = tx.ExecContext(ctx, touchSessionQuery, sessionID, truncate(utils.ClientInfoFromContext(ctx).IP, 64)); err != nil {
+		return resp, errors.WithMessage(err, "could not update session")
+	}
+
+	newRefreshToken, refreshExpiresAt, err := c.newRefreshToken(ctx, tx, sessionID)
+	if err != nil {
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	userSessionData, err := c.GetUserSessionFromUserId(ctx, userID)
+	if err != nil {
+		return
+	}
+
+	return c.newSessionResponse(sessionID, newRefreshToken, refreshExpiresAt, userSessionData)
+}
+
+const revokeSessionQuery = `
+	UPDATE SESSIONS
+	SET RevokedAt=NOW()
+	WHERE Id=$1 AND RevokedAt IS NULL
+	RETURNING UserId
+`
+
+// RevokeSession ends the session the access token was issued for
+func (c *SessionClient) RevokeSession(ctx context.Context, sessionID string) error {
+	var userID string
+	err := c.DB.QueryRowContext(ctx, revokeSessionQuery, sessionID).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return errors.WithMessage(err, "could not revoke session")
+	}
+
+	err = recordAuditEvent(ctx, c.DB, AuditEvent{
+		Action:     AuditLogout,
+		ActorID:    userID,
+		TargetType: auditTargetUser,
+		TargetID:   userID,
+		Before:     auditState(map[string]string{"sessionId": sessionID}),
+	})
+	if err != nil {
+		c.logger.Unsuccessful("could not record logout", err)
+	}
+	return nil
+}
+
+const findSessionByRefreshTokenQuery = `
+	SELECT SessionId
+	FROM REFRESH_TOKENS
+	WHERE TokenHash=$1
+`
+
+// RevokeSessionByRefreshToken ends the session the refresh token belongs to
+func (c *SessionClient) RevokeSessionByRefreshToken(ctx context.Context, refreshToken string) error {
+	var sessionID string
+	err := c.DB.QueryRowContext(ctx, findSessionByRefreshTokenQuery, utils.HashToken(refreshToken)).Scan(&sessionID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return InvalidRefreshTokenError
+		}
+		return errors.WithMessage(err, "could not find session")
+	}
+	return c.RevokeSession(ctx, sessionID)
+}
+
+const revokeUserSessionsQuery = `
+	UPDATE SESSIONS
+	SET RevokedAt=NOW()
+	WHERE UserId=$1 AND RevokedAt IS NULL
+`
+
+// RevokeUserSessions ends every active session for the given user
+func (c *SessionClient) RevokeUserSessions(ctx context.Context, userID string) error {
+	_, err := c.DB.ExecContext(ctx, revokeUserSessionsQuery, userID)
+	if err != nil {
+		return errors.WithMessage(err, "could not revoke user sessions")
+	}
+	return nil
+}
+
+const isSessionActiveQuery = `
+	SELECT CreatedAt, RevokedAt, LastSeenAt
+	FROM SESSIONS
+	WHERE Id=$1 AND UserId=$2
+`
+
+const touchSessionLastSeenQuery = `
+	UPDATE SESSIONS
+	SET LastSeenAt=NOW()
+	WHERE Id=$1
+`
+
+// lastSeenResolution limits how often a session's last seen time is written
+const lastSeenResolution = time.Minute
+
+// IsSessionActive checks that the session an access token was issued for
+// still exists and has not been revoked, and records that it was seen. It
+// returns when the session logged in
+func (c *SessionClient) IsSessionActive(ctx context.Context, userID, sessionID string) (createdAt time.Time, err error) {
+	if sessionID == "" {
+		return createdAt, SessionRevokedError
+	}
+
+	var (
+		revokedAt  *time.Time
+		lastSeenAt *time.Time
+	)
+	err = c.DB.QueryRowContext(ctx, isSessionActiveQuery, sessionID, userID).Scan(&createdAt, &revokedAt, &lastSeenAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return createdAt, SessionRevokedError
+		}
+		return createdAt, errors.WithMessage(err, "could not check session")
+	}
+
+	if revokedAt != nil {
+		return createdAt, SessionRevokedError
+	}
+
+	if lastSeenAt == nil || time.Since(*lastSeenAt) > lastSeenResolution {
+		if _, err = c.DB.ExecContext(ctx, touchSessionLastSeenQuery, sessionID); err != nil {
+			c.logger.Unsuccessful("could not update session last seen", err)
+		}
+	}
+	return createdAt, nil
+}
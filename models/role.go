@@ -0,0 +1,462 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Permission is a single thing a role allows its members to do in a company
+type Permission string
+
+const (
+	FeedbackRead     Permission = "feedback.read"
+	FeedbackCreate   Permission = "feedback.create"
+	FeedbackModerate Permission = "feedback.moderate"
+	FeedbackTriage   Permission = "feedback.triage"
+	MembersInvite    Permission = "members.invite"
+	MembersManage    Permission = "members.manage"
+	TeamsManage      Permission = "teams.manage"
+	RolesManage      Permission = "roles.manage"
+	CompanySettings  Permission = "company.settings"
+	AuditRead        Permission = "audit.read"
+)
+
+// Permissions are the permissions roles can be built from
+var Permissions = []Permission{
+	FeedbackRead,
+	FeedbackCreate,
+	FeedbackModerate,
+	FeedbackTriage,
+	MembersInvite,
+	MembersManage,
+	TeamsManage,
+	RolesManage,
+	CompanySettings,
+	AuditRead,
+}
+
+// Valid checks that the permission is one of the known permissions
+func (p Permission) Valid() bool {
+	for _, permission := range Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// adminRoleID is the id of the default admin role. Companies always keep at
+// least one member with it
+const adminRoleID = 1
+
+// readRoleID is the id of the default read role, which new members get unless
+// the company has chosen another default role
+const readRoleID = 3
+
+var (
+	RoleNotFoundError = errors.New("role not found")
+	RoleExistsError   = errors.New("the company already has a role with that name")
+	RoleInUseError    = errors.New("the role is still given to members, pending invitations or domains")
+
+	RoleNotGrantableError    = errors.New("you cannot give others a role with permissions you do not have")
+	MemberNotManageableError = errors.New("you cannot change members who have permissions you do not have")
+)
+
+// Role is a named set of permissions. The default roles admin, write and read
+// are shared by every company and cannot be changed
+type Role struct {
+	ID          int          `json:"id"`
+	Name        string       `json:"name"`
+	Default     bool         `json:"default"`
+	Permissions []Permission `json:"permissions"`
+}
+
+// RoleRequest creates or updates a custom role of a company
+type RoleRequest struct {
+	Name        string       `json:"name"`
+	Permissions []Permission `json:"permissions"`
+}
+
+func (r *RoleRequest) Valid() error {
+	errs := make(FieldErrors)
+
+	r.Name = strings.TrimSpace(r.Name)
+	switch strings.ToLower(r.Name) {
+	case "":
+		errs["name"] = "name cannot be empty"
+	case string(Admin), Write, Read:
+		errs["name"] = fmt.Sprintf("%s is the name of a default role", r.Name)
+	default:
+		if len(r.Name) > 64 {
+			errs["name"] = "name cannot be longer than 64 characters"
+		}
+	}
+
+	permissions := make([]Permission, 0, len(r.Permissions))
+	seen := make(map[Permission]bool)
+	for _, permission := range r.Permissions {
+		if !permission.Valid() {
+			errs["permissions"] = fmt.Sprintf("%s is not a valid permission", permission)
+			break
+		}
+		if !seen[permission] {
+			seen[permission] = true
+			permissions = append(permissions, permission)
+		}
+	}
+	r.Permissions = permissions
+	if len(r.Permissions) == 0 && errs["permissions"] == "" {
+		errs["permissions"] = "a role needs at least one permission"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+const findRoleQuery = `
+	SELECT Id
+	FROM ROLE
+	WHERE LOWER(Name)=LOWER($2) AND (CompanyId=$1 OR CompanyId IS NULL)
+`
+
+const findDefaultRoleQuery = `
+	SELECT COALESCE(DefaultRoleId,$2)
+	FROM COMPANY
+	WHERE Id=$1
+`
+
+// findRoleID finds the default or custom role of the company with the given
+// name. Without a name it finds the role new members of the company get
+func findRoleID(ctx context.Context, db queryRower, companyID string, role AccessLevel) (roleID int, err error) {
+	if role == "" {
+		err = db.QueryRowContext(ctx, findDefaultRoleQuery, companyID, readRoleID).Scan(&roleID)
+	} else {
+		err = db.QueryRowContext(ctx, findRoleQuery, companyID, role).Scan(&roleID)
+	}
+	if err == sql.ErrNoRows {
+		return roleID, RoleNotFoundError
+	}
+	if err != nil {
+		return roleID, errors.WithMessage(err, "could not find role")
+	}
+	return roleID, nil
+}
+
+const getCompanyRolesQuery = `
+	SELECT
+	r.Id
+	,r.Name
+	,r.CompanyId IS NULL
+	,ARRAY(
+		SELECT rp.Permission
+		FROM ROLE_PERMISSION as rp
+		WHERE rp.RoleId=r.Id
+		ORDER BY rp.Permission
+	)
+	FROM ROLE as r
+	WHERE r.CompanyId=$1 OR r.CompanyId IS NULL
+	ORDER BY r.CompanyId NULLS FIRST, r.Id
+`
+
+// GetCompanyRoles lists the default roles followed by the custom roles of the
+// company
+func (c *CompanyClient) GetCompanyRoles(ctx context.Context, companyID string) (roles []Role, err error) {
+	rows, err := c.DB.QueryContext(ctx, getCompanyRolesQuery, companyID)
+	if err != nil {
+		return roles, errors.WithMessage(err, "could not get roles")
+	}
+	defer rows.Close()
+
+	roles = []Role{}
+	for rows.Next() {
+		var (
+			role        Role
+			permissions []string
+		)
+		if err = rows.Scan(&role.ID, &role.Name, &role.Default, pq.Array(&permissions)); err != nil {
+			return roles, errors.WithStack(err)
+		}
+		role.Permissions = make([]Permission, 0, len(permissions))
+		for _, permission := range permissions {
+			role.Permissions = append(role.Permissions, Permission(permission))
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+const createRoleQuery = `
+	INSERT INTO ROLE(CompanyId,Name)
+	SELECT $1,CAST($2 AS VARCHAR)
+	WHERE NOT EXISTS (SELECT 1 FROM ROLE WHERE CompanyId=$1 AND LOWER(Name)=LOWER($2))
+	RETURNING Id
+`
+
+const deleteRolePermissionsQuery = `
+	DELETE FROM ROLE_PERMISSION
+	WHERE RoleId=$1
+`
+
+const addRolePermissionsQuery = `
+	INSERT INTO ROLE_PERMISSION(RoleId,Permission)
+	SELECT $1, UNNEST(CAST($2 AS VARCHAR[]))
+`
+
+func setRolePermissions(ctx context.Context, tx *sql.Tx, roleID int, permissions []Permission) error {
+	if _, err := tx.ExecContext(ctx, deleteRolePermissionsQuery, roleID); err != nil {
+		return errors.WithMessage(err, "could not remove role permissions")
+	}
+
+	names := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		names = append(names, string(permission))
+	}
+	if _, err := tx.ExecContext(ctx, addRolePermissionsQuery, roleID, pq.Array(names)); err != nil {
+		return errors.WithMessage(err, "could not add role permissions")
+	}
+	return nil
+}
+
+// CreateRole creates a custom role for the company
+func (c *CompanyClient) CreateRole(ctx context.Context, companyID string, roleRequest RoleRequest) (role Role, err error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	err = tx.QueryRowContext(ctx, createRoleQuery, companyID, roleRequest.Name).Scan(&role.ID)
+	if err == sql.ErrNoRows {
+		return role, RoleExistsError
+	}
+	if err != nil {
+		return role, errors.WithMessage(err, "could not create role")
+	}
+
+	if err = setRolePermissions(ctx, tx, role.ID, roleRequest.Permissions); err != nil {
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	role.Name = roleRequest.Name
+	role.Permissions = roleRequest.Permissions
+	return role, nil
+}
+
+const roleNameExistsQuery = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM ROLE
+		WHERE CompanyId=$1 AND LOWER(Name)=LOWER($2) AND Id<>$3
+	)
+`
+
+const updateRoleQuery = `
+	UPDATE ROLE
+	SET Name=$3
+	WHERE Id=$1 AND CompanyId=$2
+`
+
+// UpdateRole renames a custom role of the company and replaces its
+// permissions
+func (c *CompanyClient) UpdateRole(ctx context.Context, companyID string, roleID int, roleRequest RoleRequest) (err error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	var exists bool
+	if err = tx.QueryRowContext(ctx, roleNameExistsQuery, companyID, roleRequest.Name, roleID).Scan(&exists); err != nil {
+		return errors.WithMessage(err, "could not check role name")
+	}
+	if exists {
+		return RoleExistsError
+	}
+
+	res, err := tx.ExecContext(ctx, updateRoleQuery, roleID, companyID, roleRequest.Name)
+	if err != nil {
+		return errors.WithMessage(err, "could not update role")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return RoleNotFoundError
+	}
+
+	if err = setRolePermissions(ctx, tx, roleID, roleRequest.Permissions); err != nil {
+		return
+	}
+	return tx.Commit()
+}
+
+const isRoleInUseQuery = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM USER_COMPANY
+		WHERE RoleId=$1
+	) OR EXISTS (
+		SELECT 1
+		FROM COMPANY_INVITATIONS
+		WHERE RoleId=$1 AND AcceptedAt IS NULL AND RevokedAt IS NULL
+	) OR EXISTS (
+		SELECT 1
+		FROM COMPANY_DOMAINS
+		WHERE RoleId=$1
+	)
+`
+
+const deleteRoleQuery = `
+	DELETE FROM ROLE
+	WHERE Id=$1 AND CompanyId=$2
+`
+
+// DeleteRole deletes a custom role of the company that no member or pending
+// invitation has
+func (c *CompanyClient) DeleteRole(ctx context.Context, companyID string, roleID int) (err error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	var inUse bool
+	if err = tx.QueryRowContext(ctx, isRoleInUseQuery, roleID).Scan(&inUse); err != nil {
+		return errors.WithMessage(err, "could not check role usage")
+	}
+	if inUse {
+		return RoleInUseError
+	}
+
+	if _, err = tx.ExecContext(ctx, deleteRolePermissionsQuery, roleID); err != nil {
+		return errors.WithMessage(err, "could not remove role permissions")
+	}
+
+	res, err := tx.ExecContext(ctx, deleteRoleQuery, roleID, companyID)
+	if err != nil {
+		return errors.WithMessage(err, "could not delete role")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return RoleNotFoundError
+	}
+	return tx.Commit()
+}
+
+const canGrantRoleQuery = `
+	SELECT NOT EXISTS (
+		SELECT 1
+		FROM ROLE_PERMISSION as rp
+		WHERE rp.RoleId=$3 AND rp.Permission NOT IN (
+			SELECT up.Permission
+			FROM USER_COMPANY as uc
+			INNER JOIN ROLE_PERMISSION as up
+			ON up.RoleId=uc.RoleId
+			WHERE uc.CompanyId=$1 AND uc.UserId=$2
+		)
+	)
+`
+
+// CanGrantRole checks that the user has every permission of the role, so that
+// members cannot give others more access than they have themselves
+func (c *CompanyClient) CanGrantRole(ctx context.Context, companyID, userID string, role AccessLevel) (canGrant bool, err error) {
+	roleID, err := findRoleID(ctx, c.DB, companyID, role)
+	if err != nil {
+		return false, err
+	}
+
+	if err = c.DB.QueryRowContext(ctx, canGrantRoleQuery, companyID, userID, roleID).Scan(&canGrant); err != nil {
+		return false, errors.WithMessage(err, "could not check role permissions")
+	}
+	return canGrant, nil
+}
+
+const canManageMemberQuery = `
+	SELECT NOT EXISTS (
+		SELECT 1
+		FROM USER_COMPANY as muc
+		INNER JOIN ROLE_PERMISSION as rp
+		ON rp.RoleId=muc.RoleId
+		WHERE muc.CompanyId=$1 AND muc.UserId=$3 AND rp.Permission NOT IN (
+			SELECT up.Permission
+			FROM USER_COMPANY as uc
+			INNER JOIN ROLE_PERMISSION as up
+			ON up.RoleId=uc.RoleId
+			WHERE uc.CompanyId=$1 AND uc.UserId=$2
+		)
+	)
+`
+
+// CanManageMember checks that the user has every permission of the current
+// role of the member, so that members cannot remove or demote those with more
+// access than they have themselves
+func (c *CompanyClient) CanManageMember(ctx context.Context, companyID, userID, memberID string) (canManage bool, err error) {
+	if err = c.DB.QueryRowContext(ctx, canManageMemberQuery, companyID, userID, memberID).Scan(&canManage); err != nil {
+		return false, errors.WithMessage(err, "could not check member permissions")
+	}
+	return canManage, nil
+}
+
+const getUserPermissionsQuery = `
+	SELECT rp.Permission
+	FROM USER_COMPANY as uc
+	INNER JOIN ROLE_PERMISSION as rp
+	ON rp.RoleId=uc.RoleId
+	WHERE uc.CompanyId=$1 AND uc.UserId=$2
+	ORDER BY rp.Permission
+`
+
+// GetUserPermissions lists the permissions the role of the user in the company
+// gives them
+func (c *CompanyClient) GetUserPermissions(ctx context.Context, companyID, userID string) (permissions []Permission, err error) {
+	rows, err := c.DB.QueryContext(ctx, getUserPermissionsQuery, companyID, userID)
+	if err != nil {
+		return permissions, errors.WithMessage(err, "could not get user permissions")
+	}
+	defer rows.Close()
+
+	permissions = []Permission{}
+	for rows.Next() {
+		var permission Permission
+		if err = rows.Scan(&permission); err != nil {
+			return permissions, errors.WithStack(err)
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
@@ -4,15 +4,16 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/kristohberg/CreatixBackend/internal/password"
 	"github.com/kristohberg/CreatixBackend/logging"
 	"github.com/kristohberg/CreatixBackend/utils"
 	"github.com/labstack/echo"
 	_ "github.com/lib/pq"
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/bcrypt"
 )
 
 //
@@ -20,26 +21,56 @@ import (
 type SessionClienter interface {
 	CreateUser(ctx context.Context, signup Signup) error
 	LoginUser(ctx context.Context, loginRequest *LoginRequest) (SessionResponse, error)
+	RefreshSession(ctx context.Context, refreshToken string) (SessionResponse, error)
+	RevokeSession(ctx context.Context, sessionID string) error
+	RevokeSessionByRefreshToken(ctx context.Context, refreshToken string) error
+	RevokeUserSessions(ctx context.Context, userID string) error
+	IsSessionActive(ctx context.Context, userID, sessionID string) (createdAt time.Time, err error)
+	CreatePasswordReset(ctx context.Context, email string) (token string, user utils.SessionUser, err error)
+	ResetPassword(ctx context.Context, resetRequest ResetPasswordRequest) error
+	CreateEmailVerification(ctx context.Context, email string) (token string, user utils.SessionUser, err error)
+	VerifyEmail(ctx context.Context, token string) (userID string, err error)
+	EnrollTotp(ctx context.Context, userID string) (MfaEnrollment, error)
+	ConfirmTotp(ctx context.Context, userID, code string) (recoveryCodes []string, err error)
+	CompleteMfaLogin(ctx context.Context, mfaRequest MfaLoginRequest) (SessionResponse, error)
+	IsMfaEnabled(ctx context.Context, userID string) (bool, error)
+	LoginExternalUser(ctx context.Context, identity ExternalIdentity) (SessionResponse, error)
+	CreateAccessToken(ctx context.Context, userID string, tokenRequest AccessTokenRequest) (NewAccessTokenResponse, error)
+	GetAccessTokens(ctx context.Context, userID string) ([]AccessToken, error)
+	RevokeAccessToken(ctx context.Context, userID string, tokenID int) error
+	AuthenticateAccessToken(ctx context.Context, token string) (userID string, scopes []TokenScope, createdAt time.Time, err error)
+	GetActiveSessions(ctx context.Context, userID, currentSessionID string) ([]ActiveSession, error)
+	RevokeUserSession(ctx context.Context, userID, sessionID string) error
+	RevokeOtherSessions(ctx context.Context, userID, currentSessionID string) error
+	ForceLogoutMember(ctx context.Context, companyID, memberID string) error
+	GetSecurityLog(ctx context.Context, userID string, filter AuditFilter) (AuditLog, error)
 }
 
 type SessionClient struct {
-	DB                  *sql.DB
-	TokenSecret         []byte
-	TokenExpirationTime int
-	logger              *logging.StandardLogger
-	CompanyClient       CompanyClienter
+	DB                         *sql.DB
+	Keyring                    *utils.Keyring
+	TokenExpirationTime        int
+	RefreshTokenExpirationTime int
+	logger                     *logging.StandardLogger
+	CompanyClient              CompanyClienter
+	PasswordHasher             password.Hasher
+
+	dummyHashOnce sync.Once
+	dummyHash     string
 }
 
-// NewSessionClient creates new session client
-func NewSessionClient(DB *sql.DB, tokenSecret []byte, tokenExpirationTime int, logger *logging.StandardLogger) *SessionClient {
+// NewSessionClient creates new session client. The access token expiration time
+// is given in minutes and the refresh token expiration time in hours
+func NewSessionClient(DB *sql.DB, keyring *utils.Keyring, tokenExpirationTime, refreshTokenExpirationTime int, logger *logging.StandardLogger) *SessionClient {
 	companyClient := NewCompanyClient(DB)
-	return &SessionClient{DB: DB, TokenSecret: tokenSecret, TokenExpirationTime: tokenExpirationTime, logger: logger, CompanyClient: companyClient}
+	return &SessionClient{DB: DB, Keyring: keyring, TokenExpirationTime: tokenExpirationTime, RefreshTokenExpirationTime: refreshTokenExpirationTime, logger: logger, CompanyClient: companyClient, PasswordHasher: password.NewHasher(password.DefaultArgon2idParams)}
 }
 
 // LoginRequest contains the login credentials
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	InviteToken string `json:"inviteToken"`
 }
 
 type User struct {
@@ -53,6 +84,7 @@ type User struct {
 
 type Signup struct {
 	User
+	InviteToken string `json:"inviteToken"`
 }
 
 type FieldErrors map[string]string
@@ -100,18 +132,23 @@ type UserSessionData struct {
 	Companies   []Company         `json:"companies"`
 }
 type SessionResponse struct {
-	Status      bool      `json:"status"`
-	Message     string    `json:"message"`
-	Token       string    `json:"token"`
-	ExpiresAt   time.Time `json:"expiresAt"`
-	UserSession UserSessionData
+	Status           bool      `json:"status"`
+	Message          string    `json:"message"`
+	Token            string    `json:"token"`
+	ExpiresAt        time.Time `json:"expiresAt"`
+	RefreshToken     string    `json:"-"`
+	RefreshExpiresAt time.Time `json:"-"`
+	MfaRequired      bool      `json:"mfaRequired"`
+	MfaToken         string    `json:"mfaToken"`
+	UserSession      UserSessionData
 }
 
-// NewToken creates a new token with a default claim
-func (c *SessionClient) newToken(expiresAt time.Time, userID string) (string, error) {
+// NewToken creates a new short lived access token bound to the given session
+func (c *SessionClient) newToken(expiresAt time.Time, userID, sessionID string) (string, error) {
 
 	claims := utils.Claims{
-		UserID: userID,
+		UserID:    userID,
+		SessionID: sessionID,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expiresAt.Unix(),
 			Issuer:    "creatix",
@@ -119,8 +156,7 @@ func (c *SessionClient) newToken(expiresAt time.Time, userID string) (string, er
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.GetSigningMethod("HS256"), claims)
-	tokenString, err := token.SignedString(c.TokenSecret)
+	tokenString, err := c.Keyring.Sign(claims)
 	if err != nil {
 		c.logger.Unsuccessful("not able to generate token string", err)
 		return tokenString, err
@@ -164,44 +200,74 @@ func (c *SessionClient) GetUserSessionFromUserId(ctx context.Context, userID str
 // LoginUser checks if the user given password and username exists
 // if it does
 func (c *SessionClient) LoginUser(ctx context.Context, loginRequest *LoginRequest) (resp SessionResponse, err error) {
+	if err = c.checkLoginThrottle(ctx, loginRequest); err != nil {
+		c.logger.Unsuccessful("login throttled", err)
+		return
+	}
+
 	userSessionData, err := c.GetUserSessionFromEmail(ctx, loginRequest.Email)
 	if err != nil {
 		c.logger.Unsuccessful("could not get usersessiondata", err)
-		return
+		// Compare against a dummy hash so unknown emails take as long as
+		// wrong passwords
+		c.PasswordHasher.Verify(c.dummyPasswordHash(), loginRequest.Password)
+		return resp, c.loginFailed(ctx, loginRequest, nil)
 	}
 
 	hashedPassword, err := c.findUserPasswordByEmail(ctx, loginRequest.Email)
 	if err != nil {
 		c.logger.Unsuccessful("could not find user", err)
-		return
+		return resp, InvalidCredentialsError
 	}
 
-	errf := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(loginRequest.Password))
-	if errf == bcrypt.ErrMismatchedHashAndPassword {
-		c.logger.Unsuccessful("incorrect email or password", err)
-		err = errors.New("passwords do not match")
-		return
+	ok, errf := c.PasswordHasher.Verify(hashedPassword, loginRequest.Password)
+	if errf != nil || !ok {
+		c.logger.Unsuccessful("incorrect email or password", errf)
+		return resp, c.loginFailed(ctx, loginRequest, &userSessionData.SessionUser)
 	}
 
+	if c.PasswordHasher.NeedsRehash(hashedPassword) {
+		c.rehashPassword(ctx, userSessionData.SessionUser.ID, hashedPassword, loginRequest.Password)
+	}
+
+	mfaEnabled, err := c.IsMfaEnabled(ctx, userSessionData.SessionUser.ID)
 	if err != nil {
-		c.logger.Unsuccessful("incorrect email or password", err)
 		return
 	}
 
-	expiresAt := time.Now().Local().Add(time.Minute * time.Duration(c.TokenExpirationTime))
-	tokenString, err := c.newToken(expiresAt, userSessionData.SessionUser.ID)
-	if err != nil {
-		c.logger.Unsuccessful("not able to generate token", err)
+	// Failures are only forgotten once the second factor is checked as well
+	if mfaEnabled {
+		return c.newMfaChallenge(ctx, userSessionData.SessionUser.ID)
+	}
+
+	if err = accountLoginThrottle.reset(ctx, c.DB, accountThrottleSubject(loginRequest.Email)); err != nil {
+		c.logger.Unsuccessful("could not reset login throttle", err)
+	}
+
+	c.acceptInvitation(ctx, loginRequest.InviteToken, &userSessionData)
+	return c.newSession(ctx, userSessionData)
+}
+
+// acceptInvitation joins the company the user was invited to when signing up
+// or logging in with an invitation token. An invalid token does not fail the
+// signup or login, the user just does not join the company
+func (c *SessionClient) acceptInvitation(ctx context.Context, token string, userSessionData *UserSessionData) {
+	if token == "" {
 		return
 	}
 
-	resp.Status = false
-	resp.Message = "logged in"
-	resp.Token = tokenString
-	resp.ExpiresAt = expiresAt
-	resp.UserSession = userSessionData
+	if _, err := c.CompanyClient.AcceptInvitation(ctx, token, userSessionData.SessionUser); err != nil {
+		c.logger.Unsuccessful("could not accept invitation", err)
+		return
+	}
+	userSessionData.SessionUser.EmailVerified = true
 
-	return resp, nil
+	companies, err := c.CompanyClient.GetUserCompanies(ctx, userSessionData.SessionUser.ID)
+	if err != nil {
+		c.logger.Unsuccessful("login.getcompanies.error", err)
+		return
+	}
+	userSessionData.Companies = companies
 }
 
 var createUserCompanyQuery = `
@@ -226,11 +292,40 @@ var createUserQuery = `
 	VALUES ($1,$2,$3,$4,$5)
 `
 
+// dummyPasswordHash is verified against when the user does not exist
+func (c *SessionClient) dummyPasswordHash() string {
+	c.dummyHashOnce.Do(func() {
+		c.dummyHash, _ = c.PasswordHasher.Hash("creatix-dummy-password")
+	})
+	return c.dummyHash
+}
+
+const rehashUserPasswordQuery = `
+	UPDATE users
+	SET Password=$3
+	WHERE ID=$1 AND Password=$2
+`
+
+// rehashPassword replaces a hash made with an older algorithm or weaker
+// parameters. It only updates the password if it has not been changed since
+// it was verified, and a failure does not stop the login
+func (c *SessionClient) rehashPassword(ctx context.Context, userID, oldHash, plainPassword string) {
+	newHash, err := c.PasswordHasher.Hash(plainPassword)
+	if err != nil {
+		c.logger.Unsuccessful("could not rehash password", err)
+		return
+	}
+
+	if _, err = c.DB.ExecContext(ctx, rehashUserPasswordQuery, userID, oldHash, newHash); err != nil {
+		c.logger.Unsuccessful("could not store rehashed password", err)
+	}
+}
+
 // CreateUser creates a new user in the database
 func (c *SessionClient) CreateUser(ctx context.Context, signup Signup) error {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(signup.Password), bcrypt.DefaultCost)
+	hashedPassword, err := c.PasswordHasher.Hash(signup.Password)
 	if err != nil {
-		return errors.WithMessage(err, "could not generate hashed password")
+		return err
 	}
 
 	res, err := c.DB.ExecContext(ctx, createUserQuery, signup.Firstname, signup.Lastname, signup.Username, signup.Email, hashedPassword)
@@ -247,6 +342,14 @@ func (c *SessionClient) CreateUser(ctx context.Context, signup Signup) error {
 		return errors.New("no rows affected")
 	}
 
+	if signup.InviteToken != "" {
+		user, err := utils.FindUserByEmail(ctx, c.DB, signup.Email)
+		if err != nil {
+			return err
+		}
+		c.acceptInvitation(ctx, signup.InviteToken, &UserSessionData{SessionUser: user})
+	}
+
 	return nil
 }
 
@@ -268,25 +371,28 @@ func (c *SessionClient) findUserPasswordByEmail(ctx context.Context, email strin
 var isAuthorizedQuery = `
 	SELECT 
 	uc.UserId
+	,c.RequireMfa AND u.TotpEnabledAt IS NULL
+	,c.ArchivedAt IS NOT NULL
+	,uc.LoggedOutAt
 	FROM USER_COMPANY as uc
-	LEFT JOIN (
-		SELECT 
-		AccessID
-		FROM COMPANY_ACCESS
-	) as ca 
-	ON ca.AccessID = uc.AccessId
-	WHERE uc.CompanyId=$1 AND uc.UserId=$2 AND ca.AccessID<=$3
+	INNER JOIN COMPANY as c
+	ON c.Id = uc.CompanyId
+	INNER JOIN USERS as u
+	ON u.ID = uc.UserId
+	INNER JOIN ROLE_PERMISSION as rp
+	ON rp.RoleId = uc.RoleId
+	WHERE uc.CompanyId=$1 AND uc.UserId=$2 AND rp.Permission=$3
 `
 
-func (c *SessionClient) IsAuthorized(ctx context.Context, userID, companyID string, authorization AccessLevel) error {
-
-	accessLevelID, err := authorization.ToAccessID()
-	if err != nil {
-		return errors.Wrap(err, "not able to get accesslevelid")
-	}
-
+// IsAuthorized checks that the role of the user in the company has the given
+// permission, that the user has two factor authentication enabled if the
+// company requires it, and that the session or access token of the request
+// was not created before the user was logged out of the company
+func (c *SessionClient) IsAuthorized(ctx context.Context, userID, companyID string, permission Permission) error {
 	var userIDScan string
-	err = c.DB.QueryRowContext(ctx, isAuthorizedQuery, companyID, userID, accessLevelID).Scan(&userIDScan)
+	var missingMfa, archived bool
+	var loggedOutAt *time.Time
+	err := c.DB.QueryRowContext(ctx, isAuthorizedQuery, companyID, userID, permission).Scan(&userIDScan, &missingMfa, &archived, &loggedOutAt)
 	if err != nil {
 		return errors.Wrapf(err, "could not check if user with id %s is authorized for companyid %s", userID, companyID)
 	}
@@ -295,10 +401,24 @@ func (c *SessionClient) IsAuthorized(ctx context.Context, userID, companyID stri
 		return errors.Wrap(errors.New("invalid user id"), "suspicious")
 	}
 
+	if missingMfa {
+		return MfaRequiredByCompanyError
+	}
+
+	if signedInAt, ok := utils.SignedInAtFromContext(ctx); ok && loggedOutAt != nil && !signedInAt.After(*loggedOutAt) {
+		return LoggedOutOfCompanyError
+	}
+
+	// Archived companies are read-only, their settings can still be changed so
+	// they can be restored or deleted
+	if archived && permission != FeedbackRead && permission != CompanySettings && permission != AuditRead {
+		return CompanyArchivedError
+	}
+
 	return nil
 }
 
-func (c *SessionClient) IsAuthorizedFromEchoContext(ctx echo.Context, authorization AccessLevel) (authorized bool, err error) {
+func (c *SessionClient) IsAuthorizedFromEchoContext(ctx echo.Context, permission Permission) (authorized bool, err error) {
 	companyID := ctx.Param("company")
 	if companyID == "" {
 		return false, errors.New("company id not provided")
@@ -309,7 +429,7 @@ func (c *SessionClient) IsAuthorizedFromEchoContext(ctx echo.Context, authorizat
 		return false, errors.New("userid is not in scope")
 	}
 
-	err = c.IsAuthorized(ctx.Request().Context(), userID, companyID, authorization)
+	err = c.IsAuthorized(ctx.Request().Context(), userID, companyID, permission)
 	if err != nil {
 		return
 	}
@@ -0,0 +1,369 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	TeamNotFoundError       = errors.New("team not found")
+	TeamExistsError         = errors.New("the company already has a team with that name")
+	TeamMemberNotFoundError = errors.New("user is not a member of the team")
+)
+
+// TeamRequest creates or renames a team
+type TeamRequest struct {
+	Name string `json:"name"`
+}
+
+func (r *TeamRequest) Valid() error {
+	errs := make(FieldErrors)
+
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		errs["name"] = "name cannot be empty"
+	} else if len(r.Name) > 100 {
+		errs["name"] = "name cannot be longer than 100 characters"
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// TeamMemberRequest adds a company member to a team or changes whether they
+// lead it
+type TeamMemberRequest struct {
+	UserID string `json:"userId"`
+	Lead   bool   `json:"lead"`
+}
+
+type TeamMember struct {
+	UserID   string `json:"userId"`
+	Username string `json:"username"`
+	Lead     bool   `json:"lead"`
+}
+
+const createTeamQuery = `
+	INSERT INTO TEAM(CompanyID,Name)
+	SELECT $1,CAST($2 AS VARCHAR)
+	WHERE NOT EXISTS (SELECT 1 FROM TEAM WHERE CompanyID=$1 AND Name=$2)
+	RETURNING Id
+`
+
+// CreateTeam creates a team in the company of the team
+func (c *CompanyClient) CreateTeam(ctx context.Context, team Team) (teamID string, err error) {
+	err = c.DB.QueryRowContext(ctx, createTeamQuery, team.CompanyID, team.Name).Scan(&teamID)
+	if err == sql.ErrNoRows {
+		return teamID, TeamExistsError
+	}
+	if err != nil {
+		return teamID, errors.WithMessage(err, "could not create team")
+	}
+	return teamID, nil
+}
+
+const teamNameExistsQuery = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM TEAM
+		WHERE CompanyID=$1 AND Name=$2 AND Id<>$3
+	)
+`
+
+const renameTeamQuery = `
+	UPDATE TEAM
+	SET Name=$3
+	WHERE Id=$1 AND CompanyID=$2
+`
+
+// RenameTeam changes the name of a team in the company
+func (c *CompanyClient) RenameTeam(ctx context.Context, companyID, teamID, name string) error {
+	var exists bool
+	if err := c.DB.QueryRowContext(ctx, teamNameExistsQuery, companyID, name, teamID).Scan(&exists); err != nil {
+		return errors.WithMessage(err, "could not check team name")
+	}
+	if exists {
+		return TeamExistsError
+	}
+
+	res, err := c.DB.ExecContext(ctx, renameTeamQuery, teamID, companyID, name)
+	if err != nil {
+		return errors.WithMessage(err, "could not rename team")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return TeamNotFoundError
+	}
+	return nil
+}
+
+const deleteTeamMembersQuery = `
+	DELETE FROM USER_TEAM
+	WHERE TeamId IN (SELECT Id FROM TEAM WHERE Id=$1 AND CompanyID=$2)
+`
+
+const deleteTeamFeedbackQuery = `
+	DELETE FROM FEEDBACK_TEAM
+	WHERE TeamId IN (SELECT Id FROM TEAM WHERE Id=$1 AND CompanyID=$2)
+`
+
+const deleteTeamQuery = `
+	DELETE FROM TEAM
+	WHERE Id=$1 AND CompanyID=$2
+`
+
+// DeleteTeam deletes a team of the company together with its memberships.
+// Feedback shared with the team stays hidden from the rest of the company
+func (c *CompanyClient) DeleteTeam(ctx context.Context, companyID, teamID string) (err error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, deleteTeamMembersQuery, teamID, companyID); err != nil {
+		return errors.WithMessage(err, "could not delete team members")
+	}
+
+	if _, err = tx.ExecContext(ctx, deleteTeamFeedbackQuery, teamID, companyID); err != nil {
+		return errors.WithMessage(err, "could not unshare team feedback")
+	}
+
+	res, err := tx.ExecContext(ctx, deleteTeamQuery, teamID, companyID)
+	if err != nil {
+		return errors.WithMessage(err, "could not delete team")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return
+	}
+	if affected == 0 {
+		return TeamNotFoundError
+	}
+
+	return tx.Commit()
+}
+
+const getCompanyTeamsQuery = `
+	SELECT
+	t.Id
+	,t.CompanyID
+	,t.Name
+	FROM TEAM as t
+	WHERE t.CompanyID=$1
+	ORDER BY t.Name
+`
+
+// GetCompanyTeams lists the teams of the company
+func (c *CompanyClient) GetCompanyTeams(ctx context.Context, companyID string) (teams []Team, err error) {
+	rows, err := c.DB.QueryContext(ctx, getCompanyTeamsQuery, companyID)
+	if err != nil {
+		return teams, errors.WithMessage(err, "could not get teams")
+	}
+	defer rows.Close()
+
+	teams = []Team{}
+	for rows.Next() {
+		var team Team
+		if err = rows.Scan(&team.ID, &team.CompanyID, &team.Name); err != nil {
+			return teams, errors.WithStack(err)
+		}
+		teams = append(teams, team)
+	}
+	return teams, rows.Err()
+}
+
+const getUserTeamsQuery = `
+	SELECT
+	t.Id
+	,t.CompanyID
+	,t.Name
+	,ut.IsLead
+	FROM USER_TEAM as ut
+	INNER JOIN TEAM as t
+	ON t.Id=ut.TeamId
+	WHERE ut.UserId=$1
+	ORDER BY t.CompanyID, t.Name
+`
+
+// GetUserTeams lists the teams the user is a member of across companies
+func (c *CompanyClient) GetUserTeams(ctx context.Context, userID string) (teams []Team, err error) {
+	rows, err := c.DB.QueryContext(ctx, getUserTeamsQuery, userID)
+	if err != nil {
+		return teams, errors.WithMessage(err, "could not get user teams")
+	}
+	defer rows.Close()
+
+	teams = []Team{}
+	for rows.Next() {
+		var team Team
+		if err = rows.Scan(&team.ID, &team.CompanyID, &team.Name, &team.Lead); err != nil {
+			return teams, errors.WithStack(err)
+		}
+		teams = append(teams, team)
+	}
+	return teams, rows.Err()
+}
+
+const teamExistsQuery = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM TEAM
+		WHERE Id=$1 AND CompanyID=$2
+	)
+`
+
+const getTeamMembersQuery = `
+	SELECT
+	ut.UserId
+	,u.Username
+	,ut.IsLead
+	FROM USER_TEAM as ut
+	INNER JOIN USERS as u
+	ON u.ID=ut.UserId
+	WHERE ut.TeamId=$1
+	ORDER BY ut.IsLead DESC, u.Username
+`
+
+// GetTeamMembers lists the members of a team in the company
+func (c *CompanyClient) GetTeamMembers(ctx context.Context, companyID, teamID string) (members []TeamMember, err error) {
+	var exists bool
+	if err = c.DB.QueryRowContext(ctx, teamExistsQuery, teamID, companyID).Scan(&exists); err != nil {
+		return members, errors.WithMessage(err, "could not find team")
+	}
+	if !exists {
+		return members, TeamNotFoundError
+	}
+
+	rows, err := c.DB.QueryContext(ctx, getTeamMembersQuery, teamID)
+	if err != nil {
+		return members, errors.WithMessage(err, "could not get team members")
+	}
+	defer rows.Close()
+
+	members = []TeamMember{}
+	for rows.Next() {
+		var member TeamMember
+		if err = rows.Scan(&member.UserID, &member.Username, &member.Lead); err != nil {
+			return members, errors.WithStack(err)
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+const addUserToTeamQuery = `
+	INSERT INTO USER_TEAM(TeamId,UserId,IsLead)
+	VALUES ($1,$2,$3)
+	ON CONFLICT (TeamId,UserId) DO UPDATE
+	SET IsLead=USER_TEAM.IsLead OR EXCLUDED.IsLead
+`
+
+// AddUserToTeam adds a member of the company to one of its teams. Adding a
+// user who is already in the team only makes them lead if asked, leads are
+// demoted with SetTeamLead
+func (c *CompanyClient) AddUserToTeam(ctx context.Context, companyID, teamID string, memberRequest TeamMemberRequest) error {
+	var exists bool
+	if err := c.DB.QueryRowContext(ctx, teamExistsQuery, teamID, companyID).Scan(&exists); err != nil {
+		return errors.WithMessage(err, "could not find team")
+	}
+	if !exists {
+		return TeamNotFoundError
+	}
+
+	var isMember bool
+	if err := c.DB.QueryRowContext(ctx, isCompanyMemberQuery, companyID, memberRequest.UserID).Scan(&isMember); err != nil {
+		return errors.WithMessage(err, "could not find member")
+	}
+	if !isMember {
+		return NotCompanyMemberError
+	}
+
+	if _, err := c.DB.ExecContext(ctx, addUserToTeamQuery, teamID, memberRequest.UserID, memberRequest.Lead); err != nil {
+		return errors.WithMessage(err, "could not add user to team")
+	}
+	return nil
+}
+
+const removeUserFromTeamQuery = `
+	DELETE FROM USER_TEAM
+	WHERE TeamId IN (SELECT Id FROM TEAM WHERE Id=$1 AND CompanyID=$2) AND UserId=$3
+`
+
+// RemoveUserFromTeam removes a member from a team in the company
+func (c *CompanyClient) RemoveUserFromTeam(ctx context.Context, companyID, teamID, userID string) error {
+	res, err := c.DB.ExecContext(ctx, removeUserFromTeamQuery, teamID, companyID, userID)
+	if err != nil {
+		return errors.WithMessage(err, "could not remove user from team")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return TeamMemberNotFoundError
+	}
+	return nil
+}
+
+const setTeamLeadQuery = `
+	UPDATE USER_TEAM
+	SET IsLead=$4
+	WHERE TeamId IN (SELECT Id FROM TEAM WHERE Id=$1 AND CompanyID=$2) AND UserId=$3
+`
+
+// SetTeamLead makes a team member lead the team or stop leading it
+func (c *CompanyClient) SetTeamLead(ctx context.Context, companyID, teamID, userID string, lead bool) error {
+	res, err := c.DB.ExecContext(ctx, setTeamLeadQuery, teamID, companyID, userID, lead)
+	if err != nil {
+		return errors.WithMessage(err, "could not update team lead")
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return TeamMemberNotFoundError
+	}
+	return nil
+}
+
+const isTeamLeadQuery = `
+	SELECT EXISTS (
+		SELECT 1
+		FROM USER_TEAM as ut
+		INNER JOIN TEAM as t
+		ON t.Id=ut.TeamId
+		INNER JOIN USER_COMPANY as uc
+		ON uc.CompanyId=t.CompanyID AND uc.UserId=ut.UserId
+		WHERE t.Id=$1 AND t.CompanyID=$2 AND ut.UserId=$3 AND ut.IsLead
+	)
+`
+
+// IsTeamLead checks whether the user leads the team and is still a member of
+// its company
+func (c *CompanyClient) IsTeamLead(ctx context.Context, companyID, teamID, userID string) (isLead bool, err error) {
+	err = c.DB.QueryRowContext(ctx, isTeamLeadQuery, teamID, companyID, userID).Scan(&isLead)
+	if err != nil {
+		return false, errors.WithMessage(err, "could not check team lead")
+	}
+	return isLead, nil
+}
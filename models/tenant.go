@@ -0,0 +1,193 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// tenantRole is the database role requests run their queries as. It does not
+// own the tables, so the row level security policies limit what it sees to
+// the companies of the user in app.user_id
+const tenantRole = "creatix_user"
+
+const setTenantRoleQuery = `SET LOCAL ROLE ` + tenantRole
+
+// set_config with is_local is SET LOCAL app.user_id which takes parameters
+const setTenantUserQuery = `SELECT set_config('app.user_id', $1, true)`
+
+type tenantKey struct{}
+
+// Tenant is the transaction a request of a user runs its queries in. Clients
+// using a DB run their queries in it when it is in the context of the query
+type Tenant struct {
+	db     *sql.DB
+	userID string
+
+	mu sync.Mutex
+	tx *sql.Tx
+	// handedOut is set when the transaction is returned from DB.BeginTx, as
+	// whoever began it commits or rolls it back
+	handedOut bool
+}
+
+// NewTenant creates the transaction for a request of the user. It is begun
+// by the first query
+func NewTenant(db *sql.DB, userID string) *Tenant {
+	return &Tenant{db: db, userID: userID}
+}
+
+// Context returns a copy of ctx where clients query as the tenant
+func (t *Tenant) Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+func tenantFromContext(ctx context.Context) (*Tenant, bool) {
+	t, ok := ctx.Value(tenantKey{}).(*Tenant)
+	return t, ok
+}
+
+// beginTenantTx begins a transaction where the row level security policies
+// apply for the user
+func beginTenantTx(ctx context.Context, db *sql.DB, userID string, opts *sql.TxOptions) (tx *sql.Tx, err error) {
+	tx, err = db.BeginTx(ctx, opts)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, setTenantRoleQuery); err != nil {
+		return nil, errors.WithMessage(err, "could not set tenant role")
+	}
+	if _, err = tx.ExecContext(ctx, setTenantUserQuery, userID); err != nil {
+		return nil, errors.WithMessage(err, "could not set tenant user")
+	}
+	return tx, nil
+}
+
+// current returns the transaction of the request, and begins a new one if
+// there is none or it has been handed out
+func (t *Tenant) current(ctx context.Context) (*sql.Tx, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tx == nil || t.handedOut {
+		tx, err := beginTenantTx(ctx, t.db, t.userID, &sql.TxOptions{})
+		if err != nil {
+			return nil, err
+		}
+		t.tx, t.handedOut = tx, false
+	}
+	return t.tx, nil
+}
+
+// handOut returns the transaction of the request to be committed or rolled
+// back by the caller. Queries made before it are part of it, later queries
+// run in a new transaction. That takes a second connection while the handed
+// out transaction is open, so clients query it until they end it
+func (t *Tenant) handOut(ctx context.Context) (*sql.Tx, error) {
+	tx, err := t.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.handedOut = true
+	t.mu.Unlock()
+	return tx, nil
+}
+
+// Commit commits the queries of the request which have not been handed out
+func (t *Tenant) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tx == nil || t.handedOut {
+		return nil
+	}
+	return t.tx.Commit()
+}
+
+// Rollback rolls back the queries of the request which have not been handed
+// out
+func (t *Tenant) Rollback() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tx == nil || t.handedOut {
+		return nil
+	}
+	return t.tx.Rollback()
+}
+
+// DB runs the queries of a request as its tenant, so the row level security
+// policies apply to them. Without a tenant in the context queries run
+// directly on the database, as for logins and background work
+type DB struct {
+	*sql.DB
+}
+
+// NewDB wraps the database to run queries as the tenant of their context
+func NewDB(db *sql.DB) *DB {
+	return &DB{db}
+}
+
+// BeginTx returns the transaction of the tenant, or begins a transaction on
+// the database without a tenant
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if t, ok := tenantFromContext(ctx); ok {
+		return t.handOut(ctx)
+	}
+	return db.DB.BeginTx(ctx, opts)
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	t, ok := tenantFromContext(ctx)
+	if !ok {
+		return db.DB.ExecContext(ctx, query, args...)
+	}
+
+	tx, err := t.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tx.ExecContext(ctx, query, args...)
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	t, ok := tenantFromContext(ctx)
+	if !ok {
+		return db.DB.QueryContext(ctx, query, args...)
+	}
+
+	tx, err := t.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return tx.QueryContext(ctx, query, args...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	t, ok := tenantFromContext(ctx)
+	if !ok {
+		return db.DB.QueryRowContext(ctx, query, args...)
+	}
+
+	tx, err := t.current(ctx)
+	if err != nil {
+		// A sql.Row cannot be created with an error, so the query is made to
+		// fail instead of running without the policies
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		return db.DB.QueryRowContext(canceled, query, args...)
+	}
+	return tx.QueryRowContext(ctx, query, args...)
+}
@@ -0,0 +1,225 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/pkg/errors"
+)
+
+// InvalidCredentialsError is returned for every failed login, whether the
+// email is unknown, the password is wrong or the login is throttled, so the
+// response does not tell an attacker which accounts exist
+var InvalidCredentialsError = errors.New("invalid email or password")
+
+// AccountLockedError is returned the first time an account is locked so the
+// owner can be notified. Its message is the same as InvalidCredentialsError
+type AccountLockedError struct {
+	User        utils.SessionUser
+	LockedUntil time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return InvalidCredentialsError.Error()
+}
+
+// loginThrottle describes how failed logins for one scope are limited. After
+// freeAttempts failures every attempt has to wait twice as long as the last,
+// up to maxBackoff, and after lockAfter failures the subject is locked.
+// Failures older than window are forgotten
+type loginThrottle struct {
+	scope        string
+	freeAttempts int
+	maxBackoff   time.Duration
+	lockAfter    int
+	lockDuration time.Duration
+	window       time.Duration
+}
+
+var (
+	accountLoginThrottle = loginThrottle{
+		scope:        "account",
+		freeAttempts: 3,
+		maxBackoff:   time.Minute,
+		lockAfter:    10,
+		lockDuration: time.Minute * 15,
+		window:       time.Hour * 24,
+	}
+	ipLoginThrottle = loginThrottle{
+		scope:        "ip",
+		freeAttempts: 20,
+		maxBackoff:   time.Minute,
+		lockAfter:    100,
+		lockDuration: time.Hour,
+		window:       time.Hour,
+	}
+)
+
+func (t loginThrottle) backoff(failures int) time.Duration {
+	if failures < t.freeAttempts {
+		return 0
+	}
+
+	backoff := t.maxBackoff
+	if shift := uint(failures - t.freeAttempts); shift < 16 {
+		backoff = time.Second << shift
+	}
+	if backoff > t.maxBackoff {
+		return t.maxBackoff
+	}
+	return backoff
+}
+
+const findLoginThrottleQuery = `
+	SELECT
+	Failures
+	,LastFailedAt
+	,LockedUntil
+	FROM LOGIN_THROTTLES
+	WHERE Scope=$1 AND Subject=$2
+`
+
+// isThrottled reports whether the subject has to wait before trying again
+func (t loginThrottle) isThrottled(ctx context.Context, db *sql.DB, subject string) (bool, error) {
+	var (
+		failures     int
+		lastFailedAt time.Time
+		lockedUntil  *time.Time
+	)
+	err := db.QueryRowContext(ctx, findLoginThrottleQuery, t.scope, subject).Scan(&failures, &lastFailedAt, &lockedUntil)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.WithMessage(err, "could not find login throttle")
+	}
+
+	now := time.Now()
+	if lockedUntil != nil && now.Before(*lockedUntil) {
+		return true, nil
+	}
+	if now.Sub(lastFailedAt) > t.window {
+		return false, nil
+	}
+	return now.Before(lastFailedAt.Add(t.backoff(failures))), nil
+}
+
+const recordLoginFailureQuery = `
+	INSERT INTO LOGIN_THROTTLES(Scope,Subject,Failures,LastFailedAt)
+	VALUES ($1,$2,1,NOW())
+	ON CONFLICT (Scope,Subject) DO UPDATE
+	SET Failures=CASE
+		WHEN LOGIN_THROTTLES.LastFailedAt < NOW() - $3 * INTERVAL '1 second' THEN 1
+		ELSE LOGIN_THROTTLES.Failures + 1
+	END
+	,LastFailedAt=NOW()
+	RETURNING Failures
+`
+
+const lockLoginThrottleQuery = `
+	UPDATE LOGIN_THROTTLES
+	SET Failures=0, LockedUntil=$3
+	WHERE Scope=$1 AND Subject=$2 AND (LockedUntil IS NULL OR LockedUntil < NOW())
+`
+
+// recordFailure counts a failed login and locks the subject once it has
+// failed too many times. It returns when the lock ends if this failure
+// locked it
+func (t loginThrottle) recordFailure(ctx context.Context, db *sql.DB, subject string) (lockedUntil time.Time, err error) {
+	var failures int
+	err = db.QueryRowContext(ctx, recordLoginFailureQuery, t.scope, subject, int(t.window.Seconds())).Scan(&failures)
+	if err != nil {
+		return lockedUntil, errors.WithMessage(err, "could not record login failure")
+	}
+
+	if failures < t.lockAfter {
+		return
+	}
+
+	until := time.Now().Add(t.lockDuration)
+	res, err := db.ExecContext(ctx, lockLoginThrottleQuery, t.scope, subject, until)
+	if err != nil {
+		return lockedUntil, errors.WithMessage(err, "could not lock login")
+	}
+	if affected, err := res.RowsAffected(); err == nil && affected > 0 {
+		lockedUntil = until
+	}
+	return lockedUntil, nil
+}
+
+const resetLoginThrottleQuery = `
+	DELETE FROM LOGIN_THROTTLES
+	WHERE Scope=$1 AND Subject=$2
+`
+
+func (t loginThrottle) reset(ctx context.Context, db *sql.DB, subject string) error {
+	_, err := db.ExecContext(ctx, resetLoginThrottleQuery, t.scope, subject)
+	if err != nil {
+		return errors.WithMessage(err, "could not reset login throttle")
+	}
+	return nil
+}
+
+func accountThrottleSubject(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// checkLoginThrottle fails when either the account or the ip address has
+// to wait before trying again
+func (c *SessionClient) checkLoginThrottle(ctx context.Context, loginRequest *LoginRequest) error {
+	throttled, err := accountLoginThrottle.isThrottled(ctx, c.DB, accountThrottleSubject(loginRequest.Email))
+	if err != nil || throttled {
+		return InvalidCredentialsError
+	}
+
+	if ip := utils.ClientInfoFromContext(ctx).IP; ip != "" {
+		throttled, err = ipLoginThrottle.isThrottled(ctx, c.DB, ip)
+		if err != nil || throttled {
+			return InvalidCredentialsError
+		}
+	}
+	return nil
+}
+
+// loginFailed records the failed attempt for the account and ip address. The
+// owner of an existing account is returned in an AccountLockedError when the
+// attempt locks the account
+func (c *SessionClient) loginFailed(ctx context.Context, loginRequest *LoginRequest, user *utils.SessionUser) error {
+	lockedUntil := c.recordLoginFailure(ctx, loginRequest.Email)
+
+	event := AuditEvent{
+		Action: AuditLoginFailed,
+		After:  auditState(map[string]string{"email": loginRequest.Email, "reason": "password"}),
+	}
+	if user != nil {
+		event.TargetType, event.TargetID = auditTargetUser, user.ID
+	}
+	if err := recordAuditEvent(ctx, c.DB, event); err != nil {
+		c.logger.Unsuccessful("could not record failed login", err)
+	}
+
+	if !lockedUntil.IsZero() && user != nil {
+		return &AccountLockedError{User: *user, LockedUntil: lockedUntil}
+	}
+	return InvalidCredentialsError
+}
+
+// recordLoginFailure counts a failed password or second factor for the
+// account and ip address. It returns when the lock ends if the failure
+// locked the account
+func (c *SessionClient) recordLoginFailure(ctx context.Context, email string) time.Time {
+	lockedUntil, err := accountLoginThrottle.recordFailure(ctx, c.DB, accountThrottleSubject(email))
+	if err != nil {
+		c.logger.Unsuccessful("could not record failed login for account", err)
+	}
+
+	if ip := utils.ClientInfoFromContext(ctx).IP; ip != "" {
+		if _, err = ipLoginThrottle.recordFailure(ctx, c.DB, ip); err != nil {
+			c.logger.Unsuccessful("could not record failed login for ip", err)
+		}
+	}
+	return lockedUntil
+}
@@ -2,40 +2,18 @@ package models
 
 import (
 	"context"
-	"fmt"
 )
 
+// AccessLevel is the name of the role a member has in a company. Admin, write
+// and read are the default roles every company has
 type AccessLevel string
 
-type AccessID int
-
-const (
-	AdminID AccessID = iota
-	WriteID
-	ReadID
-)
-
 const (
 	Admin AccessLevel = "admin"
 	Write             = "write"
 	Read              = "read"
 )
 
-// ToAccessID converts accesslevel to accessid
-func (a AccessLevel) ToAccessID() (AccessID int, err error) {
-	switch a {
-	case "admin":
-		return 1, nil
-	case "write":
-		return 2, nil
-	case "read":
-		return 3, nil
-	default:
-		return 0, fmt.Errorf("%s is not a valid access level", a)
-	}
-
-}
-
 type AddUser struct {
 	Email    string      `json:"email"`
 	Username string      `json:"username"`
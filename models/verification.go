@@ -0,0 +1,129 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/kristohberg/CreatixBackend/utils"
+	"github.com/pkg/errors"
+)
+
+const emailVerificationExpirationTime = time.Hour * 48
+
+var (
+	UnverifiedEmailError               = errors.New("email address is not verified")
+	EmailAlreadyVerifiedError          = errors.New("email address is already verified")
+	InvalidEmailVerificationTokenError = errors.New("invalid or expired email verification token")
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email"`
+}
+
+const invalidateEmailVerificationsQuery = `
+	UPDATE EMAIL_VERIFICATIONS
+	SET UsedAt=NOW()
+	WHERE UserId=$1 AND UsedAt IS NULL
+`
+
+const createEmailVerificationQuery = `
+	INSERT INTO EMAIL_VERIFICATIONS(UserId,TokenHash,ExpiresAt)
+	VALUES ($1,$2,$3)
+`
+
+// CreateEmailVerification issues a new email verification token for the user
+// with the given email. Any earlier tokens for the user are invalidated
+func (c *SessionClient) CreateEmailVerification(ctx context.Context, email string) (token string, user utils.SessionUser, err error) {
+	user, err = utils.FindUserByEmail(ctx, c.DB, email)
+	if err != nil {
+		return
+	}
+
+	if user.EmailVerified {
+		return token, user, EmailAlreadyVerifiedError
+	}
+
+	token, err = utils.NewOpaqueToken()
+	if err != nil {
+		return
+	}
+
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, invalidateEmailVerificationsQuery, user.ID); err != nil {
+		return token, user, errors.WithMessage(err, "could not invalidate old email verifications")
+	}
+
+	expiresAt := time.Now().Add(emailVerificationExpirationTime)
+	if _, err = tx.ExecContext(ctx, createEmailVerificationQuery, user.ID, utils.HashToken(token), expiresAt); err != nil {
+		return token, user, errors.WithMessage(err, "could not create email verification")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	return token, user, nil
+}
+
+const useEmailVerificationQuery = `
+	UPDATE EMAIL_VERIFICATIONS
+	SET UsedAt=NOW()
+	WHERE TokenHash=$1 AND UsedAt IS NULL AND ExpiresAt > NOW()
+	RETURNING UserId
+`
+
+const verifyUserEmailQuery = `
+	UPDATE USERS
+	SET EmailVerifiedAt=NOW()
+	WHERE ID=$1 AND EmailVerifiedAt IS NULL
+`
+
+// VerifyEmail consumes the verification token and marks the email of the
+// user it was issued to as verified. Users whose email is on a domain verified
+// by a company join that company
+func (c *SessionClient) VerifyEmail(ctx context.Context, token string) (userID string, err error) {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{})
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+	}()
+
+	err = tx.QueryRowContext(ctx, useEmailVerificationQuery, utils.HashToken(token)).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return userID, InvalidEmailVerificationTokenError
+		}
+		return userID, errors.WithMessage(err, "could not use email verification token")
+	}
+
+	if _, err = tx.ExecContext(ctx, verifyUserEmailQuery, userID); err != nil {
+		return userID, errors.WithMessage(err, "could not verify email")
+	}
+
+	if _, _, err = joinDomainCompany(ctx, tx, userID); err != nil {
+		return
+	}
+
+	return userID, tx.Commit()
+}
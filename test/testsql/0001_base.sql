@@ -1,8 +1,8 @@
-INSERT INTO USERS
+INSERT INTO USERS(ID, Firstname, Lastname, Username, Email, Password, EmailVerifiedAt)
 VALUES
-    (1, 'Kristoffer', 'Berg', 'kristohb', 'kristoffer@berg.no', 'lolol'),
-    (2, 'John', 'Doe', 'doeman', 'john@doe.no', 'trorlr'),
-    (3, 'User', 'Reader', 'reader', 'user@read.no', 'reader');
+    (1, 'Kristoffer', 'Berg', 'kristohb', 'kristoffer@berg.no', 'lolol', NOW()),
+    (2, 'John', 'Doe', 'doeman', 'john@doe.no', 'trorlr', NOW()),
+    (3, 'User', 'Reader', 'reader', 'user@read.no', 'reader', NOW());
 
 INSERT INTO COMPANY
 VALUES
@@ -10,4 +10,4 @@ VALUES
 
 INSERT INTO USER_COMPANY
 VALUES
-    (1, 1, 1);
\ No newline at end of file
+    (1, 1, 1);
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo"
+	"github.com/pkg/errors"
+)
+
+// TrustedProxies are the networks of the proxies in front of the app. Only
+// they are trusted to tell the ip address of the client in X-Forwarded-For
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies parses proxies given as ip addresses or CIDR networks
+func ParseTrustedProxies(proxies []string) (TrustedProxies, error) {
+	var trusted TrustedProxies
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, errors.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid trusted proxy %q", proxy)
+		}
+		trusted = append(trusted, network)
+	}
+	return trusted, nil
+}
+
+func (t TrustedProxies) contains(ip net.IP) bool {
+	for _, network := range t {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the ip address of the client of the request, which is the
+// address of the connection unless it comes from a trusted proxy. Then
+// X-Forwarded-For is read from the right, and the first address not of a
+// trusted proxy is the client. Addresses further left are set by the client
+// and never trusted
+func (t TrustedProxies) ClientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header[echo.HeaderXForwardedFor], ","), ",")
+	for idx := len(forwarded) - 1; idx >= 0; idx-- {
+		remote := net.ParseIP(ip)
+		if remote == nil || !t.contains(remote) {
+			break
+		}
+		next := strings.TrimSpace(forwarded[idx])
+		if net.ParseIP(next) == nil {
+			break
+		}
+		ip = next
+	}
+	return ip
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		proxies   TrustedProxies
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{name: "no proxies", remote: "203.0.113.5:1234", forwarded: []string{"198.51.100.1"}, want: "203.0.113.5"},
+		{name: "untrusted proxy", proxies: proxies, remote: "203.0.113.5:1234", forwarded: []string{"198.51.100.1"}, want: "203.0.113.5"},
+		{name: "trusted proxy", proxies: proxies, remote: "10.1.2.3:1234", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "spoofed by client", proxies: proxies, remote: "10.1.2.3:1234", forwarded: []string{"1.2.3.4, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "proxy chain", proxies: proxies, remote: "10.1.2.3:1234", forwarded: []string{"198.51.100.1, 192.168.1.1", "10.0.0.1"}, want: "198.51.100.1"},
+		{name: "only proxies", proxies: proxies, remote: "10.1.2.3:1234", forwarded: []string{"10.0.0.1"}, want: "10.0.0.1"},
+		{name: "invalid forwarded", proxies: proxies, remote: "10.1.2.3:1234", forwarded: []string{"unknown"}, want: "10.1.2.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remote
+			for _, forwarded := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", forwarded)
+			}
+			req.Header.Set("X-Real-IP", "1.1.1.1")
+			assert.Equal(t, tt.want, tt.proxies.ClientIP(req))
+		})
+	}
+
+	_, err = ParseTrustedProxies([]string{"proxy"})
+	assert.Error(t, err)
+}
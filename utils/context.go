@@ -1,13 +1,20 @@
 package utils
 
 import (
+	"context"
+	"time"
+
 	"github.com/labstack/echo"
 	"github.com/pkg/errors"
 )
 
 type contextKey string
 
-const UserIDContext = contextKey("userID")
+const (
+	UserIDContext    = contextKey("userID")
+	SessionIDContext = contextKey("sessionID")
+	ClientIPContext  = contextKey("clientIP")
+)
 
 func (u contextKey) String() string {
 	return string(u)
@@ -20,3 +27,62 @@ func GetUserIDFromContext(c echo.Context) (string, error) {
 	}
 	return userID.(string), nil
 }
+
+func GetSessionIDFromContext(c echo.Context) (string, error) {
+	sessionID := c.Get(SessionIDContext.String())
+	if sessionID == nil {
+		return "", errors.New("sessionID not passed along")
+	}
+	return sessionID.(string), nil
+}
+
+type clientInfoKey struct{}
+
+// ClientInfo describes where a request came from and, for authenticated
+// requests, who made it
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+	UserID    string
+}
+
+// WithClientInfo returns the request context with the ip address and user
+// agent of the client, and the authenticated user if any, attached. The ip
+// address is the one found by the ClientIP middleware, or the address of the
+// connection without it
+func WithClientInfo(c echo.Context) context.Context {
+	userID, _ := c.Get(UserIDContext.String()).(string)
+	ip, _ := c.Get(ClientIPContext.String()).(string)
+	if ip == "" {
+		ip = TrustedProxies(nil).ClientIP(c.Request())
+	}
+	return context.WithValue(c.Request().Context(), clientInfoKey{}, ClientInfo{IP: ip, UserAgent: c.Request().UserAgent(), UserID: userID})
+}
+
+// WithoutClientInfo returns ctx without the client attached by
+// WithClientInfo, for actions which must not be traced back to the user
+func WithoutClientInfo(ctx context.Context) context.Context {
+	return context.WithValue(ctx, clientInfoKey{}, ClientInfo{})
+}
+
+type signedInAtKey struct{}
+
+// WithSignedInAt returns ctx with the time the credential of the request was
+// created attached, which is when the session logged in or the personal
+// access token was created
+func WithSignedInAt(ctx context.Context, signedInAt time.Time) context.Context {
+	return context.WithValue(ctx, signedInAtKey{}, signedInAt)
+}
+
+// SignedInAtFromContext returns the time attached by WithSignedInAt, if any
+func SignedInAtFromContext(ctx context.Context) (signedInAt time.Time, ok bool) {
+	signedInAt, ok = ctx.Value(signedInAtKey{}).(time.Time)
+	return
+}
+
+// ClientInfoFromContext returns the client attached by WithClientInfo, or an
+// empty ClientInfo
+func ClientInfoFromContext(ctx context.Context) ClientInfo {
+	clientInfo, _ := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return clientInfo
+}
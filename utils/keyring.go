@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"sort"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// SigningKey is one key in the keyring
+type SigningKey struct {
+	ID        string
+	Algorithm string
+	// RetiredAt is set when the key no longer signs tokens. Tokens signed by
+	// it are still accepted for the grace period of the keyring
+	RetiredAt time.Time
+
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+func NewHMACKey(id string, secret []byte) SigningKey {
+	return SigningKey{ID: id, Algorithm: AlgHS256, signKey: secret, verifyKey: secret}
+}
+
+func NewRSAKey(id string, privateKey *rsa.PrivateKey) SigningKey {
+	return SigningKey{ID: id, Algorithm: AlgRS256, signKey: privateKey, verifyKey: &privateKey.PublicKey}
+}
+
+func NewEdDSAKey(id string, privateKey ed25519.PrivateKey) SigningKey {
+	return SigningKey{ID: id, Algorithm: AlgEdDSA, signKey: privateKey, verifyKey: privateKey.Public()}
+}
+
+// ParseSigningKey creates a key from its configuration. HS256 keys take the
+// shared secret, RS256 and EdDSA keys take a PEM encoded private key
+func ParseSigningKey(id, algorithm string, material []byte) (SigningKey, error) {
+	if len(material) == 0 {
+		return SigningKey{}, errors.Errorf("key %s has no key material", id)
+	}
+
+	switch algorithm {
+	case AlgHS256:
+		return NewHMACKey(id, material), nil
+	case AlgRS256:
+		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(material)
+		if err != nil {
+			return SigningKey{}, errors.WithMessagef(err, "could not parse rsa key %s", id)
+		}
+		return NewRSAKey(id, privateKey), nil
+	case AlgEdDSA:
+		block, _ := pem.Decode(material)
+		if block == nil {
+			return SigningKey{}, errors.Errorf("key %s is not pem encoded", id)
+		}
+		privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return SigningKey{}, errors.WithMessagef(err, "could not parse ed25519 key %s", id)
+		}
+		edKey, ok := privateKey.(ed25519.PrivateKey)
+		if !ok {
+			return SigningKey{}, errors.Errorf("key %s is not an ed25519 key", id)
+		}
+		return NewEdDSAKey(id, edKey), nil
+	}
+	return SigningKey{}, errors.Errorf("key %s has unsupported algorithm %s", id, algorithm)
+}
+
+// Keyring signs tokens with the current key and verifies tokens with any key
+// that is active or retired within the grace period, picked by the kid header.
+// Rotating is done by adding a new current key and retiring the old one, so
+// tokens already issued stay valid until they expire
+type Keyring struct {
+	current     string
+	keys        map[string]SigningKey
+	gracePeriod time.Duration
+}
+
+func NewKeyring(current SigningKey, gracePeriod time.Duration, others ...SigningKey) (*Keyring, error) {
+	if !current.RetiredAt.IsZero() {
+		return nil, errors.Errorf("current key %s is retired", current.ID)
+	}
+
+	k := &Keyring{current: current.ID, keys: map[string]SigningKey{}, gracePeriod: gracePeriod}
+	for _, key := range append([]SigningKey{current}, others...) {
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, errors.Errorf("duplicate key %s", key.ID)
+		}
+		if jwt.GetSigningMethod(key.Algorithm) == nil {
+			return nil, errors.Errorf("key %s has unsupported algorithm %s", key.ID, key.Algorithm)
+		}
+		k.keys[key.ID] = key
+	}
+	return k, nil
+}
+
+func (k *Keyring) usable(key SigningKey) bool {
+	return key.RetiredAt.IsZero() || time.Now().Before(key.RetiredAt.Add(k.gracePeriod))
+}
+
+// Sign signs the claims with the current key and sets its kid header
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	key := k.keys[k.current]
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.signKey)
+}
+
+// Keyfunc returns the key a token must be verified with. The algorithm must
+// match the key, so a public key can never be used as an HMAC secret
+func (k *Keyring) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, errors.Errorf("unknown signing key %q", kid)
+	}
+
+	if token.Method.Alg() != key.Algorithm {
+		return nil, errors.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+
+	if !k.usable(key) {
+		return nil, errors.Errorf("signing key %s is retired", kid)
+	}
+	return key.verifyKey, nil
+}
+
+// JSONWebKey is the public part of an asymmetric key
+type JSONWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// JWKS returns the public keys other services can verify tokens with. HMAC
+// keys are shared secrets and never published
+func (k *Keyring) JWKS() JSONWebKeySet {
+	keySet := JSONWebKeySet{Keys: []JSONWebKey{}}
+	for _, key := range k.keys {
+		if !k.usable(key) {
+			continue
+		}
+
+		switch publicKey := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			keySet.Keys = append(keySet.Keys, JSONWebKey{
+				Kid: key.ID,
+				Kty: "RSA",
+				Alg: key.Algorithm,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keySet.Keys = append(keySet.Keys, JSONWebKey{
+				Kid: key.ID,
+				Kty: "OKP",
+				Alg: key.Algorithm,
+				Use: "sig",
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(publicKey),
+			})
+		}
+	}
+
+	sort.Slice(keySet.Keys, func(i, j int) bool {
+		return keySet.Keys[i].Kid < keySet.Keys[j].Kid
+	})
+	return keySet
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClaims() Claims {
+	return Claims{
+		UserID:         "1",
+		SessionID:      "session",
+		StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	oldKey := NewHMACKey("2020-01", []byte("old"))
+	oldKeyring, err := NewKeyring(oldKey, time.Hour)
+	require.NoError(t, err)
+	oldToken, err := oldKeyring.Sign(newTestClaims())
+	require.NoError(t, err)
+
+	for _, newKey := range []SigningKey{NewRSAKey("2020-02", rsaKey), NewEdDSAKey("2020-03", edKey)} {
+		t.Run(newKey.Algorithm, func(t *testing.T) {
+			// Tokens signed with the retired key are accepted during the grace period
+			oldKey.RetiredAt = time.Now().Add(-time.Minute)
+			keyring, err := NewKeyring(newKey, time.Hour, oldKey)
+			require.NoError(t, err)
+
+			claims, err := GetClaims(oldToken, keyring)
+			require.NoError(t, err)
+			assert.Equal(t, "1", claims.UserID)
+
+			newToken, err := keyring.Sign(newTestClaims())
+			require.NoError(t, err)
+			claims, err = GetClaims(newToken, keyring)
+			require.NoError(t, err)
+			assert.Equal(t, "session", claims.SessionID)
+
+			// The old keyring does not know the new key
+			_, err = GetClaims(newToken, oldKeyring)
+			assert.Error(t, err)
+
+			// After the grace period the retired key is rejected
+			oldKey.RetiredAt = time.Now().Add(-2 * time.Hour)
+			keyring, err = NewKeyring(newKey, time.Hour, oldKey)
+			require.NoError(t, err)
+			_, err = GetClaims(oldToken, keyring)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestKeyringRejectsAlgorithmMismatch(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyring, err := NewKeyring(NewRSAKey("rsa", rsaKey), time.Hour)
+	require.NoError(t, err)
+
+	// An HMAC token keyed with the public key must not verify
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, newTestClaims())
+	token.Header["kid"] = "rsa"
+	tokenString, err := token.SignedString(rsaKey.PublicKey.N.Bytes())
+	require.NoError(t, err)
+	_, err = GetClaims(tokenString, keyring)
+	assert.Error(t, err)
+
+	// Tokens without a kid are rejected
+	tokenString, err = jwt.NewWithClaims(jwt.SigningMethodRS256, newTestClaims()).SignedString(rsaKey)
+	require.NoError(t, err)
+	_, err = GetClaims(tokenString, keyring)
+	assert.Error(t, err)
+}
+
+func TestKeyringJWKS(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	expired := NewRSAKey("expired", rsaKey)
+	expired.RetiredAt = time.Now().Add(-2 * time.Hour)
+	keyring, err := NewKeyring(NewEdDSAKey("ed", edKey), time.Hour, NewRSAKey("rsa", rsaKey), NewHMACKey("hmac", []byte("secret")), expired)
+	require.NoError(t, err)
+
+	keySet := keyring.JWKS()
+	require.Len(t, keySet.Keys, 2)
+	assert.Equal(t, "ed", keySet.Keys[0].Kid)
+	assert.Equal(t, "OKP", keySet.Keys[0].Kty)
+	assert.Equal(t, "rsa", keySet.Keys[1].Kid)
+	assert.Equal(t, "RSA", keySet.Keys[1].Kty)
+}
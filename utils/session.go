@@ -9,17 +9,16 @@ import (
 )
 
 type Claims struct {
-	UserID string
+	UserID    string
+	SessionID string
 	jwt.StandardClaims
 }
 
-// GetClaims takes a token string and the jwt secret and return a
-// claim
-func GetClaims(tokenString string, secret []byte) (*Claims, error) {
+// GetClaims takes a token string and the keyring it was signed with and
+// return a claim
+func GetClaims(tokenString string, keyring *Keyring) (*Claims, error) {
 	claims := new(Claims)
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return secret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, keyring.Keyfunc)
 
 	if err != nil {
 		if err == jwt.ErrSignatureInvalid {
@@ -40,8 +39,8 @@ func GetClaims(tokenString string, secret []byte) (*Claims, error) {
 }
 
 // IsTokenValid checks to see if a token is valid
-func IsTokenValid(tokenString string, secret []byte) error {
-	claims, err := GetClaims(tokenString, secret)
+func IsTokenValid(tokenString string, keyring *Keyring) error {
+	claims, err := GetClaims(tokenString, keyring)
 	if err != nil {
 		return err
 	}
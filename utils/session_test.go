@@ -5,7 +5,7 @@ import "testing"
 func TestIsTokenValid(t *testing.T) {
 	type args struct {
 		tokenString string
-		secret      []byte
+		keyring     *Keyring
 	}
 	tests := []struct {
 		name    string
@@ -16,7 +16,7 @@ func TestIsTokenValid(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := IsTokenValid(tt.args.tokenString, tt.args.secret); (err != nil) != tt.wantErr {
+			if err := IsTokenValid(tt.args.tokenString, tt.args.keyring); (err != nil) != tt.wantErr {
 				t.Errorf("IsTokenValid() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	// totpSkew is the number of periods before and after the current one
+	// that are accepted to allow for clock drift
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random base32 encoded secret for RFC 6238 TOTP
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.WithStack(err)
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid totp secret")
+	}
+	return key, nil
+}
+
+// hotp computes the RFC 4226 HOTP value for the counter
+func hotp(key []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// TOTPStep returns the time step the given time belongs to
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// TOTPCode returns the code for the secret at the given time
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(TOTPStep(t))), nil
+}
+
+// ValidateTOTP checks the code against the secret at the given time and
+// returns the time step that matched. Callers should reject steps that
+// are not newer than the last accepted step to prevent replays
+func ValidateTOTP(secret, code string, t time.Time) (step int64, ok bool, err error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return 0, false, err
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) != totpDigits {
+		return 0, false, nil
+	}
+
+	current := TOTPStep(t)
+	for i := -totpSkew; i <= totpSkew; i++ {
+		candidate := current + int64(i)
+		if subtle.ConstantTimeCompare([]byte(hotp(key, uint64(candidate))), []byte(code)) == 1 {
+			return candidate, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// TOTPURI returns the otpauth URI authenticator apps use to enroll the secret
+func TOTPURI(issuer, account, secret string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(totpDigits))
+	values.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, values.Encode())
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA1 seed from the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode(t *testing.T) {
+	tests := []struct {
+		name string
+		unix int64
+		want string
+	}{
+		{name: "59", unix: 59, want: "287082"},
+		{name: "1111111109", unix: 1111111109, want: "081804"},
+		{name: "1111111111", unix: 1111111111, want: "050471"},
+		{name: "1234567890", unix: 1234567890, want: "005924"},
+		{name: "2000000000", unix: 2000000000, want: "279037"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := TOTPCode(rfcSecret, time.Unix(tt.unix, 0))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, code)
+		})
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	require.NoError(t, err)
+
+	now := time.Unix(1600000000, 0)
+	code, err := TOTPCode(secret, now)
+	require.NoError(t, err)
+
+	step, ok, err := ValidateTOTP(secret, code, now)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, TOTPStep(now), step)
+
+	// One period of clock drift is accepted
+	_, ok, err = ValidateTOTP(secret, code, now.Add(30*time.Second))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	_, ok, err = ValidateTOTP(secret, code, now.Add(90*time.Second))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, ok, err = ValidateTOTP(secret, "12345", now)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("Creatix", "john@doe.com", "JBSWY3DPEHPK3PXP")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Creatix:john@doe.com?"))
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=Creatix")
+}
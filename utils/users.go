@@ -11,11 +11,19 @@ var (
 	NoPermission = errors.New("user does not have permission")
 )
 
+// QueryRower is satisfied by *sql.DB, *sql.Tx and the tenant aware database
+// of the models, so users are looked up in the transaction of the request
+// when there is one
+type QueryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 type SessionUser struct {
-	ID        string `json:"id"`
-	Firstname string `json:"firstname"`
-	Lastname  string `json:"lastname"`
-	Email     string `json:"email"`
+	ID            string `json:"id"`
+	Firstname     string `json:"firstname"`
+	Lastname      string `json:"lastname"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"emailVerified"`
 }
 
 var findUserByEmailQuery = `
@@ -24,13 +32,14 @@ var findUserByEmailQuery = `
 	,Firstname
 	,Lastname
 	,Email
+	,EmailVerifiedAt IS NOT NULL
 	FROM users
 	WHERE Email = $1
 `
 
 // findUserByEmail returns the first row with the given email
-func FindUserByEmail(ctx context.Context, DB *sql.DB, email string) (user SessionUser, err error) {
-	err = DB.QueryRowContext(ctx, findUserByEmailQuery, email).Scan(&user.ID, &user.Firstname, &user.Lastname, &user.Email)
+func FindUserByEmail(ctx context.Context, DB QueryRower, email string) (user SessionUser, err error) {
+	err = DB.QueryRowContext(ctx, findUserByEmailQuery, email).Scan(&user.ID, &user.Firstname, &user.Lastname, &user.Email, &user.EmailVerified)
 	if err != nil {
 		return user, errors.WithMessagef(err, "feedback.utils.finduserbyemail")
 	}
@@ -43,12 +52,13 @@ var findUserByUserIdQuery = `
 	,Firstname
 	,Lastname
 	,Email
+	,EmailVerifiedAt IS NOT NULL
 	FROM users
 	WHERE ID = $1
 `
 
-func FindUserByUserID(ctx context.Context, DB *sql.DB, userID string) (user SessionUser, err error) {
-	err = DB.QueryRowContext(ctx, findUserByUserIdQuery, userID).Scan(&user.ID, &user.Firstname, &user.Lastname, &user.Email)
+func FindUserByUserID(ctx context.Context, DB QueryRower, userID string) (user SessionUser, err error) {
+	err = DB.QueryRowContext(ctx, findUserByUserIdQuery, userID).Scan(&user.ID, &user.Firstname, &user.Lastname, &user.Email, &user.EmailVerified)
 	if err != nil {
 		return user, errors.WithMessagef(err, "feedback.utils.finduserbyuserid")
 	}
@@ -61,12 +71,13 @@ var findUserByUsernameQuery = `
 	,Firstname
 	,Lastname
 	,Email
+	,EmailVerifiedAt IS NOT NULL
 	FROM users
 	WHERE username = $1
 `
 
-func FindUserByUsername(ctx context.Context, DB *sql.DB, username string) (user SessionUser, err error) {
-	err = DB.QueryRowContext(ctx, findUserByUsernameQuery, username).Scan(&user.ID, &user.Firstname, &user.Lastname, &user.Email)
+func FindUserByUsername(ctx context.Context, DB QueryRower, username string) (user SessionUser, err error) {
+	err = DB.QueryRowContext(ctx, findUserByUsernameQuery, username).Scan(&user.ID, &user.Firstname, &user.Lastname, &user.Email, &user.EmailVerified)
 	if err != nil {
 		return user, errors.WithMessagef(err, "feedback.utils.finduserbyuserid")
 	}